@@ -4,17 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"math/rand"
 	"net/http"
-	"net/url"
 	"runtime/pprof"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/profefe/profefe/pkg/profile"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -60,6 +58,10 @@ type Agent struct {
 
 	rawClient     httpClient
 	collectorAddr string
+	authToken     string
+	tenant        string
+	transport     transport
+	grpcDialOpts  []grpc.DialOption
 
 	tick time.Duration
 	stop chan struct{} // signals the beginning of stop
@@ -94,6 +96,16 @@ func (a *Agent) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start agent: collector address is empty")
 	}
 
+	if a.grpcDialOpts != nil {
+		t, err := newGRPCTransport(a.collectorAddr, a.authToken, a.tenant, a.grpcDialOpts...)
+		if err != nil {
+			return fmt.Errorf("failed to start agent: %w", err)
+		}
+		a.transport = t
+	} else {
+		a.transport = newHTTPTransport(a.rawClient, a.collectorAddr, a.authToken, a.tenant)
+	}
+
 	go a.collectAndSend(ctx)
 
 	return nil
@@ -102,7 +114,10 @@ func (a *Agent) Start(ctx context.Context) error {
 func (a *Agent) Stop() error {
 	close(a.stop)
 	<-a.done
-	return nil
+	if a.transport == nil {
+		return nil
+	}
+	return a.transport.Close()
 }
 
 func (a *Agent) collectProfile(ctx context.Context, ptype profile.ProfileType, buf *bytes.Buffer) error {
@@ -140,55 +155,20 @@ func (a *Agent) collectProfile(ctx context.Context, ptype profile.ProfileType, b
 }
 
 func (a *Agent) sendProfile(ctx context.Context, ptype profile.ProfileType, buf *bytes.Buffer) error {
-	q := url.Values{}
-	q.Set("service", a.service)
-	q.Set("labels", a.rawLabels.String())
-	q.Set("type", ptype.String())
-
-	surl := a.collectorAddr + "/api/0/profiles?" + q.Encode()
-	req, err := http.NewRequest(http.MethodPost, surl, buf)
-	if err != nil {
-		return err
+	params := &sendProfileParams{
+		Service: a.service,
+		Type:    ptype,
+		Labels:  a.rawLabels.String(),
 	}
-	req = req.WithContext(ctx)
 
 	return DoRetryAttempts(
 		backoffMinDelay,
 		backoffMaxDelay,
 		backoffMaxAttempts,
-		func() error { return a.doRequest(req, nil) },
+		func() error { return a.transport.SendProfile(ctx, params, buf.Bytes()) },
 	)
 }
 
-func (a *Agent) doRequest(req *http.Request, v io.Writer) error {
-	resp, err := a.rawClient.Do(req)
-	if err, ok := err.(*url.Error); ok && err.Err == context.Canceled {
-		return Cancel(err)
-	}
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		respBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("unexpected respose %s: %v", resp.Status, err)
-		}
-		if resp.StatusCode >= 500 {
-			return fmt.Errorf("unexpected respose from collector %s: %s", resp.Status, respBody)
-		}
-		return Cancel(fmt.Errorf("bad request: collector responded with %s: %s", resp.Status, respBody))
-	}
-
-	if v != nil {
-		_, err := io.Copy(v, resp.Body)
-		return err
-	}
-
-	return nil
-}
-
 func (a *Agent) collectAndSend(ctx context.Context) {
 	defer close(a.done)
 
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/url"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 type Option func(a *Agent)
@@ -81,3 +83,29 @@ func WithLogger(logf func(string, ...interface{})) Option {
 		a.logf = logf
 	}
 }
+
+// WithGRPCTransport makes the agent send profiles to the collector's gRPC API.
+// The collector address must point to the address of collector's gRPC server.
+// If no dial options are passed, the connection is insecure.
+func WithGRPCTransport(opts ...grpc.DialOption) Option {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return func(a *Agent) {
+		a.grpcDialOpts = opts
+	}
+}
+
+// WithAuthToken sets the bearer token the agent authenticates with, when sending profiles to the collector.
+func WithAuthToken(token string) Option {
+	return func(a *Agent) {
+		a.authToken = token
+	}
+}
+
+// WithTenant sets the tenant the collector stores the agent's profiles under.
+func WithTenant(tenant string) Option {
+	return func(a *Agent) {
+		a.tenant = tenant
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+type sendProfileParams struct {
+	Service string
+	Type    profile.ProfileType
+	// comma-separated, url-encoded key=value pairs
+	Labels string
+}
+
+// transport delivers collected profiles to the collector.
+// Errors, wrapped with Cancel, signal that the request must not be retried.
+type transport interface {
+	SendProfile(ctx context.Context, params *sendProfileParams, data []byte) error
+	Close() error
+}
+
+type httpTransport struct {
+	client        httpClient
+	collectorAddr string
+	authToken     string
+	tenant        string
+}
+
+func newHTTPTransport(client httpClient, addr, authToken, tenantName string) *httpTransport {
+	return &httpTransport{
+		client:        client,
+		collectorAddr: addr,
+		authToken:     authToken,
+		tenant:        tenantName,
+	}
+}
+
+func (t *httpTransport) SendProfile(ctx context.Context, params *sendProfileParams, data []byte) error {
+	q := url.Values{}
+	q.Set("service", params.Service)
+	q.Set("labels", params.Labels)
+	q.Set("type", params.Type.String())
+
+	surl := t.collectorAddr + "/api/0/profiles?" + q.Encode()
+	req, err := http.NewRequest(http.MethodPost, surl, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if t.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+t.authToken)
+	}
+	if t.tenant != "" {
+		req.Header.Set(tenant.HeaderTenant, t.tenant)
+	}
+
+	return t.doRequest(req, nil)
+}
+
+func (t *httpTransport) doRequest(req *http.Request, v io.Writer) error {
+	resp, err := t.client.Do(req)
+	if err, ok := err.(*url.Error); ok && err.Err == context.Canceled {
+		return Cancel(err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("unexpected respose %s: %v", resp.Status, err)
+		}
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("unexpected respose from collector %s: %s", resp.Status, respBody)
+		}
+		return Cancel(fmt.Errorf("bad request: collector responded with %s: %s", resp.Status, respBody))
+	}
+
+	if v != nil {
+		_, err := io.Copy(v, resp.Body)
+		return err
+	}
+
+	return nil
+}
+
+func (t *httpTransport) Close() error {
+	return nil
+}
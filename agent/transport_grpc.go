@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/profefe/profefe/pkg/profefepb"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// the maximum size of profile's data sent with a single message
+const grpcChunkSize = 64 << 10
+
+type grpcTransport struct {
+	conn      *grpc.ClientConn
+	client    profefepb.ProfefeClient
+	authToken string
+	tenant    string
+}
+
+func newGRPCTransport(addr, authToken, tenantName string, opts ...grpc.DialOption) (*grpcTransport, error) {
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial collector %q: %w", addr, err)
+	}
+	return &grpcTransport{
+		conn:      conn,
+		client:    profefepb.NewProfefeClient(conn),
+		authToken: authToken,
+		tenant:    tenantName,
+	}, nil
+}
+
+func (t *grpcTransport) SendProfile(ctx context.Context, params *sendProfileParams, data []byte) error {
+	var labels profile.Labels
+	if err := labels.FromString(params.Labels); err != nil {
+		return Cancel(fmt.Errorf("bad labels %q: %w", params.Labels, err))
+	}
+
+	if t.authToken != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+t.authToken)
+	}
+	if t.tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(tenant.HeaderTenant), t.tenant)
+	}
+
+	stream, err := t.client.WriteProfiles(ctx)
+	if err != nil {
+		return grpcTransportError(err)
+	}
+
+	pbLabels := make([]*profefepb.Label, 0, len(labels))
+	for _, label := range labels {
+		pbLabels = append(pbLabels, &profefepb.Label{Key: label.Key, Value: label.Value})
+	}
+
+	err = stream.Send(&profefepb.WriteProfilesRequest{
+		Payload: &profefepb.WriteProfilesRequest_Params{
+			Params: &profefepb.WriteProfileParams{
+				Service: params.Service,
+				Type:    profefepb.ProfileType(params.Type),
+				Labels:  pbLabels,
+			},
+		},
+	})
+	if err != nil {
+		return grpcTransportError(err)
+	}
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > grpcChunkSize {
+			n = grpcChunkSize
+		}
+		err := stream.Send(&profefepb.WriteProfilesRequest{
+			Payload: &profefepb.WriteProfilesRequest_Data{Data: data[:n]},
+		})
+		if err != nil {
+			return grpcTransportError(err)
+		}
+		data = data[n:]
+	}
+
+	_, err = stream.CloseAndRecv()
+	return grpcTransportError(err)
+}
+
+func (t *grpcTransport) Close() error {
+	return t.conn.Close()
+}
+
+// marks the errors that won't go away on retry
+func grpcTransportError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.Canceled,
+		codes.InvalidArgument,
+		codes.NotFound,
+		codes.PermissionDenied,
+		codes.Unauthenticated,
+		codes.Unimplemented:
+		return Cancel(fmt.Errorf("collector rejected profile: %w", err))
+	}
+	return err
+}
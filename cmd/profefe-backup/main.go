@@ -0,0 +1,264 @@
+// Command profefe-backup takes incremental backups of profefe's badger storage and restores them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dgraph-io/badger"
+	"github.com/profefe/profefe/pkg/log"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	"github.com/profefe/profefe/version"
+)
+
+const usage = `usage: profefe-backup <command> [flags]
+
+commands:
+  backup   take a backup of the storage
+  restore  restore the backup into the storage
+
+The running profefe server is backed up through its admin API, with -addr. The storage of a stopped server
+can be accessed directly, with -badger.dir.
+
+Run "profefe-backup <command> -h" for the command's flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if os.Args[1] == "-version" || os.Args[1] == "--version" {
+		fmt.Println(version.Details())
+		os.Exit(1)
+	}
+
+	cmd := os.Args[1]
+	if cmd != "backup" && cmd != "restore" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	f := flag.NewFlagSet("profefe-backup "+cmd, flag.ExitOnError)
+	var conf backupConfig
+	conf.RegisterFlags(f, cmd)
+	f.Parse(os.Args[2:])
+
+	logger, err := conf.Logger.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		cancel()
+	}()
+
+	if cmd == "backup" {
+		err = runBackup(ctx, logger, conf)
+	} else {
+		err = runRestore(ctx, logger, conf)
+	}
+	if err != nil {
+		logger.Errorw(err.Error())
+		os.Exit(1)
+	}
+}
+
+type backupConfig struct {
+	Logger log.Config
+
+	Addr      string
+	Token     string
+	BadgerDir string
+
+	File      string
+	Since     uint64
+	SinceFile string
+}
+
+func (conf *backupConfig) RegisterFlags(f *flag.FlagSet, cmd string) {
+	conf.Logger.RegisterFlags(f)
+
+	f.StringVar(&conf.Addr, "addr", "http://localhost:10100", "address of profefe server")
+	f.StringVar(&conf.Token, "token", "", "bearer token of the admin identity, if the server requires authentication")
+	f.StringVar(&conf.BadgerDir, "badger.dir", "", "badger data dir of the stopped server, used instead of the server's API")
+
+	if cmd == "backup" {
+		f.StringVar(&conf.File, "output", "", "path to the file the backup is written to")
+		f.Uint64Var(&conf.Since, "since", 0, "version to take the incremental backup since (full backup if zero)")
+		f.StringVar(&conf.SinceFile, "since-file", "", "path to the file that keeps the version to take the next incremental backup since; overrides -since if exists")
+	} else {
+		f.StringVar(&conf.File, "input", "", "path to the backup file")
+	}
+}
+
+func runBackup(ctx context.Context, logger *log.Logger, conf backupConfig) error {
+	if conf.File == "" {
+		return fmt.Errorf("output required")
+	}
+
+	since := conf.Since
+	if conf.SinceFile != "" {
+		data, err := ioutil.ReadFile(conf.SinceFile)
+		if err == nil {
+			since, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return fmt.Errorf("could not parse since file %q: %w", conf.SinceFile, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// the backup is written to a temporary file, so an interrupted backup doesn't leave a broken file
+	f, err := ioutil.TempFile(filepath.Dir(conf.File), filepath.Base(conf.File)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var next uint64
+	if conf.BadgerDir != "" {
+		next, err = backupDir(logger, conf.BadgerDir, f, since)
+	} else {
+		next, err = backupServer(ctx, conf, f, since)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not take backup: %w", err)
+	}
+	if err := os.Rename(f.Name(), conf.File); err != nil {
+		return err
+	}
+
+	if conf.SinceFile != "" {
+		if err := ioutil.WriteFile(conf.SinceFile, []byte(strconv.FormatUint(next, 10)+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	logger.Infow("backup taken", "output", conf.File, "since", since, "next_since", next)
+	fmt.Println(next)
+
+	return nil
+}
+
+func backupServer(ctx context.Context, conf backupConfig, w io.Writer, since uint64) (uint64, error) {
+	url := strings.TrimRight(conf.Addr, "/") + storageBadger.APIBackupPath + "?since=" + strconv.FormatUint(since, 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := doRequest(req, conf.Token)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return 0, err
+	}
+	// the server sends the next version in the trailer, once the whole backup is written
+	v := resp.Trailer.Get(storageBadger.BackupSinceTrailer)
+	if v == "" {
+		return 0, fmt.Errorf("backup is incomplete, see the server's logs")
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+func backupDir(logger *log.Logger, dir string, w io.Writer, since uint64) (uint64, error) {
+	st, closer, err := openStorage(logger, dir)
+	if err != nil {
+		return 0, err
+	}
+	defer closer.Close()
+	return st.Backup(w, since)
+}
+
+func runRestore(ctx context.Context, logger *log.Logger, conf backupConfig) error {
+	if conf.File == "" {
+		return fmt.Errorf("input required")
+	}
+	f, err := os.Open(conf.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if conf.BadgerDir != "" {
+		err = restoreDir(logger, conf.BadgerDir, f)
+	} else {
+		err = restoreServer(ctx, conf, f)
+	}
+	if err != nil {
+		return fmt.Errorf("could not restore backup: %w", err)
+	}
+
+	logger.Infow("backup restored", "input", conf.File)
+
+	return nil
+}
+
+func restoreServer(ctx context.Context, conf backupConfig, r io.Reader) error {
+	url := strings.TrimRight(conf.Addr, "/") + storageBadger.APIRestorePath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, r)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequest(req, conf.Token)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func restoreDir(logger *log.Logger, dir string, r io.Reader) error {
+	st, closer, err := openStorage(logger, dir)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	return st.Restore(r)
+}
+
+func openStorage(logger *log.Logger, dir string) (*storageBadger.Storage, io.Closer, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open db: %w", err)
+	}
+	return storageBadger.NewStorage(logger, db, 0), db, nil
+}
+
+func doRequest(req *http.Request, token string) (*http.Response, error) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
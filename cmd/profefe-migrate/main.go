@@ -0,0 +1,322 @@
+// Command profefe-migrate copies the profiles between storages, exports them to archives and imports them back.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/profefe/profefe/pkg/config"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/migrate"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	storageCH "github.com/profefe/profefe/pkg/storage/clickhouse"
+	storageFS "github.com/profefe/profefe/pkg/storage/fs"
+	storageGCS "github.com/profefe/profefe/pkg/storage/gcs"
+	storagePG "github.com/profefe/profefe/pkg/storage/postgres"
+	storageS3 "github.com/profefe/profefe/pkg/storage/s3"
+	"github.com/profefe/profefe/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const usage = `usage: profefe-migrate <command> [flags]
+
+commands:
+  copy    copy profiles from the source storage to the destination storage
+  export  export profiles from the source storage to the archive
+  import  import profiles from the archive to the destination storage
+  verify  verify that profiles of the source storage, or the archive, are in the destination storage
+
+Run "profefe-migrate <command> -h" for the command's flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if os.Args[1] == "-version" || os.Args[1] == "--version" {
+		fmt.Println(version.Details())
+		os.Exit(1)
+	}
+
+	cmd := os.Args[1]
+	switch cmd {
+	case "copy", "export", "import", "verify":
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	f := flag.NewFlagSet("profefe-migrate "+cmd, flag.ExitOnError)
+	var conf migrateConfig
+	conf.RegisterFlags(f, cmd)
+	f.Parse(os.Args[2:])
+
+	logger, err := conf.Logger.Build()
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		cancel()
+	}()
+
+	if err := run(ctx, logger, cmd, conf); err != nil {
+		logger.Errorw(err.Error())
+		os.Exit(1)
+	}
+}
+
+type migrateConfig struct {
+	Logger log.Config
+
+	Src storageConfig
+	Dst storageConfig
+
+	Tenant    string
+	Services  string
+	Types     string
+	Since     string
+	Until     string
+	BatchSize int
+	StateFile string
+	Archive   string
+	// verify only
+	VerifyData bool
+}
+
+func (conf *migrateConfig) RegisterFlags(f *flag.FlagSet, cmd string) {
+	conf.Logger.RegisterFlags(f)
+
+	if cmd != "import" {
+		conf.Src.RegisterFlags(f, "src.")
+	}
+	if cmd != "export" {
+		conf.Dst.RegisterFlags(f, "dst.")
+	}
+
+	f.StringVar(&conf.Tenant, "tenant", "", "tenant of the profiles (default tenant if empty)")
+	f.StringVar(&conf.Services, "service", "", "comma-separated services of the profiles (every service if empty)")
+	f.StringVar(&conf.Types, "type", "", "comma-separated types of the profiles (every type if empty)")
+	f.StringVar(&conf.Since, "since", "", "migrate profiles created at or after the time, RFC3339")
+	f.StringVar(&conf.Until, "until", "", "migrate profiles created before the time, RFC3339")
+	f.IntVar(&conf.BatchSize, "batch-size", 1000, "number of profiles read from the source at once")
+	if cmd != "verify" {
+		f.StringVar(&conf.StateFile, "state-file", "", "path to the file the progress is saved to, to resume the interrupted migration (the progress isn't saved if empty)")
+	}
+	if cmd == "export" || cmd == "import" || cmd == "verify" {
+		f.StringVar(&conf.Archive, "archive", "", "path to the archive (verify the source storage if empty)")
+	}
+	if cmd == "verify" {
+		f.BoolVar(&conf.VerifyData, "verify-data", false, "compare the data of the profiles as well")
+	}
+}
+
+func (conf *migrateConfig) Filter() (migrate.Filter, error) {
+	filter := migrate.Filter{
+		Tenant: conf.Tenant,
+	}
+	if conf.Services != "" {
+		filter.Services = strings.Split(conf.Services, ",")
+	}
+	if conf.Types != "" {
+		for _, s := range strings.Split(conf.Types, ",") {
+			var ptype profile.ProfileType
+			if err := ptype.FromString(s); err != nil {
+				return filter, err
+			}
+			filter.Types = append(filter.Types, ptype)
+		}
+	}
+	var err error
+	if conf.Since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, conf.Since); err != nil {
+			return filter, fmt.Errorf("could not parse since: %w", err)
+		}
+	}
+	if conf.Until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, conf.Until); err != nil {
+			return filter, fmt.Errorf("could not parse until: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+type storageConfig struct {
+	Type       string
+	Badger     storageBadger.Config
+	ClickHouse storageCH.Config
+	S3         storageS3.Config
+	GCS        storageGCS.Config
+	FS         storageFS.Config
+	Postgres   storagePG.Config
+}
+
+// RegisterFlags registers the flags of the storages, prefixed with the prefix, e.g. "-src.badger.dir".
+func (conf *storageConfig) RegisterFlags(f *flag.FlagSet, prefix string) {
+	f.StringVar(&conf.Type, prefix+"storage-type", "", fmt.Sprintf("storage type: %s, %s, %s, %s, %s, %s", config.StorageTypeBadger, config.StorageTypeCH, config.StorageTypeS3, config.StorageTypeGCS, config.StorageTypeFS, config.StorageTypePG))
+
+	sf := flag.NewFlagSet("", flag.ContinueOnError)
+	conf.Badger.RegisterFlags(sf)
+	conf.ClickHouse.RegisterFlags(sf)
+	conf.S3.RegisterFlags(sf)
+	conf.GCS.RegisterFlags(sf)
+	conf.FS.RegisterFlags(sf)
+	conf.Postgres.RegisterFlags(sf)
+	sf.VisitAll(func(fl *flag.Flag) {
+		f.Var(fl.Value, prefix+fl.Name, fl.Usage)
+	})
+}
+
+func (conf *storageConfig) CreateStorage(logger *log.Logger) (storage.Storage, io.Closer, error) {
+	logger = logger.With(zap.String("storage", conf.Type))
+	switch conf.Type {
+	case config.StorageTypeBadger:
+		st, closer, err := conf.Badger.CreateStorage(logger)
+		return st, closer, err
+	case config.StorageTypeS3:
+		st, err := conf.S3.CreateStorage(logger)
+		return st, nil, err
+	case config.StorageTypeCH:
+		// the migration doesn't expose metrics; the source and the destination may both be clickhouse storages
+		st, closer, err := conf.ClickHouse.CreateStorage(logger, prometheus.NewRegistry())
+		return st, closer, err
+	case config.StorageTypeGCS:
+		st, err := conf.GCS.CreateStorage(logger)
+		return st, nil, err
+	case config.StorageTypeFS:
+		if conf.FS.Dir == "" {
+			return nil, nil, fmt.Errorf("empty fs dir")
+		}
+		// the expired profiles aren't removed, as the migrated profiles are usually old
+		return storageFS.NewStorage(logger, conf.FS.Dir, 0), nil, nil
+	case config.StorageTypePG:
+		// the expired partitions aren't dropped, as the migrated profiles are usually old
+		db, err := sql.Open("postgres", conf.Postgres.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := storagePG.Migrate(context.Background(), logger, db); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("could not migrate db schema: %w", err)
+		}
+		return storagePG.NewStorage(logger, db, 0), db, nil
+	case "":
+		return nil, nil, fmt.Errorf("storage type required")
+	default:
+		return nil, nil, fmt.Errorf("unknown storage type %q", conf.Type)
+	}
+}
+
+func run(ctx context.Context, logger *log.Logger, cmd string, conf migrateConfig) error {
+	if conf.BatchSize <= 0 {
+		return fmt.Errorf("batch size must be positive, got %d", conf.BatchSize)
+	}
+	filter, err := conf.Filter()
+	if err != nil {
+		return err
+	}
+	state, err := migrate.LoadState(conf.StateFile)
+	if err != nil {
+		return fmt.Errorf("could not load migration state: %w", err)
+	}
+	m := migrate.NewMigrator(logger, filter, conf.BatchSize, state)
+
+	var src, dst storage.Storage
+	if cmd == "copy" || cmd == "export" || (cmd == "verify" && conf.Archive == "") {
+		st, closer, err := conf.Src.CreateStorage(logger)
+		if err != nil {
+			return fmt.Errorf("could not init source storage: %w", err)
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		src = st
+	}
+	if cmd != "export" {
+		st, closer, err := conf.Dst.CreateStorage(logger)
+		if err != nil {
+			return fmt.Errorf("could not init destination storage: %w", err)
+		}
+		if closer != nil {
+			defer closer.Close()
+		}
+		dst = st
+	}
+
+	switch cmd {
+	case "copy":
+		total, err := m.Copy(ctx, src, dst)
+		logger.Infow("copied profiles", "total", total)
+		return err
+
+	case "export":
+		if conf.Archive == "" {
+			return fmt.Errorf("archive required")
+		}
+		f, err := os.OpenFile(conf.Archive, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		total, err := m.Export(ctx, src, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		logger.Infow("exported profiles", "total", total, "archive", conf.Archive)
+		return err
+
+	case "import":
+		if conf.Archive == "" {
+			return fmt.Errorf("archive required")
+		}
+		f, err := os.Open(conf.Archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		total, err := m.Import(ctx, f, dst)
+		logger.Infow("imported profiles", "total", total, "archive", conf.Archive)
+		return err
+
+	case "verify":
+		var report migrate.Report
+		if conf.Archive != "" {
+			f, err := os.Open(conf.Archive)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			report, err = m.VerifyArchive(ctx, f, dst, conf.VerifyData)
+			if err != nil {
+				return err
+			}
+		} else {
+			report, err = m.VerifyStorage(ctx, src, dst, conf.VerifyData)
+			if err != nil {
+				return err
+			}
+		}
+		logger.Infow("verified profiles", "verified", report.Verified, "missing", report.Missing)
+		if report.Missing > 0 {
+			return fmt.Errorf("%d profiles missing in destination storage", report.Missing)
+		}
+		return nil
+	}
+	return nil
+}
@@ -6,21 +6,34 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/profefe/profefe/pkg/alerting"
+	"github.com/profefe/profefe/pkg/compaction"
 	"github.com/profefe/profefe/pkg/config"
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/middleware"
 	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profefepb"
+	"github.com/profefe/profefe/pkg/recording"
+	"github.com/profefe/profefe/pkg/regression"
+	"github.com/profefe/profefe/pkg/relabel"
+	"github.com/profefe/profefe/pkg/retention"
+	"github.com/profefe/profefe/pkg/scrape"
 	"github.com/profefe/profefe/pkg/storage"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	"github.com/profefe/profefe/pkg/symbolizer"
 	"github.com/profefe/profefe/version"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
@@ -31,6 +44,12 @@ func main() {
 
 	flag.Parse()
 
+	// the retention policies may keep the profiles longer than postgres ttl, thus the ttl is only applied
+	// along with the policies, if it's set explicitly
+	if conf.Retention.Enabled() && !isFlagSet(flag.CommandLine, "postgres.data-ttl") {
+		conf.Postgres.ProfileTTL = 0
+	}
+
 	if *printVersion {
 		fmt.Println(version.Details())
 		os.Exit(1)
@@ -46,6 +65,15 @@ func main() {
 	}
 }
 
+func isFlagSet(f *flag.FlagSet, name string) (set bool) {
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func run(ctx context.Context, logger *log.Logger, conf config.Config, stdout io.Writer) error {
 	sigs := make(chan os.Signal, 2)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
@@ -53,15 +81,85 @@ func run(ctx context.Context, logger *log.Logger, conf config.Config, stdout io.
 	ctx, cancelTopMostCtx := context.WithCancel(ctx)
 	defer cancelTopMostCtx()
 
-	collector, querier, closer, err := initProfefe(logger, conf)
+	collector, querier, symbolStore, storages, closer, err := initProfefe(logger, conf)
 	if err != nil {
 		return err
 	}
 	defer closer()
 
+	collector.SetRateLimiter(profefe.NewRateLimiter(conf.RateLimit, prometheus.DefaultRegisterer))
+
+	enricher, err := profefe.NewEnricher(conf.Enrich, identityName)
+	if err != nil {
+		return err
+	}
+	if enricher.Enabled() {
+		collector.SetEnricher(enricher)
+	}
+
+	if err := setupRelabeler(ctx, logger, conf, collector); err != nil {
+		return err
+	}
+
+	var symbols *symbolizer.Symbolizer
+	if symbolStore != nil {
+		symbols = symbolizer.New(logger, symbolStore)
+		querier.SetSymbolizer(symbols)
+	}
+
+	tlsConf, err := conf.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	var auth *middleware.Authenticator
+	if conf.Auth.Enabled() {
+		auth, err = conf.Auth.CreateAuthenticator()
+		if err != nil {
+			return err
+		}
+	}
+
 	mux := http.NewServeMux()
 
-	profefe.SetupRoutes(mux, logger, prometheus.DefaultRegisterer, collector, querier)
+	apiMux := http.NewServeMux()
+	profefe.SetupRoutes(apiMux, logger, prometheus.DefaultRegisterer, collector, querier)
+
+	if err := setupScrapeManager(ctx, apiMux, logger, conf, collector); err != nil {
+		return err
+	}
+
+	if err := setupRegressionAnalyzer(ctx, apiMux, logger, conf, querier); err != nil {
+		return err
+	}
+
+	if err := setupAlerting(ctx, apiMux, logger, conf, querier); err != nil {
+		return err
+	}
+
+	if err := setupRecorder(ctx, logger, conf, querier); err != nil {
+		return err
+	}
+
+	if err := setupRetention(ctx, logger, conf, storages); err != nil {
+		return err
+	}
+
+	if err := setupCompaction(ctx, logger, conf, storages, querier); err != nil {
+		return err
+	}
+
+	setupBadgerBackup(apiMux, logger, storages)
+
+	if symbolStore != nil {
+		apiMux.Handle(symbolizer.APISymbolsPath+"/", symbolizer.NewHandler(logger, symbolStore, symbols))
+	}
+
+	apiHandler := middleware.TenantHandler(logger, apiMux)
+	if auth != nil {
+		apiHandler = middleware.AuthHandler(logger, auth, apiHandler)
+	}
+	mux.Handle("/api/", apiHandler)
 
 	setupDebugRoutes(mux)
 
@@ -70,16 +168,58 @@ func run(ctx context.Context, logger *log.Logger, conf config.Config, stdout io.
 	h = middleware.RecoveryHandler(logger, h)
 
 	server := http.Server{
-		Addr:    conf.Addr,
-		Handler: h,
+		Addr:      conf.Addr,
+		Handler:   h,
+		TLSConfig: tlsConf,
 	}
 
-	errc := make(chan error, 1)
+	errc := make(chan error, 2)
 	go func() {
-		logger.Infow("server is running", "addr", server.Addr)
-		errc <- server.ListenAndServe()
+		logger.Infow("server is running", "addr", server.Addr, "tls", tlsConf != nil, "auth", auth != nil)
+		if tlsConf != nil {
+			// certificates are already loaded to TLSConfig
+			errc <- server.ListenAndServeTLS("", "")
+		} else {
+			errc <- server.ListenAndServe()
+		}
 	}()
 
+	var grpcServer *grpc.Server
+	if conf.GRPCAddr != "" {
+		ln, err := net.Listen("tcp", conf.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen grpc addr %q: %w", conf.GRPCAddr, err)
+		}
+
+		var opts []grpc.ServerOption
+		if tlsConf != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+		}
+		var (
+			unaryInterceptors  []grpc.UnaryServerInterceptor
+			streamInterceptors []grpc.StreamServerInterceptor
+		)
+		if auth != nil {
+			unaryInterceptors = append(unaryInterceptors, middleware.AuthUnaryInterceptor(logger, auth))
+			streamInterceptors = append(streamInterceptors, middleware.AuthStreamInterceptor(logger, auth))
+		}
+		// the tenant interceptors go after the auth ones, as the tenant can come from client's identity
+		unaryInterceptors = append(unaryInterceptors, middleware.TenantUnaryInterceptor(logger))
+		streamInterceptors = append(streamInterceptors, middleware.TenantStreamInterceptor(logger))
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(unaryInterceptors...),
+			grpc.ChainStreamInterceptor(streamInterceptors...),
+		)
+
+		grpcServer = grpc.NewServer(opts...)
+		profefepb.RegisterProfefeServer(grpcServer, profefe.NewGRPCServer(logger, collector, querier))
+
+		go func() {
+			logger.Infow("grpc server is running", "addr", conf.GRPCAddr)
+			errc <- grpcServer.Serve(ln)
+		}()
+	}
+
 	if err := setupProfefeAgent(ctx, logger, conf); err != nil {
 		return err
 	}
@@ -90,7 +230,7 @@ func run(ctx context.Context, logger *log.Logger, conf config.Config, stdout io.
 	case <-ctx.Done():
 		logger.Infow("exiting", zap.Error(ctx.Err()))
 	case err := <-errc:
-		if err != http.ErrServerClosed {
+		if err != http.ErrServerClosed && err != grpc.ErrServerStopped {
 			return fmt.Errorf("terminated: %w", err)
 		}
 	}
@@ -101,21 +241,47 @@ func run(ctx context.Context, logger *log.Logger, conf config.Config, stdout io.
 	ctx, cancel := context.WithTimeout(context.Background(), conf.ExitTimeout)
 	defer cancel()
 
+	if grpcServer != nil {
+		shutdownGRPCServer(ctx, grpcServer)
+	}
+
 	return server.Shutdown(ctx)
 }
 
+// stops the server gracefully, forcing it to stop if the context is done before all requests finish
+func shutdownGRPCServer(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
 func initProfefe(
 	logger *log.Logger,
 	conf config.Config,
 ) (
 	collector *profefe.Collector,
 	querier *profefe.Querier,
+	symbolStore storage.SymbolStore,
+	storages map[string]storage.Storage,
 	closer func(),
 	err error,
 ) {
 	stypes, err := conf.StorageType()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, nil, err
+	}
+
+	policies, err := conf.Tenants.Load()
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
 	}
 
 	if len(stypes) > 1 {
@@ -128,7 +294,9 @@ func initProfefe(
 		closers []io.Closer
 	)
 
-	assembleStorage := func(sw storage.Writer, sr storage.Reader, closer io.Closer) {
+	storages = make(map[string]storage.Storage)
+
+	assembleStorage := func(stype string, sw storage.Writer, sr storage.Reader, closer io.Closer) {
 		writers = append(writers, sw)
 		// only the first reader is used
 		if reader == nil {
@@ -137,6 +305,13 @@ func initProfefe(
 		if closer != nil {
 			closers = append(closers, closer)
 		}
+		// symbols are stored to the first storage that supports them
+		if ss, ok := sw.(storage.SymbolStore); ok && symbolStore == nil {
+			symbolStore = ss
+		}
+		if st, ok := sw.(storage.Storage); ok {
+			storages[stype] = st
+		}
 	}
 
 	initStorage := func(stype string) error {
@@ -146,25 +321,46 @@ func initProfefe(
 		case config.StorageTypeBadger:
 			st, closer, err := conf.Badger.CreateStorage(logger)
 			if err == nil {
-				assembleStorage(st, st, closer)
+				st.SetTenantTTL(policies.Retention)
+				assembleStorage(stype, st, st, closer)
 			}
 			return err
 		case config.StorageTypeS3:
 			st, err := conf.S3.CreateStorage(logger)
 			if err == nil {
-				assembleStorage(st, st, nil)
+				assembleStorage(stype, st, st, nil)
 			}
 			return err
 		case config.StorageTypeCH:
-			st, closer, err := conf.ClickHouse.CreateStorage(logger)
+			st, closer, err := conf.ClickHouse.CreateStorage(logger, prometheus.DefaultRegisterer)
 			if err == nil {
-				assembleStorage(st, st, closer)
+				assembleStorage(stype, st, st, closer)
 			}
 			return err
 		case config.StorageTypeGCS:
 			st, err := conf.GCS.CreateStorage(logger)
 			if err == nil {
-				assembleStorage(st, st, nil)
+				assembleStorage(stype, st, st, nil)
+			}
+			return err
+		case config.StorageTypeFS:
+			st, closer, err := conf.FS.CreateStorage(logger)
+			if err == nil {
+				st.SetTenantTTL(policies.Retention)
+				assembleStorage(stype, st, st, closer)
+			}
+			return err
+		case config.StorageTypePG:
+			st, closer, err := conf.Postgres.CreateStorage(logger)
+			if err == nil {
+				st.SetTenantTTL(policies.Retention)
+				assembleStorage(stype, st, st, closer)
+			}
+			return err
+		case config.StorageTypeMemory:
+			st, err := conf.Memory.CreateStorage(logger)
+			if err == nil {
+				assembleStorage(stype, st, st, nil)
 			}
 			return err
 		default:
@@ -174,7 +370,7 @@ func initProfefe(
 
 	for _, stype := range stypes {
 		if err := initStorage(stype); err != nil {
-			return nil, nil, nil, fmt.Errorf("could not init storage %q: %w", stype, err)
+			return nil, nil, nil, nil, nil, fmt.Errorf("could not init storage %q: %w", stype, err)
 		}
 	}
 
@@ -192,7 +388,8 @@ func initProfefe(
 	} else {
 		writer = storage.NewMultiWriter(writers...)
 	}
-	return profefe.NewCollector(logger, writer), profefe.NewQuerier(logger, reader), closer, nil
+	writer = storage.NewQuotaWriter(writer, policies.DailyQuota)
+	return profefe.NewCollector(logger, writer), profefe.NewQuerier(logger, reader), symbolStore, storages, closer, nil
 }
 
 func setupDebugRoutes(mux *http.ServeMux) {
@@ -213,6 +410,232 @@ func setupDebugRoutes(mux *http.ServeMux) {
 	mux.Handle("/debug/metrics", promhttp.Handler())
 }
 
+// returns the name of the client authenticated with the request
+func identityName(ctx context.Context) string {
+	if id := middleware.IdentityFromContext(ctx); id != nil {
+		return id.Name
+	}
+	return ""
+}
+
+// loads relabel rules and reloads them on SIGHUP
+func setupRelabeler(ctx context.Context, logger *log.Logger, conf config.Config, collector *profefe.Collector) error {
+	if conf.Relabel.ConfigFile == "" {
+		return nil
+	}
+
+	logger = logger.With(zap.String("component", "relabel"))
+	relabeler := relabel.NewRelabeler(logger, prometheus.DefaultRegisterer)
+	if err := relabeler.LoadFile(conf.Relabel.ConfigFile); err != nil {
+		return err
+	}
+	collector.SetRelabeler(relabeler)
+
+	reloadOnSIGHUP(ctx, logger, func() error {
+		return relabeler.LoadFile(conf.Relabel.ConfigFile)
+	})
+
+	return nil
+}
+
+// calls reload on every SIGHUP, until the context is done
+func reloadOnSIGHUP(ctx context.Context, logger *log.Logger, reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				if err := reload(); err != nil {
+					logger.Errorw("could not reload config", zap.Error(err))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func setupScrapeManager(ctx context.Context, mux *http.ServeMux, logger *log.Logger, conf config.Config, collector *profefe.Collector) error {
+	if conf.Scrape.ConfigFile == "" {
+		return nil
+	}
+
+	scrapeConf, err := scrape.LoadFile(conf.Scrape.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	logger = logger.With(zap.String("component", "scrape"))
+	mgr := scrape.NewManager(logger, collector, prometheus.DefaultRegisterer)
+	if err := mgr.ApplyConfig(scrapeConf); err != nil {
+		return err
+	}
+	go mgr.Run(ctx)
+
+	mux.Handle(scrape.APITargetsPath, mgr)
+
+	return nil
+}
+
+func setupRegressionAnalyzer(ctx context.Context, mux *http.ServeMux, logger *log.Logger, conf config.Config, querier *profefe.Querier) error {
+	if !conf.Regression.Enabled() {
+		return nil
+	}
+
+	store, err := regression.NewStore(conf.Regression.FindingsFile)
+	if err != nil {
+		return fmt.Errorf("could not load regression findings: %w", err)
+	}
+
+	logger = logger.With(zap.String("component", "regression"))
+	analyzer, err := regression.NewAnalyzer(logger, conf.Regression, querier, store, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	go analyzer.Run(ctx)
+
+	mux.Handle(regression.APIRegressionsPath, regression.NewHandler(logger, store))
+
+	return nil
+}
+
+func setupAlerting(ctx context.Context, mux *http.ServeMux, logger *log.Logger, conf config.Config, querier *profefe.Querier) error {
+	if !conf.Alerting.Enabled() {
+		return nil
+	}
+	if conf.Alerting.Interval <= 0 {
+		return fmt.Errorf("alerting interval must be positive, got %v", conf.Alerting.Interval)
+	}
+
+	logger = logger.With(zap.String("component", "alerting"))
+	mgr := alerting.NewManager(logger, querier, conf.Alerting.Interval, prometheus.DefaultRegisterer)
+	if err := mgr.LoadFile(conf.Alerting.ConfigFile); err != nil {
+		return err
+	}
+	go mgr.Run(ctx)
+
+	reloadOnSIGHUP(ctx, logger, func() error {
+		return mgr.LoadFile(conf.Alerting.ConfigFile)
+	})
+
+	mux.Handle(alerting.APIAlertsPath, alerting.NewHandler(logger, mgr))
+
+	return nil
+}
+
+func setupRecorder(ctx context.Context, logger *log.Logger, conf config.Config, querier *profefe.Querier) error {
+	if !conf.Recording.Enabled() {
+		return nil
+	}
+
+	logger = logger.With(zap.String("component", "recording"))
+	recorder, err := recording.NewRecorder(logger, conf.Recording, querier, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	if err := recorder.LoadFile(conf.Recording.ConfigFile); err != nil {
+		return err
+	}
+	go recorder.Run(ctx)
+
+	reloadOnSIGHUP(ctx, logger, func() error {
+		return recorder.LoadFile(conf.Recording.ConfigFile)
+	})
+
+	return nil
+}
+
+func setupRetention(ctx context.Context, logger *log.Logger, conf config.Config, storages map[string]storage.Storage) error {
+	if !conf.Retention.Enabled() {
+		return nil
+	}
+
+	// retention policies are applied to every storage that can delete profiles
+	sweptStorages := make(map[string]retention.Storage)
+	for stype, st := range storages {
+		if rs, ok := st.(retention.Storage); ok {
+			sweptStorages[stype] = rs
+		}
+	}
+	if len(sweptStorages) == 0 {
+		return fmt.Errorf("retention policies set, but none of the storages can delete profiles")
+	}
+
+	policies, err := conf.Tenants.Load()
+	if err != nil {
+		return err
+	}
+
+	logger = logger.With(zap.String("component", "retention"))
+	sweeper, err := retention.NewSweeper(logger, conf.Retention, sweptStorages, policies, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	if err := sweeper.LoadFile(conf.Retention.ConfigFile); err != nil {
+		return err
+	}
+	go sweeper.Run(ctx)
+
+	reloadOnSIGHUP(ctx, logger, func() error {
+		return sweeper.LoadFile(conf.Retention.ConfigFile)
+	})
+
+	return nil
+}
+
+func setupCompaction(ctx context.Context, logger *log.Logger, conf config.Config, storages map[string]storage.Storage, querier *profefe.Querier) error {
+	if !conf.Compaction.Enabled() {
+		return nil
+	}
+
+	// the profiles are compacted in every storage that can delete profiles
+	compactedStorages := make(map[string]compaction.Storage)
+	for stype, st := range storages {
+		if cs, ok := st.(compaction.Storage); ok {
+			compactedStorages[stype] = cs
+		}
+	}
+	if len(compactedStorages) == 0 {
+		return fmt.Errorf("compaction levels set, but none of the storages can delete profiles")
+	}
+
+	policies, err := conf.Tenants.Load()
+	if err != nil {
+		return err
+	}
+
+	logger = logger.With(zap.String("component", "compaction"))
+	compactor, err := compaction.NewCompactor(logger, conf.Compaction, compactedStorages, policies, prometheus.DefaultRegisterer)
+	if err != nil {
+		return err
+	}
+	if err := compactor.LoadFile(conf.Compaction.ConfigFile); err != nil {
+		return err
+	}
+	go compactor.Run(ctx)
+
+	reloadOnSIGHUP(ctx, logger, func() error {
+		return compactor.LoadFile(conf.Compaction.ConfigFile)
+	})
+
+	querier.SetResolutionSelector(compaction.SelectResolution)
+
+	return nil
+}
+
+// serves the backups of badger storage, if it's configured
+func setupBadgerBackup(mux *http.ServeMux, logger *log.Logger, storages map[string]storage.Storage) {
+	st, ok := storages[config.StorageTypeBadger].(*storageBadger.Storage)
+	if !ok {
+		return
+	}
+	h := storageBadger.NewBackupHandler(logger.With(zap.String("component", "badger-backup")), st)
+	mux.Handle(storageBadger.APIBackupPath, h)
+	mux.Handle(storageBadger.APIRestorePath, h)
+}
+
 func setupProfefeAgent(ctx context.Context, logger *log.Logger, conf config.Config) error {
 	logger = logger.With(zap.String("component", "profefe-agent"))
 	return conf.AgentConfig.Start(ctx, logger)
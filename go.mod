@@ -9,7 +9,9 @@ require (
 	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/dgraph-io/badger v1.6.0
 	github.com/frankban/quicktest v1.7.2 // indirect
+	github.com/golang/protobuf v1.4.2
 	github.com/kr/pretty v0.2.0 // indirect
+	github.com/lib/pq v1.3.0
 	github.com/pierrec/lz4 v2.4.1+incompatible // indirect
 	github.com/prometheus/client_golang v1.1.0
 	github.com/prometheus/common v0.9.1 // indirect
@@ -18,7 +20,9 @@ require (
 	github.com/stretchr/testify v1.5.1
 	go.uber.org/zap v1.16.0
 	google.golang.org/api v0.32.0
+	google.golang.org/grpc v1.32.0
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 	honnef.co/go/tools v0.0.1-2020.1.4
 )
@@ -18,6 +18,9 @@ const (
 
 type Config struct {
 	CollectorAddr string
+	GRPC          bool   `json:",omitempty"`
+	AuthToken     string `json:"-"`
+	Tenant        string `json:",omitempty"`
 	Service       string
 	Labels        profile.Labels `json:",omitempty"`
 
@@ -34,6 +37,9 @@ type Config struct {
 
 func (conf *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&conf.CollectorAddr, "profefe.agent.collector-addr", "", "profefe collector public address to send profiling data")
+	f.BoolVar(&conf.GRPC, "profefe.agent.grpc", false, "send profiling data via collector's gRPC API (collector address must point to gRPC server)")
+	f.StringVar(&conf.AuthToken, "profefe.agent.auth-token", "", "token to authenticate with the collector")
+	f.StringVar(&conf.Tenant, "profefe.agent.tenant", "", "tenant to store profiling data under")
 	f.StringVar(&conf.Service, "profefe.agent.service-name", "profefe", "application service name")
 
 	labels := (*labelsValue)(&conf.Labels)
@@ -87,6 +93,16 @@ func (conf *Config) options() (opts []agent.Option) {
 
 	opts = append(opts, agent.WithLabels(labels...))
 
+	if conf.GRPC {
+		opts = append(opts, agent.WithGRPCTransport())
+	}
+	if conf.AuthToken != "" {
+		opts = append(opts, agent.WithAuthToken(conf.AuthToken))
+	}
+	if conf.Tenant != "" {
+		opts = append(opts, agent.WithTenant(conf.Tenant))
+	}
+
 	if conf.TickInterval != 0 {
 		opts = append(opts, agent.WithTickInterval(conf.TickInterval))
 	}
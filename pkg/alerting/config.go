@@ -0,0 +1,107 @@
+package alerting
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const defaultInterval = time.Minute
+
+type Config struct {
+	ConfigFile string
+	Interval   time.Duration
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "alerts.config-file", "", "path to YAML file with alerting rules (reloaded on SIGHUP, alerting is disabled if empty)")
+	f.DurationVar(&conf.Interval, "alerts.interval", defaultInterval, "how often to evaluate alerting rules")
+}
+
+// Enabled reports whether the rules must be evaluated.
+func (conf *Config) Enabled() bool {
+	return conf.ConfigFile != ""
+}
+
+// FileConfig is the configuration of alerting, loaded from a YAML file.
+//
+// Example:
+//
+//	alertmanagers:
+//	  - http://alertmanager:9093
+//	webhooks:
+//	  - https://hooks.example.com/profefe
+//	rules:
+//	  # JSON encoding takes more than 15% of CPU time of the service
+//	  - alert: HotJSONEncoding
+//	    service: api-backend
+//	    type: cpu
+//	    window: 30m
+//	    function: encoding/json\..*
+//	    threshold: 15%
+//	    for: 10m
+//	    labels:
+//	      severity: warning
+//	    annotations:
+//	      summary: encoding/json is hot in api-backend
+//	  # the objects allocated by the cache package hold more than 2GB of heap
+//	  - alert: CacheHeapInUse
+//	    service: api-backend
+//	    type: heap
+//	    sample_type: inuse_space
+//	    function: github.com/example/cache\..*
+//	    threshold: 2GB
+type FileConfig struct {
+	// the base URLs of Alertmanagers the firing alerts are sent to
+	Alertmanagers []string `yaml:"alertmanagers,omitempty"`
+	// the URLs the changes of alerts' states are POSTed to
+	Webhooks []string `yaml:"webhooks,omitempty"`
+	Rules    []*Rule  `yaml:"rules"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+
+	for _, rawURL := range append(conf.Alertmanagers, conf.Webhooks...) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("bad receiver URL %q: only http and https are supported", rawURL)
+		}
+	}
+
+	seen := make(map[ruleKey]bool, len(conf.Rules))
+	for n, r := range conf.Rules {
+		if r == nil {
+			return nil, fmt.Errorf("empty alerting rule %d", n)
+		}
+		if seen[r.key()] {
+			return nil, fmt.Errorf("duplicate alerting rule %q for service %q", r.Alert, r.Service)
+		}
+		seen[r.key()] = true
+	}
+	return conf, nil
+}
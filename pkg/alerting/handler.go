@@ -0,0 +1,57 @@
+package alerting
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+const APIAlertsPath = "/api/0/alerts"
+
+// Handler serves the alerts of the tenant, e.g. "GET /api/0/alerts?service=<service>&state=<state>".
+type Handler struct {
+	logger  *log.Logger
+	manager *Manager
+}
+
+func NewHandler(logger *log.Logger, manager *Manager) *Handler {
+	return &Handler{
+		logger:  logger,
+		manager: manager,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.URL.Path != APIAlertsPath {
+		err = profefe.ErrNotFound
+	} else if r.Method != http.MethodGet {
+		err = profefe.StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+	} else {
+		err = h.HandleListAlerts(w, r)
+	}
+	profefe.HandleErrorHTTP(h.logger, err, w, r)
+}
+
+func (h *Handler) HandleListAlerts(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+
+	service, state := q.Get("service"), q.Get("state")
+	switch state {
+	case "", StateInactive, StatePending, StateFiring:
+	default:
+		return profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: bad \"state\" %q", state), nil)
+	}
+
+	alerts := h.manager.Alerts(tenant.FromContext(r.Context()), func(a *Alert) bool {
+		return (service == "" || a.Service == service) &&
+			(state == "" || a.State == state)
+	})
+
+	profefe.ReplyJSON(w, alerts)
+
+	return nil
+}
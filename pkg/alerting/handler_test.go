@@ -0,0 +1,86 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	testLogger := log.New(zaptest.NewLogger(t))
+	m := NewManager(testLogger, profefe.NewQuerier(testLogger, &storage.StubReader{}), time.Minute, prometheus.NewRegistry())
+
+	conf, err := Load([]byte(`
+rules:
+  - {alert: a, service: svc1, type: cpu, function: main, threshold: 10%}
+  - {alert: b, service: svc1, type: heap, function: main, threshold: 1GB}
+  - {alert: a, service: svc2, type: cpu, function: main, threshold: 10%}
+  - {alert: a, tenant: t1, service: svc1, type: cpu, function: main, threshold: 10%}
+`))
+	require.NoError(t, err)
+	m.ApplyConfig(conf)
+	m.rules[1].alert.State = StateFiring
+
+	h := NewHandler(testLogger, m)
+
+	cases := []struct {
+		tenant       string
+		query        string
+		wantCode     int
+		wantServices []string
+	}{
+		{"", "", http.StatusOK, []string{"a/svc1", "a/svc2", "b/svc1"}},
+		{"", "service=svc1", http.StatusOK, []string{"a/svc1", "b/svc1"}},
+		{"", "state=firing", http.StatusOK, []string{"b/svc1"}},
+		{"", "state=unknown", http.StatusBadRequest, nil},
+		{"t1", "", http.StatusOK, []string{"a/svc1"}},
+		{"t2", "", http.StatusOK, []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.tenant+"?"+tc.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, APIAlertsPath+"?"+tc.query, nil)
+			req = req.WithContext(tenant.ContextWithTenant(req.Context(), tc.tenant))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			require.Equal(t, tc.wantCode, resp.Code, resp.Body.String())
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Body []Alert `json:"body"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+			alerts := []string{}
+			for _, a := range body.Body {
+				alerts = append(alerts, a.Name+"/"+a.Service)
+			}
+			assert.Equal(t, tc.wantServices, alerts)
+		})
+	}
+}
+
+func TestHandler_ServeHTTP_methodNotAllowed(t *testing.T) {
+	testLogger := log.New(zaptest.NewLogger(t))
+	m := NewManager(testLogger, profefe.NewQuerier(testLogger, &storage.StubReader{}), time.Minute, prometheus.NewRegistry())
+	h := NewHandler(testLogger, m)
+
+	req := httptest.NewRequest(http.MethodPost, APIAlertsPath, nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.Code)
+}
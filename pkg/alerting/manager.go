@@ -0,0 +1,293 @@
+// Package alerting implements the rules, that are periodically evaluated against the merged profiles of services,
+// and the notifications about the alerts they fire.
+package alerting
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+)
+
+// Alert is the state of an alerting rule.
+type Alert struct {
+	Name        string            `json:"name"`
+	Tenant      string            `json:"tenant,omitempty"`
+	Service     string            `json:"service"`
+	Type        string            `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Threshold   string            `json:"threshold"`
+
+	State string `json:"state"`
+	// the value of the last successful evaluation
+	Value float64 `json:"value"`
+	// when the threshold was exceeded for the first time, if the alert is pending or firing
+	ActiveAt *time.Time `json:"active_at,omitempty"`
+	// when the alert started firing, if the alert is firing
+	FiringAt       *time.Time `json:"firing_at,omitempty"`
+	LastEvaluation time.Time  `json:"last_evaluation"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+type ruleState struct {
+	rule  *Rule
+	alert Alert
+}
+
+// Manager evaluates the alerting rules and sends the notifications about the changes of alerts.
+// The rules can be replaced at runtime.
+type Manager struct {
+	logger   *log.Logger
+	querier  *profefe.Querier
+	notifier *notifier
+	interval time.Duration
+
+	mu    sync.Mutex
+	rules []*ruleState
+	conf  *FileConfig
+
+	evalFailuresTotal prometheus.Counter
+	alertsGauge       *prometheus.GaugeVec
+	reloadStatus      prometheus.Gauge
+
+	now func() time.Time
+}
+
+func NewManager(logger *log.Logger, querier *profefe.Querier, interval time.Duration, registry prometheus.Registerer) *Manager {
+	m := &Manager{
+		logger:   logger,
+		querier:  querier,
+		interval: interval,
+		conf:     &FileConfig{},
+		evalFailuresTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "alerting_rule_evaluation_failures_total",
+			Help:      "Number of failed evaluations of alerting rules.",
+		}),
+		alertsGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "alerts",
+			Help:      "Number of alerts by state.",
+		}, []string{"state"}),
+		reloadStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "alerting_config_last_reload_successful",
+			Help:      "Whether the last reload of alerting config was successful.",
+		}),
+		now: time.Now,
+	}
+	m.notifier = newNotifier(logger, registry)
+	registry.MustRegister(m.evalFailuresTotal, m.alertsGauge, m.reloadStatus)
+	return m
+}
+
+// ApplyConfig replaces the rules and the receivers with the ones from the config.
+// The states of the rules, that are kept, are preserved.
+func (m *Manager) ApplyConfig(conf *FileConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	known := make(map[ruleKey]*ruleState, len(m.rules))
+	for _, rs := range m.rules {
+		known[rs.rule.key()] = rs
+	}
+
+	rules := make([]*ruleState, 0, len(conf.Rules))
+	for _, r := range conf.Rules {
+		rs := &ruleState{
+			rule: r,
+			alert: Alert{
+				Name:        r.Alert,
+				Tenant:      r.Tenant,
+				Service:     r.Service,
+				Type:        r.ptype.String(),
+				Labels:      r.Labels,
+				Annotations: r.Annotations,
+				Threshold:   r.Threshold.String(),
+				State:       StateInactive,
+			},
+		}
+		if prev, ok := known[r.key()]; ok {
+			rs.alert.State = prev.alert.State
+			rs.alert.Value = prev.alert.Value
+			rs.alert.ActiveAt = prev.alert.ActiveAt
+			rs.alert.FiringAt = prev.alert.FiringAt
+			rs.alert.LastEvaluation = prev.alert.LastEvaluation
+			rs.alert.LastError = prev.alert.LastError
+		}
+		rules = append(rules, rs)
+	}
+	m.rules = rules
+	m.conf = conf
+	m.updateGaugeLocked()
+
+	m.logger.Infow("alerting rules applied", "rules", len(conf.Rules))
+}
+
+// LoadFile loads the config from the file and applies it. The current rules are kept, if the config
+// couldn't be loaded.
+func (m *Manager) LoadFile(fileName string) error {
+	conf, err := LoadFile(fileName)
+	if err != nil {
+		m.reloadStatus.Set(0)
+		return err
+	}
+	m.ApplyConfig(conf)
+	m.reloadStatus.Set(1)
+	return nil
+}
+
+// Run evaluates the rules every interval, until the context is done.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evaluate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// evaluates every rule and notifies the receivers
+func (m *Manager) evaluate(ctx context.Context) {
+	m.mu.Lock()
+	rules, conf := m.rules, m.conf
+	m.mu.Unlock()
+
+	now := m.now().UTC()
+
+	var changed, active []Alert
+	for _, rs := range rules {
+		value, err := m.evaluateRule(ctx, rs.rule, now)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.mu.Lock()
+		prevState, prevFiringAt := rs.alert.State, rs.alert.FiringAt
+		rs.alert.LastEvaluation = now
+		if err != nil {
+			m.logger.Errorw("could not evaluate alerting rule", "alert", rs.rule.Alert, "tenant", rs.rule.Tenant, "service", rs.rule.Service, zap.Error(err))
+			m.evalFailuresTotal.Inc()
+			rs.alert.LastError = err.Error()
+		} else {
+			rs.alert.LastError = ""
+			rs.alert.Value = value
+			rs.transition(rs.rule.Threshold.Exceeded(value), now)
+		}
+		alert := rs.alert
+		m.mu.Unlock()
+
+		if prevState == StateFiring && alert.State != StateFiring {
+			// the resolved alert is reported with the time it started firing at
+			alert.FiringAt = prevFiringAt
+		}
+		if alert.State != prevState && (alert.State == StateFiring || prevState == StateFiring) {
+			changed = append(changed, alert)
+		}
+		if alert.State == StateFiring || prevState == StateFiring {
+			active = append(active, alert)
+		}
+	}
+
+	m.mu.Lock()
+	m.updateGaugeLocked()
+	m.mu.Unlock()
+
+	// the notifications must not outlive the evaluation interval
+	ctx, cancel := context.WithTimeout(ctx, m.interval)
+	defer cancel()
+	m.notifier.notify(ctx, conf, now, m.interval, changed, active)
+}
+
+// returns the value of the rule, computed from the profiles merged the same way the profiles API merges them
+func (m *Manager) evaluateRule(ctx context.Context, r *Rule, now time.Time) (float64, error) {
+	params := &storage.FindProfilesParams{
+		Tenant:       r.Tenant,
+		Service:      r.Service,
+		Type:         r.ptype,
+		Labels:       r.profileLabels(),
+		CreatedAtMin: now.Add(-r.Window),
+		CreatedAtMax: now,
+	}
+	pp, n, err := m.querier.FindMergeProfile(ctx, params)
+	if err == storage.ErrNotFound {
+		// no profiles, nothing to alert about
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return r.value(pp, n)
+}
+
+// moves the alert to the next state, depending on whether the threshold is exceeded
+func (rs *ruleState) transition(exceeded bool, now time.Time) {
+	if !exceeded {
+		rs.alert.State = StateInactive
+		rs.alert.ActiveAt = nil
+		rs.alert.FiringAt = nil
+		return
+	}
+
+	if rs.alert.State == StateInactive {
+		rs.alert.State = StatePending
+		rs.alert.ActiveAt = &now
+	}
+	if rs.alert.State == StatePending && now.Sub(*rs.alert.ActiveAt) >= rs.rule.For {
+		rs.alert.State = StateFiring
+		rs.alert.FiringAt = &now
+	}
+}
+
+func (m *Manager) updateGaugeLocked() {
+	counts := map[string]int{
+		StateInactive: 0,
+		StatePending:  0,
+		StateFiring:   0,
+	}
+	for _, rs := range m.rules {
+		counts[rs.alert.State]++
+	}
+	for state, n := range counts {
+		m.alertsGauge.WithLabelValues(state).Set(float64(n))
+	}
+}
+
+// Alerts returns the alerts of the tenant, that match the filter, ordered by name and service.
+func (m *Manager) Alerts(tenant string, match func(a *Alert) bool) []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0)
+	for _, rs := range m.rules {
+		alert := rs.alert
+		if alert.Tenant != tenant || (match != nil && !match(&alert)) {
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].Name != alerts[j].Name {
+			return alerts[i].Name < alerts[j].Name
+		}
+		return alerts[i].Service < alerts[j].Service
+	})
+	return alerts
+}
@@ -0,0 +1,288 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type testProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) { return bytes.NewReader(pl.data), nil }
+
+func (pl *testProfileList) Close() error { return nil }
+
+// returns the reader, that finds the current profiles of the service
+func newTestReader(t *testing.T, service string, current func() []*pprofProfile.Profile) *storage.StubReader {
+	return &storage.StubReader{
+		FindProfileIDsFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+			if params.Service != service || len(current()) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			pids := make([]profile.ID, len(current()))
+			for i := range pids {
+				pids[i] = profile.ID(service)
+			}
+			return pids, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &testProfileList{}
+			for _, pp := range current() {
+				var buf bytes.Buffer
+				require.NoError(t, pp.Write(&buf))
+				list.profiles = append(list.profiles, buf.Bytes())
+			}
+			return list, nil
+		},
+	}
+}
+
+// testReceiver stands in for Alertmanager and webhook receivers
+type testReceiver struct {
+	mu              sync.Mutex
+	alertmanager    [][]notification
+	webhookStatuses []string
+	webhookAlerts   [][]notification
+}
+
+func (rc *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	switch r.URL.Path {
+	case alertmanagerAlertsPath:
+		var alerts []notification
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rc.alertmanager = append(rc.alertmanager, alerts)
+	case "/webhook":
+		var body struct {
+			Status string         `json:"status"`
+			Alerts []notification `json:"alerts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rc.webhookStatuses = append(rc.webhookStatuses, body.Status)
+		rc.webhookAlerts = append(rc.webhookAlerts, body.Alerts)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (rc *testReceiver) reset() {
+	rc.mu.Lock()
+	rc.alertmanager, rc.webhookStatuses, rc.webhookAlerts = nil, nil, nil
+	rc.mu.Unlock()
+}
+
+func TestManager_evaluate(t *testing.T) {
+	rc := &testReceiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	conf, err := Load([]byte(`
+alertmanagers: [` + srv.URL + `]
+webhooks: [` + srv.URL + `/webhook]
+rules:
+  - alert: CacheHeap
+    service: svc1
+    type: heap
+    function: cache\..*
+    threshold: 50%
+    for: 2m
+    labels:
+      severity: warning
+    annotations:
+      summary: cache holds too much
+`))
+	require.NoError(t, err)
+
+	var profiles []*pprofProfile.Profile
+	sr := newTestReader(t, "svc1", func() []*pprofProfile.Profile { return profiles })
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	registry := prometheus.NewRegistry()
+	m := NewManager(testLogger, profefe.NewQuerier(testLogger, sr), time.Minute, registry)
+	m.ApplyConfig(conf)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	evaluate := func(wantState string) Alert {
+		t.Helper()
+		rc.reset()
+		m.evaluate(context.Background())
+		alerts := m.Alerts("", nil)
+		require.Len(t, alerts, 1)
+		assert.Equal(t, wantState, alerts[0].State)
+		assert.Empty(t, alerts[0].LastError)
+		assert.Equal(t, now, alerts[0].LastEvaluation)
+		return alerts[0]
+	}
+
+	// no profiles
+	evaluate(StateInactive)
+	assert.Empty(t, rc.alertmanager)
+	assert.Empty(t, rc.webhookStatuses)
+
+	profiles = []*pprofProfile.Profile{
+		newTestProfile(map[string][]int64{"cache.Set;main.main": {0, 80}, "main.main": {0, 20}}),
+		newTestProfile(map[string][]int64{"cache.Set;main.main": {0, 40}, "main.main": {0, 60}}),
+	}
+	alert := evaluate(StatePending)
+	assert.InDelta(t, 0.6, alert.Value, 1e-9)
+	assert.Equal(t, now, *alert.ActiveAt)
+	assert.Empty(t, rc.alertmanager)
+	assert.Empty(t, rc.webhookStatuses)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.alertsGauge.WithLabelValues(StatePending)))
+
+	now = now.Add(time.Minute)
+	evaluate(StatePending)
+
+	firingAt := now.Add(time.Minute)
+	now = firingAt
+	alert = evaluate(StateFiring)
+	assert.Equal(t, firingAt, *alert.FiringAt)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.alertsGauge.WithLabelValues(StateFiring)))
+
+	wantLabels := map[string]string{
+		"alertname": "CacheHeap",
+		"service":   "svc1",
+		"type":      "heap",
+		"severity":  "warning",
+	}
+	require.Len(t, rc.alertmanager, 1)
+	require.Len(t, rc.alertmanager[0], 1)
+	amAlert := rc.alertmanager[0][0]
+	assert.Empty(t, amAlert.Status)
+	assert.Equal(t, wantLabels, amAlert.Labels)
+	assert.Equal(t, "cache holds too much", amAlert.Annotations["summary"])
+	assert.Equal(t, "0.6", amAlert.Annotations["value"])
+	assert.Equal(t, "50%", amAlert.Annotations["threshold"])
+	assert.Equal(t, firingAt, amAlert.StartsAt)
+	assert.Equal(t, now.Add(4*time.Minute), amAlert.EndsAt)
+
+	require.Equal(t, []string{notificationStatusFiring}, rc.webhookStatuses)
+	require.Len(t, rc.webhookAlerts[0], 1)
+	assert.Equal(t, notificationStatusFiring, rc.webhookAlerts[0][0].Status)
+	assert.Equal(t, wantLabels, rc.webhookAlerts[0][0].Labels)
+
+	// the firing alert is resent to alertmanager, but not to webhooks
+	now = now.Add(time.Minute)
+	evaluate(StateFiring)
+	require.Len(t, rc.alertmanager, 1)
+	assert.Equal(t, now.Add(4*time.Minute), rc.alertmanager[0][0].EndsAt)
+	assert.Empty(t, rc.webhookStatuses)
+
+	// the threshold isn't exceeded anymore
+	profiles = profiles[1:]
+	now = now.Add(time.Minute)
+	alert = evaluate(StateInactive)
+	assert.InDelta(t, 0.4, alert.Value, 1e-9)
+	assert.Nil(t, alert.ActiveAt)
+	assert.Nil(t, alert.FiringAt)
+
+	require.Len(t, rc.alertmanager, 1)
+	assert.Equal(t, firingAt, rc.alertmanager[0][0].StartsAt)
+	assert.Equal(t, now, rc.alertmanager[0][0].EndsAt)
+	require.Equal(t, []string{notificationStatusResolved}, rc.webhookStatuses)
+	assert.Equal(t, notificationStatusResolved, rc.webhookAlerts[0][0].Status)
+	assert.Equal(t, now, rc.webhookAlerts[0][0].EndsAt)
+
+	now = now.Add(time.Minute)
+	evaluate(StateInactive)
+	assert.Empty(t, rc.alertmanager)
+	assert.Empty(t, rc.webhookStatuses)
+}
+
+func TestManager_evaluate_error(t *testing.T) {
+	conf, err := Load([]byte(`
+rules:
+  - alert: HeapDuration
+    service: svc1
+    type: heap
+    function: main\..*
+    threshold: 1s
+`))
+	require.NoError(t, err)
+
+	profiles := []*pprofProfile.Profile{
+		newTestProfile(map[string][]int64{"main.main": {0, 80}}),
+	}
+	sr := newTestReader(t, "svc1", func() []*pprofProfile.Profile { return profiles })
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	m := NewManager(testLogger, profefe.NewQuerier(testLogger, sr), time.Minute, prometheus.NewRegistry())
+	m.ApplyConfig(conf)
+
+	m.evaluate(context.Background())
+
+	alerts := m.Alerts("", nil)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateInactive, alerts[0].State)
+	assert.NotEmpty(t, alerts[0].LastError)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.evalFailuresTotal))
+}
+
+func TestManager_ApplyConfig_keepsState(t *testing.T) {
+	testLogger := log.New(zaptest.NewLogger(t))
+	m := NewManager(testLogger, profefe.NewQuerier(testLogger, &storage.StubReader{}), time.Minute, prometheus.NewRegistry())
+
+	conf, err := Load([]byte(`
+rules:
+  - {alert: a, service: svc1, type: cpu, function: main, threshold: 10%}
+  - {alert: b, service: svc1, type: cpu, function: main, threshold: 10%}
+`))
+	require.NoError(t, err)
+	m.ApplyConfig(conf)
+	m.rules[0].alert.State = StateFiring
+	m.rules[1].alert.State = StateFiring
+
+	conf, err = Load([]byte(`
+rules:
+  - {alert: a, service: svc1, type: cpu, function: main, threshold: 20%}
+  - {alert: c, service: svc1, type: cpu, function: main, threshold: 10%}
+`))
+	require.NoError(t, err)
+	m.ApplyConfig(conf)
+
+	alerts := m.Alerts("", nil)
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "a", alerts[0].Name)
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, "20%", alerts[0].Threshold)
+	assert.Equal(t, "c", alerts[1].Name)
+	assert.Equal(t, StateInactive, alerts[1].State)
+}
@@ -0,0 +1,169 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	alertmanagerAlertsPath = "/api/v2/alerts"
+
+	notificationStatusFiring   = "firing"
+	notificationStatusResolved = "resolved"
+
+	notifyTimeout = 10 * time.Second
+)
+
+// notification is the alert in the format of Alertmanager API. The webhooks also receive the status
+// of the alert, the same way Alertmanager's webhook receivers do.
+type notification struct {
+	Status      string            `json:"status,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt,omitempty"`
+}
+
+func newNotification(alert Alert) notification {
+	n := notification{
+		Status:      notificationStatusResolved,
+		Labels:      make(map[string]string, len(alert.Labels)+4),
+		Annotations: make(map[string]string, len(alert.Annotations)+2),
+	}
+	if alert.State == StateFiring {
+		n.Status = notificationStatusFiring
+	}
+
+	for k, v := range alert.Labels {
+		n.Labels[k] = v
+	}
+	n.Labels["alertname"] = alert.Name
+	n.Labels["service"] = alert.Service
+	n.Labels["type"] = alert.Type
+	if alert.Tenant != "" {
+		n.Labels["tenant"] = alert.Tenant
+	}
+
+	for k, v := range alert.Annotations {
+		n.Annotations[k] = v
+	}
+	if _, ok := n.Annotations["value"]; !ok {
+		n.Annotations["value"] = strconv.FormatFloat(alert.Value, 'g', -1, 64)
+	}
+	if _, ok := n.Annotations["threshold"]; !ok {
+		n.Annotations["threshold"] = alert.Threshold
+	}
+
+	if alert.FiringAt != nil {
+		n.StartsAt = *alert.FiringAt
+	}
+	return n
+}
+
+type notifier struct {
+	logger *log.Logger
+	client *http.Client
+
+	failuresTotal *prometheus.CounterVec
+}
+
+func newNotifier(logger *log.Logger, registry prometheus.Registerer) *notifier {
+	n := &notifier{
+		logger: logger,
+		client: &http.Client{Timeout: notifyTimeout},
+		failuresTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "alerting_notifications_failed_total",
+			Help:      "Number of failed notifications about alerts.",
+		}, []string{"receiver"}),
+	}
+	registry.MustRegister(n.failuresTotal)
+	return n
+}
+
+// notify sends the firing and just resolved alerts to every Alertmanager, and the alerts, that started
+// firing or were resolved, to every webhook.
+//
+// Alertmanager expects the firing alerts to be resent periodically; the firing alerts are sent
+// with the end time several evaluation intervals ahead, so Alertmanager resolves them by itself,
+// if the collector stops sending them.
+func (n *notifier) notify(ctx context.Context, conf *FileConfig, now time.Time, interval time.Duration, changed, active []Alert) {
+	if len(active) > 0 && len(conf.Alertmanagers) > 0 {
+		alerts := make([]notification, 0, len(active))
+		for _, alert := range active {
+			a := newNotification(alert)
+			if a.Status == notificationStatusFiring {
+				a.EndsAt = now.Add(4 * interval)
+			} else {
+				a.EndsAt = now
+			}
+			a.Status = ""
+			alerts = append(alerts, a)
+		}
+		for _, u := range conf.Alertmanagers {
+			if err := n.post(ctx, strings.TrimSuffix(u, "/")+alertmanagerAlertsPath, alerts); err != nil {
+				n.logger.Errorw("could not send alerts to alertmanager", "url", u, zap.Error(err))
+				n.failuresTotal.WithLabelValues("alertmanager").Inc()
+			}
+		}
+	}
+
+	if len(changed) > 0 && len(conf.Webhooks) > 0 {
+		body := struct {
+			Status string         `json:"status"`
+			Alerts []notification `json:"alerts"`
+		}{
+			Status: notificationStatusResolved,
+			Alerts: make([]notification, 0, len(changed)),
+		}
+		for _, alert := range changed {
+			a := newNotification(alert)
+			if a.Status == notificationStatusFiring {
+				body.Status = notificationStatusFiring
+			} else {
+				a.EndsAt = now
+			}
+			body.Alerts = append(body.Alerts, a)
+		}
+		for _, u := range conf.Webhooks {
+			if err := n.post(ctx, u, body); err != nil {
+				n.logger.Errorw("could not send alerts to webhook", "url", u, zap.Error(err))
+				n.failuresTotal.WithLabelValues("webhook").Inc()
+			}
+		}
+	}
+}
+
+func (n *notifier) post(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package alerting
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+const defaultWindow = 30 * time.Minute
+
+// Rule is an alerting rule, that fires when the samples of the service's profiles, attributed
+// to the functions matching the regexp, exceed the threshold.
+type Rule struct {
+	Alert   string `yaml:"alert"`
+	Tenant  string `yaml:"tenant,omitempty"`
+	Service string `yaml:"service"`
+	Type    string `yaml:"type"`
+	// the labels the profiles are selected by
+	ProfileLabels map[string]string `yaml:"profile_labels,omitempty"`
+	// the profiles created within the window before the evaluation are merged
+	Window time.Duration `yaml:"window,omitempty"`
+	// the functions, which samples are summed up; the function is matched against the whole regexp
+	Function relabel.Regexp `yaml:"function"`
+	// whether only the samples of the functions themselves are summed up; by default, the samples
+	// of every function called by the matching functions are summed up as well
+	Flat bool `yaml:"flat,omitempty"`
+	// the sample type of the profile, e.g. "inuse_space"; the default sample type of the profile if empty
+	SampleType string `yaml:"sample_type,omitempty"`
+	// the value, that fires the alert when exceeded, e.g. "15%", "2GB", "500ms" or "1000"
+	Threshold Threshold `yaml:"threshold"`
+	// how long the threshold must be exceeded before the alert fires
+	For         time.Duration     `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+
+	ptype profile.ProfileType
+}
+
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return r.validate()
+}
+
+func (r *Rule) validate() error {
+	if r.Alert == "" {
+		return fmt.Errorf("alerting rule requires alert name")
+	}
+	if err := tenant.ValidateName(r.Tenant); err != nil {
+		return fmt.Errorf("alerting rule %q: %w", r.Alert, err)
+	}
+	if r.Service == "" {
+		return fmt.Errorf("alerting rule %q requires service", r.Alert)
+	}
+	r.ptype.FromString(r.Type)
+	if r.ptype == profile.TypeUnknown || r.ptype == profile.TypeTrace {
+		return fmt.Errorf("alerting rule %q: unsupported profile type %q", r.Alert, r.Type)
+	}
+	if r.Window == 0 {
+		r.Window = defaultWindow
+	} else if r.Window < 0 {
+		return fmt.Errorf("alerting rule %q: window must be positive, got %v", r.Alert, r.Window)
+	}
+	if r.Function.Regexp == nil {
+		return fmt.Errorf("alerting rule %q requires function", r.Alert)
+	}
+	if r.Threshold.kind == thresholdUnset {
+		return fmt.Errorf("alerting rule %q requires threshold", r.Alert)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("alerting rule %q: for must not be negative, got %v", r.Alert, r.For)
+	}
+	return nil
+}
+
+func (r *Rule) key() ruleKey {
+	return ruleKey{
+		alert:   r.Alert,
+		tenant:  r.Tenant,
+		service: r.Service,
+	}
+}
+
+type ruleKey struct {
+	alert, tenant, service string
+}
+
+func (r *Rule) profileLabels() profile.Labels {
+	labels := make(profile.Labels, 0, len(r.ProfileLabels))
+	for k, v := range r.ProfileLabels {
+		labels = append(labels, profile.Label{Key: k, Value: v})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Key < labels[j].Key
+	})
+	return labels
+}
+
+// value returns the value the threshold is compared with: the share of the samples of the matching functions
+// in the merged profile for percent thresholds, or their average value per merged profile otherwise.
+func (r *Rule) value(pp *pprofProfile.Profile, n int) (float64, error) {
+	idx, err := r.sampleValueIndex(pp)
+	if err != nil {
+		return 0, err
+	}
+
+	var total, matched int64
+	for _, s := range pp.Sample {
+		v := s.Value[idx]
+		total += v
+		if r.matchSample(s) {
+			matched += v
+		}
+	}
+
+	if r.Threshold.kind == thresholdPercent {
+		if total == 0 {
+			return 0, nil
+		}
+		return float64(matched) / float64(total), nil
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return float64(matched) / float64(n), nil
+}
+
+func (r *Rule) sampleValueIndex(pp *pprofProfile.Profile) (int, error) {
+	if len(pp.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+
+	idx := len(pp.SampleType) - 1
+	if r.SampleType != "" {
+		idx = -1
+		for i, st := range pp.SampleType {
+			if st.Type == r.SampleType {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return 0, fmt.Errorf("profile has no sample type %q", r.SampleType)
+		}
+	}
+
+	if unit := r.Threshold.unit(); unit != "" && pp.SampleType[idx].Unit != unit {
+		return 0, fmt.Errorf("threshold %q doesn't match unit %q of sample type %q", r.Threshold, pp.SampleType[idx].Unit, pp.SampleType[idx].Type)
+	}
+	return idx, nil
+}
+
+// reports whether the sample is attributed to the matching functions
+func (r *Rule) matchSample(s *pprofProfile.Sample) bool {
+	if r.Flat {
+		if len(s.Location) == 0 {
+			return false
+		}
+		return r.matchLine(s.Location[0].Line...)
+	}
+	for _, loc := range s.Location {
+		if r.matchLine(loc.Line...) {
+			return true
+		}
+	}
+	return false
+}
+
+// reports whether the innermost of the lines belongs to the matching function
+func (r *Rule) matchLine(lines ...pprofProfile.Line) bool {
+	return len(lines) > 0 && lines[0].Function != nil && r.Function.MatchString(lines[0].Function.Name)
+}
+
+type thresholdKind int
+
+const (
+	thresholdUnset thresholdKind = iota
+	thresholdNumber
+	thresholdPercent
+	thresholdBytes
+	thresholdDuration
+)
+
+var byteSuffixes = []struct {
+	suffix string
+	mult   float64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// Threshold is the value the alert fires above. It's either a share of the profile's total in percent,
+// an amount of bytes (1KB is 1024 bytes), a duration or a plain number.
+type Threshold struct {
+	kind     thresholdKind
+	value    float64
+	original string
+}
+
+func ParseThreshold(s string) (Threshold, error) {
+	th := Threshold{original: s}
+	s = strings.TrimSpace(s)
+
+	var err error
+	switch {
+	case strings.HasSuffix(s, "%"):
+		th.kind = thresholdPercent
+		th.value, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		th.value /= 100
+	case strings.HasSuffix(s, "B"):
+		th.kind = thresholdBytes
+		for _, bs := range byteSuffixes {
+			if strings.HasSuffix(s, bs.suffix) {
+				th.value, err = strconv.ParseFloat(strings.TrimSuffix(s, bs.suffix), 64)
+				th.value *= bs.mult
+				break
+			}
+		}
+	default:
+		th.kind = thresholdNumber
+		th.value, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			var d time.Duration
+			if d, err = time.ParseDuration(s); err == nil {
+				th.kind = thresholdDuration
+				th.value = float64(d)
+			}
+		}
+	}
+	if err != nil {
+		return Threshold{}, fmt.Errorf("bad threshold %q: %w", th.original, err)
+	}
+	if th.value < 0 {
+		return Threshold{}, fmt.Errorf("bad threshold %q: must not be negative", th.original)
+	}
+	return th, nil
+}
+
+func (th *Threshold) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := ParseThreshold(s)
+	if err != nil {
+		return err
+	}
+	*th = v
+	return nil
+}
+
+func (th Threshold) MarshalYAML() (interface{}, error) {
+	return th.original, nil
+}
+
+func (th Threshold) String() string {
+	return th.original
+}
+
+// Exceeded reports whether the value is above the threshold.
+func (th Threshold) Exceeded(v float64) bool {
+	return v > th.value
+}
+
+// returns the unit of sample type, the threshold can be compared with; any unit matches plain numbers and percents
+func (th Threshold) unit() string {
+	switch th.kind {
+	case thresholdBytes:
+		return "bytes"
+	case thresholdDuration:
+		return "nanoseconds"
+	}
+	return ""
+}
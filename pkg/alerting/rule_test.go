@@ -0,0 +1,210 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreshold(t *testing.T) {
+	cases := []struct {
+		in       string
+		wantKind thresholdKind
+		want     float64
+		wantErr  bool
+	}{
+		{"15%", thresholdPercent, 0.15, false},
+		{"2GB", thresholdBytes, 2 << 30, false},
+		{"1.5MB", thresholdBytes, 1.5 * (1 << 20), false},
+		{"512B", thresholdBytes, 512, false},
+		{"500ms", thresholdDuration, float64(500 * time.Millisecond), false},
+		{"1m", thresholdDuration, float64(time.Minute), false},
+		{"1000", thresholdNumber, 1000, false},
+		{"", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"GB", 0, 0, true},
+		{"-1%", 0, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.in, func(t *testing.T) {
+			th, err := ParseThreshold(tc.in)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantKind, th.kind)
+			assert.InDelta(t, tc.want, th.value, 1e-9)
+			assert.Equal(t, tc.in, th.String())
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	conf, err := Load([]byte(`
+alertmanagers:
+  - http://alertmanager:9093
+webhooks:
+  - https://hooks.example.com/profefe
+rules:
+  - alert: HotJSON
+    service: svc1
+    type: cpu
+    function: encoding/json\..*
+    threshold: 15%
+    for: 10m
+    profile_labels:
+      region: eu
+    labels:
+      severity: warning
+  - alert: CacheHeap
+    tenant: team-a
+    service: svc1
+    type: heap
+    sample_type: inuse_space
+    window: 1h
+    function: example.com/cache\..*
+    flat: true
+    threshold: 2GB
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"http://alertmanager:9093"}, conf.Alertmanagers)
+	assert.Equal(t, []string{"https://hooks.example.com/profefe"}, conf.Webhooks)
+	require.Len(t, conf.Rules, 2)
+
+	r := conf.Rules[0]
+	assert.Equal(t, "HotJSON", r.Alert)
+	assert.Equal(t, profile.TypeCPU, r.ptype)
+	assert.Equal(t, defaultWindow, r.Window)
+	assert.Equal(t, 10*time.Minute, r.For)
+	assert.Equal(t, profile.Labels{{Key: "region", Value: "eu"}}, r.profileLabels())
+	assert.True(t, r.Function.MatchString("encoding/json.Marshal"))
+	assert.False(t, r.Function.MatchString("main.encoding/json.Marshal"))
+
+	r = conf.Rules[1]
+	assert.Equal(t, "team-a", r.Tenant)
+	assert.Equal(t, profile.TypeHeap, r.ptype)
+	assert.Equal(t, time.Hour, r.Window)
+	assert.True(t, r.Flat)
+	assert.Equal(t, "inuse_space", r.SampleType)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"no alert":         "rules: [{service: svc1, type: cpu, function: main, threshold: 1%}]",
+		"no service":       "rules: [{alert: a, type: cpu, function: main, threshold: 1%}]",
+		"bad type":         "rules: [{alert: a, service: svc1, type: trace, function: main, threshold: 1%}]",
+		"no function":      "rules: [{alert: a, service: svc1, type: cpu, threshold: 1%}]",
+		"bad function":     "rules: [{alert: a, service: svc1, type: cpu, function: '(', threshold: 1%}]",
+		"no threshold":     "rules: [{alert: a, service: svc1, type: cpu, function: main}]",
+		"bad threshold":    "rules: [{alert: a, service: svc1, type: cpu, function: main, threshold: 1XB}]",
+		"bad tenant":       "rules: [{alert: a, tenant: 'a/b', service: svc1, type: cpu, function: main, threshold: 1%}]",
+		"duplicate":        "rules: [{alert: a, service: svc1, type: cpu, function: main, threshold: 1%}, {alert: a, service: svc1, type: heap, function: main, threshold: 1%}]",
+		"unknown field":    "rules: [{alert: a, service: svc1, type: cpu, function: main, threshold: 1%, foo: bar}]",
+		"bad alertmanager": "alertmanagers: ['alertmanager:9093']",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+// creates heap profile, where every stack, listed from the innermost function, takes the values
+func newTestProfile(stacks map[string][]int64) *pprofProfile.Profile {
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+	}
+
+	funcs := make(map[string]*pprofProfile.Location)
+	for stack, values := range stacks {
+		var locs []*pprofProfile.Location
+		for _, name := range strings.Split(stack, ";") {
+			loc := funcs[name]
+			if loc == nil {
+				fn := &pprofProfile.Function{ID: uint64(len(funcs) + 1), Name: name}
+				loc = &pprofProfile.Location{ID: fn.ID, Line: []pprofProfile.Line{{Function: fn}}}
+				funcs[name] = loc
+				pp.Function = append(pp.Function, fn)
+				pp.Location = append(pp.Location, loc)
+			}
+			locs = append(locs, loc)
+		}
+		pp.Sample = append(pp.Sample, &pprofProfile.Sample{Location: locs, Value: values})
+	}
+	return pp
+}
+
+func mustNewRegexp(t *testing.T, s string) relabel.Regexp {
+	re, err := relabel.NewRegexp(s)
+	require.NoError(t, err)
+	return re
+}
+
+func mustParseThreshold(t *testing.T, s string) Threshold {
+	th, err := ParseThreshold(s)
+	require.NoError(t, err)
+	return th
+}
+
+func TestRule_value(t *testing.T) {
+	pp := newTestProfile(map[string][]int64{
+		"cache.(*Cache).Set;main.handle;main.main":    {100, 60},
+		"cache.newEntry;cache.(*Cache).Set;main.main": {200, 20},
+		"main.handle;main.main":                       {700, 20},
+	})
+
+	cases := []struct {
+		name       string
+		function   string
+		flat       bool
+		sampleType string
+		threshold  string
+		n          int
+		want       float64
+	}{
+		{"share of default sample type", `cache\..*`, false, "", "10%", 1, 0.8},
+		{"share of sample type", `cache\..*`, false, "alloc_space", "10%", 1, 0.3},
+		{"flat share", `cache\.\(\*Cache\)\.Set`, true, "alloc_space", "10%", 1, 0.1},
+		{"cumulative share", `main\.main`, false, "alloc_space", "10%", 1, 1},
+		{"average value", `cache\..*`, false, "", "1KB", 2, 40},
+		{"no matches", `other\..*`, false, "", "1KB", 2, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Rule{
+				Function:   mustNewRegexp(t, tc.function),
+				Flat:       tc.flat,
+				SampleType: tc.sampleType,
+				Threshold:  mustParseThreshold(t, tc.threshold),
+			}
+			v, err := r.value(pp, tc.n)
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, v, 1e-9)
+		})
+	}
+}
+
+func TestRule_value_badSampleType(t *testing.T) {
+	pp := newTestProfile(map[string][]int64{"main.main": {1, 1}})
+
+	r := &Rule{Function: mustNewRegexp(t, "main"), SampleType: "cpu", Threshold: mustParseThreshold(t, "10%")}
+	_, err := r.value(pp, 1)
+	require.Error(t, err)
+
+	// the duration threshold can't be compared with bytes
+	r = &Rule{Function: mustNewRegexp(t, "main"), Threshold: mustParseThreshold(t, "10s")}
+	_, err = r.value(pp, 1)
+	require.Error(t, err)
+}
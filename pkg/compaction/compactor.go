@@ -0,0 +1,393 @@
+// Package compaction implements the compaction of old profiles, that periodically merges the profiles
+// into coarser aggregates, e.g. one profile per service, type and labels per hour.
+package compaction
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// the number of profiles parsed before they are merged into the aggregate
+const mergeBatchSize = 100
+
+// Storage is the storage the profiles are compacted in.
+type Storage interface {
+	storage.Reader
+	storage.Writer
+	storage.Deleter
+}
+
+// the profiles are looked up type by type, as object storages list the profiles of a service
+// ordered by the type first; runtime traces aren't compacted, as they can't be merged
+var compactedTypes = []profile.ProfileType{
+	profile.TypeCPU,
+	profile.TypeHeap,
+	profile.TypeBlock,
+	profile.TypeMutex,
+	profile.TypeGoroutine,
+	profile.TypeThreadcreate,
+	profile.TypeOther,
+}
+
+// Compactor periodically merges the old profiles of every series, i.e. the profiles of a service with the same
+// type and labels, into aggregates, written back to the storage with the ResolutionLabel. The compacted profiles
+// are deleted. The levels can be replaced at runtime.
+type Compactor struct {
+	logger   *log.Logger
+	conf     Config
+	storages map[string]Storage
+	// the tenants' policies, that list the known tenants
+	tenants *tenant.Policies
+
+	mu       sync.Mutex
+	fileConf *FileConfig
+
+	compactedTotal  *prometheus.CounterVec
+	aggregatesTotal *prometheus.CounterVec
+	failuresTotal   prometheus.Counter
+	reloadStatus    prometheus.Gauge
+
+	now func() time.Time
+}
+
+// NewCompactor creates the compactor of the storages, keyed by the storage type.
+func NewCompactor(logger *log.Logger, conf Config, storages map[string]Storage, tenants *tenant.Policies, registry prometheus.Registerer) (*Compactor, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	if tenants == nil {
+		tenants = &tenant.Policies{}
+	}
+
+	c := &Compactor{
+		logger:   logger,
+		conf:     conf,
+		storages: storages,
+		tenants:  tenants,
+		fileConf: &FileConfig{},
+		compactedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "compaction_compacted_profiles_total",
+			Help:      "Number of profiles merged into aggregates and deleted.",
+		}, []string{"storage", "resolution"}),
+		aggregatesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "compaction_aggregates_written_total",
+			Help:      "Number of aggregates written by compaction.",
+		}, []string{"storage", "resolution"}),
+		failuresTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "compaction_failures_total",
+			Help:      "Number of failed compactions of services' profiles.",
+		}),
+		reloadStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "compaction_config_last_reload_successful",
+			Help:      "Whether the last reload of compaction config was successful.",
+		}),
+		now: time.Now,
+	}
+	registry.MustRegister(c.compactedTotal, c.aggregatesTotal, c.failuresTotal, c.reloadStatus)
+	return c, nil
+}
+
+// ApplyConfig replaces the levels with the levels from the config.
+func (c *Compactor) ApplyConfig(conf *FileConfig) {
+	c.mu.Lock()
+	c.fileConf = conf
+	c.mu.Unlock()
+
+	c.logger.Infow("compaction levels applied", "levels", len(conf.Levels))
+}
+
+// LoadFile loads the config from the file and applies it. The current levels are kept, if the config
+// couldn't be loaded.
+func (c *Compactor) LoadFile(fileName string) error {
+	conf, err := LoadFile(fileName)
+	if err != nil {
+		c.reloadStatus.Set(0)
+		return err
+	}
+	c.ApplyConfig(conf)
+	c.reloadStatus.Set(1)
+	return nil
+}
+
+// Run compacts the profiles right away and then every interval, until the context is done.
+func (c *Compactor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.compact(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// compacts the profiles of every service of every stored tenant to every level
+func (c *Compactor) compact(ctx context.Context) {
+	c.mu.Lock()
+	fileConf := c.fileConf
+	c.mu.Unlock()
+
+	if len(fileConf.Levels) == 0 {
+		return
+	}
+
+	start := c.now()
+
+	var total int
+	for name, st := range c.storages {
+		// the tenants are listed from the storage, as the profiles of any tenant may be written
+		tenants, err := st.ListTenants(ctx)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			c.logger.Errorw("could not list tenants", "storage", name, zap.Error(err))
+			c.failuresTotal.Inc()
+			continue
+		}
+
+		for _, tn := range tenants {
+			services, err := st.ListServices(ctx, tn)
+			if err == storage.ErrNotFound {
+				continue
+			} else if err != nil {
+				c.logger.Errorw("could not list services", "storage", name, "tenant", tn, zap.Error(err))
+				c.failuresTotal.Inc()
+				continue
+			}
+
+			for _, service := range services {
+				for _, level := range fileConf.Levels {
+					n, err := c.compactService(ctx, name, st, tn, service, level, start)
+					total += n
+					if ctx.Err() != nil {
+						return
+					}
+					if err != nil {
+						c.logger.Errorw("could not compact profiles", "storage", name, "tenant", tn, "service", service, "resolution", level.Resolution, zap.Error(err))
+						c.failuresTotal.Inc()
+					}
+				}
+			}
+		}
+	}
+
+	c.logger.Infow("compaction done", "compacted", total, "took", c.now().Sub(start))
+}
+
+// compacts the service's profiles, older than the level's age, to the level; returns the number of compacted profiles
+func (c *Compactor) compactService(ctx context.Context, name string, st Storage, tn, service string, level *Level, now time.Time) (total int, err error) {
+	for _, ptype := range compactedTypes {
+		params := &storage.FindProfilesParams{
+			Tenant:       tn,
+			Service:      service,
+			Type:         ptype,
+			CreatedAtMin: time.Unix(0, 0).UTC(),
+			// only the whole periods are compacted
+			CreatedAtMax: now.Add(-level.After).Truncate(level.Resolution),
+			Limit:        c.conf.BatchSize,
+		}
+		b := &bucket{
+			compactor:  c,
+			st:         st,
+			name:       name,
+			params:     params,
+			resolution: level.Resolution,
+		}
+		n, err := b.compactProfiles(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// bucket collects the profiles of the current period of time, that are compacted into the aggregates
+type bucket struct {
+	compactor  *Compactor
+	st         Storage
+	name       string
+	params     *storage.FindProfilesParams
+	resolution time.Duration
+
+	start time.Time
+	metas []profile.Meta
+}
+
+// finds the profiles batch by batch and compacts them period by period
+func (b *bucket) compactProfiles(ctx context.Context) (total int, err error) {
+	err = storage.WalkProfiles(ctx, b.st, b.params, func(metas []profile.Meta) error {
+		for _, meta := range metas {
+			if resolutionOf(meta) >= b.resolution {
+				continue
+			}
+			if start := meta.CreatedAt.Truncate(b.resolution); !start.Equal(b.start) {
+				n, err := b.flush(ctx)
+				total += n
+				if err != nil {
+					return err
+				}
+				b.start = start
+			}
+			b.metas = append(b.metas, meta)
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+
+	n, err := b.flush(ctx)
+	return total + n, err
+}
+
+// merges the collected profiles of every series into an aggregate, writes it to the storage and deletes
+// the merged profiles, once the aggregates are stored
+func (b *bucket) flush(ctx context.Context) (total int, err error) {
+	if len(b.metas) == 0 {
+		return 0, nil
+	}
+
+	series := make(map[string][]profile.Meta)
+	for _, meta := range b.metas {
+		key := seriesKey(meta)
+		series[key] = append(series[key], meta)
+	}
+	pids := make([]profile.ID, 0, len(b.metas))
+	b.metas = b.metas[:0]
+
+	// the profiles of the written aggregates are deleted, even if the next series couldn't be compacted,
+	// so that they aren't compacted twice
+	resolution := formatResolution(b.resolution)
+	var (
+		aggregates int
+		compactErr error
+	)
+	for _, metas := range series {
+		if err := b.compactSeries(ctx, metas, resolution); err != nil {
+			compactErr = fmt.Errorf("could not compact %d profiles of %v: %w", len(metas), b.start, err)
+			break
+		}
+		for _, meta := range metas {
+			pids = append(pids, meta.ProfileID)
+		}
+		aggregates++
+	}
+	if len(pids) == 0 {
+		return 0, compactErr
+	}
+
+	// the storages, that acknowledge the writes before they store the profiles, might lose the aggregates
+	if f, ok := b.st.(storage.Flusher); ok {
+		if err := f.Flush(ctx); err != nil {
+			return 0, fmt.Errorf("could not flush aggregates of %v: %w", b.start, err)
+		}
+	}
+	if err := b.st.DeleteProfiles(ctx, b.params.Tenant, pids); err != nil {
+		return 0, fmt.Errorf("could not delete %d compacted profiles of %v: %w", len(pids), b.start, err)
+	}
+
+	b.compactor.compactedTotal.WithLabelValues(b.name, resolution).Add(float64(len(pids)))
+	b.compactor.aggregatesTotal.WithLabelValues(b.name, resolution).Add(float64(aggregates))
+	return len(pids), compactErr
+}
+
+// merges the profiles of the series and writes the aggregate to the storage
+func (b *bucket) compactSeries(ctx context.Context, metas []profile.Meta, resolution string) error {
+	pids := make([]profile.ID, 0, len(metas))
+	for _, meta := range metas {
+		pids = append(pids, meta.ProfileID)
+	}
+
+	pp, err := b.merge(ctx, pids)
+	if err != nil {
+		return err
+	}
+	pp.TimeNanos = b.start.UnixNano()
+	pp.DurationNanos = b.resolution.Nanoseconds()
+
+	var buf bytes.Buffer
+	if err := pp.Write(&buf); err != nil {
+		return err
+	}
+
+	labels := append(seriesLabels(metas[0].Labels), profile.Label{Key: ResolutionLabel, Value: resolution})
+	params := &storage.WriteProfileParams{
+		Tenant:    b.params.Tenant,
+		Service:   b.params.Service,
+		Type:      b.params.Type,
+		Labels:    labels,
+		CreatedAt: b.start,
+	}
+	meta, err := b.st.WriteProfile(ctx, params, &buf)
+	if err != nil {
+		return fmt.Errorf("could not write aggregate: %w", err)
+	}
+
+	b.compactor.logger.Debugw("compactSeries: write aggregate", "storage", b.name, "pid", meta.ProfileID, "labels", labels, "compacted", len(pids))
+
+	return nil
+}
+
+// merges the profiles batch by batch, so that only a batch of parsed profiles is kept in memory
+func (b *bucket) merge(ctx context.Context, pids []profile.ID) (*pprofProfile.Profile, error) {
+	list, err := b.st.ListProfiles(ctx, b.params.Tenant, pids)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
+
+	var merged *pprofProfile.Profile
+	pps := make([]*pprofProfile.Profile, 0, mergeBatchSize+1)
+	for list.Next() {
+		pr, err := list.Profile()
+		if err != nil {
+			return nil, err
+		}
+		pp, err := pprofProfile.Parse(pr)
+		if err != nil {
+			return nil, err
+		}
+		pps = append(pps, pp)
+
+		if len(pps) == mergeBatchSize {
+			if merged, err = mergeBatch(merged, pps); err != nil {
+				return nil, err
+			}
+			pps = pps[:0]
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(pps) > 0 || merged == nil {
+		return mergeBatch(merged, pps)
+	}
+	return merged, nil
+}
+
+func mergeBatch(merged *pprofProfile.Profile, pps []*pprofProfile.Profile) (*pprofProfile.Profile, error) {
+	if merged != nil {
+		pps = append(pps, merged)
+	}
+	return pprofProfile.Merge(pps)
+}
@@ -0,0 +1,342 @@
+package compaction
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+type testProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) { return bytes.NewReader(pl.data), nil }
+
+func (pl *testProfileList) Close() error { return nil }
+
+// stores the profiles of the default tenant in memory, and finds the newest of them first, the way badger does
+type testStorage struct {
+	*storage.StubWriter
+	*storage.StubReader
+	*storage.StubDeleter
+
+	metas  []profile.Meta
+	data   map[profile.ID][]byte
+	lastID int
+}
+
+func newTestStorage(t *testing.T) *testStorage {
+	st := &testStorage{
+		data: make(map[profile.ID][]byte),
+	}
+	st.StubWriter = &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			st.lastID++
+			meta := profile.Meta{
+				ProfileID: profile.ID(fmt.Sprintf("p%d", st.lastID)),
+				Service:   params.Service,
+				Type:      params.Type,
+				Labels:    params.Labels,
+				CreatedAt: params.CreatedAt,
+			}
+			st.metas = append(st.metas, meta)
+			st.data[meta.ProfileID] = data
+			return meta, nil
+		},
+	}
+	st.StubReader = &storage.StubReader{
+		ListTenantsFunc: func(ctx context.Context) ([]string, error) {
+			return []string{""}, nil
+		},
+		ListServicesFunc: func(ctx context.Context, tn string) ([]string, error) {
+			if tn != "" {
+				return nil, storage.ErrNotFound
+			}
+			return []string{"svc1"}, nil
+		},
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			var metas []profile.Meta
+			for _, meta := range st.metas {
+				if meta.Service != params.Service || (params.Type != profile.TypeUnknown && meta.Type != params.Type) {
+					continue
+				}
+				if meta.CreatedAt.Before(params.CreatedAtMin) || !meta.CreatedAt.Before(params.CreatedAtMax) {
+					continue
+				}
+				metas = append(metas, meta)
+			}
+			sort.SliceStable(metas, func(i, j int) bool {
+				return metas[i].CreatedAt.After(metas[j].CreatedAt)
+			})
+			if params.Limit > 0 && len(metas) > params.Limit {
+				metas = metas[:params.Limit]
+			}
+			if len(metas) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			return metas, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, tn string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &testProfileList{}
+			for _, pid := range pids {
+				list.profiles = append(list.profiles, st.data[pid])
+			}
+			return list, nil
+		},
+	}
+	st.StubDeleter = &storage.StubDeleter{
+		DeleteProfilesFunc: func(ctx context.Context, tn string, pids []profile.ID) error {
+			for _, pid := range pids {
+				delete(st.data, pid)
+			}
+			metas := st.metas[:0]
+			for _, meta := range st.metas {
+				if _, ok := st.data[meta.ProfileID]; ok {
+					metas = append(metas, meta)
+				}
+			}
+			st.metas = metas
+			return nil
+		},
+	}
+	return st
+}
+
+// writes CPU profile, which single sample takes the value
+func (st *testStorage) add(t *testing.T, labels profile.Labels, createdAt time.Time, v int64) {
+	writeTestProfile(t, st, labels, createdAt, v)
+}
+
+// writes CPU profile of the svc1, which single sample takes the value, to the storage
+func writeTestProfile(t *testing.T, sw storage.Writer, labels profile.Labels, createdAt time.Time, v int64) {
+	fn := &pprofProfile.Function{ID: 1, Name: "main.main"}
+	loc := &pprofProfile.Location{ID: 1, Line: []pprofProfile.Line{{Function: fn}}}
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &pprofProfile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1,
+		Sample:     []*pprofProfile.Sample{{Location: []*pprofProfile.Location{loc}, Value: []int64{v}}},
+		Location:   []*pprofProfile.Location{loc},
+		Function:   []*pprofProfile.Function{fn},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, pp.Write(&buf))
+
+	params := &storage.WriteProfileParams{
+		Service:   "svc1",
+		Type:      profile.TypeCPU,
+		Labels:    labels,
+		CreatedAt: createdAt,
+	}
+	_, err := sw.WriteProfile(context.Background(), params, &buf)
+	require.NoError(t, err)
+}
+
+// returns the total of the samples of the profile
+func (st *testStorage) total(t *testing.T, pid profile.ID) (total int64) {
+	pp, err := pprofProfile.ParseData(st.data[pid])
+	require.NoError(t, err)
+	for _, s := range pp.Sample {
+		total += s.Value[0]
+	}
+	return total
+}
+
+func newTestCompactor(t *testing.T, conf Config, st Storage, levels string) *Compactor {
+	c, err := NewCompactor(log.New(zaptest.NewLogger(t)), conf, map[string]Storage{"test": st}, nil, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	fileConf, err := Load([]byte(levels))
+	require.NoError(t, err)
+	c.ApplyConfig(fileConf)
+
+	return c
+}
+
+func TestCompactor_compact(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := now.Add(-10 * 24 * time.Hour)
+
+	st := newTestStorage(t)
+	for h := 0; h < 48; h++ {
+		for m := 0; m < 60; m += 20 {
+			createdAt := day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+			st.add(t, profile.Labels{{Key: "host", Value: "h1"}}, createdAt, 1)
+			st.add(t, profile.Labels{{Key: "host", Value: "h2"}}, createdAt, 2)
+		}
+	}
+	// the recent profiles aren't compacted
+	st.add(t, profile.Labels{{Key: "host", Value: "h1"}}, now.Add(-time.Hour), 1)
+
+	// the small batches make the compactor page through the profiles
+	conf := Config{Interval: time.Hour, BatchSize: 7}
+	c := newTestCompactor(t, conf, st, `
+levels:
+  - {after: 168h, resolution: 1h}
+  - {after: 216h, resolution: 24h}
+`)
+	c.now = func() time.Time { return now }
+
+	c.compact(context.Background())
+
+	// the first day is compacted to daily aggregates, the next one to hourly aggregates
+	byResolution := make(map[string]int)
+	var totals [3]int64
+	for _, meta := range st.metas {
+		var resolution string
+		for _, label := range meta.Labels {
+			if label.Key == ResolutionLabel {
+				resolution = label.Value
+			}
+		}
+		byResolution[resolution]++
+
+		switch resolution {
+		case "24h":
+			assert.Equal(t, day, meta.CreatedAt)
+			totals[0] += st.total(t, meta.ProfileID)
+		case "1h":
+			assert.Equal(t, meta.CreatedAt, meta.CreatedAt.Truncate(time.Hour))
+			totals[1] += st.total(t, meta.ProfileID)
+		default:
+			totals[2] += st.total(t, meta.ProfileID)
+		}
+	}
+	assert.Equal(t, map[string]int{"24h": 2, "1h": 48, "": 1}, byResolution)
+	assert.Equal(t, [3]int64{24 * 3 * 3, 24 * 3 * 3, 1}, totals)
+
+	assert.Equal(t, float64(2*48*3), testutil.ToFloat64(c.compactedTotal.WithLabelValues("test", "1h")))
+	assert.Equal(t, float64(48), testutil.ToFloat64(c.compactedTotal.WithLabelValues("test", "24h")))
+
+	// the compacted profiles aren't compacted again
+	c.compact(context.Background())
+	assert.Len(t, st.metas, 2+48+1)
+}
+
+// the storage, that acknowledges the writes before the profiles are stored
+type testFlushingStorage struct {
+	*testStorage
+	flushErr error
+	flushed  int
+}
+
+func (st *testFlushingStorage) Flush(ctx context.Context) error {
+	st.flushed++
+	return st.flushErr
+}
+
+func TestCompactor_compact_flush(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := now.Add(-10 * 24 * time.Hour)
+
+	st := &testFlushingStorage{testStorage: newTestStorage(t), flushErr: errors.New("flush failed")}
+	for h := 0; h < 2; h++ {
+		st.add(t, nil, day.Add(time.Duration(h)*time.Hour), 1)
+	}
+
+	conf := Config{Interval: time.Hour, BatchSize: 10}
+	c := newTestCompactor(t, conf, st, `levels: [{after: 168h, resolution: 24h}]`)
+	c.now = func() time.Time { return now }
+
+	c.compact(context.Background())
+
+	// the compacted profiles aren't deleted, as the aggregate might be lost
+	assert.Equal(t, 1, st.flushed)
+	assert.Len(t, st.metas, 2+1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c.failuresTotal))
+
+	st.testStorage = newTestStorage(t)
+	st.flushErr = nil
+	for h := 0; h < 2; h++ {
+		st.add(t, nil, day.Add(time.Duration(h)*time.Hour), 1)
+	}
+
+	c.compact(context.Background())
+
+	assert.Equal(t, 2, st.flushed)
+	assert.Len(t, st.metas, 1)
+}
+
+func TestCompactor_compact_badger(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dbPath)
+
+	db, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	st := storageBadger.NewStorage(log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))), db, 0)
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := now.Add(-10 * 24 * time.Hour)
+	for m := 0; m < 3*60; m += 10 {
+		writeTestProfile(t, st, profile.Labels{{Key: "host", Value: "h1"}}, day.Add(time.Duration(m)*time.Minute), 1)
+	}
+
+	// badger finds the newest profiles of the batch first
+	conf := Config{Interval: time.Hour, BatchSize: 4}
+	c := newTestCompactor(t, conf, st, `levels: [{after: 168h, resolution: 1h}]`)
+	c.now = func() time.Time { return now }
+
+	c.compact(context.Background())
+
+	metas, err := st.FindProfiles(context.Background(), &storage.FindProfilesParams{
+		Service:      "svc1",
+		CreatedAtMin: day,
+		CreatedAtMax: now,
+	})
+	require.NoError(t, err)
+	require.Len(t, metas, 3)
+
+	for _, meta := range metas {
+		assert.Equal(t, 1*time.Hour, resolutionOf(meta))
+
+		list, err := st.ListProfiles(context.Background(), "", []profile.ID{meta.ProfileID})
+		require.NoError(t, err)
+		require.True(t, list.Next())
+		pr, err := list.Profile()
+		require.NoError(t, err)
+		pp, err := pprofProfile.Parse(pr)
+		require.NoError(t, err)
+		require.NoError(t, list.Close())
+
+		var total int64
+		for _, s := range pp.Sample {
+			total += s.Value[0]
+		}
+		assert.Equal(t, int64(6), total, "aggregate of %v", meta.CreatedAt)
+	}
+	assert.Equal(t, float64(18), testutil.ToFloat64(c.compactedTotal.WithLabelValues("test", "1h")))
+}
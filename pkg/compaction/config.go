@@ -0,0 +1,108 @@
+package compaction
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultInterval  = time.Hour
+	defaultBatchSize = 1000
+)
+
+type Config struct {
+	ConfigFile string
+	Interval   time.Duration
+	BatchSize  int
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "compaction.config-file", "", "path to YAML file with compaction levels (reloaded on SIGHUP, compaction is disabled if empty)")
+	f.DurationVar(&conf.Interval, "compaction.interval", defaultInterval, "how often to compact old profiles")
+	f.IntVar(&conf.BatchSize, "compaction.batch-size", defaultBatchSize, "the number of profiles looked up at once")
+}
+
+// Enabled reports whether the profiles must be compacted.
+func (conf *Config) Enabled() bool {
+	return conf.ConfigFile != ""
+}
+
+func (conf *Config) validate() error {
+	if conf.Interval <= 0 {
+		return fmt.Errorf("compaction interval must be positive, got %v", conf.Interval)
+	}
+	if conf.BatchSize <= 0 {
+		return fmt.Errorf("compaction batch size must be positive, got %d", conf.BatchSize)
+	}
+	return nil
+}
+
+// FileConfig is the configuration of compaction, loaded from a YAML file.
+//
+// Example:
+//
+//	levels:
+//	  # profiles older than 7 days are merged into one profile per hour
+//	  - after: 168h
+//	    resolution: 1h
+//	  # profiles older than 30 days are merged into one profile per day
+//	  - after: 720h
+//	    resolution: 24h
+type FileConfig struct {
+	Levels []*Level `yaml:"levels"`
+}
+
+// Level defines the resolution the profiles are compacted to, once they get old enough.
+type Level struct {
+	// the age of the profiles compacted to the level
+	After time.Duration `yaml:"after"`
+	// the period of time an aggregate covers; the periods are aligned with time.Truncate, e.g. the daily
+	// periods start at midnight UTC
+	Resolution time.Duration `yaml:"resolution"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+
+	var prev *Level
+	for n, l := range conf.Levels {
+		if l == nil {
+			return nil, fmt.Errorf("empty compaction level %d", n)
+		}
+		if l.Resolution < time.Minute || l.Resolution%time.Minute != 0 {
+			return nil, fmt.Errorf("compaction level %d: resolution must be whole minutes, got %v", n, l.Resolution)
+		}
+		if l.After < l.Resolution {
+			return nil, fmt.Errorf("compaction level %d: after %v is less than resolution %v", n, l.After, l.Resolution)
+		}
+		// every aggregate of a level is compacted into a single aggregate of the next level
+		if prev != nil {
+			if l.After <= prev.After || l.Resolution <= prev.Resolution || l.Resolution%prev.Resolution != 0 {
+				return nil, fmt.Errorf("compaction level %d: after and resolution must be greater than the previous level's, and the resolution must be a multiple of it", n)
+			}
+		}
+		prev = l
+	}
+	return conf, nil
+}
@@ -0,0 +1,42 @@
+package compaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	conf, err := Load([]byte(`
+levels:
+  - after: 168h
+    resolution: 1h
+  - after: 720h
+    resolution: 24h
+`))
+	require.NoError(t, err)
+	require.Len(t, conf.Levels, 2)
+	assert.Equal(t, &Level{After: 168 * time.Hour, Resolution: time.Hour}, conf.Levels[0])
+	assert.Equal(t, &Level{After: 720 * time.Hour, Resolution: 24 * time.Hour}, conf.Levels[1])
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"no resolution":        "levels: [{after: 1h}]",
+		"seconds resolution":   "levels: [{after: 1h, resolution: 90s}]",
+		"after less":           "levels: [{after: 1h, resolution: 2h}]",
+		"not ascending after":  "levels: [{after: 720h, resolution: 1h}, {after: 168h, resolution: 24h}]",
+		"not multiple":         "levels: [{after: 168h, resolution: 2h}, {after: 720h, resolution: 3h}]",
+		"not ascending levels": "levels: [{after: 168h, resolution: 24h}, {after: 720h, resolution: 1h}]",
+		"unknown field":        "levels: [{after: 1h, resolution: 1h, foo: bar}]",
+		"empty level":          "levels: [~]",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
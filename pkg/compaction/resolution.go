@@ -0,0 +1,149 @@
+package compaction
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// ResolutionLabel is the label of the aggregates, which value is the period of time an aggregate covers,
+// e.g. "1h". The raw profiles don't have the label.
+const ResolutionLabel = "profefe_resolution"
+
+// returns the resolution of the profile, or zero if the profile isn't an aggregate
+func resolutionOf(meta profile.Meta) time.Duration {
+	for _, label := range meta.Labels {
+		if label.Key == ResolutionLabel {
+			d, err := time.ParseDuration(label.Value)
+			if err != nil || d < 0 {
+				return 0
+			}
+			return d
+		}
+	}
+	return 0
+}
+
+// formats the resolution the shortest way, e.g. "24h" instead of "24h0m0s"
+func formatResolution(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return d.String()
+	}
+}
+
+// returns the key of the series the profile belongs to: the profiles of the same type with the same labels,
+// except for the resolution, are compacted into the same aggregate
+func seriesKey(meta profile.Meta) string {
+	labels := seriesLabels(meta.Labels)
+
+	var buf strings.Builder
+	buf.WriteString(meta.Type.String())
+	buf.WriteByte('/')
+	labels.EncodeTo(&buf)
+	return buf.String()
+}
+
+// returns the sorted copy of the labels without the resolution label
+func seriesLabels(labels profile.Labels) profile.Labels {
+	ret := make(profile.Labels, 0, len(labels))
+	for _, label := range labels {
+		if label.Key != ResolutionLabel {
+			ret = append(ret, label)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Key != ret[j].Key {
+			return ret[i].Key < ret[j].Key
+		}
+		return ret[i].Value < ret[j].Value
+	})
+	return ret
+}
+
+// SelectResolution selects the profiles a query is answered with, so that every period of time of every series
+// is covered by the profiles of a single resolution. The coarsest aggregate, that falls within the query's time
+// range, is selected, and the finer profiles of its period are skipped; an aggregate, that only partially falls
+// within the time range, is skipped in favour of the finer profiles, if there are any.
+func SelectResolution(params *storage.FindProfilesParams, metas []profile.Meta) []profile.Meta {
+	type entry struct {
+		meta       profile.Meta
+		resolution time.Duration
+		skipped    bool
+	}
+
+	var hasAggregates bool
+	series := make(map[string][]*entry)
+	entries := make([]*entry, 0, len(metas))
+	for _, meta := range metas {
+		e := &entry{meta: meta, resolution: resolutionOf(meta)}
+		if e.resolution > 0 {
+			hasAggregates = true
+		}
+		key := seriesKey(meta)
+		series[key] = append(series[key], e)
+		entries = append(entries, e)
+	}
+	if !hasAggregates {
+		return metas
+	}
+
+	for _, ss := range series {
+		sort.SliceStable(ss, func(i, j int) bool {
+			return ss[i].meta.CreatedAt.Before(ss[j].meta.CreatedAt)
+		})
+
+		aggregates := make([]*entry, 0, len(ss))
+		for _, e := range ss {
+			if e.resolution > 0 {
+				aggregates = append(aggregates, e)
+			}
+		}
+		sort.SliceStable(aggregates, func(i, j int) bool {
+			return aggregates[i].resolution > aggregates[j].resolution
+		})
+
+		for _, agg := range aggregates {
+			if agg.skipped {
+				continue
+			}
+			start, end := agg.meta.CreatedAt, agg.meta.CreatedAt.Add(agg.resolution)
+
+			var finer []*entry
+			for i := sort.Search(len(ss), func(i int) bool { return !ss[i].meta.CreatedAt.Before(start) }); i < len(ss); i++ {
+				e := ss[i]
+				if !e.meta.CreatedAt.Before(end) {
+					break
+				}
+				if !e.skipped && e.resolution < agg.resolution {
+					finer = append(finer, e)
+				}
+			}
+
+			within := !start.Before(params.CreatedAtMin) && (params.CreatedAtMax.IsZero() || !end.After(params.CreatedAtMax))
+			if within || len(finer) == 0 {
+				for _, e := range finer {
+					e.skipped = true
+				}
+			} else {
+				agg.skipped = true
+			}
+		}
+	}
+
+	selected := make([]profile.Meta, 0, len(entries))
+	for _, e := range entries {
+		if !e.skipped {
+			selected = append(selected, e.meta)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,107 @@
+package compaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatResolution(t *testing.T) {
+	cases := map[time.Duration]string{
+		time.Hour:        "1h",
+		24 * time.Hour:   "24h",
+		90 * time.Minute: "90m",
+		time.Second:      "1s",
+	}
+	for d, want := range cases {
+		assert.Equal(t, want, formatResolution(d))
+	}
+}
+
+func TestSeriesKey(t *testing.T) {
+	m1 := profile.Meta{Type: profile.TypeCPU, Labels: profile.Labels{{Key: "b", Value: "2"}, {Key: "a", Value: "1"}}}
+	m2 := profile.Meta{Type: profile.TypeCPU, Labels: profile.Labels{{Key: "a", Value: "1"}, {Key: ResolutionLabel, Value: "1h"}, {Key: "b", Value: "2"}}}
+	m3 := profile.Meta{Type: profile.TypeHeap, Labels: profile.Labels{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}}
+
+	assert.Equal(t, seriesKey(m1), seriesKey(m2))
+	assert.NotEqual(t, seriesKey(m1), seriesKey(m3))
+}
+
+func TestSelectResolution(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time { return day.Add(time.Duration(h) * time.Hour) }
+
+	meta := func(id string, createdAt time.Time, labels ...profile.Label) profile.Meta {
+		return profile.Meta{
+			ProfileID: profile.ID(id),
+			Type:      profile.TypeCPU,
+			Labels:    append(profile.Labels{{Key: "host", Value: "h1"}}, labels...),
+			CreatedAt: createdAt,
+		}
+	}
+	hourly := profile.Label{Key: ResolutionLabel, Value: "1h"}
+	daily := profile.Label{Key: ResolutionLabel, Value: "24h"}
+
+	metas := []profile.Meta{
+		// the daily aggregate, written while its hourly aggregates weren't deleted yet
+		meta("d1", day, daily),
+		meta("h1", hour(1), hourly),
+		meta("h2", hour(2), hourly),
+		// raw profiles of the next day, partially compacted to hourly aggregates
+		meta("h25", hour(25), hourly),
+		meta("r25", hour(25).Add(10*time.Minute)),
+		meta("r26", hour(26).Add(10*time.Minute)),
+		// other series
+		{ProfileID: "other", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "host", Value: "h2"}}, CreatedAt: hour(1)},
+	}
+
+	ids := func(metas []profile.Meta) (ids []profile.ID) {
+		for _, meta := range metas {
+			ids = append(ids, meta.ProfileID)
+		}
+		return ids
+	}
+
+	cases := []struct {
+		name     string
+		min, max time.Time
+		want     []profile.ID
+	}{
+		{
+			"whole days",
+			day, hour(48),
+			[]profile.ID{"d1", "h25", "r26", "other"},
+		},
+		{
+			"part of aggregated day",
+			hour(1), hour(48),
+			[]profile.ID{"h1", "h2", "h25", "r26", "other"},
+		},
+		{
+			"part of hour",
+			day, hour(25).Add(30 * time.Minute),
+			[]profile.ID{"d1", "r25", "other"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the storage finds the profiles created within the time range
+			var found []profile.Meta
+			for _, meta := range metas {
+				if !meta.CreatedAt.Before(tc.min) && meta.CreatedAt.Before(tc.max) {
+					found = append(found, meta)
+				}
+			}
+			params := &storage.FindProfilesParams{CreatedAtMin: tc.min, CreatedAtMax: tc.max}
+			assert.Equal(t, tc.want, ids(SelectResolution(params, found)))
+		})
+	}
+}
+
+func TestSelectResolution_noAggregates(t *testing.T) {
+	metas := []profile.Meta{{ProfileID: "p1"}, {ProfileID: "p2"}}
+	assert.Equal(t, metas, SelectResolution(&storage.FindProfilesParams{}, metas))
+}
@@ -1,17 +1,33 @@
 package config
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
 	"github.com/profefe/profefe/pkg/agentutil"
+	"github.com/profefe/profefe/pkg/alerting"
+	"github.com/profefe/profefe/pkg/compaction"
 	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/middleware"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/recording"
+	"github.com/profefe/profefe/pkg/regression"
+	"github.com/profefe/profefe/pkg/relabel"
+	"github.com/profefe/profefe/pkg/retention"
+	"github.com/profefe/profefe/pkg/scrape"
 	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
 	storageCH "github.com/profefe/profefe/pkg/storage/clickhouse"
+	storageFS "github.com/profefe/profefe/pkg/storage/fs"
 	storageGCS "github.com/profefe/profefe/pkg/storage/gcs"
+	storageMemory "github.com/profefe/profefe/pkg/storage/memory"
+	storagePG "github.com/profefe/profefe/pkg/storage/postgres"
 	storageS3 "github.com/profefe/profefe/pkg/storage/s3"
+	"github.com/profefe/profefe/pkg/tenant"
 )
 
 const (
@@ -23,29 +39,67 @@ const (
 	StorageTypeS3      = "s3"
 	StorageTypeCH      = "clickhouse"
 	StorageTypeGCS     = "gcs"
+	StorageTypeFS      = "fs"
+	StorageTypePG      = "postgres"
+	StorageTypeMemory  = "memory"
 )
 
-var storageTypes = []string{StorageTypeBadger, StorageTypeCH, StorageTypeS3, StorageTypeGCS}
+var storageTypes = []string{StorageTypeBadger, StorageTypeCH, StorageTypeS3, StorageTypeGCS, StorageTypeFS, StorageTypePG, StorageTypeMemory}
 
 type Config struct {
 	Addr        string
+	GRPCAddr    string
 	ExitTimeout time.Duration
 	Logger      log.Config
+	Auth        middleware.AuthConfig
 	AgentConfig agentutil.Config
+	Scrape      scrape.Config
+	Tenants     tenant.Config
+	RateLimit   profefe.RateLimitConfig
+	Enrich      profefe.EnrichConfig
+	Relabel     relabel.Config
+	Regression  regression.Config
+	Alerting    alerting.Config
+	Recording   recording.Config
+	Retention   retention.Config
+	Compaction  compaction.Config
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
 
 	storageType string
 	Badger      storageBadger.Config
 	ClickHouse  storageCH.Config
 	S3          storageS3.Config
 	GCS         storageGCS.Config
+	FS          storageFS.Config
+	Postgres    storagePG.Config
+	Memory      storageMemory.Config
 }
 
 func (conf *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&conf.Addr, "addr", defaultAddr, "address to listen")
+	f.StringVar(&conf.GRPCAddr, "grpc-addr", "", "address to listen for gRPC API (disabled if empty)")
 	f.DurationVar(&conf.ExitTimeout, "exit-timeout", defaultExitTimeout, "server shutdown timeout")
 
+	f.StringVar(&conf.TLSCertFile, "tls-cert-file", "", "path to server's TLS certificate (TLS is disabled if empty)")
+	f.StringVar(&conf.TLSKeyFile, "tls-key-file", "", "path to server's TLS private key")
+	f.StringVar(&conf.TLSClientCAFile, "tls-client-ca-file", "", "path to CA certificates to verify TLS client certificates")
+
 	conf.Logger.RegisterFlags(f)
+	conf.Auth.RegisterFlags(f)
 	conf.AgentConfig.RegisterFlags(f)
+	conf.Scrape.RegisterFlags(f)
+	conf.Tenants.RegisterFlags(f)
+	conf.RateLimit.RegisterFlags(f)
+	conf.Enrich.RegisterFlags(f)
+	conf.Relabel.RegisterFlags(f)
+	conf.Regression.RegisterFlags(f)
+	conf.Alerting.RegisterFlags(f)
+	conf.Recording.RegisterFlags(f)
+	conf.Retention.RegisterFlags(f)
+	conf.Compaction.RegisterFlags(f)
 
 	f.StringVar(&conf.storageType, "storage-type", defaultStorageType, fmt.Sprintf("storage type: %s", strings.Join(storageTypes, ", ")))
 
@@ -53,6 +107,9 @@ func (conf *Config) RegisterFlags(f *flag.FlagSet) {
 	conf.ClickHouse.RegisterFlags(f)
 	conf.S3.RegisterFlags(f)
 	conf.GCS.RegisterFlags(f)
+	conf.FS.RegisterFlags(f)
+	conf.Postgres.RegisterFlags(f)
+	conf.Memory.RegisterFlags(f)
 }
 
 func (conf *Config) StorageType() ([]string, error) {
@@ -68,3 +125,37 @@ func (conf *Config) StorageType() ([]string, error) {
 	}
 	return nil, fmt.Errorf("storage configuration required")
 }
+
+// TLSConfig returns the TLS config of the servers, or nil if TLS is disabled.
+func (conf *Config) TLSConfig() (*tls.Config, error) {
+	if conf.TLSCertFile == "" {
+		if conf.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("TLS client CA requires TLS certificate")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if conf.TLSClientCAFile != "" {
+		data, err := ioutil.ReadFile(conf.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %q", conf.TLSClientCAFile)
+		}
+		// clients may authenticate with a token instead of a certificate
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConf.ClientCAs = pool
+	}
+
+	return tlsConf, nil
+}
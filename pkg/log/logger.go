@@ -28,6 +28,10 @@ func (logger *Logger) Infow(msg string, pairs ...interface{}) {
 	logger.base.Infow(msg, pairs...)
 }
 
+func (logger *Logger) Warnw(msg string, pairs ...interface{}) {
+	logger.base.Warnw(msg, pairs...)
+}
+
 func (logger *Logger) Errorw(msg string, pairs ...interface{}) {
 	logger.base.Errorw(msg, pairs...)
 }
@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+// Scope defines what an authenticated client is allowed to do.
+type Scope string
+
+const (
+	// ScopeWrite allows to store profiles of the identity's services.
+	ScopeWrite Scope = "write"
+	// ScopeRead allows to query profiles and meta information about them.
+	ScopeRead Scope = "read"
+	// ScopeAdmin allows everything.
+	ScopeAdmin Scope = "admin"
+)
+
+func (s Scope) validate() error {
+	switch s {
+	case ScopeWrite, ScopeRead, ScopeAdmin:
+		return nil
+	}
+	return fmt.Errorf("unknown scope %q", s)
+}
+
+var (
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Identity is an authenticated client.
+type Identity struct {
+	Name  string `json:"sub" yaml:"name"`
+	Scope Scope  `json:"scope" yaml:"scope"`
+	// services the client with write scope is allowed to store profiles of; any service if empty
+	Services []string `json:"services,omitempty" yaml:"services,omitempty"`
+	// the tenant the client is bound to; the client chooses the tenant with the request if empty
+	Tenant string `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+}
+
+func (id *Identity) CanRead() bool {
+	return id.Scope == ScopeRead || id.Scope == ScopeAdmin
+}
+
+func (id *Identity) CanWrite(service string) bool {
+	if id.Scope == ScopeAdmin {
+		return true
+	}
+	if id.Scope != ScopeWrite {
+		return false
+	}
+	if len(id.Services) == 0 {
+		return true
+	}
+	for _, s := range id.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (id *Identity) IsAdmin() bool {
+	return id.Scope == ScopeAdmin
+}
+
+func (id *Identity) validate() error {
+	if err := id.Scope.validate(); err != nil {
+		return err
+	}
+	return tenant.ValidateName(id.Tenant)
+}
+
+type identityKey struct{}
+
+func ContextWithIdentity(parentCtx context.Context, id *Identity) context.Context {
+	return context.WithValue(parentCtx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity of authenticated client, or nil if the authentication is disabled.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Credentials are presented by the client with the request.
+type Credentials struct {
+	// bearer token, either static or HMAC-signed
+	Token string
+	// verified chain of client's TLS certificate, leaf first
+	Certificates []*x509.Certificate
+}
+
+// Authenticator verifies client's credentials using static tokens, HMAC-signed tokens and TLS client certificates.
+type Authenticator struct {
+	tokens      map[string]*Identity
+	hmacSecret  []byte
+	clientCerts map[string]*Identity
+
+	now func() time.Time
+}
+
+func (a *Authenticator) Authenticate(creds Credentials) (*Identity, error) {
+	if creds.Token != "" {
+		if id := a.lookupToken(creds.Token); id != nil {
+			return id, nil
+		}
+		if a.hmacSecret != nil && strings.Contains(creds.Token, ".") {
+			return a.verifySignedToken(creds.Token)
+		}
+		return nil, fmt.Errorf("%w: unknown token", ErrUnauthenticated)
+	}
+
+	if len(creds.Certificates) > 0 {
+		cn := creds.Certificates[0].Subject.CommonName
+		if id, ok := a.clientCerts[cn]; ok {
+			return id, nil
+		}
+		return nil, fmt.Errorf("%w: unknown client certificate %q", ErrUnauthenticated, cn)
+	}
+
+	return nil, fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+}
+
+func (a *Authenticator) lookupToken(token string) *Identity {
+	// compare in constant time to not leak the tokens through timing
+	var found *Identity
+	for t, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = id
+		}
+	}
+	return found
+}
+
+type tokenClaims struct {
+	Identity
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+// SignToken creates a token, signed with HMAC-SHA256, that grants the identity's scope until expiration time.
+// Zero expiration time means the token never expires.
+func SignToken(secret []byte, id Identity, expiresAt time.Time) (string, error) {
+	if err := id.validate(); err != nil {
+		return "", err
+	}
+	claims := tokenClaims{Identity: id}
+	if !expiresAt.IsZero() {
+		claims.ExpiresAt = expiresAt.Unix()
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encPayload + "." + base64.RawURLEncoding.EncodeToString(signPayload(secret, encPayload)), nil
+}
+
+func (a *Authenticator) verifySignedToken(token string) (*Identity, error) {
+	n := strings.LastIndexByte(token, '.')
+	encPayload, encSig := token[:n], token[n+1:]
+
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, signPayload(a.hmacSecret, encPayload)) {
+		return nil, fmt.Errorf("%w: bad token signature", ErrUnauthenticated)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	if err := claims.Identity.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+	if claims.ExpiresAt != 0 && a.now().Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+
+	return &claims.Identity, nil
+}
+
+func signPayload(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// APIAdminPath is the prefix of the API paths, that manage the whole server, e.g. backup the storage.
+const APIAdminPath = "/api/0/admin/"
+
+// AuthHandler authenticates the requests to the API and checks that client's scope allows the request:
+// GET requests require read scope, storing profiles requires write scope for the service, everything else,
+// including any request to the admin API, requires admin scope.
+func AuthHandler(logger *log.Logger, auth *Authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := auth.Authenticate(credentialsFromRequest(r))
+		if err != nil {
+			profefe.HandleErrorHTTP(logger, profefe.StatusError(http.StatusUnauthorized, profefe.ErrUnauthorized.Error(), err), w, r)
+			return
+		}
+
+		if !allowedHTTP(id, r) {
+			err := fmt.Errorf("%w: identity %q", ErrPermissionDenied, id.Name)
+			profefe.HandleErrorHTTP(logger, profefe.StatusError(http.StatusForbidden, profefe.ErrForbidden.Error(), err), w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r.WithContext(ContextWithIdentity(r.Context(), id)))
+	})
+}
+
+func allowedHTTP(id *Identity, r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, APIAdminPath) {
+		return id.IsAdmin()
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return id.CanRead()
+	case http.MethodPost:
+		// storing profiles is the only write request to API
+		if r.URL.Path == "/api/0/profiles" {
+			return id.CanWrite(r.URL.Query().Get("service"))
+		}
+	}
+	return id.IsAdmin()
+}
+
+func credentialsFromRequest(r *http.Request) (creds Credentials) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		creds.Token = bearerToken(auth)
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		creds.Certificates = r.TLS.VerifiedChains[0]
+	}
+	return creds
+}
+
+func bearerToken(auth string) string {
+	const prefix = "Bearer "
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type AuthConfig struct {
+	TokensFile     string
+	HMACSecretFile string
+}
+
+func (conf *AuthConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.TokensFile, "auth.tokens-file", "", "path to YAML file with static tokens and TLS client identities")
+	f.StringVar(&conf.HMACSecretFile, "auth.hmac-secret-file", "", "path to file with the secret to verify HMAC-signed tokens")
+}
+
+// Enabled reports whether the API requires authentication.
+func (conf *AuthConfig) Enabled() bool {
+	return conf.TokensFile != "" || conf.HMACSecretFile != ""
+}
+
+// authFile is the format of the tokens file.
+//
+// Example:
+//
+//	tokens:
+//	  - token: "<random string>"
+//	    name: api-backend-agent
+//	    scope: write
+//	    services: [api-backend]
+//	  - token: "<random string>"
+//	    name: grafana
+//	    scope: read
+//	client_certs:
+//	  - common_name: ops.example.com
+//	    name: ops
+//	    scope: admin
+type authFile struct {
+	Tokens []struct {
+		Token    string `yaml:"token"`
+		Identity `yaml:",inline"`
+	} `yaml:"tokens"`
+	ClientCerts []struct {
+		CommonName string `yaml:"common_name"`
+		Identity   `yaml:",inline"`
+	} `yaml:"client_certs"`
+}
+
+func (conf *AuthConfig) CreateAuthenticator() (*Authenticator, error) {
+	auth := &Authenticator{
+		tokens:      make(map[string]*Identity),
+		clientCerts: make(map[string]*Identity),
+		now:         time.Now,
+	}
+
+	if conf.TokensFile != "" {
+		data, err := ioutil.ReadFile(conf.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := auth.loadTokens(data); err != nil {
+			return nil, fmt.Errorf("could not load tokens file %q: %w", conf.TokensFile, err)
+		}
+	}
+
+	if conf.HMACSecretFile != "" {
+		data, err := ioutil.ReadFile(conf.HMACSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret := bytes.TrimSpace(data)
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("empty HMAC secret in %q", conf.HMACSecretFile)
+		}
+		auth.hmacSecret = secret
+	}
+
+	return auth, nil
+}
+
+func (a *Authenticator) loadTokens(data []byte) error {
+	var f authFile
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return err
+	}
+
+	for n, t := range f.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("token %d: empty token", n)
+		}
+		if err := t.Identity.validate(); err != nil {
+			return fmt.Errorf("token %d: %w", n, err)
+		}
+		if _, ok := a.tokens[t.Token]; ok {
+			return fmt.Errorf("token %d: duplicate token", n)
+		}
+		id := t.Identity
+		a.tokens[t.Token] = &id
+	}
+
+	for n, c := range f.ClientCerts {
+		if c.CommonName == "" {
+			return fmt.Errorf("client cert %d: empty common name", n)
+		}
+		if err := c.Identity.validate(); err != nil {
+			return fmt.Errorf("client cert %d: %w", n, err)
+		}
+		id := c.Identity
+		if id.Name == "" {
+			id.Name = c.CommonName
+		}
+		a.clientCerts[c.CommonName] = &id
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const grpcMethodWriteProfiles = "/profefe.v0.Profefe/WriteProfiles"
+
+// AuthUnaryInterceptor authenticates gRPC calls. All unary calls of profefe API require read scope.
+func AuthUnaryInterceptor(logger *log.Logger, auth *Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, err := authenticateGRPC(ctx, logger, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if !id.CanRead() {
+			return nil, permissionDeniedGRPC(logger, id, info.FullMethod)
+		}
+		return handler(ContextWithIdentity(ctx, id), req)
+	}
+}
+
+// AuthStreamInterceptor authenticates gRPC streams. WriteProfiles requires write scope for the service
+// of every profile in the stream, other streams require read scope.
+func AuthStreamInterceptor(logger *log.Logger, auth *Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, err := authenticateGRPC(ss.Context(), logger, auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		stream := &authServerStream{
+			ServerStream: ss,
+			ctx:          ContextWithIdentity(ss.Context(), id),
+		}
+		if info.FullMethod == grpcMethodWriteProfiles {
+			stream.checkRecv = func(m interface{}) error {
+				req, ok := m.(*profefepb.WriteProfilesRequest)
+				if !ok {
+					return nil
+				}
+				if params := req.GetParams(); params != nil && !id.CanWrite(params.Service) {
+					return permissionDeniedGRPC(logger, id, info.FullMethod)
+				}
+				return nil
+			}
+		} else if !id.CanRead() {
+			return permissionDeniedGRPC(logger, id, info.FullMethod)
+		}
+
+		return handler(srv, stream)
+	}
+}
+
+type authServerStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	checkRecv func(m interface{}) error
+}
+
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.checkRecv != nil {
+		return s.checkRecv(m)
+	}
+	return nil
+}
+
+func authenticateGRPC(ctx context.Context, logger *log.Logger, auth *Authenticator, method string) (*Identity, error) {
+	id, err := auth.Authenticate(credentialsFromGRPCContext(ctx))
+	if err != nil {
+		logger.Infow("grpc request denied", "method", method, "code", codes.Unauthenticated, "reason", err.Error())
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return id, nil
+}
+
+func permissionDeniedGRPC(logger *log.Logger, id *Identity, method string) error {
+	logger.Infow("grpc request denied", "method", method, "code", codes.PermissionDenied, "identity", id.Name)
+	return status.Error(codes.PermissionDenied, "forbidden")
+}
+
+func credentialsFromGRPCContext(ctx context.Context) (creds Credentials) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("authorization"); len(vals) > 0 {
+			creds.Token = bearerToken(vals[0])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			creds.Certificates = tlsInfo.State.VerifiedChains[0]
+		}
+	}
+	return creds
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+var testHMACSecret = []byte("secret")
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	auth := &Authenticator{
+		tokens:      make(map[string]*Identity),
+		clientCerts: make(map[string]*Identity),
+		hmacSecret:  testHMACSecret,
+		now:         func() time.Time { return time.Unix(1000, 0) },
+	}
+	err := auth.loadTokens([]byte(`
+tokens:
+  - token: write-token
+    name: agent
+    scope: write
+    services: [svc1]
+  - token: read-token
+    name: grafana
+    scope: read
+client_certs:
+  - common_name: ops.example.com
+    scope: admin
+`))
+	require.NoError(t, err)
+	return auth
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	auth := newTestAuthenticator(t)
+
+	t.Run("static token", func(t *testing.T) {
+		id, err := auth.Authenticate(Credentials{Token: "write-token"})
+		require.NoError(t, err)
+		assert.Equal(t, "agent", id.Name)
+		assert.True(t, id.CanWrite("svc1"))
+		assert.False(t, id.CanWrite("svc2"))
+		assert.False(t, id.CanRead())
+	})
+
+	t.Run("signed token", func(t *testing.T) {
+		token, err := SignToken(testHMACSecret, Identity{Name: "ci", Scope: ScopeRead}, time.Unix(2000, 0))
+		require.NoError(t, err)
+
+		id, err := auth.Authenticate(Credentials{Token: token})
+		require.NoError(t, err)
+		assert.Equal(t, "ci", id.Name)
+		assert.True(t, id.CanRead())
+		assert.False(t, id.IsAdmin())
+	})
+
+	t.Run("expired signed token", func(t *testing.T) {
+		token, err := SignToken(testHMACSecret, Identity{Name: "ci", Scope: ScopeRead}, time.Unix(500, 0))
+		require.NoError(t, err)
+
+		_, err = auth.Authenticate(Credentials{Token: token})
+		assert.True(t, errors.Is(err, ErrUnauthenticated))
+	})
+
+	t.Run("signed with other secret", func(t *testing.T) {
+		token, err := SignToken([]byte("other"), Identity{Name: "ci", Scope: ScopeAdmin}, time.Time{})
+		require.NoError(t, err)
+
+		_, err = auth.Authenticate(Credentials{Token: token})
+		assert.True(t, errors.Is(err, ErrUnauthenticated))
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops.example.com"}}
+		id, err := auth.Authenticate(Credentials{Certificates: []*x509.Certificate{cert}})
+		require.NoError(t, err)
+		assert.Equal(t, "ops.example.com", id.Name)
+		assert.True(t, id.IsAdmin())
+
+		cert = &x509.Certificate{Subject: pkix.Name{CommonName: "unknown.example.com"}}
+		_, err = auth.Authenticate(Credentials{Certificates: []*x509.Certificate{cert}})
+		assert.True(t, errors.Is(err, ErrUnauthenticated))
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		_, err := auth.Authenticate(Credentials{})
+		assert.True(t, errors.Is(err, ErrUnauthenticated))
+	})
+}
+
+func TestAuthHandler(t *testing.T) {
+	auth := newTestAuthenticator(t)
+
+	h := AuthHandler(log.New(zaptest.NewLogger(t)), auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotNil(t, IdentityFromContext(r.Context()))
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		method   string
+		url      string
+		token    string
+		wantCode int
+	}{
+		{http.MethodGet, "/api/0/profiles?service=svc1", "", http.StatusUnauthorized},
+		{http.MethodGet, "/api/0/profiles?service=svc1", "unknown-token", http.StatusUnauthorized},
+		{http.MethodGet, "/api/0/profiles?service=svc1", "read-token", http.StatusOK},
+		{http.MethodGet, "/api/0/profiles?service=svc1", "write-token", http.StatusForbidden},
+		{http.MethodPost, "/api/0/profiles?service=svc1&type=cpu", "write-token", http.StatusOK},
+		{http.MethodPost, "/api/0/profiles?service=svc2&type=cpu", "write-token", http.StatusForbidden},
+		{http.MethodPost, "/api/0/profiles?service=svc1&type=cpu", "read-token", http.StatusForbidden},
+		{http.MethodDelete, "/api/0/profiles/id", "read-token", http.StatusForbidden},
+		{http.MethodGet, "/api/0/admin/badger/backup", "read-token", http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.url+" "+tc.token, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.wantCode, rec.Code)
+			if tc.wantCode == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
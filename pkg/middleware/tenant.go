@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/tenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var grpcMetadataTenant = strings.ToLower(tenant.HeaderTenant)
+
+// TenantHandler puts the tenant of the request to the request's context. The tenant is taken from
+// the identity of authenticated client, if the identity is bound to a tenant, or from X-Profefe-Tenant header.
+func TenantHandler(logger *log.Logger, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		t, err := requestTenant(IdentityFromContext(ctx), r.Header.Get(tenant.HeaderTenant))
+		if errors.Is(err, ErrPermissionDenied) {
+			profefe.HandleErrorHTTP(logger, profefe.StatusError(http.StatusForbidden, profefe.ErrForbidden.Error(), err), w, r)
+			return
+		} else if err != nil {
+			profefe.HandleErrorHTTP(logger, profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: %s", err), err), w, r)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(tenant.ContextWithTenant(ctx, t)))
+	})
+}
+
+// TenantUnaryInterceptor puts the tenant, passed with x-profefe-tenant metadata, to the context of gRPC call.
+func TenantUnaryInterceptor(logger *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := grpcTenantContext(ctx, logger, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TenantStreamInterceptor puts the tenant, passed with x-profefe-tenant metadata, to the context of gRPC stream.
+func TenantStreamInterceptor(logger *log.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := grpcTenantContext(ss.Context(), logger, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func grpcTenantContext(ctx context.Context, logger *log.Logger, method string) (context.Context, error) {
+	var requested string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(grpcMetadataTenant); len(vals) > 0 {
+			requested = vals[0]
+		}
+	}
+
+	t, err := requestTenant(IdentityFromContext(ctx), requested)
+	if err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			logger.Infow("grpc request denied", "method", method, "code", codes.PermissionDenied, "reason", err.Error())
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "bad request: %s", err)
+	}
+	return tenant.ContextWithTenant(ctx, t), nil
+}
+
+// returns the tenant of the request; the client, bound to a tenant, can't request a different one
+func requestTenant(id *Identity, requested string) (string, error) {
+	if err := tenant.ValidateName(requested); err != nil {
+		return "", err
+	}
+	if id == nil || id.Tenant == "" {
+		return requested, nil
+	}
+	if requested != "" && requested != id.Tenant {
+		return "", fmt.Errorf("%w: identity %q is not allowed to access tenant %q", ErrPermissionDenied, id.Name, requested)
+	}
+	return id.Tenant, nil
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestTenantHandler(t *testing.T) {
+	var gotTenant string
+	h := TenantHandler(log.New(zaptest.NewLogger(t)), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = tenant.FromContext(r.Context())
+	}))
+
+	cases := []struct {
+		name       string
+		id         *Identity
+		header     string
+		wantCode   int
+		wantTenant string
+	}{
+		{"no tenant", nil, "", http.StatusOK, tenant.Default},
+		{"tenant from header", nil, "team1", http.StatusOK, "team1"},
+		{"bad tenant in header", nil, "team/1", http.StatusBadRequest, ""},
+		{"tenant from identity", &Identity{Name: "agent", Tenant: "team1"}, "", http.StatusOK, "team1"},
+		{"same tenant in header and identity", &Identity{Name: "agent", Tenant: "team1"}, "team1", http.StatusOK, "team1"},
+		{"other tenant than identity's", &Identity{Name: "agent", Tenant: "team1"}, "team2", http.StatusForbidden, ""},
+		{"identity not bound to tenant", &Identity{Name: "ops"}, "team2", http.StatusOK, "team2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotTenant = ""
+
+			req := httptest.NewRequest(http.MethodGet, "/api/0/services", nil)
+			if tc.header != "" {
+				req.Header.Set(tenant.HeaderTenant, tc.header)
+			}
+			if tc.id != nil {
+				req = req.WithContext(ContextWithIdentity(req.Context(), tc.id))
+			}
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantTenant, gotTenant)
+		})
+	}
+}
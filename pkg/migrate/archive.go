@@ -0,0 +1,172 @@
+package migrate
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// The archive is a tar file, that holds two entries for every profile: "<pid>.json" with the profile's meta,
+// followed by "<pid>.pb.gz" (or "<pid>.trace" for the runtime traces) with the profile's data, as it was
+// read from the storage.
+
+const (
+	metaExt  = ".json"
+	pprofExt = ".pb.gz"
+	traceExt = ".trace"
+)
+
+func dataExt(ptype profile.ProfileType) string {
+	if ptype == profile.TypeTrace {
+		return traceExt
+	}
+	return pprofExt
+}
+
+// Export writes the profiles of the source storage to the archive. If the export is resumed,
+// the archive is truncated to the last completed batch of profiles.
+func (m *Migrator) Export(ctx context.Context, src storage.Reader, f *os.File) (total int, err error) {
+	if err := f.Truncate(m.state.ArchiveOffset); err != nil {
+		return 0, fmt.Errorf("could not truncate archive: %w", err)
+	}
+	if _, err := f.Seek(m.state.ArchiveOffset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("could not seek archive: %w", err)
+	}
+
+	cw := &countingWriter{w: f, n: m.state.ArchiveOffset}
+	tw := tar.NewWriter(cw)
+
+	err = m.walk(ctx, src, m.state, func(meta profile.Meta, data []byte) error {
+		if err := writeEntry(tw, meta, data); err != nil {
+			return fmt.Errorf("could not write profile %s to archive: %w", meta.ProfileID, err)
+		}
+		total++
+		return nil
+	}, func() error {
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		m.state.ArchiveOffset = cw.n
+		return m.state.Save()
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, meta profile.Meta, data []byte) error {
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(tw, string(meta.ProfileID)+metaExt, meta.CreatedAt, metaData); err != nil {
+		return err
+	}
+	return writeFile(tw, string(meta.ProfileID)+dataExt(meta.Type), meta.CreatedAt, data)
+}
+
+func writeFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Import writes the profiles of the archive, that match the filter, to the destination storage.
+// If the import is resumed, the profiles, read from the archive before, are skipped.
+func (m *Migrator) Import(ctx context.Context, r io.Reader, dst storage.Writer) (total int, err error) {
+	var n int
+	err = readArchive(r, func(meta profile.Meta, data []byte) error {
+		n++
+		if n <= m.state.Imported {
+			return nil
+		}
+		if m.filter.match(meta) {
+			if err := writeProfile(ctx, dst, meta, data); err != nil {
+				return err
+			}
+			total++
+		}
+		m.state.Imported = n
+		if n%m.batchSize == 0 {
+			return m.state.Save()
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+	return total, m.state.Save()
+}
+
+// calls fn for every profile of the archive
+func readArchive(r io.Reader, fn func(meta profile.Meta, data []byte) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not read archive: %w", err)
+		}
+		if !strings.HasSuffix(hdr.Name, metaExt) {
+			return fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+
+		var meta profile.Meta
+		if err := json.NewDecoder(tr).Decode(&meta); err != nil {
+			return fmt.Errorf("could not decode archive entry %q: %w", hdr.Name, err)
+		}
+
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			return errors.New("could not read archive: unexpected end of archive")
+		} else if err != nil {
+			return fmt.Errorf("could not read archive: %w", err)
+		}
+		if want := string(meta.ProfileID) + dataExt(meta.Type); hdr.Name != want {
+			return fmt.Errorf("unexpected archive entry %q, want %q", hdr.Name, want)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("could not read archive entry %q: %w", hdr.Name, err)
+		}
+
+		if err := fn(meta, data); err != nil {
+			return err
+		}
+	}
+}
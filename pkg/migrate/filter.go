@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+// the profiles are looked up type by type, as object storages list the profiles of a service
+// ordered by the type first
+var allTypes = []profile.ProfileType{
+	profile.TypeCPU,
+	profile.TypeHeap,
+	profile.TypeBlock,
+	profile.TypeMutex,
+	profile.TypeGoroutine,
+	profile.TypeThreadcreate,
+	profile.TypeOther,
+	profile.TypeTrace,
+}
+
+// Filter selects the migrated profiles.
+type Filter struct {
+	Tenant string
+	// the services of the profiles; every service of the tenant if empty
+	Services []string
+	// the types of the profiles; every type if empty
+	Types []profile.ProfileType
+	// the time range the profiles were created in; the range isn't limited if zero
+	Since time.Time
+	Until time.Time
+}
+
+func (f *Filter) types() []profile.ProfileType {
+	if len(f.Types) > 0 {
+		return f.Types
+	}
+	return allTypes
+}
+
+func (f *Filter) match(meta profile.Meta) bool {
+	if meta.Tenant != f.Tenant {
+		return false
+	}
+	if len(f.Services) > 0 && !containsString(f.Services, meta.Service) {
+		return false
+	}
+	if len(f.Types) > 0 && !containsType(f.Types, meta.Type) {
+		return false
+	}
+	if !f.Since.IsZero() && meta.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !meta.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []profile.ProfileType, ptype profile.ProfileType) bool {
+	for _, v := range types {
+		if v == ptype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// Migrator moves the profiles, selected by the filter, between storages and archives.
+type Migrator struct {
+	logger    *log.Logger
+	filter    Filter
+	batchSize int
+	state     *State
+
+	now func() time.Time
+}
+
+func NewMigrator(logger *log.Logger, filter Filter, batchSize int, state *State) *Migrator {
+	if state == nil {
+		state = &State{Series: make(map[string]*Checkpoint)}
+	}
+	return &Migrator{
+		logger:    logger,
+		filter:    filter,
+		batchSize: batchSize,
+		state:     state,
+		now:       time.Now,
+	}
+}
+
+// Copy writes the profiles of the source storage to the destination storage. The profiles keep
+// their metas, except for the profile IDs, which are assigned by the destination storage.
+func (m *Migrator) Copy(ctx context.Context, src storage.Reader, dst storage.Writer) (total int, err error) {
+	err = m.walk(ctx, src, m.state, func(meta profile.Meta, data []byte) error {
+		if err := writeProfile(ctx, dst, meta, data); err != nil {
+			return err
+		}
+		total++
+		return nil
+	}, m.state.Save)
+	return total, err
+}
+
+func writeProfile(ctx context.Context, dst storage.Writer, meta profile.Meta, data []byte) error {
+	params := &storage.WriteProfileParams{
+		ExternalID: meta.ExternalID,
+		Tenant:     meta.Tenant,
+		Service:    meta.Service,
+		Type:       meta.Type,
+		Labels:     meta.Labels,
+		CreatedAt:  meta.CreatedAt,
+	}
+	if _, err := dst.WriteProfile(ctx, params, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("could not write profile %s: %w", meta.ProfileID, err)
+	}
+	return nil
+}
+
+// calls fn for every profile of the source storage, that matches the filter, series by series;
+// the state is updated after every batch of profiles, and the checkpoint func is called
+func (m *Migrator) walk(ctx context.Context, src storage.Reader, state *State, fn func(meta profile.Meta, data []byte) error, checkpoint func() error) error {
+	services := m.filter.Services
+	if len(services) == 0 {
+		var err error
+		services, err = src.ListServices(ctx, m.filter.Tenant)
+		if err == storage.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not list services: %w", err)
+		}
+	}
+
+	for _, service := range services {
+		for _, ptype := range m.filter.types() {
+			key := seriesKey(service, ptype)
+			cp := state.Series[key]
+			if cp == nil {
+				cp = &Checkpoint{}
+				state.Series[key] = cp
+			}
+			if cp.Done {
+				continue
+			}
+
+			n, err := m.walkSeries(ctx, src, service, ptype, cp, fn, checkpoint)
+			if n > 0 {
+				m.logger.Infow("migrated profiles", "service", service, "type", ptype, "profiles", n)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) walkSeries(ctx context.Context, src storage.Reader, service string, ptype profile.ProfileType, cp *Checkpoint, fn func(meta profile.Meta, data []byte) error, checkpoint func() error) (total int, err error) {
+	params := &storage.FindProfilesParams{
+		Tenant:       m.filter.Tenant,
+		Service:      service,
+		Type:         ptype,
+		CreatedAtMin: time.Unix(0, 0).UTC(),
+		CreatedAtMax: m.now().UTC(),
+		Limit:        m.batchSize,
+	}
+	if !m.filter.Since.IsZero() {
+		params.CreatedAtMin = m.filter.Since
+	}
+	if !m.filter.Until.IsZero() {
+		params.CreatedAtMax = m.filter.Until
+	}
+	if cp.CreatedAt.After(params.CreatedAtMin) {
+		params.CreatedAtMin = cp.CreatedAt
+	}
+
+	// the profiles, created at the checkpoint's time, that were migrated before
+	seen := make(map[profile.ID]bool, len(cp.ProfileIDs))
+	for _, pid := range cp.ProfileIDs {
+		seen[pid] = true
+	}
+
+	err = storage.WalkProfiles(ctx, src, params, func(metas []profile.Meta) error {
+		for _, meta := range metas {
+			if seen[meta.ProfileID] {
+				continue
+			}
+			// the metas of some storages don't keep the tenant
+			meta.Tenant = m.filter.Tenant
+			if !m.filter.match(meta) {
+				continue
+			}
+
+			data, err := readProfile(ctx, src, meta)
+			if err == storage.ErrNotFound {
+				// the profile was deleted after it was found, e.g. by the retention
+				m.logger.Warnw("profile not found, skipping", "service", service, "type", ptype, "pid", meta.ProfileID)
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := fn(meta, data); err != nil {
+				return err
+			}
+			total++
+		}
+
+		// the batches are walked in the order of the profiles' creation, and the profiles created at once
+		// are found in the same batch
+		last := metas[len(metas)-1].CreatedAt
+		if !last.Equal(cp.CreatedAt) {
+			cp.CreatedAt = last
+			cp.ProfileIDs = cp.ProfileIDs[:0]
+			seen = make(map[profile.ID]bool)
+		}
+		for _, meta := range metas {
+			if meta.CreatedAt.Equal(last) && !seen[meta.ProfileID] {
+				cp.ProfileIDs = append(cp.ProfileIDs, meta.ProfileID)
+				seen[meta.ProfileID] = true
+			}
+		}
+		if err := checkpoint(); err != nil {
+			return fmt.Errorf("could not save migration state: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+
+	cp.Done = true
+	if err := checkpoint(); err != nil {
+		return total, fmt.Errorf("could not save migration state: %w", err)
+	}
+	return total, nil
+}
+
+// reads the profile's data; the profiles are read one by one, as storages skip the profiles
+// they don't find, which makes a list of profiles impossible to match with their metas
+func readProfile(ctx context.Context, src storage.Reader, meta profile.Meta) ([]byte, error) {
+	list, err := src.ListProfiles(ctx, meta.Tenant, []profile.ID{meta.ProfileID})
+	if err == storage.ErrNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("could not list profile %s: %w", meta.ProfileID, err)
+	}
+	defer list.Close()
+
+	if !list.Next() {
+		return nil, storage.ErrNotFound
+	}
+	r, err := list.Profile()
+	if err != nil {
+		return nil, fmt.Errorf("could not read profile %s: %w", meta.ProfileID, err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read profile %s: %w", meta.ProfileID, err)
+	}
+	return data, nil
+}
+
+func seriesKey(service string, ptype profile.ProfileType) string {
+	return service + "/" + ptype.String()
+}
@@ -0,0 +1,395 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+type testProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) { return bytes.NewReader(pl.data), nil }
+
+func (pl *testProfileList) Close() error { return nil }
+
+// stores the profiles in memory, and finds the newest of them first, the way badger does
+type testStorage struct {
+	*storage.StubWriter
+	*storage.StubReader
+
+	metas  []profile.Meta
+	data   map[profile.ID][]byte
+	lastID int
+	// the number of profiles written before the writes fail; the writes don't fail if negative
+	failAfter int
+}
+
+var errTestWrite = errors.New("write failed")
+
+func newTestStorage(t *testing.T) *testStorage {
+	st := &testStorage{
+		data:      make(map[profile.ID][]byte),
+		failAfter: -1,
+	}
+	st.StubWriter = &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			if st.failAfter == 0 {
+				return profile.Meta{}, errTestWrite
+			}
+			st.failAfter--
+
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			st.lastID++
+			meta := profile.Meta{
+				ProfileID:  profile.ID(fmt.Sprintf("p%d", st.lastID)),
+				ExternalID: params.ExternalID,
+				Tenant:     params.Tenant,
+				Service:    params.Service,
+				Type:       params.Type,
+				Labels:     params.Labels,
+				CreatedAt:  params.CreatedAt,
+			}
+			st.metas = append(st.metas, meta)
+			st.data[meta.ProfileID] = data
+			return meta, nil
+		},
+	}
+	st.StubReader = &storage.StubReader{
+		ListServicesFunc: func(ctx context.Context, tn string) ([]string, error) {
+			seen := make(map[string]bool)
+			var services []string
+			for _, meta := range st.metas {
+				if meta.Tenant == tn && !seen[meta.Service] {
+					seen[meta.Service] = true
+					services = append(services, meta.Service)
+				}
+			}
+			if len(services) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			sort.Strings(services)
+			return services, nil
+		},
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			var metas []profile.Meta
+			for _, meta := range st.metas {
+				if meta.Tenant != params.Tenant || meta.Service != params.Service || meta.Type != params.Type {
+					continue
+				}
+				if !meta.Labels.Include(params.Labels) {
+					continue
+				}
+				if meta.CreatedAt.Before(params.CreatedAtMin) || meta.CreatedAt.After(params.CreatedAtMax) {
+					continue
+				}
+				metas = append(metas, meta)
+			}
+			sort.SliceStable(metas, func(i, j int) bool {
+				return metas[i].CreatedAt.After(metas[j].CreatedAt)
+			})
+			if params.Limit > 0 && len(metas) > params.Limit {
+				metas = metas[:params.Limit]
+			}
+			if len(metas) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			return metas, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, tn string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &testProfileList{}
+			for _, pid := range pids {
+				// missing profiles are skipped, the way badger does
+				if data, ok := st.data[pid]; ok {
+					list.profiles = append(list.profiles, data)
+				}
+			}
+			return list, nil
+		},
+	}
+	return st
+}
+
+// writes CPU profile, which single sample takes the value
+func (st *testStorage) add(t *testing.T, service string, labels profile.Labels, createdAt time.Time, v int64) {
+	writeTestProfile(t, st, service, labels, createdAt, v)
+}
+
+// writes CPU profile of the service, which single sample takes the value, to the storage
+func writeTestProfile(t *testing.T, sw storage.Writer, service string, labels profile.Labels, createdAt time.Time, v int64) {
+	fn := &pprofProfile.Function{ID: 1, Name: "main.main"}
+	loc := &pprofProfile.Location{ID: 1, Line: []pprofProfile.Line{{Function: fn}}}
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &pprofProfile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1,
+		Sample:     []*pprofProfile.Sample{{Location: []*pprofProfile.Location{loc}, Value: []int64{v}}},
+		Location:   []*pprofProfile.Location{loc},
+		Function:   []*pprofProfile.Function{fn},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, pp.Write(&buf))
+
+	params := &storage.WriteProfileParams{
+		Service:   service,
+		Type:      profile.TypeCPU,
+		Labels:    labels,
+		CreatedAt: createdAt,
+	}
+	_, err := sw.WriteProfile(context.Background(), params, &buf)
+	require.NoError(t, err)
+}
+
+// returns the metas of the stored profiles without their IDs, ordered by the creation time
+func (st *testStorage) profiles() []profile.Meta {
+	metas := make([]profile.Meta, 0, len(st.metas))
+	for _, meta := range st.metas {
+		meta.ProfileID = ""
+		metas = append(metas, meta)
+	}
+	sort.SliceStable(metas, func(i, j int) bool {
+		if metas[i].Service != metas[j].Service {
+			return metas[i].Service < metas[j].Service
+		}
+		return metas[i].CreatedAt.Before(metas[j].CreatedAt)
+	})
+	return metas
+}
+
+var testNow = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestMigrator(t *testing.T, filter Filter, batchSize int, state *State) *Migrator {
+	m := NewMigrator(log.New(zaptest.NewLogger(t)), filter, batchSize, state)
+	m.now = func() time.Time { return testNow }
+	return m
+}
+
+func newTestSource(t *testing.T) *testStorage {
+	src := newTestStorage(t)
+	writeTestSource(t, src)
+	return src
+}
+
+func writeTestSource(t *testing.T, sw storage.Writer) {
+	base := testNow.Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		writeTestProfile(t, sw, "svc1", profile.Labels{{Key: "i", Value: fmt.Sprint(i)}}, base.Add(time.Duration(i)*time.Minute), int64(i+1))
+	}
+	// more profiles created at once than a batch holds
+	for i := 0; i < 3; i++ {
+		writeTestProfile(t, sw, "svc1", profile.Labels{{Key: "j", Value: fmt.Sprint(i)}}, base.Add(10*time.Minute), int64(10+i))
+	}
+	writeTestProfile(t, sw, "svc2", nil, base, 100)
+}
+
+func TestMigrator_Copy(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestStorage(t)
+
+	m := newTestMigrator(t, Filter{}, 2, nil)
+	total, err := m.Copy(context.Background(), src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 9, total)
+	assert.Equal(t, src.profiles(), dst.profiles())
+
+	report, err := m.VerifyStorage(context.Background(), src, dst, true)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Verified: 9}, report)
+}
+
+func TestMigrator_Copy_badger(t *testing.T) {
+	dbPath := newTempDir(t)
+	db, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	src := storageBadger.NewStorage(log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))), db, 0)
+	writeTestSource(t, src)
+	dst := newTestStorage(t)
+
+	m := newTestMigrator(t, Filter{}, 2, nil)
+	total, err := m.Copy(context.Background(), src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 9, total)
+	assert.Equal(t, newTestSource(t).profiles(), dst.profiles())
+}
+
+func TestMigrator_Copy_filter(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestStorage(t)
+
+	filter := Filter{
+		Services: []string{"svc1"},
+		Since:    testNow.Add(-time.Hour + time.Minute),
+		Until:    testNow.Add(-time.Hour + 4*time.Minute),
+	}
+	m := newTestMigrator(t, filter, 2, nil)
+	total, err := m.Copy(context.Background(), src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+
+	for i, meta := range dst.profiles() {
+		assert.Equal(t, profile.Labels{{Key: "i", Value: fmt.Sprint(i + 1)}}, meta.Labels)
+	}
+}
+
+func TestMigrator_Copy_resume(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestStorage(t)
+	// the write of the first profile of the batch of the profiles created at once fails; the profiles
+	// of the interrupted batch would be written twice otherwise
+	dst.failAfter = 5
+
+	statePath := filepath.Join(newTempDir(t), "state.json")
+
+	state, err := LoadState(statePath)
+	require.NoError(t, err)
+	_, err = newTestMigrator(t, Filter{}, 2, state).Copy(context.Background(), src, dst)
+	require.True(t, errors.Is(err, errTestWrite), "got %v", err)
+
+	dst.failAfter = -1
+
+	state, err = LoadState(statePath)
+	require.NoError(t, err)
+	m := newTestMigrator(t, Filter{}, 2, state)
+	_, err = m.Copy(context.Background(), src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, src.profiles(), dst.profiles())
+
+	report, err := m.VerifyStorage(context.Background(), src, dst, false)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Verified: 9}, report)
+}
+
+func TestMigrator_ExportImport(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestStorage(t)
+
+	dir := newTempDir(t)
+	archivePath := filepath.Join(dir, "profiles.tar")
+
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	total, err := newTestMigrator(t, Filter{}, 2, nil).Export(context.Background(), src, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, 9, total)
+
+	f, err = os.Open(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+	total, err = newTestMigrator(t, Filter{Services: []string{"svc1"}}, 2, nil).Import(context.Background(), f, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 8, total)
+	assert.Equal(t, src.profiles()[:8], dst.profiles())
+
+	_, err = f.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	report, err := newTestMigrator(t, Filter{}, 2, nil).VerifyArchive(context.Background(), f, dst, true)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Verified: 8, Missing: 1}, report)
+}
+
+func TestMigrator_Export_resume(t *testing.T) {
+	src := newTestSource(t)
+
+	dir := newTempDir(t)
+	archivePath := filepath.Join(dir, "profiles.tar")
+	statePath := filepath.Join(dir, "state.json")
+
+	// the export is interrupted in the middle of a batch
+	var listed int
+	listProfiles := src.ListProfilesFunc
+	src.ListProfilesFunc = func(ctx context.Context, tn string, pids []profile.ID) (storage.ProfileList, error) {
+		listed++
+		if listed == 4 {
+			return nil, errors.New("list failed")
+		}
+		return listProfiles(ctx, tn, pids)
+	}
+
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	state, err := LoadState(statePath)
+	require.NoError(t, err)
+	_, err = newTestMigrator(t, Filter{}, 2, state).Export(context.Background(), src, f)
+	require.Error(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = os.OpenFile(archivePath, os.O_RDWR, 0)
+	require.NoError(t, err)
+	state, err = LoadState(statePath)
+	require.NoError(t, err)
+	assert.NotZero(t, state.ArchiveOffset)
+	_, err = newTestMigrator(t, Filter{}, 2, state).Export(context.Background(), src, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	dst := newTestStorage(t)
+	f, err = os.Open(archivePath)
+	require.NoError(t, err)
+	defer f.Close()
+	total, err := newTestMigrator(t, Filter{}, 2, nil).Import(context.Background(), f, dst)
+	require.NoError(t, err)
+	assert.Equal(t, 9, total)
+	assert.Equal(t, src.profiles(), dst.profiles())
+}
+
+func TestMigrator_VerifyStorage_missing(t *testing.T) {
+	src := newTestSource(t)
+	dst := newTestStorage(t)
+
+	m := newTestMigrator(t, Filter{}, 2, nil)
+	_, err := m.Copy(context.Background(), src, dst)
+	require.NoError(t, err)
+
+	// a profile was lost, and another one was corrupted
+	dst.metas = dst.metas[1:]
+	pid := dst.metas[0].ProfileID
+	dst.data[pid] = src.data[src.metas[2].ProfileID]
+
+	report, err := m.VerifyStorage(context.Background(), src, dst, false)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Verified: 8, Missing: 1}, report)
+
+	report, err = m.VerifyStorage(context.Background(), src, dst, true)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Verified: 7, Missing: 2}, report)
+}
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "migrate")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+// State is the progress of a migration, saved to the state file, so that an interrupted migration can be resumed.
+type State struct {
+	// the progress of the series of profiles read from the source storage, keyed by the series
+	Series map[string]*Checkpoint `json:"series,omitempty"`
+	// the size of the archive, written by the completed batches
+	ArchiveOffset int64 `json:"archive_offset,omitempty"`
+	// the number of profiles, read from the archive
+	Imported int `json:"imported,omitempty"`
+
+	path string
+}
+
+// Checkpoint is the progress of a series of profiles, i.e. the profiles of the same service and type.
+type Checkpoint struct {
+	// the time the last migrated profile was created at
+	CreatedAt time.Time `json:"created_at"`
+	// the migrated profiles, created at CreatedAt
+	ProfileIDs []profile.ID `json:"profile_ids,omitempty"`
+	// whether all profiles of the series were migrated
+	Done bool `json:"done,omitempty"`
+}
+
+// LoadState reads the state from the file. A new state is returned, if the file doesn't exist; the state
+// isn't saved, if the path is empty.
+func LoadState(path string) (*State, error) {
+	state := &State{
+		Series: make(map[string]*Checkpoint),
+		path:   path,
+	}
+	if path == "" {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Series == nil {
+		state.Series = make(map[string]*Checkpoint)
+	}
+	return state, nil
+}
+
+// Save writes the state to the file, replacing the file atomically.
+func (s *State) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
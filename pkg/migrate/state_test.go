@@ -0,0 +1,30 @@
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadState(t *testing.T) {
+	path := filepath.Join(newTempDir(t), "state.json")
+
+	state, err := LoadState(path)
+	require.NoError(t, err)
+	assert.Empty(t, state.Series)
+
+	state.Series["svc1/cpu"] = &Checkpoint{
+		CreatedAt:  time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+		ProfileIDs: []profile.ID{"p1", "p2"},
+	}
+	state.ArchiveOffset = 1024
+	require.NoError(t, state.Save())
+
+	got, err := LoadState(path)
+	require.NoError(t, err)
+	assert.Equal(t, state, got)
+}
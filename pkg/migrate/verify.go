@@ -0,0 +1,168 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// Report is the result of the verification.
+type Report struct {
+	// the number of source profiles found in the destination storage
+	Verified int
+	// the number of source profiles missing in the destination storage
+	Missing int
+}
+
+// VerifyStorage checks that every profile of the source storage, that matches the filter, was written
+// to the destination storage. If withData is set, the data of the profiles are compared as well.
+func (m *Migrator) VerifyStorage(ctx context.Context, src, dst storage.Reader, withData bool) (Report, error) {
+	v := m.newVerifier(dst, withData)
+	// the verification doesn't touch the migration state
+	state := &State{Series: make(map[string]*Checkpoint)}
+	err := m.walk(ctx, src, state, v.verify(ctx), func() error { return nil })
+	return v.report, err
+}
+
+// VerifyArchive checks that every profile of the archive, that matches the filter, was written
+// to the destination storage. If withData is set, the data of the profiles are compared as well.
+func (m *Migrator) VerifyArchive(ctx context.Context, r io.Reader, dst storage.Reader, withData bool) (Report, error) {
+	v := m.newVerifier(dst, withData)
+	verify := v.verify(ctx)
+	err := readArchive(r, func(meta profile.Meta, data []byte) error {
+		if !m.filter.match(meta) {
+			return nil
+		}
+		return verify(meta, data)
+	})
+	return v.report, err
+}
+
+type verifier struct {
+	m        *Migrator
+	dst      storage.Reader
+	withData bool
+	// the destination profiles, matched with the source profiles
+	matched map[profile.ID]bool
+	report  Report
+}
+
+func (m *Migrator) newVerifier(dst storage.Reader, withData bool) *verifier {
+	return &verifier{
+		m:        m,
+		dst:      dst,
+		withData: withData,
+		matched:  make(map[profile.ID]bool),
+	}
+}
+
+func (v *verifier) verify(ctx context.Context) func(meta profile.Meta, data []byte) error {
+	return func(meta profile.Meta, data []byte) error {
+		ok, err := v.find(ctx, meta, data)
+		if err != nil {
+			return err
+		}
+		if ok {
+			v.report.Verified++
+		} else {
+			v.report.Missing++
+			v.m.logger.Warnw("profile missing in destination", "service", meta.Service, "type", meta.Type, "pid", meta.ProfileID, "created_at", meta.CreatedAt)
+		}
+		return nil
+	}
+}
+
+// looks for the destination profile that matches the source profile; storages may keep the creation time
+// with the seconds precision, thus, the profile is looked up within the second it was created at
+func (v *verifier) find(ctx context.Context, meta profile.Meta, data []byte) (bool, error) {
+	createdAt := meta.CreatedAt.Truncate(time.Second)
+	params := &storage.FindProfilesParams{
+		Tenant:       meta.Tenant,
+		Service:      meta.Service,
+		Type:         meta.Type,
+		Labels:       meta.Labels,
+		CreatedAtMin: createdAt,
+		CreatedAtMax: createdAt.Add(time.Second - 1),
+	}
+	metas, err := v.dst.FindProfiles(ctx, params)
+	if err == storage.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not find profiles: %w", err)
+	}
+
+	for _, dstMeta := range metas {
+		if v.matched[dstMeta.ProfileID] || !dstMeta.Labels.Equal(meta.Labels) {
+			continue
+		}
+		if v.withData {
+			dstData, err := readProfile(ctx, v.dst, dstMeta)
+			if err == storage.ErrNotFound {
+				continue
+			} else if err != nil {
+				return false, err
+			}
+			equal, err := dataEqual(meta.Type, data, dstData)
+			if err != nil {
+				return false, fmt.Errorf("could not compare profile %s: %w", meta.ProfileID, err)
+			}
+			if !equal {
+				continue
+			}
+		}
+		v.matched[dstMeta.ProfileID] = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// compares the data of the profiles; storages may encode the profiles differently, thus the profiles
+// are compared by the totals of their sample values
+func dataEqual(ptype profile.ProfileType, data1, data2 []byte) (bool, error) {
+	if ptype == profile.TypeTrace {
+		return bytes.Equal(data1, data2), nil
+	}
+
+	pp1, err := pprofProfile.ParseData(data1)
+	if err != nil {
+		return false, err
+	}
+	pp2, err := pprofProfile.ParseData(data2)
+	if err != nil {
+		return false, err
+	}
+
+	if len(pp1.SampleType) != len(pp2.SampleType) {
+		return false, nil
+	}
+	for i := range pp1.SampleType {
+		if pp1.SampleType[i].Type != pp2.SampleType[i].Type || pp1.SampleType[i].Unit != pp2.SampleType[i].Unit {
+			return false, nil
+		}
+	}
+	totals1, totals2 := sampleTotals(pp1), sampleTotals(pp2)
+	for i := range totals1 {
+		if totals1[i] != totals2[i] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func sampleTotals(pp *pprofProfile.Profile) []int64 {
+	totals := make([]int64, len(pp.SampleType))
+	for _, s := range pp.Sample {
+		for i, v := range s.Value {
+			if i < len(totals) {
+				totals[i] += v
+			}
+		}
+	}
+	return totals
+}
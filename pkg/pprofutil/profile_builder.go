@@ -46,12 +46,30 @@ func (pb *ProfileBuilder) AddFunction(fn *pprofProfile.Function) {
 	pb.prof.Function = append(pb.prof.Function, fn)
 }
 
+// SetSampleType sets the types of the samples' values. If not set, Build uses the default sample types
+// of CPU and heap profiles.
+func (pb *ProfileBuilder) SetSampleType(st []*pprofProfile.ValueType) {
+	pb.prof.SampleType = st
+}
+
+func (pb *ProfileBuilder) SetPeriod(pt *pprofProfile.ValueType, period int64) {
+	pb.prof.PeriodType = pt
+	pb.prof.Period = period
+}
+
+func (pb *ProfileBuilder) SetTime(timeNanos, durationNanos int64) {
+	pb.prof.TimeNanos = timeNanos
+	pb.prof.DurationNanos = durationNanos
+}
+
 func (pb *ProfileBuilder) Build() (*pprofProfile.Profile, error) {
-	switch pb.ptyp {
-	case profile.TypeCPU:
-		pb.buildCPU()
-	case profile.TypeHeap:
-		pb.buildHeap()
+	if len(pb.prof.SampleType) == 0 {
+		switch pb.ptyp {
+		case profile.TypeCPU:
+			pb.buildCPU()
+		case profile.TypeHeap:
+			pb.buildHeap()
+		}
 	}
 
 	err := pb.prof.CheckValid()
@@ -64,9 +82,11 @@ func (pb *ProfileBuilder) buildCPU() {
 		{Type: "samples", Unit: "count"},
 		{Type: "cpu", Unit: "nanoseconds"},
 	}
-	pb.prof.PeriodType = &pprofProfile.ValueType{
-		Type: "cpu",
-		Unit: "nanoseconds",
+	if pb.prof.PeriodType == nil {
+		pb.prof.PeriodType = &pprofProfile.ValueType{
+			Type: "cpu",
+			Unit: "nanoseconds",
+		}
 	}
 }
 
@@ -77,9 +97,11 @@ func (pb *ProfileBuilder) buildHeap() {
 		{Type: "inuse_objects", Unit: "count"},
 		{Type: "inuse_space", Unit: "bytes"},
 	}
-	pb.prof.PeriodType = &pprofProfile.ValueType{
-		Type: "space",
-		Unit: "bytes",
+	if pb.prof.PeriodType == nil {
+		pb.prof.PeriodType = &pprofProfile.ValueType{
+			Type: "space",
+			Unit: "bytes",
+		}
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/profile"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestProfileBuilder_IsEmpty(t *testing.T) {
@@ -15,3 +16,18 @@ func TestProfileBuilder_IsEmpty(t *testing.T) {
 	b.AddSample(&pprofProfile.Sample{})
 	assert.False(t, b.IsEmpty())
 }
+
+func TestProfileBuilder_SetSampleType(t *testing.T) {
+	b := NewProfileBuilder(profile.TypeCPU)
+
+	sampleType := []*pprofProfile.ValueType{{Type: "contentions", Unit: "count"}}
+	b.SetSampleType(sampleType)
+	b.SetPeriod(&pprofProfile.ValueType{Type: "contentions", Unit: "count"}, 1)
+	b.AddSample(&pprofProfile.Sample{Value: []int64{10}})
+
+	pp, err := b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, sampleType, pp.SampleType)
+	assert.Equal(t, "contentions", pp.PeriodType.Type)
+	assert.Equal(t, int64(1), pp.Period)
+}
@@ -10,12 +10,16 @@ import (
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/pprofutil"
 	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
 	"github.com/profefe/profefe/pkg/storage"
 )
 
 type Collector struct {
-	logger *log.Logger
-	sw     storage.Writer
+	logger    *log.Logger
+	sw        storage.Writer
+	limiter   *RateLimiter
+	enricher  *Enricher
+	relabeler *relabel.Relabeler
 }
 
 func NewCollector(logger *log.Logger, sw storage.Writer) *Collector {
@@ -25,10 +29,56 @@ func NewCollector(logger *log.Logger, sw storage.Writer) *Collector {
 	}
 }
 
+// SetRateLimiter sets the limiter that rejects the profiles exceeding rate limits and quotas.
+func (c *Collector) SetRateLimiter(limiter *RateLimiter) {
+	c.limiter = limiter
+}
+
+// SetEnricher sets the enricher that adds the labels derived from the request to the profiles.
+func (c *Collector) SetEnricher(enricher *Enricher) {
+	c.enricher = enricher
+}
+
+// SetRelabeler sets the relabeler that rewrites or drops the profiles before they are stored.
+func (c *Collector) SetRelabeler(relabeler *relabel.Relabeler) {
+	c.relabeler = relabeler
+}
+
 func (c *Collector) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (Profile, error) {
+	// the labels are added before relabeling, so the rules can use them
+	c.enricher.enrich(ctx, params)
+
+	if c.relabeler != nil {
+		keep, err := c.relabeler.Relabel(params)
+		if err != nil {
+			return Profile{}, err
+		}
+		if !keep {
+			// the dropped profile isn't stored, but it's acknowledged, so the client doesn't retry it
+			return Profile{Type: params.Type.String(), Service: params.Service, Labels: params.Labels}, nil
+		}
+	}
+
+	key := rateLimitKey{
+		tenant:  params.Tenant,
+		service: params.Service,
+		source:  sourceFromContext(ctx),
+	}
+
 	// don't parse or even read trace profiles, pass them directly to an underlying storage.Writer
 	if params.Type == profile.TypeTrace {
-		return c.writeProfile(ctx, params, r)
+		if err := c.limiter.allow(key, 0); err != nil {
+			return Profile{}, err
+		}
+		cr := &countingReader{r: r}
+		profModel, err := c.writeProfile(ctx, key, params, cr)
+		if err != nil {
+			c.limiter.refund(key, 0)
+			return Profile{}, err
+		}
+		// the size of the trace is only known after it was read
+		c.limiter.charge(key, cr.n)
+		return profModel, nil
 	}
 
 	data, err := ioutil.ReadAll(r)
@@ -36,10 +86,15 @@ func (c *Collector) WriteProfile(ctx context.Context, params *storage.WriteProfi
 		return Profile{}, err
 	}
 
+	if err := c.limiter.allow(key, int64(len(data))); err != nil {
+		return Profile{}, err
+	}
+
 	parser := pprofutil.NewProfileParser(data)
 
 	pp, err := parser.ParseProfile()
 	if err != nil {
+		c.limiter.refund(key, int64(len(data)))
 		return Profile{}, fmt.Errorf("could not parse pprof profile: %w", err)
 	}
 	if pp.TimeNanos > 0 {
@@ -49,17 +104,34 @@ func (c *Collector) WriteProfile(ctx context.Context, params *storage.WriteProfi
 	// move reader's reading position to start to allow storage writers to read the data
 	parser.Seek(0, io.SeekStart)
 
-	return c.writeProfile(ctx, params, parser)
+	profModel, err := c.writeProfile(ctx, key, params, parser)
+	if err != nil {
+		// the profile wasn't stored, it mustn't count towards the limits
+		c.limiter.refund(key, int64(len(data)))
+		return Profile{}, err
+	}
+	return profModel, nil
 }
 
-func (c *Collector) writeProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (Profile, error) {
+func (c *Collector) writeProfile(ctx context.Context, key rateLimitKey, params *storage.WriteProfileParams, r io.Reader) (Profile, error) {
 	if params.CreatedAt.IsZero() {
 		params.CreatedAt = time.Now().UTC()
 	}
 
 	meta, err := c.sw.WriteProfile(ctx, params, r)
 	if err != nil {
-		return Profile{}, err
+		return Profile{}, c.limiter.quotaError(key, err)
 	}
 	return ProfileFromProfileMeta(meta), nil
 }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
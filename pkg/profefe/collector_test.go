@@ -13,7 +13,9 @@ import (
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/pprofutil"
 	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
@@ -93,3 +95,55 @@ func TestCollector_WriteProfile_MalformedPprofData(t *testing.T) {
 	var perr *pprofutil.ProfileParserError
 	require.True(t, errors.As(err, &perr))
 }
+
+func TestCollector_WriteProfile_relabel(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	var written []*storage.WriteProfileParams
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			written = append(written, params)
+			return profile.Meta{ProfileID: profile.TestID, Service: params.Service, Type: params.Type, Labels: params.Labels}, nil
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	relabelConf, err := relabel.Load([]byte(`
+relabel_configs:
+  - source_labels: [hostname]
+    target_label: host
+  - action: labeldrop
+    regex: hostname
+  - source_labels: [__service__]
+    regex: "drop-.*"
+    action: drop
+`))
+	require.NoError(t, err)
+	relabeler := relabel.NewRelabeler(testLogger, prometheus.NewRegistry())
+	relabeler.ApplyConfig(relabelConf)
+
+	collector := NewCollector(testLogger, sw)
+	collector.SetRelabeler(relabeler)
+
+	params := &storage.WriteProfileParams{
+		Service: "service1",
+		Type:    profile.TypeCPU,
+		Labels:  profile.Labels{{Key: "hostname", Value: "h1"}},
+	}
+	profModel, err := collector.WriteProfile(context.Background(), params, bytes.NewReader(pprofData))
+	require.NoError(t, err)
+	assert.Equal(t, profile.TestID, profModel.ProfileID)
+	assert.Equal(t, "host=h1", profModel.Labels.String())
+
+	params = &storage.WriteProfileParams{
+		Service: "drop-me",
+		Type:    profile.TypeCPU,
+	}
+	profModel, err = collector.WriteProfile(context.Background(), params, bytes.NewReader(pprofData))
+	require.NoError(t, err)
+	assert.Empty(t, profModel.ProfileID)
+	assert.Equal(t, "drop-me", profModel.Service)
+
+	require.Len(t, written, 1, "dropped profile must not be stored")
+}
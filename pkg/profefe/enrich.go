@@ -0,0 +1,182 @@
+package profefe
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// how long to wait for the reverse-DNS lookup of client's address
+	dnsLookupTimeout = time.Second
+	// how long to cache the results of reverse-DNS lookups, including the failed ones
+	dnsCacheTTL = 10 * time.Minute
+	// the cache is reset, when it grows beyond the limit
+	dnsCacheMaxSize = 10000
+)
+
+type EnrichConfig struct {
+	ClientIPLabel   string
+	ClientHostLabel string
+	IdentityLabel   string
+	HeaderLabels    string
+}
+
+func (conf *EnrichConfig) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ClientIPLabel, "enrich.client-ip-label", "", "label to set to client's IP address (disabled if empty)")
+	f.StringVar(&conf.ClientHostLabel, "enrich.client-host-label", "", "label to set to reverse-DNS name of client's IP address (disabled if empty)")
+	f.StringVar(&conf.IdentityLabel, "enrich.identity-label", "", "label to set to the name of authenticated client (disabled if empty)")
+	f.StringVar(&conf.HeaderLabels, "enrich.header-labels", "", "comma-separated list of header=label pairs, the values of request headers (gRPC metadata) to set as labels, e.g. X-Cluster=cluster")
+}
+
+// Enricher adds the labels derived from the request to the profiles the collector stores.
+type Enricher struct {
+	clientIPLabel   string
+	clientHostLabel string
+	identityLabel   string
+	headerLabels    []headerLabel
+
+	identity   func(ctx context.Context) string
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+
+	mu       sync.Mutex
+	dnsCache map[string]dnsCacheEntry
+	now      func() time.Time
+}
+
+type headerLabel struct {
+	header string
+	label  string
+}
+
+type dnsCacheEntry struct {
+	host      string
+	expiresAt time.Time
+}
+
+// NewEnricher creates an enricher from the config. The identity function returns the name of the client,
+// authenticated with the request, or empty string.
+func NewEnricher(conf EnrichConfig, identity func(ctx context.Context) string) (*Enricher, error) {
+	e := &Enricher{
+		clientIPLabel:   conf.ClientIPLabel,
+		clientHostLabel: conf.ClientHostLabel,
+		identityLabel:   conf.IdentityLabel,
+		identity:        identity,
+		lookupAddr:      net.DefaultResolver.LookupAddr,
+		dnsCache:        make(map[string]dnsCacheEntry),
+		now:             time.Now,
+	}
+
+	for _, pair := range strings.Split(conf.HeaderLabels, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		header, label := profile.Split2(pair, '=')
+		header, label = strings.TrimSpace(header), strings.TrimSpace(label)
+		if header == "" || label == "" {
+			return nil, fmt.Errorf("bad header label %q, want header=label", pair)
+		}
+		e.headerLabels = append(e.headerLabels, headerLabel{header: header, label: label})
+	}
+
+	return e, nil
+}
+
+// Enabled reports whether the enricher adds any labels.
+func (e *Enricher) Enabled() bool {
+	return e.clientIPLabel != "" || e.clientHostLabel != "" || e.identityLabel != "" || len(e.headerLabels) != 0
+}
+
+// adds the labels derived from the request to the profile's labels; nil enricher does nothing
+func (e *Enricher) enrich(ctx context.Context, params *storage.WriteProfileParams) {
+	if e == nil {
+		return
+	}
+
+	var labels profile.Labels
+	addLabel := func(key, value string) {
+		if value != "" {
+			labels = append(labels, profile.Label{Key: key, Value: value})
+		}
+	}
+
+	source := sourceFromContext(ctx)
+	if e.clientIPLabel != "" {
+		addLabel(e.clientIPLabel, source)
+	}
+	if e.clientHostLabel != "" && source != "" {
+		addLabel(e.clientHostLabel, e.lookupHost(ctx, source))
+	}
+	if e.identityLabel != "" && e.identity != nil {
+		addLabel(e.identityLabel, e.identity(ctx))
+	}
+	for _, hl := range e.headerLabels {
+		addLabel(hl.label, requestHeader(ctx, hl.header))
+	}
+
+	if len(labels) == 0 {
+		return
+	}
+	// the labels provided by the client go first
+	params.Labels = params.Labels.Add(labels)
+	sort.Stable(params.Labels)
+}
+
+// returns the reverse-DNS name of the address, or empty string if it has none
+func (e *Enricher) lookupHost(ctx context.Context, addr string) string {
+	now := e.now()
+
+	e.mu.Lock()
+	entry, ok := e.dnsCache[addr]
+	e.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+
+	entry = dnsCacheEntry{expiresAt: now.Add(dnsCacheTTL)}
+	if names, err := e.lookupAddr(ctx, addr); err == nil && len(names) > 0 {
+		entry.host = strings.TrimSuffix(names[0], ".")
+	}
+
+	e.mu.Lock()
+	if len(e.dnsCache) >= dnsCacheMaxSize {
+		e.dnsCache = make(map[string]dnsCacheEntry)
+	}
+	e.dnsCache[addr] = entry
+	e.mu.Unlock()
+
+	return entry.host
+}
+
+type headerKey struct{}
+
+func contextWithHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headerKey{}, header)
+}
+
+// returns the value of HTTP request header, or of gRPC request metadata, with the name
+func requestHeader(ctx context.Context, name string) string {
+	if header, ok := ctx.Value(headerKey{}).(http.Header); ok {
+		return header.Get(name)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(name); len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
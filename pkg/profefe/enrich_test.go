@@ -0,0 +1,117 @@
+package profefe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestEnricher_enrich(t *testing.T) {
+	conf := EnrichConfig{
+		ClientIPLabel:   "client_ip",
+		ClientHostLabel: "client_host",
+		IdentityLabel:   "identity",
+		HeaderLabels:    "X-Cluster=cluster, X-Deploy-Id=deploy_id",
+	}
+	e, err := NewEnricher(conf, func(ctx context.Context) string { return "ci" })
+	require.NoError(t, err)
+	require.True(t, e.Enabled())
+
+	var lookups int
+	e.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "10.0.0.1" {
+			return []string{"host1.example.com."}, nil
+		}
+		return nil, errors.New("not found")
+	}
+
+	t.Run("http", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("X-Cluster", "eu-1")
+		ctx := contextWithHeader(contextWithSource(context.Background(), "10.0.0.1"), header)
+
+		params := &storage.WriteProfileParams{
+			Labels: profile.Labels{{Key: "version", Value: "1.0"}, {Key: "cluster", Value: "eu-2"}},
+		}
+		e.enrich(ctx, params)
+
+		// client's labels aren't replaced
+		assert.Equal(t, "client_host=host1.example.com,client_ip=10.0.0.1,cluster=eu-2,cluster=eu-1,identity=ci,version=1.0", params.Labels.String())
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-deploy-id", "d42"))
+		ctx = contextWithSource(ctx, "10.0.0.2")
+
+		params := &storage.WriteProfileParams{}
+		e.enrich(ctx, params)
+
+		assert.Equal(t, "client_ip=10.0.0.2,deploy_id=d42,identity=ci", params.Labels.String())
+	})
+
+	// the lookups are cached
+	params := &storage.WriteProfileParams{}
+	e.enrich(contextWithSource(context.Background(), "10.0.0.1"), params)
+	assert.Equal(t, 2, lookups)
+
+	e.now = func() time.Time { return time.Now().Add(dnsCacheTTL) }
+	e.enrich(contextWithSource(context.Background(), "10.0.0.1"), params)
+	assert.Equal(t, 3, lookups)
+}
+
+func TestNewEnricher_badHeaderLabels(t *testing.T) {
+	for _, headerLabels := range []string{"X-Cluster", "X-Cluster=", "=cluster"} {
+		_, err := NewEnricher(EnrichConfig{HeaderLabels: headerLabels}, nil)
+		assert.Error(t, err, headerLabels)
+	}
+
+	e, err := NewEnricher(EnrichConfig{}, nil)
+	require.NoError(t, err)
+	assert.False(t, e.Enabled())
+}
+
+func TestProfilesHandler_enrich(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	var gotLabels profile.Labels
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			gotLabels = params.Labels
+			return profile.Meta{ProfileID: profile.TestID, Service: params.Service, Type: params.Type, Labels: params.Labels}, nil
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	enricher, err := NewEnricher(EnrichConfig{ClientIPLabel: "client_ip", HeaderLabels: "X-Cluster=cluster"}, nil)
+	require.NoError(t, err)
+
+	collector := NewCollector(testLogger, sw)
+	collector.SetEnricher(enricher)
+
+	h := NewProfilesHandler(testLogger, collector, NewQuerier(testLogger, &storage.StubReader{}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/0/profiles?service=svc1&type=cpu&labels=version=1.0", bytes.NewReader(pprofData))
+	req.RemoteAddr = "10.0.0.1:12345"
+	req.Header.Set("X-Cluster", "eu-1")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, "client_ip=10.0.0.1,cluster=eu-1,version=1.0", gotLabels.String())
+}
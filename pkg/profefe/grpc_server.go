@@ -0,0 +1,291 @@
+package profefe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefepb"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// the size of a chunk of profile's data sent with GetProfiles stream
+const grpcProfileChunkSize = 64 << 10
+
+// GRPCServer implements profefepb.ProfefeServer on top of Collector and Querier.
+type GRPCServer struct {
+	logger    *log.Logger
+	collector *Collector
+	querier   *Querier
+}
+
+var _ profefepb.ProfefeServer = (*GRPCServer)(nil)
+
+func NewGRPCServer(logger *log.Logger, collector *Collector, querier *Querier) *GRPCServer {
+	return &GRPCServer{
+		logger:    logger,
+		collector: collector,
+		querier:   querier,
+	}
+}
+
+func (s *GRPCServer) WriteProfiles(stream profefepb.Profefe_WriteProfilesServer) error {
+	ctx := stream.Context()
+	if p, ok := peer.FromContext(ctx); ok {
+		ctx = contextWithSource(ctx, sourceFromAddr(p.Addr.String()))
+	}
+
+	var (
+		params   *storage.WriteProfileParams
+		buf      bytes.Buffer
+		profiles []*profefepb.Profile
+	)
+
+	// writes the profile collected so far
+	flush := func() error {
+		if params == nil {
+			return nil
+		}
+		profModel, err := s.collector.WriteProfile(ctx, params, &buf)
+		if err != nil {
+			var rlErr *RateLimitError
+			if errors.As(err, &rlErr) {
+				stream.SetHeader(metadata.Pairs("retry-after", strconv.Itoa(rlErr.RetryAfterSeconds())))
+			}
+			return s.handleError("WriteProfiles", writeProfileError(err))
+		}
+		profiles = append(profiles, profileToPB(profModel))
+		params = nil
+		buf.Reset()
+		return nil
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch payload := req.Payload.(type) {
+		case *profefepb.WriteProfilesRequest_Params:
+			if err := flush(); err != nil {
+				return err
+			}
+			params = writeProfileParamsFromPB(payload.Params)
+			params.Tenant = tenant.FromContext(stream.Context())
+			if err := params.Validate(); err != nil {
+				return status.Errorf(codes.InvalidArgument, "bad request: %s", err)
+			}
+		case *profefepb.WriteProfilesRequest_Data:
+			if params == nil {
+				return status.Error(codes.InvalidArgument, "bad request: profile data sent before params")
+			}
+			buf.Write(payload.Data)
+		default:
+			return status.Errorf(codes.InvalidArgument, "bad request: unexpected payload %T", payload)
+		}
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&profefepb.WriteProfilesResponse{
+		Profiles: profiles,
+	})
+}
+
+func (s *GRPCServer) FindProfiles(ctx context.Context, req *profefepb.FindProfilesRequest) (*profefepb.FindProfilesResponse, error) {
+	params := findProfilesParamsFromPB(req)
+	params.Tenant = tenant.FromContext(ctx)
+	if err := params.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad request: %s", err)
+	}
+
+	profModels, err := s.querier.FindProfiles(ctx, params)
+	if err != nil {
+		return nil, s.handleError("FindProfiles", err)
+	}
+
+	resp := &profefepb.FindProfilesResponse{
+		Profiles: make([]*profefepb.Profile, 0, len(profModels)),
+	}
+	for _, profModel := range profModels {
+		resp.Profiles = append(resp.Profiles, profileToPB(profModel))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) GetProfiles(req *profefepb.GetProfilesRequest, stream profefepb.Profefe_GetProfilesServer) error {
+	if len(req.Ids) == 0 {
+		return status.Error(codes.InvalidArgument, "no profile id")
+	}
+
+	pids := make([]profile.ID, 0, len(req.Ids))
+	for _, id := range req.Ids {
+		if id == "" {
+			return status.Error(codes.InvalidArgument, "empty profile id")
+		}
+		pids = append(pids, profile.ID(id))
+	}
+
+	w := bufio.NewWriterSize(getProfilesStreamWriter{stream}, grpcProfileChunkSize)
+	if err := s.querier.GetProfilesTo(stream.Context(), w, tenant.FromContext(stream.Context()), pids); err != nil {
+		return s.handleError("GetProfiles", err)
+	}
+	return w.Flush()
+}
+
+func (s *GRPCServer) ListServices(ctx context.Context, _ *profefepb.ListServicesRequest) (*profefepb.ListServicesResponse, error) {
+	services, err := s.querier.ListServices(ctx, tenant.FromContext(ctx))
+	if err != nil {
+		return nil, s.handleError("ListServices", err)
+	}
+	return &profefepb.ListServicesResponse{
+		Services: services,
+	}, nil
+}
+
+// converts the error to gRPC status error, logging the unexpected ones
+func (s *GRPCServer) handleError(method string, err error) error {
+	st := grpcStatusFromError(err)
+	if st.Code() == codes.Internal || st.Code() == codes.Unknown {
+		if origErr := errors.Unwrap(err); origErr != nil {
+			err = origErr
+		}
+		s.logger.Errorw("grpc request failed", "method", method, zap.Error(err))
+	}
+	return st.Err()
+}
+
+func grpcStatusFromError(err error) *status.Status {
+	if err == storage.ErrNotFound {
+		return status.New(codes.NotFound, ErrNotFound.Error())
+	} else if err == storage.ErrNoResults {
+		return status.New(codes.NotFound, ErrNoResults.Error())
+	} else if err == context.Canceled {
+		return status.New(codes.Canceled, err.Error())
+	} else if err == context.DeadlineExceeded {
+		return status.New(codes.DeadlineExceeded, err.Error())
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return status.New(grpcCodeFromHTTPStatus(statusErr.Code()), statusErr.Error())
+	}
+	return status.New(codes.Internal, "internal server error")
+}
+
+func grpcCodeFromHTTPStatus(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound, http.StatusNoContent:
+		return codes.NotFound
+	case http.StatusMethodNotAllowed:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
+
+type getProfilesStreamWriter struct {
+	stream profefepb.Profefe_GetProfilesServer
+}
+
+func (w getProfilesStreamWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&profefepb.GetProfilesResponse{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func profileToPB(profModel Profile) *profefepb.Profile {
+	var ptype profile.ProfileType
+	ptype.FromString(profModel.Type)
+
+	return &profefepb.Profile{
+		Id:         string(profModel.ProfileID),
+		ExternalId: string(profModel.ExternalID),
+		Type:       profefepb.ProfileType(ptype),
+		Service:    profModel.Service,
+		Labels:     labelsToPB(profModel.Labels),
+		CreatedAt:  profModel.CreatedAt.UnixNano(),
+	}
+}
+
+func writeProfileParamsFromPB(in *profefepb.WriteProfileParams) *storage.WriteProfileParams {
+	params := &storage.WriteProfileParams{
+		ExternalID: profile.ID(in.ExternalId),
+		Service:    in.Service,
+		Type:       profile.ProfileType(in.Type),
+		Labels:     labelsFromPB(in.Labels),
+	}
+	if in.CreatedAt != 0 {
+		params.CreatedAt = time.Unix(0, in.CreatedAt).UTC()
+	}
+	return params
+}
+
+func findProfilesParamsFromPB(in *profefepb.FindProfilesRequest) *storage.FindProfilesParams {
+	params := &storage.FindProfilesParams{
+		Service: in.Service,
+		Type:    profile.ProfileType(in.Type),
+		Labels:  labelsFromPB(in.Labels),
+		Limit:   int(in.Limit),
+	}
+	if in.CreatedAtMin != 0 {
+		params.CreatedAtMin = time.Unix(0, in.CreatedAtMin).UTC()
+	}
+	if in.CreatedAtMax != 0 {
+		params.CreatedAtMax = time.Unix(0, in.CreatedAtMax).UTC()
+	}
+	return params
+}
+
+func labelsToPB(labels profile.Labels) []*profefepb.Label {
+	if len(labels) == 0 {
+		return nil
+	}
+	pbLabels := make([]*profefepb.Label, 0, len(labels))
+	for _, label := range labels {
+		pbLabels = append(pbLabels, &profefepb.Label{Key: label.Key, Value: label.Value})
+	}
+	return pbLabels
+}
+
+func labelsFromPB(pbLabels []*profefepb.Label) profile.Labels {
+	if len(pbLabels) == 0 {
+		return nil
+	}
+	labels := make(profile.Labels, 0, len(pbLabels))
+	for _, label := range pbLabels {
+		if label.Key == "" {
+			continue
+		}
+		labels = append(labels, profile.Label{Key: label.Key, Value: label.Value})
+	}
+	sort.Sort(labels)
+	return labels
+}
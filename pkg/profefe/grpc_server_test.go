@@ -0,0 +1,234 @@
+package profefe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefepb"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupGRPCClient(t *testing.T, sw storage.Writer, sr storage.Reader) profefepb.ProfefeClient {
+	testLogger := log.New(zaptest.NewLogger(t))
+
+	ln := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	profefepb.RegisterProfefeServer(server, NewGRPCServer(testLogger, NewCollector(testLogger, sw), NewQuerier(testLogger, sr)))
+	go server.Serve(ln)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	return profefepb.NewProfefeClient(conn)
+}
+
+func TestGRPCServer_WriteProfiles(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	var written []*storage.WriteProfileParams
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			data, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, pprofData, data)
+
+			written = append(written, params)
+
+			meta := profile.Meta{
+				ProfileID: profile.TestID,
+				Service:   params.Service,
+				Type:      params.Type,
+				Labels:    params.Labels,
+				CreatedAt: params.CreatedAt,
+			}
+			return meta, nil
+		},
+	}
+
+	client := setupGRPCClient(t, sw, &storage.StubReader{})
+
+	stream, err := client.WriteProfiles(context.Background())
+	require.NoError(t, err)
+
+	for _, service := range []string{"service1", "service2"} {
+		err := stream.Send(&profefepb.WriteProfilesRequest{
+			Payload: &profefepb.WriteProfilesRequest_Params{
+				Params: &profefepb.WriteProfileParams{
+					Service: service,
+					Type:    profefepb.ProfileType_CPU,
+					Labels:  []*profefepb.Label{{Key: "key1", Value: "val1"}},
+				},
+			},
+		})
+		require.NoError(t, err)
+
+		// send the data in two chunks
+		for _, chunk := range [][]byte{pprofData[:100], pprofData[100:]} {
+			err := stream.Send(&profefepb.WriteProfilesRequest{
+				Payload: &profefepb.WriteProfilesRequest_Data{Data: chunk},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	require.Len(t, resp.Profiles, 2)
+	require.Len(t, written, 2)
+
+	for i, service := range []string{"service1", "service2"} {
+		assert.Equal(t, string(profile.TestID), resp.Profiles[i].Id)
+		assert.Equal(t, service, resp.Profiles[i].Service)
+		assert.Equal(t, profefepb.ProfileType_CPU, resp.Profiles[i].Type)
+
+		assert.Equal(t, service, written[i].Service)
+		assert.Equal(t, profile.TypeCPU, written[i].Type)
+		assert.Equal(t, profile.Labels{{Key: "key1", Value: "val1"}}, written[i].Labels)
+	}
+}
+
+func TestGRPCServer_WriteProfiles_badRequest(t *testing.T) {
+	client := setupGRPCClient(t, &storage.StubWriter{}, &storage.StubReader{})
+
+	t.Run("data before params", func(t *testing.T) {
+		stream, err := client.WriteProfiles(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&profefepb.WriteProfilesRequest{
+			Payload: &profefepb.WriteProfilesRequest_Data{Data: []byte("data")},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("malformed profile", func(t *testing.T) {
+		stream, err := client.WriteProfiles(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&profefepb.WriteProfilesRequest{
+			Payload: &profefepb.WriteProfilesRequest_Params{
+				Params: &profefepb.WriteProfileParams{Service: "service1", Type: profefepb.ProfileType_CPU},
+			},
+		})
+		require.NoError(t, err)
+
+		err = stream.Send(&profefepb.WriteProfilesRequest{
+			Payload: &profefepb.WriteProfilesRequest_Data{Data: []byte("not a pprof")},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestGRPCServer_GetProfiles(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	sr := &storage.StubReader{
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			require.Equal(t, []profile.ID{profile.TestID}, pids)
+			return &testProfileList{data: [][]byte{pprofData}}, nil
+		},
+	}
+
+	client := setupGRPCClient(t, &storage.StubWriter{}, sr)
+
+	stream, err := client.GetProfiles(context.Background(), &profefepb.GetProfilesRequest{
+		Ids: []string{string(profile.TestID)},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		buf.Write(resp.Data)
+	}
+
+	assert.Equal(t, pprofData, buf.Bytes())
+}
+
+func TestGRPCServer_ListServices(t *testing.T) {
+	sr := &storage.StubReader{
+		ListServicesFunc: func(ctx context.Context, _ string) ([]string, error) {
+			return []string{"service2", "service1"}, nil
+		},
+	}
+
+	client := setupGRPCClient(t, &storage.StubWriter{}, sr)
+
+	resp, err := client.ListServices(context.Background(), &profefepb.ListServicesRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"service1", "service2"}, resp.Services)
+}
+
+func TestGRPCServer_FindProfiles_nothingFound(t *testing.T) {
+	sr := &storage.StubReader{
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			return nil, storage.ErrNotFound
+		},
+	}
+
+	client := setupGRPCClient(t, &storage.StubWriter{}, sr)
+
+	_, err := client.FindProfiles(context.Background(), &profefepb.FindProfilesRequest{
+		Service:      "service1",
+		CreatedAtMin: 1,
+		CreatedAtMax: 2,
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+type testProfileList struct {
+	data [][]byte
+	cur  []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.data) == 0 {
+		return false
+	}
+	pl.cur, pl.data = pl.data[0], pl.data[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) {
+	return bytes.NewReader(pl.cur), nil
+}
+
+func (pl *testProfileList) Close() error {
+	return nil
+}
@@ -110,8 +110,20 @@ func fixAPIPathLabel(p string) string {
 		return p
 	}
 	// fix ID-based API path making it suitable to be used in metrics labels
-	if strings.HasPrefix(p, apiProfilesPath) && p != apiProfilesMergePath {
+	if strings.HasPrefix(p, apiProfilesPath) && p != apiProfilesMergePath && p != apiProfilesBreakdownPath {
 		p = apiProfilesPath + "/__pid__"
 	}
 	return p
 }
+
+func newIngestRejectedMetric(registry prometheus.Registerer) *prometheus.CounterVec {
+	rejectedTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "profefe",
+		Name:      "ingest_rejected_profiles_total",
+		Help:      "Number of profiles rejected by rate limits and quotas.",
+	}, []string{"tenant", "service", "reason"})
+
+	registry.MustRegister(rejectedTotal)
+
+	return rejectedTotal
+}
@@ -1,8 +1,11 @@
 package profefe
 
 import (
+	"sort"
+	"strings"
 	"time"
 
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/profile"
 )
 
@@ -26,3 +29,133 @@ func ProfileFromProfileMeta(meta profile.Meta) Profile {
 		CreatedAt:  meta.CreatedAt.Truncate(time.Second),
 	}
 }
+
+// Breakdown is the JSON representation of the totals of merged profile's samples, grouped by the values
+// of a sample label.
+type Breakdown struct {
+	Label       string           `json:"label"`
+	SampleTypes []string         `json:"sample_types"`
+	Totals      []BreakdownTotal `json:"totals"`
+}
+
+// BreakdownTotal is the sum of the values of the samples with the label's value. The samples without
+// the label are summed up under empty value.
+type BreakdownTotal struct {
+	Value  string  `json:"value"`
+	Values []int64 `json:"values"`
+}
+
+func newBreakdown(pp *pprofProfile.Profile, label string) *Breakdown {
+	b := &Breakdown{
+		Label:       label,
+		SampleTypes: make([]string, 0, len(pp.SampleType)),
+	}
+	for _, st := range pp.SampleType {
+		b.SampleTypes = append(b.SampleTypes, st.Type+"/"+st.Unit)
+	}
+
+	totals := make(map[string][]int64)
+	for _, s := range pp.Sample {
+		value := strings.Join(s.Label[label], ",")
+		vals, ok := totals[value]
+		if !ok {
+			vals = make([]int64, len(pp.SampleType))
+			totals[value] = vals
+		}
+		for i, v := range s.Value {
+			vals[i] += v
+		}
+	}
+
+	b.Totals = make([]BreakdownTotal, 0, len(totals))
+	for value, vals := range totals {
+		b.Totals = append(b.Totals, BreakdownTotal{Value: value, Values: vals})
+	}
+
+	// the largest totals of the default sample type, which is the last one, go first
+	n := len(pp.SampleType) - 1
+	sort.Slice(b.Totals, func(i, j int) bool {
+		ti, tj := b.Totals[i], b.Totals[j]
+		if n >= 0 && ti.Values[n] != tj.Values[n] {
+			return ti.Values[n] > tj.Values[n]
+		}
+		return ti.Value < tj.Value
+	})
+
+	return b
+}
+
+// TopFunction is the JSON representation of the sum of the values of a function's samples in merged profile.
+type TopFunction struct {
+	Name string `json:"name"`
+	// the values of the samples, the function is the innermost frame of
+	Flat int64 `json:"flat"`
+	// the values of the samples, the function is in the stack of
+	Cum int64 `json:"cum"`
+}
+
+// returns the functions with the largest flat values of the sample type, the same way the aggregating storages
+// do, i.e. only the innermost function of a location is counted, and every function is counted once per sample.
+func newTopFunctions(pp *pprofProfile.Profile, sampleType string, limit int) []TopFunction {
+	// the default sample type is the last one
+	idx := len(pp.SampleType) - 1
+	if sampleType != "" {
+		idx = -1
+		for i, st := range pp.SampleType {
+			if st.Type == sampleType {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx < 0 {
+		return []TopFunction{}
+	}
+
+	totals := make(map[string]*TopFunction)
+	seen := make(map[string]bool)
+	for _, s := range pp.Sample {
+		v := s.Value[idx]
+		if v == 0 {
+			continue
+		}
+		for k := range seen {
+			delete(seen, k)
+		}
+		for n, loc := range s.Location {
+			if len(loc.Line) == 0 || loc.Line[0].Function == nil {
+				continue
+			}
+			name := loc.Line[0].Function.Name
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			ft, ok := totals[name]
+			if !ok {
+				ft = &TopFunction{Name: name}
+				totals[name] = ft
+			}
+			if n == 0 {
+				ft.Flat += v
+			}
+			ft.Cum += v
+		}
+	}
+
+	funcs := make([]TopFunction, 0, len(totals))
+	for _, ft := range totals {
+		funcs = append(funcs, *ft)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		if funcs[i].Flat != funcs[j].Flat {
+			return funcs[i].Flat > funcs[j].Flat
+		}
+		return funcs[i].Name < funcs[j].Name
+	})
+	if len(funcs) > limit {
+		funcs = funcs[:limit]
+	}
+	return funcs
+}
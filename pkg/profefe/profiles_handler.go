@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/pprofutil"
 	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
 )
 
 type ProfilesHandler struct {
@@ -43,6 +46,10 @@ func (h *ProfilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	} else if urlPath == apiProfilesMergePath {
 		err = h.HandleMergeProfiles(w, r)
+	} else if urlPath == apiProfilesBreakdownPath {
+		err = h.HandleBreakdownProfiles(w, r)
+	} else if urlPath == apiProfilesTopPath {
+		err = h.HandleTopFunctions(w, r)
 	} else if strings.HasPrefix(urlPath, apiProfilesPath) {
 		err = h.HandleGetProfile(w, r)
 	} else {
@@ -58,13 +65,15 @@ func (h *ProfilesHandler) HandleCreateProfile(w http.ResponseWriter, r *http.Req
 		return err
 	}
 
-	profModel, err := h.collector.WriteProfile(r.Context(), params, r.Body)
+	ctx := contextWithSource(r.Context(), sourceFromAddr(r.RemoteAddr))
+	ctx = contextWithHeader(ctx, r.Header)
+	profModel, err := h.collector.WriteProfile(ctx, params, r.Body)
 	if err != nil {
-		var perr *pprofutil.ProfileParserError
-		if errors.As(err, &perr) {
-			return StatusError(http.StatusBadRequest, fmt.Sprintf("malformed profile (%s)", err), perr)
+		var rlErr *RateLimitError
+		if errors.As(err, &rlErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(rlErr.RetryAfterSeconds()))
 		}
-		return StatusError(http.StatusInternalServerError, "failed to collect profile", err)
+		return writeProfileError(err)
 	}
 
 	ReplyJSON(w, profModel)
@@ -72,6 +81,22 @@ func (h *ProfilesHandler) HandleCreateProfile(w http.ResponseWriter, r *http.Req
 	return nil
 }
 
+// wraps Collector.WriteProfile error making it suitable to reply to the client
+func writeProfileError(err error) error {
+	var perr *pprofutil.ProfileParserError
+	if errors.As(err, &perr) {
+		return StatusError(http.StatusBadRequest, fmt.Sprintf("malformed profile (%s)", err), perr)
+	}
+	var rlErr *RateLimitError
+	if errors.As(err, &rlErr) {
+		return StatusError(http.StatusTooManyRequests, err.Error(), rlErr)
+	}
+	if errors.Is(err, relabel.ErrBadParams) {
+		return StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: %s", err), err)
+	}
+	return StatusError(http.StatusInternalServerError, "failed to collect profile", err)
+}
+
 func (h *ProfilesHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) error {
 	rawPids := r.URL.Path[len(apiProfilesPath):] // id part of the path
 	rawPids = strings.Trim(rawPids, "/")
@@ -92,7 +117,7 @@ func (h *ProfilesHandler) HandleGetProfile(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, rawPids))
 
-	err = h.querier.GetProfilesTo(r.Context(), w, pids)
+	err = h.querier.GetProfilesTo(r.Context(), w, tenant.FromContext(r.Context()), pids)
 	if err == storage.ErrNotFound {
 		return ErrNotFound
 	} else if err == storage.ErrNoResults {
@@ -131,6 +156,9 @@ func (h *ProfilesHandler) HandleMergeProfiles(w http.ResponseWriter, r *http.Req
 	if err := parseFindProfileParams(params, r); err != nil {
 		return err
 	}
+	if err := parseSampleLabels(params, r); err != nil {
+		return err
+	}
 
 	switch params.Type {
 	case profile.TypeUnknown, profile.TypeTrace:
@@ -148,3 +176,77 @@ func (h *ProfilesHandler) HandleMergeProfiles(w http.ResponseWriter, r *http.Req
 	}
 	return err
 }
+
+func (h *ProfilesHandler) HandleBreakdownProfiles(w http.ResponseWriter, r *http.Request) error {
+	params := &storage.FindProfilesParams{}
+	if err := parseFindProfileParams(params, r); err != nil {
+		return err
+	}
+	if err := parseSampleLabels(params, r); err != nil {
+		return err
+	}
+
+	switch params.Type {
+	case profile.TypeUnknown, profile.TypeTrace:
+		return StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("can't merge profiles of %v type", params.Type), nil)
+	}
+
+	label := r.URL.Query().Get("by")
+	if label == "" {
+		return StatusError(http.StatusBadRequest, "bad request: missing \"by\"", nil)
+	}
+
+	breakdown, err := h.querier.FindBreakdown(r.Context(), params, label)
+	if err == storage.ErrNotFound {
+		return ErrNotFound
+	} else if err == storage.ErrNoResults {
+		return ErrNoResults
+	} else if err != nil {
+		return err
+	}
+
+	ReplyJSON(w, breakdown)
+
+	return nil
+}
+
+const defaultTopFunctions = 10
+
+func (h *ProfilesHandler) HandleTopFunctions(w http.ResponseWriter, r *http.Request) error {
+	params := &storage.FindProfilesParams{}
+	if err := parseFindProfileParams(params, r); err != nil {
+		return err
+	}
+	if err := parseSampleLabels(params, r); err != nil {
+		return err
+	}
+
+	switch params.Type {
+	case profile.TypeUnknown, profile.TypeTrace:
+		return StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("can't merge profiles of %v type", params.Type), nil)
+	}
+
+	q := r.URL.Query()
+
+	top := defaultTopFunctions
+	if v := q.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: bad \"top\" %q", v), nil)
+		}
+		top = n
+	}
+
+	funcs, err := h.querier.FindTopFunctions(r.Context(), params, q.Get("sample_type"), top)
+	if err == storage.ErrNotFound {
+		return ErrNotFound
+	} else if err == storage.ErrNoResults {
+		return ErrNoResults
+	} else if err != nil {
+		return err
+	}
+
+	ReplyJSON(w, funcs)
+
+	return nil
+}
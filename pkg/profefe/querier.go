@@ -1,6 +1,7 @@
 package profefe
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -12,9 +13,21 @@ import (
 	"github.com/profefe/profefe/pkg/storage"
 )
 
+// Symbolizer resolves the addresses of profile's locations that don't have symbol information.
+type Symbolizer interface {
+	// Symbolize reports whether any location of the profile was symbolized.
+	Symbolize(ctx context.Context, pp *pprofProfile.Profile) (bool, error)
+}
+
+// ResolutionSelector selects, among the found profiles, the profiles a query is answered with, when the storage
+// holds the profiles of different resolutions, e.g. the aggregates of compacted profiles.
+type ResolutionSelector func(params *storage.FindProfilesParams, metas []profile.Meta) []profile.Meta
+
 type Querier struct {
-	logger *log.Logger
-	sr     storage.Reader
+	logger     *log.Logger
+	sr         storage.Reader
+	symbolizer Symbolizer
+	resolution ResolutionSelector
 }
 
 func NewQuerier(logger *log.Logger, sr storage.Reader) *Querier {
@@ -24,56 +37,161 @@ func NewQuerier(logger *log.Logger, sr storage.Reader) *Querier {
 	}
 }
 
-func (q *Querier) GetProfilesTo(ctx context.Context, dst io.Writer, pids []profile.ID) error {
-	list, err := q.sr.ListProfiles(ctx, pids)
+// SetSymbolizer sets the symbolizer the profiles are symbolized with before they are returned or merged.
+func (q *Querier) SetSymbolizer(symbolizer Symbolizer) {
+	q.symbolizer = symbolizer
+}
+
+// SetResolutionSelector sets the function, that selects the resolution of the profiles the queries are answered with.
+func (q *Querier) SetResolutionSelector(resolution ResolutionSelector) {
+	q.resolution = resolution
+}
+
+func (q *Querier) GetProfilesTo(ctx context.Context, dst io.Writer, tenant string, pids []profile.ID) error {
+	if len(pids) != 1 {
+		pp, err := q.mergeProfileIDs(ctx, tenant, pids, nil)
+		if err != nil {
+			return err
+		}
+		return pp.Write(dst)
+	}
+
+	list, err := q.sr.ListProfiles(ctx, tenant, pids)
 	if err != nil {
 		return err
 	}
 	defer list.Close()
 
-	if len(pids) == 1 {
-		if !list.Next() {
-			return storage.ErrNotFound
-		}
-		pr, err := list.Profile()
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(dst, pr)
+	if !list.Next() {
+		return storage.ErrNotFound
+	}
+	pr, err := list.Profile()
+	if err != nil {
 		return err
 	}
+	return q.copyProfile(ctx, dst, pr)
+}
+
+// returns the storage's aggregator, unless the profiles must be symbolized before they are merged
+func (q *Querier) aggregator() storage.Aggregator {
+	if q.symbolizer != nil {
+		return nil
+	}
+	agg, _ := q.sr.(storage.Aggregator)
+	return agg
+}
+
+// merges the profiles, leaving only the samples that have all sample labels; the profiles are merged by
+// the storage, if it aggregates the samples itself
+func (q *Querier) mergeProfileIDs(ctx context.Context, tenant string, pids []profile.ID, sampleLabels profile.Labels) (*pprofProfile.Profile, error) {
+	if agg := q.aggregator(); agg != nil {
+		return agg.MergeProfiles(ctx, &storage.AggregateParams{
+			Tenant:       tenant,
+			ProfileIDs:   pids,
+			SampleLabels: sampleLabels,
+		})
+	}
+
+	list, err := q.sr.ListProfiles(ctx, tenant, pids)
+	if err != nil {
+		return nil, err
+	}
+	defer list.Close()
 
+	return q.mergeProfiles(ctx, list, len(pids), sampleLabels)
+}
+
+// parses and merges the profiles from the list, leaving only the samples that have all sample labels
+func (q *Querier) mergeProfiles(ctx context.Context, list storage.ProfileList, n int, sampleLabels profile.Labels) (*pprofProfile.Profile, error) {
 	// TODO(narqo): limit maximum number of profiles to merge; as an example,
 	//  Stackdriver merges up to 250 random profiles if query returns more than that
-	pps := make([]*pprofProfile.Profile, 0, len(pids))
+	pps := make([]*pprofProfile.Profile, 0, n)
 	for list.Next() {
 		// exit fast if context canceled
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
 		pr, err := list.Profile()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		p, err := pprofProfile.Parse(pr)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if len(sampleLabels) > 0 {
+			p.FilterSamplesByTag(matchSampleLabels(sampleLabels), nil)
+		}
+		if q.symbolizer != nil {
+			if _, err := q.symbolizer.Symbolize(ctx, p); err != nil {
+				return nil, err
+			}
 		}
 		pps = append(pps, p)
 	}
 
 	pp, err := pprofProfile.Merge(pps)
 	if err != nil {
-		return fmt.Errorf("could not merge %d profiles: %w", len(pps), err)
+		return nil, fmt.Errorf("could not merge %d profiles: %w", len(pps), err)
+	}
+	return pp, nil
+}
+
+// returns the tag matcher that selects the samples having all the labels
+func matchSampleLabels(labels profile.Labels) pprofProfile.TagMatch {
+	return func(s *pprofProfile.Sample) bool {
+		for _, label := range labels {
+			if !containsString(s.Label[label.Key], label.Value) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copies the profile to dst as is, unless some of its locations were symbolized
+func (q *Querier) copyProfile(ctx context.Context, dst io.Writer, pr io.Reader) error {
+	if q.symbolizer == nil {
+		_, err := io.Copy(dst, pr)
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(pr); err != nil {
+		return err
+	}
+
+	pp, err := pprofProfile.ParseData(buf.Bytes())
+	if err != nil {
+		// not every stored profile is pprof-formatted, e.g. runtime traces are returned as is
+		_, err = buf.WriteTo(dst)
+		return err
+	}
+	symbolized, err := q.symbolizer.Symbolize(ctx, pp)
+	if err != nil {
+		return err
+	}
+	if !symbolized {
+		_, err = buf.WriteTo(dst)
+		return err
 	}
 	return pp.Write(dst)
 }
 
 func (q *Querier) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]Profile, error) {
-	metas, err := q.sr.FindProfiles(ctx, params)
+	metas, err := q.findProfiles(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -90,17 +208,114 @@ func (q *Querier) FindProfiles(ctx context.Context, params *storage.FindProfiles
 	return profModels, nil
 }
 
+// WalkProfiles calls the fn with the metas of all the profiles, found by the params, batch by batch in the order
+// of their creation; the params' limit is the size of a batch.
+func (q *Querier) WalkProfiles(ctx context.Context, params *storage.FindProfilesParams, fn func(metas []profile.Meta) error) error {
+	return storage.WalkProfiles(ctx, q.sr, params, fn)
+}
+
 func (q *Querier) FindMergeProfileTo(ctx context.Context, dst io.Writer, params *storage.FindProfilesParams) error {
-	pids, err := q.sr.FindProfileIDs(ctx, params)
+	if len(params.SampleLabels) == 0 {
+		pids, err := q.findProfileIDs(ctx, params)
+		if err != nil {
+			return err
+		}
+		return q.GetProfilesTo(ctx, dst, params.Tenant, pids)
+	}
+
+	pp, _, err := q.FindMergeProfile(ctx, params)
 	if err != nil {
 		return err
 	}
+	return pp.Write(dst)
+}
+
+// FindBreakdown merges the profiles found by the params and returns the totals of their samples,
+// grouped by the values of the sample label.
+func (q *Querier) FindBreakdown(ctx context.Context, params *storage.FindProfilesParams, label string) (*Breakdown, error) {
+	pp, _, err := q.FindMergeProfile(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return newBreakdown(pp, label), nil
+}
+
+// FindMergeProfile returns the profiles found by the params, merged into a single profile,
+// and the number of the merged profiles.
+func (q *Querier) FindMergeProfile(ctx context.Context, params *storage.FindProfilesParams) (*pprofProfile.Profile, int, error) {
+	pids, err := q.findProfileIDs(ctx, params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pp, err := q.mergeProfileIDs(ctx, params.Tenant, pids, params.SampleLabels)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pp, len(pids), nil
+}
+
+// FindTopFunctions returns the functions of the profiles, found by the params, with the largest flat values
+// of the sample type; the default sample type of the profiles is used if the sample type is empty.
+func (q *Querier) FindTopFunctions(ctx context.Context, params *storage.FindProfilesParams, sampleType string, limit int) ([]TopFunction, error) {
+	pids, err := q.findProfileIDs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if agg := q.aggregator(); agg != nil {
+		aggParams := &storage.AggregateParams{
+			Tenant:       params.Tenant,
+			ProfileIDs:   pids,
+			SampleLabels: params.SampleLabels,
+		}
+		totals, err := agg.TopFunctions(ctx, aggParams, sampleType, limit)
+		if err != nil {
+			return nil, err
+		}
+		funcs := make([]TopFunction, 0, len(totals))
+		for _, ft := range totals {
+			funcs = append(funcs, TopFunction{Name: ft.Name, Flat: ft.Flat, Cum: ft.Cum})
+		}
+		return funcs, nil
+	}
 
-	return q.GetProfilesTo(ctx, dst, pids)
+	pp, err := q.mergeProfileIDs(ctx, params.Tenant, pids, params.SampleLabels)
+	if err != nil {
+		return nil, err
+	}
+	return newTopFunctions(pp, sampleType, limit), nil
+}
+
+// finds the metas of the profiles of the selected resolution
+func (q *Querier) findProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	metas, err := q.sr.FindProfiles(ctx, params)
+	if err != nil || q.resolution == nil {
+		return metas, err
+	}
+	return q.resolution(params, metas), nil
+}
+
+// finds the ids of the profiles of the selected resolution; the metas are only looked up, if the profiles
+// of different resolutions are stored
+func (q *Querier) findProfileIDs(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+	if q.resolution == nil {
+		return q.sr.FindProfileIDs(ctx, params)
+	}
+
+	metas, err := q.findProfiles(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]profile.ID, 0, len(metas))
+	for _, meta := range metas {
+		pids = append(pids, meta.ProfileID)
+	}
+	return pids, nil
 }
 
-func (q *Querier) ListServices(ctx context.Context) ([]string, error) {
-	services, err := q.sr.ListServices(ctx)
+func (q *Querier) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	services, err := q.sr.ListServices(ctx, tenant)
 	if err != nil {
 		return nil, err
 	}
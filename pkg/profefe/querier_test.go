@@ -1,22 +1,29 @@
 package profefe
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/profile"
 	"github.com/profefe/profefe/pkg/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 )
 
 func TestQuerier_GetProfilesTo_contextCancelled(t *testing.T) {
 	list := &unboundProfileList{}
 	sr := &storage.StubReader{
-		ListProfilesFunc: func(ctx context.Context, _ []profile.ID) (storage.ProfileList, error) {
+		ListProfilesFunc: func(ctx context.Context, _ string, _ []profile.ID) (storage.ProfileList, error) {
 			return list, nil
 		},
 	}
@@ -28,7 +35,7 @@ func TestQuerier_GetProfilesTo_contextCancelled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	err := querier.GetProfilesTo(ctx, ioutil.Discard, []profile.ID{"p1", "p2"})
+	err := querier.GetProfilesTo(ctx, ioutil.Discard, "", []profile.ID{"p1", "p2"})
 	assert.Equal(t, context.Canceled, err)
 
 	assert.True(t, list.closed, "profile list must be closed")
@@ -49,3 +56,413 @@ func (pl *unboundProfileList) Close() error {
 }
 
 func (pl *unboundProfileList) Profile() (pr io.Reader, err error) { return }
+
+type stubSymbolizer struct {
+	symbolized bool
+	calls      int
+}
+
+func (s *stubSymbolizer) Symbolize(ctx context.Context, pp *pprofProfile.Profile) (bool, error) {
+	s.calls++
+	if s.symbolized {
+		for _, loc := range pp.Location {
+			loc.Line = nil
+		}
+	}
+	return s.symbolized, nil
+}
+
+func TestQuerier_GetProfilesTo_symbolize(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	sr := &storage.StubReader{
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &bytesProfileList{}
+			for range pids {
+				list.profiles = append(list.profiles, pprofData)
+			}
+			return list, nil
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+
+	t.Run("not symbolized", func(t *testing.T) {
+		symbolizer := &stubSymbolizer{}
+		querier := NewQuerier(testLogger, sr)
+		querier.SetSymbolizer(symbolizer)
+
+		var buf bytes.Buffer
+		err := querier.GetProfilesTo(context.Background(), &buf, "", []profile.ID{"p1"})
+		require.NoError(t, err)
+		assert.Equal(t, pprofData, buf.Bytes(), "profile must be returned as is")
+		assert.Equal(t, 1, symbolizer.calls)
+	})
+
+	for _, pids := range [][]profile.ID{{"p1"}, {"p1", "p2"}} {
+		t.Run(fmt.Sprintf("symbolized profiles=%d", len(pids)), func(t *testing.T) {
+			symbolizer := &stubSymbolizer{symbolized: true}
+			querier := NewQuerier(testLogger, sr)
+			querier.SetSymbolizer(symbolizer)
+
+			var buf bytes.Buffer
+			err := querier.GetProfilesTo(context.Background(), &buf, "", pids)
+			require.NoError(t, err)
+			assert.Equal(t, len(pids), symbolizer.calls)
+
+			pp, err := pprofProfile.Parse(&buf)
+			require.NoError(t, err)
+			for _, loc := range pp.Location {
+				assert.Empty(t, loc.Line)
+			}
+		})
+	}
+}
+
+type bytesProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *bytesProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *bytesProfileList) Profile() (io.Reader, error) {
+	return bytes.NewReader(pl.data), nil
+}
+
+func (pl *bytesProfileList) Close() error {
+	return nil
+}
+
+func newTestSampleLabelsProfile(t *testing.T) []byte {
+	fn := &pprofProfile.Function{ID: 1, Name: "main.work"}
+	loc := &pprofProfile.Location{ID: 1, Line: []pprofProfile.Line{{Function: fn, Line: 10}}}
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		Function: []*pprofProfile.Function{fn},
+		Location: []*pprofProfile.Location{loc},
+		Sample: []*pprofProfile.Sample{
+			{Location: []*pprofProfile.Location{loc}, Value: []int64{1, 10}, Label: map[string][]string{"endpoint": {"/checkout"}}},
+			{Location: []*pprofProfile.Location{loc}, Value: []int64{2, 20}, Label: map[string][]string{"endpoint": {"/cart"}, "region": {"eu"}}},
+			{Location: []*pprofProfile.Location{loc}, Value: []int64{4, 40}, Label: map[string][]string{"endpoint": {"/checkout"}, "region": {"eu"}}},
+			{Location: []*pprofProfile.Location{loc}, Value: []int64{8, 5}},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, pp.Write(&buf))
+	return buf.Bytes()
+}
+
+func newTestSampleLabelsReader(data []byte, gotParams **storage.FindProfilesParams) *storage.StubReader {
+	return &storage.StubReader{
+		FindProfileIDsFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+			*gotParams = params
+			return []profile.ID{"p1", "p2"}, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &bytesProfileList{}
+			for range pids {
+				list.profiles = append(list.profiles, data)
+			}
+			return list, nil
+		},
+	}
+}
+
+func TestQuerier_FindMergeProfileTo_sampleLabels(t *testing.T) {
+	var gotParams *storage.FindProfilesParams
+	sr := newTestSampleLabelsReader(newTestSampleLabelsProfile(t), &gotParams)
+
+	querier := NewQuerier(log.New(zaptest.NewLogger(t)), sr)
+
+	params := &storage.FindProfilesParams{
+		Service:      "svc1",
+		Type:         profile.TypeCPU,
+		SampleLabels: profile.Labels{{Key: "endpoint", Value: "/checkout"}},
+	}
+	var buf bytes.Buffer
+	err := querier.FindMergeProfileTo(context.Background(), &buf, params)
+	require.NoError(t, err)
+	assert.Equal(t, params, gotParams, "sample labels must be passed to the storage")
+
+	pp, err := pprofProfile.Parse(&buf)
+	require.NoError(t, err)
+
+	var total int64
+	for _, s := range pp.Sample {
+		assert.Equal(t, []string{"/checkout"}, s.Label["endpoint"])
+		total += s.Value[1]
+	}
+	assert.Equal(t, int64(2*(10+40)), total)
+}
+
+func TestQuerier_FindBreakdown(t *testing.T) {
+	var gotParams *storage.FindProfilesParams
+	sr := newTestSampleLabelsReader(newTestSampleLabelsProfile(t), &gotParams)
+
+	querier := NewQuerier(log.New(zaptest.NewLogger(t)), sr)
+
+	t.Run("all samples", func(t *testing.T) {
+		params := &storage.FindProfilesParams{Service: "svc1", Type: profile.TypeCPU}
+		breakdown, err := querier.FindBreakdown(context.Background(), params, "endpoint")
+		require.NoError(t, err)
+
+		want := &Breakdown{
+			Label:       "endpoint",
+			SampleTypes: []string{"samples/count", "cpu/nanoseconds"},
+			Totals: []BreakdownTotal{
+				{Value: "/checkout", Values: []int64{10, 100}},
+				{Value: "/cart", Values: []int64{4, 40}},
+				{Value: "", Values: []int64{16, 10}},
+			},
+		}
+		assert.Equal(t, want, breakdown)
+	})
+
+	t.Run("filtered by sample labels", func(t *testing.T) {
+		params := &storage.FindProfilesParams{
+			Service:      "svc1",
+			Type:         profile.TypeCPU,
+			SampleLabels: profile.Labels{{Key: "region", Value: "eu"}},
+		}
+		breakdown, err := querier.FindBreakdown(context.Background(), params, "endpoint")
+		require.NoError(t, err)
+
+		assert.Equal(t, []BreakdownTotal{
+			{Value: "/checkout", Values: []int64{8, 80}},
+			{Value: "/cart", Values: []int64{4, 40}},
+		}, breakdown.Totals)
+	})
+}
+
+func TestQuerier_FindMergeProfile_resolution(t *testing.T) {
+	data := newTestSampleLabelsProfile(t)
+
+	var listed []profile.ID
+	sr := &storage.StubReader{
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			return []profile.Meta{{ProfileID: "p1"}, {ProfileID: "agg1"}, {ProfileID: "p2"}}, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			listed = pids
+			list := &bytesProfileList{}
+			for range pids {
+				list.profiles = append(list.profiles, data)
+			}
+			return list, nil
+		},
+	}
+
+	querier := NewQuerier(log.New(zaptest.NewLogger(t)), sr)
+	querier.SetResolutionSelector(func(params *storage.FindProfilesParams, metas []profile.Meta) []profile.Meta {
+		return metas[1:2]
+	})
+
+	params := &storage.FindProfilesParams{Service: "svc1", Type: profile.TypeCPU}
+	_, n, err := querier.FindMergeProfile(context.Background(), params)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []profile.ID{"agg1"}, listed)
+
+	profs, err := querier.FindProfiles(context.Background(), params)
+	require.NoError(t, err)
+	require.Len(t, profs, 1)
+	assert.Equal(t, profile.ID("agg1"), profs[0].ProfileID)
+}
+
+func TestProfilesHandler_breakdown(t *testing.T) {
+	var gotParams *storage.FindProfilesParams
+	sr := newTestSampleLabelsReader(newTestSampleLabelsProfile(t), &gotParams)
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	h := NewProfilesHandler(testLogger, NewCollector(testLogger, &storage.StubWriter{}), NewQuerier(testLogger, sr))
+
+	const query = "service=svc1&type=cpu&from=2020-01-01T00:00:00&to=2020-01-02T00:00:00&sample_labels=region=eu"
+
+	t.Run("no by", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/0/profiles/breakdown?"+query, nil)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/0/profiles/breakdown?"+query+"&by=endpoint", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, "region=eu", gotParams.SampleLabels.String())
+
+	var body struct {
+		Body Breakdown `json:"body"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "endpoint", body.Body.Label)
+	require.Len(t, body.Body.Totals, 2)
+	assert.Equal(t, "/checkout", body.Body.Totals[0].Value)
+}
+
+func TestQuerier_FindTopFunctions(t *testing.T) {
+	var gotParams *storage.FindProfilesParams
+	sr := newTestSampleLabelsReader(newTestSampleLabelsProfile(t), &gotParams)
+
+	querier := NewQuerier(log.New(zaptest.NewLogger(t)), sr)
+
+	params := &storage.FindProfilesParams{Service: "svc1", Type: profile.TypeCPU}
+
+	t.Run("default sample type", func(t *testing.T) {
+		funcs, err := querier.FindTopFunctions(context.Background(), params, "", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []TopFunction{{Name: "main.work", Flat: 2 * 75, Cum: 2 * 75}}, funcs)
+	})
+
+	t.Run("sample type", func(t *testing.T) {
+		funcs, err := querier.FindTopFunctions(context.Background(), params, "samples", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []TopFunction{{Name: "main.work", Flat: 2 * 15, Cum: 2 * 15}}, funcs)
+	})
+
+	t.Run("unknown sample type", func(t *testing.T) {
+		funcs, err := querier.FindTopFunctions(context.Background(), params, "alloc_space", 10)
+		require.NoError(t, err)
+		assert.Empty(t, funcs)
+	})
+}
+
+func TestNewTopFunctions(t *testing.T) {
+	data, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	pp, err := pprofProfile.ParseData(data)
+	require.NoError(t, err)
+
+	var total int64
+	for _, s := range pp.Sample {
+		total += s.Value[1]
+	}
+
+	funcs := newTopFunctions(pp, "", 5)
+	require.Len(t, funcs, 5)
+	for i, ft := range funcs {
+		assert.True(t, ft.Flat <= ft.Cum, "flat must not exceed cum: %v", ft)
+		assert.True(t, ft.Cum <= total, "cum must not exceed total: %v", ft)
+		if i > 0 {
+			assert.True(t, funcs[i-1].Flat >= ft.Flat, "functions must be sorted by flat: %v", funcs)
+		}
+	}
+}
+
+type aggregatingReader struct {
+	*storage.StubReader
+	*storage.StubAggregator
+}
+
+func TestQuerier_aggregator(t *testing.T) {
+	data := newTestSampleLabelsProfile(t)
+	merged, err := pprofProfile.ParseData(data)
+	require.NoError(t, err)
+
+	var (
+		gotParams *storage.FindProfilesParams
+		aggParams *storage.AggregateParams
+	)
+	sr := &aggregatingReader{
+		StubReader: newTestSampleLabelsReader(data, &gotParams),
+		StubAggregator: &storage.StubAggregator{
+			MergeProfilesFunc: func(ctx context.Context, params *storage.AggregateParams) (*pprofProfile.Profile, error) {
+				aggParams = params
+				return merged, nil
+			},
+			TopFunctionsFunc: func(ctx context.Context, params *storage.AggregateParams, sampleType string, limit int) ([]storage.FunctionTotal, error) {
+				aggParams = params
+				return []storage.FunctionTotal{{Name: "main.work", Flat: 1, Cum: 2}}, nil
+			},
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+
+	params := &storage.FindProfilesParams{
+		Tenant:       "t1",
+		Service:      "svc1",
+		Type:         profile.TypeCPU,
+		SampleLabels: profile.Labels{{Key: "region", Value: "eu"}},
+	}
+	wantAggParams := &storage.AggregateParams{
+		Tenant:       "t1",
+		ProfileIDs:   []profile.ID{"p1", "p2"},
+		SampleLabels: params.SampleLabels,
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		aggParams = nil
+		querier := NewQuerier(testLogger, sr)
+
+		pp, n, err := querier.FindMergeProfile(context.Background(), params)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, merged, pp, "profiles must be merged by the storage")
+		assert.Equal(t, wantAggParams, aggParams)
+	})
+
+	t.Run("top", func(t *testing.T) {
+		aggParams = nil
+		querier := NewQuerier(testLogger, sr)
+
+		funcs, err := querier.FindTopFunctions(context.Background(), params, "", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []TopFunction{{Name: "main.work", Flat: 1, Cum: 2}}, funcs)
+		assert.Equal(t, wantAggParams, aggParams)
+	})
+
+	t.Run("symbolized", func(t *testing.T) {
+		aggParams = nil
+		querier := NewQuerier(testLogger, sr)
+		querier.SetSymbolizer(&stubSymbolizer{})
+
+		_, _, err := querier.FindMergeProfile(context.Background(), params)
+		require.NoError(t, err)
+		assert.Nil(t, aggParams, "profiles must be merged in memory to be symbolized")
+	})
+}
+
+func TestProfilesHandler_top(t *testing.T) {
+	var gotParams *storage.FindProfilesParams
+	sr := newTestSampleLabelsReader(newTestSampleLabelsProfile(t), &gotParams)
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	h := NewProfilesHandler(testLogger, NewCollector(testLogger, &storage.StubWriter{}), NewQuerier(testLogger, sr))
+
+	const query = "service=svc1&type=cpu&from=2020-01-01T00:00:00&to=2020-01-02T00:00:00&sample_labels=region=eu"
+
+	t.Run("bad top", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/0/profiles/top?"+query+"&top=0", nil)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/0/profiles/top?"+query+"&sample_type=samples&top=5", nil)
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, "region=eu", gotParams.SampleLabels.String())
+
+	var body struct {
+		Body []TopFunction `json:"body"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, []TopFunction{{Name: "main.work", Flat: 2 * 6, Cum: 2 * 6}}, body.Body)
+}
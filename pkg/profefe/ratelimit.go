@@ -0,0 +1,290 @@
+package profefe
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	rejectReasonProfilesRate = "profiles_rate"
+	rejectReasonBytesRate    = "bytes_rate"
+	rejectReasonDailyQuota   = "daily_quota"
+)
+
+// how often the limiter drops the buckets of the sources that stopped sending profiles
+const rateLimitCleanupInterval = 10 * time.Minute
+
+type RateLimitConfig struct {
+	ProfilesPerSecond float64
+	ProfilesBurst     int
+	BytesPerSecond    float64
+	BytesBurst        int64
+	DailyQuotaBytes   int64
+}
+
+func (conf *RateLimitConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Float64Var(&conf.ProfilesPerSecond, "ratelimit.profiles-per-second", 0, "rate of profiles a single source can store per service (unlimited if zero)")
+	f.IntVar(&conf.ProfilesBurst, "ratelimit.profiles-burst", 10, "number of profiles a single source can store per service at once")
+	f.Float64Var(&conf.BytesPerSecond, "ratelimit.bytes-per-second", 0, "rate of bytes a single source can store per service (unlimited if zero)")
+	f.Int64Var(&conf.BytesBurst, "ratelimit.bytes-burst", 32<<20, "number of bytes a single source can store per service at once")
+	f.Int64Var(&conf.DailyQuotaBytes, "ratelimit.daily-quota-bytes", 0, "number of bytes a service can store per day (UTC) (unlimited if zero)")
+}
+
+// RateLimitError is returned when the profile is rejected by the rate limits or quotas.
+type RateLimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("rate limit exceeded (%s), retry after %s", e.Reason, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfterSeconds returns the value of Retry-After header.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+type rateLimitKey struct {
+	tenant  string
+	service string
+	source  string
+}
+
+// RateLimiter limits the rate of profiles and bytes stored by a source for a service with token buckets,
+// and the number of bytes stored for a service during a day.
+type RateLimiter struct {
+	conf  RateLimitConfig
+	usage *storage.DailyUsage
+
+	mu          sync.Mutex
+	buckets     map[rateLimitKey]*rateLimitBuckets
+	lastCleanup time.Time
+
+	rejectedTotal *prometheus.CounterVec
+
+	now func() time.Time
+}
+
+type rateLimitBuckets struct {
+	profiles tokenBucket
+	bytes    tokenBucket
+}
+
+func NewRateLimiter(conf RateLimitConfig, registry prometheus.Registerer) *RateLimiter {
+	l := &RateLimiter{
+		conf:          conf,
+		buckets:       make(map[rateLimitKey]*rateLimitBuckets),
+		lastCleanup:   time.Now(),
+		rejectedTotal: newIngestRejectedMetric(registry),
+		now:           time.Now,
+	}
+	l.usage = storage.NewDailyUsage(func() time.Time { return l.now() })
+	return l
+}
+
+// allow checks that the profile of the size doesn't exceed the limits, taking the size out of the limits.
+func (l *RateLimiter) allow(key rateLimitKey, size int64) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.allowRate(key, size); err != nil {
+		return err
+	}
+	if l.conf.DailyQuotaBytes > 0 && !l.usage.Add(key.tenant+"/"+key.service, size, l.conf.DailyQuotaBytes) {
+		return l.reject(key, &RateLimitError{Reason: rejectReasonDailyQuota, RetryAfter: untilNextDay(l.now())})
+	}
+	return nil
+}
+
+// charge takes the size out of the limits after the profile, which size wasn't known in advance, was stored.
+func (l *RateLimiter) charge(key rateLimitKey, size int64) {
+	if l == nil {
+		return
+	}
+	if l.conf.BytesPerSecond > 0 {
+		l.mu.Lock()
+		l.bucketsLocked(key, l.now()).bytes.charge(float64(size))
+		l.mu.Unlock()
+	}
+	if l.conf.DailyQuotaBytes > 0 {
+		l.usage.Add(key.tenant+"/"+key.service, size, 0)
+	}
+}
+
+// refund gives the profile of the size back to the limits, after the profile, that was allowed, wasn't stored.
+func (l *RateLimiter) refund(key rateLimitKey, size int64) {
+	if l == nil {
+		return
+	}
+	if l.conf.ProfilesPerSecond > 0 || l.conf.BytesPerSecond > 0 {
+		l.mu.Lock()
+		b := l.bucketsLocked(key, l.now())
+		b.profiles.refund(1)
+		b.bytes.refund(float64(size))
+		l.mu.Unlock()
+	}
+	if l.conf.DailyQuotaBytes > 0 && size > 0 {
+		l.usage.Add(key.tenant+"/"+key.service, -size, 0)
+	}
+}
+
+func (l *RateLimiter) allowRate(key rateLimitKey, size int64) error {
+	if l.conf.ProfilesPerSecond <= 0 && l.conf.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastCleanup) > rateLimitCleanupInterval {
+		l.cleanupLocked(now)
+	}
+
+	b := l.bucketsLocked(key, now)
+
+	// the profile is rejected without taking any tokens, if either of the buckets doesn't have enough
+	if d := b.profiles.wait(now, 1); d > 0 {
+		return l.reject(key, &RateLimitError{Reason: rejectReasonProfilesRate, RetryAfter: d})
+	}
+	if d := b.bytes.wait(now, float64(size)); d > 0 {
+		return l.reject(key, &RateLimitError{Reason: rejectReasonBytesRate, RetryAfter: d})
+	}
+	b.profiles.charge(1)
+	b.bytes.charge(float64(size))
+
+	return nil
+}
+
+func (l *RateLimiter) bucketsLocked(key rateLimitKey, now time.Time) *rateLimitBuckets {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateLimitBuckets{
+			profiles: newTokenBucket(l.conf.ProfilesPerSecond, float64(l.conf.ProfilesBurst), now),
+			bytes:    newTokenBucket(l.conf.BytesPerSecond, float64(l.conf.BytesBurst), now),
+		}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// drops the buckets that refilled completely, as they are no different from the new ones
+func (l *RateLimiter) cleanupLocked(now time.Time) {
+	for key, b := range l.buckets {
+		if b.profiles.full(now) && b.bytes.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
+
+func (l *RateLimiter) reject(key rateLimitKey, err *RateLimitError) error {
+	l.rejectedTotal.WithLabelValues(key.tenant, key.service, err.Reason).Inc()
+	return err
+}
+
+// converts the error of storage.QuotaWriter to RateLimitError
+func (l *RateLimiter) quotaError(key rateLimitKey, err error) error {
+	if !errors.Is(err, storage.ErrQuotaExceeded) {
+		return err
+	}
+	if l == nil {
+		return &RateLimitError{Reason: rejectReasonDailyQuota, RetryAfter: untilNextDay(time.Now()), err: err}
+	}
+	return l.reject(key, &RateLimitError{Reason: rejectReasonDailyQuota, RetryAfter: untilNextDay(l.now()), err: err})
+}
+
+// returns the time left until the daily quotas are reset
+func untilNextDay(now time.Time) time.Duration {
+	now = now.UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// tokenBucket is filled with tokens at the rate up to burst. Zero rate means no limit.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) tokenBucket {
+	return tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// returns how long to wait until the bucket has n tokens
+func (b *tokenBucket) wait(now time.Time, n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// n larger than burst is allowed when the bucket is full, otherwise it would never be allowed
+	need := math.Min(n, b.burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+// takes n tokens; the number of tokens can become negative
+func (b *tokenBucket) charge(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+func (b *tokenBucket) refund(n float64) {
+	if b.rate > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+n)
+	}
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+type sourceKey struct{}
+
+func contextWithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// returns the address of the client that sent the profile, or empty string if unknown
+func sourceFromContext(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// strips the port from client's address
+func sourceFromAddr(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,190 @@
+package profefe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestRateLimiter(conf RateLimitConfig, now *time.Time) *RateLimiter {
+	l := NewRateLimiter(conf, prometheus.NewRegistry())
+	l.now = func() time.Time { return *now }
+	l.lastCleanup = *now
+	return l
+}
+
+func TestRateLimiter_profilesRate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestRateLimiter(RateLimitConfig{ProfilesPerSecond: 1, ProfilesBurst: 2}, &now)
+
+	key := rateLimitKey{service: "svc1", source: "10.0.0.1"}
+
+	require.NoError(t, l.allow(key, 100))
+	require.NoError(t, l.allow(key, 100))
+
+	err := l.allow(key, 100)
+	var rlErr *RateLimitError
+	require.True(t, errors.As(err, &rlErr), "want rate limit error, got %v", err)
+	assert.Equal(t, rejectReasonProfilesRate, rlErr.Reason)
+	assert.Equal(t, time.Second, rlErr.RetryAfter)
+	assert.Equal(t, 1, rlErr.RetryAfterSeconds())
+
+	// other sources aren't affected
+	require.NoError(t, l.allow(rateLimitKey{service: "svc1", source: "10.0.0.2"}, 100))
+
+	now = now.Add(time.Second)
+	require.NoError(t, l.allow(key, 100))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(l.rejectedTotal.WithLabelValues("", "svc1", rejectReasonProfilesRate)))
+}
+
+func TestRateLimiter_bytesRate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestRateLimiter(RateLimitConfig{BytesPerSecond: 100, BytesBurst: 200}, &now)
+
+	key := rateLimitKey{service: "svc1"}
+
+	// a profile larger than burst is allowed when the bucket is full
+	require.NoError(t, l.allow(key, 300))
+
+	err := l.allow(key, 10)
+	var rlErr *RateLimitError
+	require.True(t, errors.As(err, &rlErr), "want rate limit error, got %v", err)
+	assert.Equal(t, rejectReasonBytesRate, rlErr.Reason)
+	assert.Equal(t, 1100*time.Millisecond, rlErr.RetryAfter)
+
+	now = now.Add(rlErr.RetryAfter)
+	require.NoError(t, l.allow(key, 10))
+}
+
+func TestRateLimiter_dailyQuota(t *testing.T) {
+	now := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	l := newTestRateLimiter(RateLimitConfig{DailyQuotaBytes: 100}, &now)
+
+	require.NoError(t, l.allow(rateLimitKey{service: "svc1", source: "10.0.0.1"}, 60))
+
+	// the quota is shared by all sources of the service
+	err := l.allow(rateLimitKey{service: "svc1", source: "10.0.0.2"}, 60)
+	var rlErr *RateLimitError
+	require.True(t, errors.As(err, &rlErr), "want rate limit error, got %v", err)
+	assert.Equal(t, rejectReasonDailyQuota, rlErr.Reason)
+	assert.Equal(t, time.Hour, rlErr.RetryAfter)
+
+	require.NoError(t, l.allow(rateLimitKey{tenant: "t1", service: "svc1"}, 60))
+
+	now = now.Add(time.Hour)
+	require.NoError(t, l.allow(rateLimitKey{service: "svc1"}, 60))
+}
+
+func TestCollector_WriteProfile_refund(t *testing.T) {
+	data, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	quota := int64(len(data))
+	l := newTestRateLimiter(RateLimitConfig{ProfilesPerSecond: 1, ProfilesBurst: 1, DailyQuotaBytes: quota}, &now)
+
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			return profile.Meta{}, errors.New("storage is down")
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	collector := NewCollector(testLogger, sw)
+	collector.SetRateLimiter(l)
+
+	params := &storage.WriteProfileParams{Service: "svc1", Type: profile.TypeCPU}
+
+	// neither the bad profiles, nor the profiles the storage failed to store, take the limits
+	for n := 0; n < 3; n++ {
+		_, err := collector.WriteProfile(context.Background(), params, bytes.NewReader([]byte("not a profile")))
+		require.Error(t, err)
+		var rlErr *RateLimitError
+		require.False(t, errors.As(err, &rlErr), "want parse error, got %v", err)
+	}
+
+	for n := 0; n < 3; n++ {
+		_, err := collector.WriteProfile(context.Background(), params, bytes.NewReader(data))
+		require.EqualError(t, err, "storage is down")
+	}
+
+	require.NoError(t, l.allow(rateLimitKey{service: "svc1"}, quota))
+}
+
+func TestRateLimiter_cleanup(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newTestRateLimiter(RateLimitConfig{ProfilesPerSecond: 1, ProfilesBurst: 1}, &now)
+
+	require.NoError(t, l.allow(rateLimitKey{service: "svc1"}, 0))
+	require.Len(t, l.buckets, 1)
+
+	now = now.Add(rateLimitCleanupInterval + time.Second)
+	require.NoError(t, l.allow(rateLimitKey{service: "svc2"}, 0))
+	require.Len(t, l.buckets, 1)
+}
+
+func TestProfilesHandler_rateLimited(t *testing.T) {
+	pprofData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			return profile.Meta{ProfileID: profile.TestID, Service: params.Service, Type: params.Type}, nil
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	collector := NewCollector(testLogger, sw)
+	collector.SetRateLimiter(NewRateLimiter(RateLimitConfig{ProfilesPerSecond: 0.1, ProfilesBurst: 1}, prometheus.NewRegistry()))
+
+	h := NewProfilesHandler(testLogger, collector, NewQuerier(testLogger, &storage.StubReader{}))
+
+	for n, wantCode := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		t.Run(fmt.Sprintf("request=%d", n), func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/0/profiles?service=svc1&type=cpu", bytes.NewReader(pprofData))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			assert.Equal(t, wantCode, resp.Code)
+			if wantCode == http.StatusTooManyRequests {
+				assert.Equal(t, "10", resp.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestCollector_WriteProfile_quotaExceeded(t *testing.T) {
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			return profile.Meta{}, fmt.Errorf("tenant %q: %w", params.Tenant, storage.ErrQuotaExceeded)
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	collector := NewCollector(testLogger, sw)
+
+	params := &storage.WriteProfileParams{Tenant: "t1", Service: "svc1", Type: profile.TypeTrace}
+	_, err := collector.WriteProfile(context.Background(), params, bytes.NewReader([]byte("trace")))
+
+	var rlErr *RateLimitError
+	require.True(t, errors.As(err, &rlErr), "want rate limit error, got %v", err)
+	assert.Equal(t, rejectReasonDailyQuota, rlErr.Reason)
+	assert.True(t, errors.Is(err, storage.ErrQuotaExceeded))
+}
@@ -12,8 +12,10 @@ import (
 )
 
 var (
-	ErrNoResults = StatusError(http.StatusNoContent, "no results", nil)
-	ErrNotFound  = StatusError(http.StatusNotFound, "nothing found", nil)
+	ErrNoResults    = StatusError(http.StatusNoContent, "no results", nil)
+	ErrNotFound     = StatusError(http.StatusNotFound, "nothing found", nil)
+	ErrUnauthorized = StatusError(http.StatusUnauthorized, "unauthorized", nil)
+	ErrForbidden    = StatusError(http.StatusForbidden, "forbidden", nil)
 )
 
 type jsonResponse struct {
@@ -47,6 +49,10 @@ func ReplyError(w http.ResponseWriter, err error) {
 		errMsg = "internal server error"
 	}
 
+	if statusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="profefe"`)
+	}
+
 	w.WriteHeader(statusCode)
 
 	resp := jsonResponse{
@@ -72,6 +78,13 @@ func HandleErrorHTTP(logger *log.Logger, err error, w http.ResponseWriter, r *ht
 
 	ReplyError(w, err)
 
+	// authentication and authorization failures are expected, they aren't logged as errors
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && (statusErr.Code() == http.StatusUnauthorized || statusErr.Code() == http.StatusForbidden) {
+		logger.Infow("request denied", "url", r.URL.String(), "code", statusErr.Code(), zap.Error(err))
+		return
+	}
+
 	if origErr := errors.Unwrap(err); origErr != nil {
 		err = origErr
 	}
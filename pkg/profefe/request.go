@@ -10,6 +10,7 @@ import (
 
 	"github.com/profefe/profefe/pkg/profile"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
 )
 
 const timeFormat = "2006-01-02T15:04:05"
@@ -53,6 +54,7 @@ func parseWriteProfileParams(in *storage.WriteProfileParams, r *http.Request) er
 	}
 
 	*in = storage.WriteProfileParams{
+		Tenant:  tenant.FromContext(r.Context()),
 		Service: service,
 		Type:    ptype,
 		Labels:  labels,
@@ -86,6 +88,7 @@ func parseFindProfileParams(in *storage.FindProfilesParams, r *http.Request) (er
 	}
 
 	*in = storage.FindProfilesParams{
+		Tenant:  tenant.FromContext(r.Context()),
 		Service: service,
 		Type:    ptype,
 		Labels:  labels,
@@ -125,3 +128,12 @@ func parseFindProfileParams(in *storage.FindProfilesParams, r *http.Request) (er
 
 	return nil
 }
+
+// parses the labels of profile's samples, the merged profiles are filtered by
+func parseSampleLabels(in *storage.FindProfilesParams, r *http.Request) error {
+	v := r.URL.Query().Get("sample_labels")
+	if err := in.SampleLabels.FromString(v); err != nil {
+		return StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: bad \"sample_labels\" %q: %s", v, err), nil)
+	}
+	return nil
+}
@@ -8,10 +8,12 @@ import (
 )
 
 const (
-	apiProfilesPath      = "/api/0/profiles"
-	apiProfilesMergePath = "/api/0/profiles/merge"
-	apiServicesPath      = "/api/0/services"
-	apiVersionPath       = "/api/0/version"
+	apiProfilesPath          = "/api/0/profiles"
+	apiProfilesMergePath     = "/api/0/profiles/merge"
+	apiProfilesBreakdownPath = "/api/0/profiles/breakdown"
+	apiProfilesTopPath       = "/api/0/profiles/top"
+	apiServicesPath          = "/api/0/services"
+	apiVersionPath           = "/api/0/version"
 )
 
 func SetupRoutes(
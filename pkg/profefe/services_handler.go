@@ -5,6 +5,7 @@ import (
 
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
 )
 
 type ServicesHandler struct {
@@ -27,7 +28,7 @@ func (h *ServicesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	services, err := h.querier.ListServices(r.Context())
+	services, err := h.querier.ListServices(r.Context(), tenant.FromContext(r.Context()))
 	if err != nil {
 		if err == storage.ErrNotFound {
 			err = ErrNotFound
@@ -18,7 +18,7 @@ import (
 func TestServicesHandler_success(t *testing.T) {
 	services := []string{"service1", "service2"}
 	sr := &storage.StubReader{
-		ListServicesFunc: func(ctx context.Context) ([]string, error) {
+		ListServicesFunc: func(ctx context.Context, _ string) ([]string, error) {
 			return services, nil
 		},
 	}
@@ -44,7 +44,7 @@ func TestServicesHandler_success(t *testing.T) {
 
 func TestServicesHandler_nothingFound(t *testing.T) {
 	sr := &storage.StubReader{
-		ListServicesFunc: func(ctx context.Context) ([]string, error) {
+		ListServicesFunc: func(ctx context.Context, _ string) ([]string, error) {
 			return nil, storage.ErrNotFound
 		},
 	}
@@ -64,7 +64,7 @@ func TestServicesHandler_nothingFound(t *testing.T) {
 
 func TestServicesHandler_storageFailure(t *testing.T) {
 	sr := &storage.StubReader{
-		ListServicesFunc: func(ctx context.Context) ([]string, error) {
+		ListServicesFunc: func(ctx context.Context, _ string) ([]string, error) {
 			return nil, errors.New("unexpected storage error")
 		},
 	}
@@ -0,0 +1,4 @@
+// Package profefepb contains protobuf messages and gRPC service definitions of profefe's API.
+package profefepb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. profefe.proto
@@ -0,0 +1,1349 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.25.0
+// 	protoc        v3.13.0
+// source: profefe.proto
+
+package profefepb
+
+import (
+	context "context"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// Values must match with profile.ProfileType.
+type ProfileType int32
+
+const (
+	ProfileType_UNKNOWN      ProfileType = 0
+	ProfileType_CPU          ProfileType = 1
+	ProfileType_HEAP         ProfileType = 2
+	ProfileType_BLOCK        ProfileType = 3
+	ProfileType_MUTEX        ProfileType = 4
+	ProfileType_GOROUTINE    ProfileType = 5
+	ProfileType_THREADCREATE ProfileType = 6
+	ProfileType_OTHER        ProfileType = 127
+	ProfileType_TRACE        ProfileType = 128
+)
+
+// Enum value maps for ProfileType.
+var (
+	ProfileType_name = map[int32]string{
+		0:   "UNKNOWN",
+		1:   "CPU",
+		2:   "HEAP",
+		3:   "BLOCK",
+		4:   "MUTEX",
+		5:   "GOROUTINE",
+		6:   "THREADCREATE",
+		127: "OTHER",
+		128: "TRACE",
+	}
+	ProfileType_value = map[string]int32{
+		"UNKNOWN":      0,
+		"CPU":          1,
+		"HEAP":         2,
+		"BLOCK":        3,
+		"MUTEX":        4,
+		"GOROUTINE":    5,
+		"THREADCREATE": 6,
+		"OTHER":        127,
+		"TRACE":        128,
+	}
+)
+
+func (x ProfileType) Enum() *ProfileType {
+	p := new(ProfileType)
+	*p = x
+	return p
+}
+
+func (x ProfileType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ProfileType) Descriptor() protoreflect.EnumDescriptor {
+	return file_profefe_proto_enumTypes[0].Descriptor()
+}
+
+func (ProfileType) Type() protoreflect.EnumType {
+	return &file_profefe_proto_enumTypes[0]
+}
+
+func (x ProfileType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ProfileType.Descriptor instead.
+func (ProfileType) EnumDescriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{0}
+}
+
+type Label struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *Label) Reset() {
+	*x = Label{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Label) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Label) ProtoMessage() {}
+
+func (x *Label) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Label.ProtoReflect.Descriptor instead.
+func (*Label) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Label) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Label) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type Profile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id         string      `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	ExternalId string      `protobuf:"bytes,2,opt,name=external_id,json=externalId,proto3" json:"external_id,omitempty"`
+	Type       ProfileType `protobuf:"varint,3,opt,name=type,proto3,enum=profefe.v0.ProfileType" json:"type,omitempty"`
+	Service    string      `protobuf:"bytes,4,opt,name=service,proto3" json:"service,omitempty"`
+	Labels     []*Label    `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty"`
+	// unix timestamp in nanoseconds
+	CreatedAt int64 `protobuf:"varint,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+}
+
+func (x *Profile) Reset() {
+	*x = Profile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Profile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Profile) ProtoMessage() {}
+
+func (x *Profile) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Profile.ProtoReflect.Descriptor instead.
+func (*Profile) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Profile) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Profile) GetExternalId() string {
+	if x != nil {
+		return x.ExternalId
+	}
+	return ""
+}
+
+func (x *Profile) GetType() ProfileType {
+	if x != nil {
+		return x.Type
+	}
+	return ProfileType_UNKNOWN
+}
+
+func (x *Profile) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Profile) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Profile) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+type WriteProfileParams struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ExternalId string      `protobuf:"bytes,1,opt,name=external_id,json=externalId,proto3" json:"external_id,omitempty"`
+	Service    string      `protobuf:"bytes,2,opt,name=service,proto3" json:"service,omitempty"`
+	Type       ProfileType `protobuf:"varint,3,opt,name=type,proto3,enum=profefe.v0.ProfileType" json:"type,omitempty"`
+	Labels     []*Label    `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty"`
+	// unix timestamp in nanoseconds
+	CreatedAt int64 `protobuf:"varint,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+}
+
+func (x *WriteProfileParams) Reset() {
+	*x = WriteProfileParams{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WriteProfileParams) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteProfileParams) ProtoMessage() {}
+
+func (x *WriteProfileParams) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteProfileParams.ProtoReflect.Descriptor instead.
+func (*WriteProfileParams) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WriteProfileParams) GetExternalId() string {
+	if x != nil {
+		return x.ExternalId
+	}
+	return ""
+}
+
+func (x *WriteProfileParams) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *WriteProfileParams) GetType() ProfileType {
+	if x != nil {
+		return x.Type
+	}
+	return ProfileType_UNKNOWN
+}
+
+func (x *WriteProfileParams) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *WriteProfileParams) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+type WriteProfilesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Payload:
+	//	*WriteProfilesRequest_Params
+	//	*WriteProfilesRequest_Data
+	Payload isWriteProfilesRequest_Payload `protobuf_oneof:"payload"`
+}
+
+func (x *WriteProfilesRequest) Reset() {
+	*x = WriteProfilesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WriteProfilesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteProfilesRequest) ProtoMessage() {}
+
+func (x *WriteProfilesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteProfilesRequest.ProtoReflect.Descriptor instead.
+func (*WriteProfilesRequest) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{3}
+}
+
+func (m *WriteProfilesRequest) GetPayload() isWriteProfilesRequest_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *WriteProfilesRequest) GetParams() *WriteProfileParams {
+	if x, ok := x.GetPayload().(*WriteProfilesRequest_Params); ok {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *WriteProfilesRequest) GetData() []byte {
+	if x, ok := x.GetPayload().(*WriteProfilesRequest_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+type isWriteProfilesRequest_Payload interface {
+	isWriteProfilesRequest_Payload()
+}
+
+type WriteProfilesRequest_Params struct {
+	Params *WriteProfileParams `protobuf:"bytes,1,opt,name=params,proto3,oneof"`
+}
+
+type WriteProfilesRequest_Data struct {
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
+}
+
+func (*WriteProfilesRequest_Params) isWriteProfilesRequest_Payload() {}
+
+func (*WriteProfilesRequest_Data) isWriteProfilesRequest_Payload() {}
+
+type WriteProfilesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Profiles []*Profile `protobuf:"bytes,1,rep,name=profiles,proto3" json:"profiles,omitempty"`
+}
+
+func (x *WriteProfilesResponse) Reset() {
+	*x = WriteProfilesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WriteProfilesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WriteProfilesResponse) ProtoMessage() {}
+
+func (x *WriteProfilesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WriteProfilesResponse.ProtoReflect.Descriptor instead.
+func (*WriteProfilesResponse) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *WriteProfilesResponse) GetProfiles() []*Profile {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+type FindProfilesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Service string      `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+	Type    ProfileType `protobuf:"varint,2,opt,name=type,proto3,enum=profefe.v0.ProfileType" json:"type,omitempty"`
+	Labels  []*Label    `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty"`
+	// unix timestamp in nanoseconds
+	CreatedAtMin int64 `protobuf:"varint,4,opt,name=created_at_min,json=createdAtMin,proto3" json:"created_at_min,omitempty"`
+	// unix timestamp in nanoseconds
+	CreatedAtMax int64 `protobuf:"varint,5,opt,name=created_at_max,json=createdAtMax,proto3" json:"created_at_max,omitempty"`
+	Limit        int32 `protobuf:"varint,6,opt,name=limit,proto3" json:"limit,omitempty"`
+}
+
+func (x *FindProfilesRequest) Reset() {
+	*x = FindProfilesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindProfilesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindProfilesRequest) ProtoMessage() {}
+
+func (x *FindProfilesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindProfilesRequest.ProtoReflect.Descriptor instead.
+func (*FindProfilesRequest) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FindProfilesRequest) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *FindProfilesRequest) GetType() ProfileType {
+	if x != nil {
+		return x.Type
+	}
+	return ProfileType_UNKNOWN
+}
+
+func (x *FindProfilesRequest) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *FindProfilesRequest) GetCreatedAtMin() int64 {
+	if x != nil {
+		return x.CreatedAtMin
+	}
+	return 0
+}
+
+func (x *FindProfilesRequest) GetCreatedAtMax() int64 {
+	if x != nil {
+		return x.CreatedAtMax
+	}
+	return 0
+}
+
+func (x *FindProfilesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type FindProfilesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Profiles []*Profile `protobuf:"bytes,1,rep,name=profiles,proto3" json:"profiles,omitempty"`
+}
+
+func (x *FindProfilesResponse) Reset() {
+	*x = FindProfilesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FindProfilesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FindProfilesResponse) ProtoMessage() {}
+
+func (x *FindProfilesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FindProfilesResponse.ProtoReflect.Descriptor instead.
+func (*FindProfilesResponse) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FindProfilesResponse) GetProfiles() []*Profile {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+type GetProfilesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ids []string `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+}
+
+func (x *GetProfilesRequest) Reset() {
+	*x = GetProfilesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetProfilesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetProfilesRequest) ProtoMessage() {}
+
+func (x *GetProfilesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetProfilesRequest.ProtoReflect.Descriptor instead.
+func (*GetProfilesRequest) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetProfilesRequest) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+type GetProfilesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *GetProfilesResponse) Reset() {
+	*x = GetProfilesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetProfilesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetProfilesResponse) ProtoMessage() {}
+
+func (x *GetProfilesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetProfilesResponse.ProtoReflect.Descriptor instead.
+func (*GetProfilesResponse) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetProfilesResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type ListServicesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListServicesRequest) Reset() {
+	*x = ListServicesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListServicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListServicesRequest) ProtoMessage() {}
+
+func (x *ListServicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListServicesRequest.ProtoReflect.Descriptor instead.
+func (*ListServicesRequest) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{9}
+}
+
+type ListServicesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Services []string `protobuf:"bytes,1,rep,name=services,proto3" json:"services,omitempty"`
+}
+
+func (x *ListServicesResponse) Reset() {
+	*x = ListServicesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_profefe_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListServicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListServicesResponse) ProtoMessage() {}
+
+func (x *ListServicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_profefe_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListServicesResponse.ProtoReflect.Descriptor instead.
+func (*ListServicesResponse) Descriptor() ([]byte, []int) {
+	return file_profefe_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListServicesResponse) GetServices() []string {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+var File_profefe_proto protoreflect.FileDescriptor
+
+var file_profefe_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x0a, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x22, 0x2f, 0x0a, 0x05, 0x4c,
+	0x61, 0x62, 0x65, 0x6c, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0xcb, 0x01, 0x0a,
+	0x07, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x78, 0x74, 0x65,
+	0x72, 0x6e, 0x61, 0x6c, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x65,
+	0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x49, 0x64, 0x12, 0x2b, 0x0a, 0x04, 0x74, 0x79, 0x70,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66,
+	0x65, 0x2e, 0x76, 0x30, 0x2e, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65,
+	0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63,
+	0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x12, 0x29, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x4c, 0x61,
+	0x62, 0x65, 0x6c, 0x52, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x63,
+	0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x22, 0xc6, 0x01, 0x0a, 0x12, 0x57,
+	0x72, 0x69, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x5f, 0x69, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x65, 0x78, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c,
+	0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x2b, 0x0a, 0x04,
+	0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f,
+	0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x54,
+	0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x29, 0x0a, 0x06, 0x6c, 0x61, 0x62,
+	0x65, 0x6c, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x72, 0x6f, 0x66,
+	0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x52, 0x06, 0x6c, 0x61,
+	0x62, 0x65, 0x6c, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f,
+	0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x22, 0x71, 0x0a, 0x14, 0x57, 0x72, 0x69, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x66,
+	0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x38, 0x0a, 0x06, 0x70,
+	0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x70, 0x72,
+	0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65, 0x50, 0x72,
+	0x6f, 0x66, 0x69, 0x6c, 0x65, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x48, 0x00, 0x52, 0x06, 0x70,
+	0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x14, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x42, 0x09, 0x0a, 0x07, 0x70,
+	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0x48, 0x0a, 0x15, 0x57, 0x72, 0x69, 0x74, 0x65, 0x50,
+	0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x2f, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x50,
+	0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73,
+	0x22, 0xe9, 0x01, 0x0a, 0x13, 0x46, 0x69, 0x6e, 0x64, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65,
+	0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x12, 0x2b, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x17, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x50, 0x72,
+	0x6f, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12,
+	0x29, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x11, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x4c, 0x61, 0x62,
+	0x65, 0x6c, 0x52, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x12, 0x24, 0x0a, 0x0e, 0x63, 0x72,
+	0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x5f, 0x6d, 0x69, 0x6e, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0c, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x4d, 0x69, 0x6e,
+	0x12, 0x24, 0x0a, 0x0e, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x5f, 0x6d,
+	0x61, 0x78, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x4d, 0x61, 0x78, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x22, 0x47, 0x0a, 0x14,
+	0x46, 0x69, 0x6e, 0x64, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73,
+	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65,
+	0x2e, 0x76, 0x30, 0x2e, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x52, 0x08, 0x70, 0x72, 0x6f,
+	0x66, 0x69, 0x6c, 0x65, 0x73, 0x22, 0x26, 0x0a, 0x12, 0x47, 0x65, 0x74, 0x50, 0x72, 0x6f, 0x66,
+	0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x69,
+	0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x69, 0x64, 0x73, 0x22, 0x29, 0x0a,
+	0x13, 0x47, 0x65, 0x74, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x15, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22,
+	0x32, 0x0a, 0x14, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x73, 0x2a, 0x7b, 0x0a, 0x0b, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12,
+	0x07, 0x0a, 0x03, 0x43, 0x50, 0x55, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x48, 0x45, 0x41, 0x50,
+	0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x42, 0x4c, 0x4f, 0x43, 0x4b, 0x10, 0x03, 0x12, 0x09, 0x0a,
+	0x05, 0x4d, 0x55, 0x54, 0x45, 0x58, 0x10, 0x04, 0x12, 0x0d, 0x0a, 0x09, 0x47, 0x4f, 0x52, 0x4f,
+	0x55, 0x54, 0x49, 0x4e, 0x45, 0x10, 0x05, 0x12, 0x10, 0x0a, 0x0c, 0x54, 0x48, 0x52, 0x45, 0x41,
+	0x44, 0x43, 0x52, 0x45, 0x41, 0x54, 0x45, 0x10, 0x06, 0x12, 0x09, 0x0a, 0x05, 0x4f, 0x54, 0x48,
+	0x45, 0x52, 0x10, 0x7f, 0x12, 0x0a, 0x0a, 0x05, 0x54, 0x52, 0x41, 0x43, 0x45, 0x10, 0x80, 0x01,
+	0x32, 0xd9, 0x02, 0x0a, 0x07, 0x50, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x12, 0x56, 0x0a, 0x0d,
+	0x57, 0x72, 0x69, 0x74, 0x65, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x20, 0x2e,
+	0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x57, 0x72, 0x69, 0x74, 0x65,
+	0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x21, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x57, 0x72, 0x69,
+	0x74, 0x65, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x28, 0x01, 0x12, 0x51, 0x0a, 0x0c, 0x46, 0x69, 0x6e, 0x64, 0x50, 0x72, 0x6f, 0x66,
+	0x69, 0x6c, 0x65, 0x73, 0x12, 0x1f, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e, 0x76,
+	0x30, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2e,
+	0x76, 0x30, 0x2e, 0x46, 0x69, 0x6e, 0x64, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x50, 0x0a, 0x0b, 0x47, 0x65, 0x74, 0x50, 0x72,
+	0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x12, 0x1e, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65,
+	0x2e, 0x76, 0x30, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1f, 0x2e, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65,
+	0x2e, 0x76, 0x30, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x72, 0x6f, 0x66, 0x69, 0x6c, 0x65, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x30, 0x01, 0x12, 0x51, 0x0a, 0x0c, 0x4c, 0x69, 0x73,
+	0x74, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x1f, 0x2e, 0x70, 0x72, 0x6f, 0x66,
+	0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x70, 0x72, 0x6f,
+	0x66, 0x65, 0x66, 0x65, 0x2e, 0x76, 0x30, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x53, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2a, 0x5a, 0x28,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x72, 0x6f, 0x66, 0x65,
+	0x66, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70,
+	0x72, 0x6f, 0x66, 0x65, 0x66, 0x65, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_profefe_proto_rawDescOnce sync.Once
+	file_profefe_proto_rawDescData = file_profefe_proto_rawDesc
+)
+
+func file_profefe_proto_rawDescGZIP() []byte {
+	file_profefe_proto_rawDescOnce.Do(func() {
+		file_profefe_proto_rawDescData = protoimpl.X.CompressGZIP(file_profefe_proto_rawDescData)
+	})
+	return file_profefe_proto_rawDescData
+}
+
+var file_profefe_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_profefe_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_profefe_proto_goTypes = []interface{}{
+	(ProfileType)(0),              // 0: profefe.v0.ProfileType
+	(*Label)(nil),                 // 1: profefe.v0.Label
+	(*Profile)(nil),               // 2: profefe.v0.Profile
+	(*WriteProfileParams)(nil),    // 3: profefe.v0.WriteProfileParams
+	(*WriteProfilesRequest)(nil),  // 4: profefe.v0.WriteProfilesRequest
+	(*WriteProfilesResponse)(nil), // 5: profefe.v0.WriteProfilesResponse
+	(*FindProfilesRequest)(nil),   // 6: profefe.v0.FindProfilesRequest
+	(*FindProfilesResponse)(nil),  // 7: profefe.v0.FindProfilesResponse
+	(*GetProfilesRequest)(nil),    // 8: profefe.v0.GetProfilesRequest
+	(*GetProfilesResponse)(nil),   // 9: profefe.v0.GetProfilesResponse
+	(*ListServicesRequest)(nil),   // 10: profefe.v0.ListServicesRequest
+	(*ListServicesResponse)(nil),  // 11: profefe.v0.ListServicesResponse
+}
+var file_profefe_proto_depIdxs = []int32{
+	0,  // 0: profefe.v0.Profile.type:type_name -> profefe.v0.ProfileType
+	1,  // 1: profefe.v0.Profile.labels:type_name -> profefe.v0.Label
+	0,  // 2: profefe.v0.WriteProfileParams.type:type_name -> profefe.v0.ProfileType
+	1,  // 3: profefe.v0.WriteProfileParams.labels:type_name -> profefe.v0.Label
+	3,  // 4: profefe.v0.WriteProfilesRequest.params:type_name -> profefe.v0.WriteProfileParams
+	2,  // 5: profefe.v0.WriteProfilesResponse.profiles:type_name -> profefe.v0.Profile
+	0,  // 6: profefe.v0.FindProfilesRequest.type:type_name -> profefe.v0.ProfileType
+	1,  // 7: profefe.v0.FindProfilesRequest.labels:type_name -> profefe.v0.Label
+	2,  // 8: profefe.v0.FindProfilesResponse.profiles:type_name -> profefe.v0.Profile
+	4,  // 9: profefe.v0.Profefe.WriteProfiles:input_type -> profefe.v0.WriteProfilesRequest
+	6,  // 10: profefe.v0.Profefe.FindProfiles:input_type -> profefe.v0.FindProfilesRequest
+	8,  // 11: profefe.v0.Profefe.GetProfiles:input_type -> profefe.v0.GetProfilesRequest
+	10, // 12: profefe.v0.Profefe.ListServices:input_type -> profefe.v0.ListServicesRequest
+	5,  // 13: profefe.v0.Profefe.WriteProfiles:output_type -> profefe.v0.WriteProfilesResponse
+	7,  // 14: profefe.v0.Profefe.FindProfiles:output_type -> profefe.v0.FindProfilesResponse
+	9,  // 15: profefe.v0.Profefe.GetProfiles:output_type -> profefe.v0.GetProfilesResponse
+	11, // 16: profefe.v0.Profefe.ListServices:output_type -> profefe.v0.ListServicesResponse
+	13, // [13:17] is the sub-list for method output_type
+	9,  // [9:13] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_profefe_proto_init() }
+func file_profefe_proto_init() {
+	if File_profefe_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_profefe_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Label); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Profile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WriteProfileParams); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WriteProfilesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WriteProfilesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindProfilesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FindProfilesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetProfilesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetProfilesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListServicesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_profefe_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListServicesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_profefe_proto_msgTypes[3].OneofWrappers = []interface{}{
+		(*WriteProfilesRequest_Params)(nil),
+		(*WriteProfilesRequest_Data)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_profefe_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_profefe_proto_goTypes,
+		DependencyIndexes: file_profefe_proto_depIdxs,
+		EnumInfos:         file_profefe_proto_enumTypes,
+		MessageInfos:      file_profefe_proto_msgTypes,
+	}.Build()
+	File_profefe_proto = out.File
+	file_profefe_proto_rawDesc = nil
+	file_profefe_proto_goTypes = nil
+	file_profefe_proto_depIdxs = nil
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// ProfefeClient is the client API for Profefe service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ProfefeClient interface {
+	// WriteProfiles receives a stream of profiles. Each profile starts with
+	// a message that carries WriteProfileParams, followed by one or more
+	// messages with chunks of the profile's data.
+	WriteProfiles(ctx context.Context, opts ...grpc.CallOption) (Profefe_WriteProfilesClient, error)
+	FindProfiles(ctx context.Context, in *FindProfilesRequest, opts ...grpc.CallOption) (*FindProfilesResponse, error)
+	// GetProfiles streams the profile's data, split into chunks. If several IDs
+	// are requested, the profiles are merged into a single profile.
+	GetProfiles(ctx context.Context, in *GetProfilesRequest, opts ...grpc.CallOption) (Profefe_GetProfilesClient, error)
+	ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error)
+}
+
+type profefeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProfefeClient(cc grpc.ClientConnInterface) ProfefeClient {
+	return &profefeClient{cc}
+}
+
+func (c *profefeClient) WriteProfiles(ctx context.Context, opts ...grpc.CallOption) (Profefe_WriteProfilesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Profefe_serviceDesc.Streams[0], "/profefe.v0.Profefe/WriteProfiles", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &profefeWriteProfilesClient{stream}
+	return x, nil
+}
+
+type Profefe_WriteProfilesClient interface {
+	Send(*WriteProfilesRequest) error
+	CloseAndRecv() (*WriteProfilesResponse, error)
+	grpc.ClientStream
+}
+
+type profefeWriteProfilesClient struct {
+	grpc.ClientStream
+}
+
+func (x *profefeWriteProfilesClient) Send(m *WriteProfilesRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *profefeWriteProfilesClient) CloseAndRecv() (*WriteProfilesResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(WriteProfilesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *profefeClient) FindProfiles(ctx context.Context, in *FindProfilesRequest, opts ...grpc.CallOption) (*FindProfilesResponse, error) {
+	out := new(FindProfilesResponse)
+	err := c.cc.Invoke(ctx, "/profefe.v0.Profefe/FindProfiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *profefeClient) GetProfiles(ctx context.Context, in *GetProfilesRequest, opts ...grpc.CallOption) (Profefe_GetProfilesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Profefe_serviceDesc.Streams[1], "/profefe.v0.Profefe/GetProfiles", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &profefeGetProfilesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Profefe_GetProfilesClient interface {
+	Recv() (*GetProfilesResponse, error)
+	grpc.ClientStream
+}
+
+type profefeGetProfilesClient struct {
+	grpc.ClientStream
+}
+
+func (x *profefeGetProfilesClient) Recv() (*GetProfilesResponse, error) {
+	m := new(GetProfilesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *profefeClient) ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error) {
+	out := new(ListServicesResponse)
+	err := c.cc.Invoke(ctx, "/profefe.v0.Profefe/ListServices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ProfefeServer is the server API for Profefe service.
+type ProfefeServer interface {
+	// WriteProfiles receives a stream of profiles. Each profile starts with
+	// a message that carries WriteProfileParams, followed by one or more
+	// messages with chunks of the profile's data.
+	WriteProfiles(Profefe_WriteProfilesServer) error
+	FindProfiles(context.Context, *FindProfilesRequest) (*FindProfilesResponse, error)
+	// GetProfiles streams the profile's data, split into chunks. If several IDs
+	// are requested, the profiles are merged into a single profile.
+	GetProfiles(*GetProfilesRequest, Profefe_GetProfilesServer) error
+	ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error)
+}
+
+// UnimplementedProfefeServer can be embedded to have forward compatible implementations.
+type UnimplementedProfefeServer struct {
+}
+
+func (*UnimplementedProfefeServer) WriteProfiles(Profefe_WriteProfilesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WriteProfiles not implemented")
+}
+func (*UnimplementedProfefeServer) FindProfiles(context.Context, *FindProfilesRequest) (*FindProfilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindProfiles not implemented")
+}
+func (*UnimplementedProfefeServer) GetProfiles(*GetProfilesRequest, Profefe_GetProfilesServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetProfiles not implemented")
+}
+func (*UnimplementedProfefeServer) ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServices not implemented")
+}
+
+func RegisterProfefeServer(s *grpc.Server, srv ProfefeServer) {
+	s.RegisterService(&_Profefe_serviceDesc, srv)
+}
+
+func _Profefe_WriteProfiles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ProfefeServer).WriteProfiles(&profefeWriteProfilesServer{stream})
+}
+
+type Profefe_WriteProfilesServer interface {
+	SendAndClose(*WriteProfilesResponse) error
+	Recv() (*WriteProfilesRequest, error)
+	grpc.ServerStream
+}
+
+type profefeWriteProfilesServer struct {
+	grpc.ServerStream
+}
+
+func (x *profefeWriteProfilesServer) SendAndClose(m *WriteProfilesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *profefeWriteProfilesServer) Recv() (*WriteProfilesRequest, error) {
+	m := new(WriteProfilesRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Profefe_FindProfiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindProfilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProfefeServer).FindProfiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/profefe.v0.Profefe/FindProfiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProfefeServer).FindProfiles(ctx, req.(*FindProfilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Profefe_GetProfiles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetProfilesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ProfefeServer).GetProfiles(m, &profefeGetProfilesServer{stream})
+}
+
+type Profefe_GetProfilesServer interface {
+	Send(*GetProfilesResponse) error
+	grpc.ServerStream
+}
+
+type profefeGetProfilesServer struct {
+	grpc.ServerStream
+}
+
+func (x *profefeGetProfilesServer) Send(m *GetProfilesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Profefe_ListServices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProfefeServer).ListServices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/profefe.v0.Profefe/ListServices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProfefeServer).ListServices(ctx, req.(*ListServicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Profefe_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "profefe.v0.Profefe",
+	HandlerType: (*ProfefeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindProfiles",
+			Handler:    _Profefe_FindProfiles_Handler,
+		},
+		{
+			MethodName: "ListServices",
+			Handler:    _Profefe_ListServices_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WriteProfiles",
+			Handler:       _Profefe_WriteProfiles_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetProfiles",
+			Handler:       _Profefe_GetProfiles_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "profefe.proto",
+}
@@ -125,8 +125,8 @@ func (labels *Labels) FromString(s string) (err error) {
 
 	var chunk string
 	for s != "" {
-		chunk, s = split2(s, ',')
-		key, val := split2(chunk, '=')
+		chunk, s = Split2(s, ',')
+		key, val := Split2(chunk, '=')
 
 		key, err = url.QueryUnescape(strings.TrimSpace(key))
 		if err != nil {
@@ -149,7 +149,8 @@ func (labels *Labels) FromString(s string) (err error) {
 	return nil
 }
 
-func split2(s string, ch byte) (s1, s2 string) {
+// Split2 splits the string around the first occurrence of the char; s2 is empty if s has no char.
+func Split2(s string, ch byte) (s1, s2 string) {
 	for i := 0; i < len(s); i++ {
 		if s[i] == ch {
 			return s[:i], s[i+1:]
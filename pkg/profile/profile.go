@@ -46,6 +46,7 @@ func SplitIDs(s string) ([]ID, error) {
 type Meta struct {
 	ProfileID  ID          `json:"profile_id"`
 	ExternalID ID          `json:"external_id,omitempty"`
+	Tenant     string      `json:"tenant,omitempty"`
 	Service    string      `json:"service"`
 	Type       ProfileType `json:"type"`
 	Labels     Labels      `json:"labels,omitempty"`
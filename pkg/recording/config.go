@@ -0,0 +1,97 @@
+package recording
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultInterval  = time.Minute
+	defaultMaxSeries = 10000
+)
+
+type Config struct {
+	ConfigFile string
+	Interval   time.Duration
+	MaxSeries  int
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "recording.config-file", "", "path to YAML file with recording rules (reloaded on SIGHUP, recording is disabled if empty)")
+	f.DurationVar(&conf.Interval, "recording.interval", defaultInterval, "how often to evaluate recording rules over newly ingested profiles")
+	f.IntVar(&conf.MaxSeries, "recording.max-series", defaultMaxSeries, "the maximum number of series exported by all recording rules")
+}
+
+// Enabled reports whether the rules must be evaluated.
+func (conf *Config) Enabled() bool {
+	return conf.ConfigFile != ""
+}
+
+func (conf *Config) validate() error {
+	if conf.Interval <= 0 {
+		return fmt.Errorf("recording interval must be positive, got %v", conf.Interval)
+	}
+	if conf.MaxSeries <= 0 {
+		return fmt.Errorf("recording max series must be positive, got %d", conf.MaxSeries)
+	}
+	return nil
+}
+
+// FileConfig is the configuration of recording rules, loaded from a YAML file.
+//
+// Example:
+//
+//	rules:
+//	  # the top 10 functions of every service by CPU time they take by themselves
+//	  - record: pprof_function_cpu_seconds
+//	    type: cpu
+//	    group_by: function
+//	    flat: true
+//	    top: 10
+//	  # the top 5 packages of api-backend by the heap allocated by their functions and the functions they call
+//	  - record: pprof_package_alloc_bytes
+//	    services: [api-backend]
+//	    type: heap
+//	    sample_type: alloc_space
+//	    group_by: package
+//	    top: 5
+type FileConfig struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(conf.Rules))
+	for n, r := range conf.Rules {
+		if r == nil {
+			return nil, fmt.Errorf("empty recording rule %d", n)
+		}
+		if seen[r.Record] {
+			return nil, fmt.Errorf("duplicate recording rule %q", r.Record)
+		}
+		seen[r.Record] = true
+	}
+	return conf, nil
+}
@@ -0,0 +1,252 @@
+// Package recording implements the recording rules, that periodically aggregate the newly ingested profiles
+// of services into Prometheus series.
+package recording
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type series struct {
+	service string
+	group   string
+	value   float64
+}
+
+type ruleSeries struct {
+	rule   *Rule
+	desc   *prometheus.Desc
+	series []series
+}
+
+// Recorder evaluates the recording rules and exports their series as a Prometheus collector.
+// The rules can be replaced at runtime.
+type Recorder struct {
+	logger  *log.Logger
+	querier *profefe.Querier
+	conf    Config
+
+	mu       sync.Mutex
+	rules    []*Rule
+	recorded []ruleSeries
+	lastEval time.Time
+
+	evalFailuresTotal  prometheus.Counter
+	droppedSeriesTotal prometheus.Counter
+	reloadStatus       prometheus.Gauge
+
+	now func() time.Time
+}
+
+func NewRecorder(logger *log.Logger, conf Config, querier *profefe.Querier, registry prometheus.Registerer) (*Recorder, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		logger:  logger,
+		querier: querier,
+		conf:    conf,
+		evalFailuresTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "recording_rule_evaluation_failures_total",
+			Help:      "Number of failed evaluations of recording rules.",
+		}),
+		droppedSeriesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "recording_series_dropped_total",
+			Help:      "Number of series of recording rules dropped over the limit of series.",
+		}),
+		reloadStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "recording_config_last_reload_successful",
+			Help:      "Whether the last reload of recording config was successful.",
+		}),
+		now: time.Now,
+	}
+	registry.MustRegister(r.evalFailuresTotal, r.droppedSeriesTotal, r.reloadStatus, r)
+	return r, nil
+}
+
+// ApplyConfig replaces the rules with the rules from the config. The series of the removed rules
+// are dropped.
+func (r *Recorder) ApplyConfig(conf *FileConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make(map[string]bool, len(conf.Rules))
+	for _, rule := range conf.Rules {
+		records[rule.Record] = true
+	}
+	recorded := make([]ruleSeries, 0, len(r.recorded))
+	for _, rs := range r.recorded {
+		if records[rs.rule.Record] {
+			recorded = append(recorded, rs)
+		}
+	}
+	r.recorded = recorded
+	r.rules = conf.Rules
+
+	r.logger.Infow("recording rules applied", "rules", len(conf.Rules))
+}
+
+// LoadFile loads the config from the file and applies it. The current rules are kept, if the config
+// couldn't be loaded.
+func (r *Recorder) LoadFile(fileName string) error {
+	conf, err := LoadFile(fileName)
+	if err != nil {
+		r.reloadStatus.Set(0)
+		return err
+	}
+	r.ApplyConfig(conf)
+	r.reloadStatus.Set(1)
+	return nil
+}
+
+// Run evaluates the rules every interval, until the context is done.
+func (r *Recorder) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.evaluate(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// evaluates every rule over the profiles ingested since the previous successful evaluation, and replaces
+// the recorded series; the profiles are evaluated again, if any rule failed
+func (r *Recorder) evaluate(ctx context.Context) {
+	r.mu.Lock()
+	rules, from := r.rules, r.lastEval
+	r.mu.Unlock()
+
+	now := r.now().UTC()
+	if from.IsZero() {
+		from = now.Add(-r.conf.Interval)
+	}
+
+	var (
+		recorded []ruleSeries
+		nseries  int
+		dropped  int
+		failed   bool
+	)
+	for _, rule := range rules {
+		rs := ruleSeries{
+			rule: rule,
+			desc: prometheus.NewDesc(
+				rule.Record,
+				fmt.Sprintf("Top %ss of services by %s of %s profiles ingested during the last interval.", rule.GroupBy, sampleTypeName(rule), rule.ptype),
+				[]string{"service", rule.GroupBy},
+				nil,
+			),
+		}
+
+		services, err := r.services(ctx, rule)
+		if err != nil {
+			r.logger.Errorw("could not list services", "record", rule.Record, "tenant", rule.Tenant, zap.Error(err))
+			r.evalFailuresTotal.Inc()
+			failed = true
+		}
+		for _, service := range services {
+			groups, err := r.evaluateRule(ctx, rule, service, from, now)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				r.logger.Errorw("could not evaluate recording rule", "record", rule.Record, "tenant", rule.Tenant, "service", service, zap.Error(err))
+				r.evalFailuresTotal.Inc()
+				failed = true
+				continue
+			}
+			for _, g := range groups {
+				if nseries >= r.conf.MaxSeries {
+					dropped++
+					continue
+				}
+				rs.series = append(rs.series, series{service: service, group: g.name, value: g.value})
+				nseries++
+			}
+		}
+		recorded = append(recorded, rs)
+	}
+
+	if dropped > 0 {
+		r.logger.Warnw("recorded series exceed the limit", "limit", r.conf.MaxSeries, "dropped", dropped)
+		r.droppedSeriesTotal.Add(float64(dropped))
+	}
+
+	r.mu.Lock()
+	r.recorded = recorded
+	if !failed {
+		r.lastEval = now
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) services(ctx context.Context, rule *Rule) ([]string, error) {
+	if len(rule.Services) > 0 {
+		return rule.Services, nil
+	}
+	services, err := r.querier.ListServices(ctx, rule.Tenant)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	return services, err
+}
+
+// returns the top groups of the service's profiles, merged the same way the profiles API merges them
+func (r *Recorder) evaluateRule(ctx context.Context, rule *Rule, service string, from, to time.Time) ([]group, error) {
+	params := &storage.FindProfilesParams{
+		Tenant:       rule.Tenant,
+		Service:      service,
+		Type:         rule.ptype,
+		CreatedAtMin: from,
+		CreatedAtMax: to,
+	}
+	pp, _, err := r.querier.FindMergeProfile(ctx, params)
+	if err == storage.ErrNotFound {
+		// no new profiles
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return rule.groups(pp)
+}
+
+func sampleTypeName(rule *Rule) string {
+	if rule.SampleType != "" {
+		return rule.SampleType
+	}
+	return "default sample type"
+}
+
+// Describe implements prometheus.Collector. The recorder is an unchecked collector, as its series change
+// with the rules; the rules can't collide with the other metrics, as their names can't have the reserved prefixes.
+func (r *Recorder) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	recorded := r.recorded
+	r.mu.Unlock()
+
+	for _, rs := range recorded {
+		for _, s := range rs.series {
+			ch <- prometheus.MustNewConstMetric(rs.desc, prometheus.GaugeValue, s.value, s.service, s.group)
+		}
+	}
+}
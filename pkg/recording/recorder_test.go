@@ -0,0 +1,224 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type testProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) { return bytes.NewReader(pl.data), nil }
+
+func (pl *testProfileList) Close() error { return nil }
+
+// returns the reader, that finds the profiles of the services, keyed by the service, and records
+// the time windows the profiles are looked up in
+func newTestReader(t *testing.T, profiles map[string]*pprofProfile.Profile, windows *[][2]time.Time) *storage.StubReader {
+	return &storage.StubReader{
+		ListServicesFunc: func(ctx context.Context, tenant string) ([]string, error) {
+			services := make([]string, 0, len(profiles))
+			for service := range profiles {
+				services = append(services, service)
+			}
+			return services, nil
+		},
+		FindProfileIDsFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+			*windows = append(*windows, [2]time.Time{params.CreatedAtMin, params.CreatedAtMax})
+			if profiles[params.Service] == nil {
+				return nil, storage.ErrNotFound
+			}
+			return []profile.ID{profile.ID(params.Service)}, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, _ string, pids []profile.ID) (storage.ProfileList, error) {
+			list := &testProfileList{}
+			for _, pid := range pids {
+				var buf bytes.Buffer
+				require.NoError(t, profiles[string(pid)].Write(&buf))
+				list.profiles = append(list.profiles, buf.Bytes())
+			}
+			return list, nil
+		},
+	}
+}
+
+func newTestRecorder(t *testing.T, conf Config, sr storage.Reader, rules string) *Recorder {
+	testLogger := log.New(zaptest.NewLogger(t))
+	r, err := NewRecorder(testLogger, conf, profefe.NewQuerier(testLogger, sr), prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	fileConf, err := Load([]byte(rules))
+	require.NoError(t, err)
+	r.ApplyConfig(fileConf)
+
+	return r
+}
+
+func TestRecorder_evaluate(t *testing.T) {
+	profiles := map[string]*pprofProfile.Profile{
+		"svc1": newTestProfile(map[string]int64{"main.a;main.main": 3e9, "main.b;main.main": 1e9}),
+		"svc2": newTestProfile(map[string]int64{"main.c;main.main": 2e9}),
+	}
+	var windows [][2]time.Time
+	sr := newTestReader(t, profiles, &windows)
+
+	conf := Config{Interval: time.Minute, MaxSeries: 100}
+	r := newTestRecorder(t, conf, sr, `
+rules:
+  - record: pprof_function_cpu_seconds
+    type: cpu
+    flat: true
+    top: 1
+`)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	r.evaluate(context.Background())
+
+	err := testutil.CollectAndCompare(r, strings.NewReader(`
+# HELP pprof_function_cpu_seconds Top functions of services by default sample type of cpu profiles ingested during the last interval.
+# TYPE pprof_function_cpu_seconds gauge
+pprof_function_cpu_seconds{function="main.a",service="svc1"} 3
+pprof_function_cpu_seconds{function="main.c",service="svc2"} 2
+`))
+	require.NoError(t, err)
+
+	// the next evaluation only looks at the profiles ingested since the previous one
+	prev := now
+	now = now.Add(time.Minute)
+	delete(profiles, "svc2")
+	windows = nil
+
+	r.evaluate(context.Background())
+
+	require.Len(t, windows, 1)
+	assert.Equal(t, [2]time.Time{prev, now}, windows[0])
+	assert.Equal(t, 1, collectCount(r))
+}
+
+func TestRecorder_evaluate_failed(t *testing.T) {
+	profiles := map[string]*pprofProfile.Profile{
+		"svc1": newTestProfile(map[string]int64{"main.main": 1e9}),
+	}
+	var windows [][2]time.Time
+	sr := newTestReader(t, profiles, &windows)
+
+	conf := Config{Interval: time.Minute, MaxSeries: 100}
+	r := newTestRecorder(t, conf, sr, `rules: [{record: pprof_function_cpu_seconds, type: cpu}]`)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	r.evaluate(context.Background())
+	require.Len(t, windows, 1)
+
+	listServices := sr.ListServicesFunc
+	sr.ListServicesFunc = func(ctx context.Context, tenant string) ([]string, error) {
+		return nil, errors.New("storage is unavailable")
+	}
+	prev := now
+	now = now.Add(time.Minute)
+
+	r.evaluate(context.Background())
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.evalFailuresTotal))
+
+	// the profiles, ingested since the last successful evaluation, are evaluated by the next one
+	sr.ListServicesFunc = listServices
+	now = now.Add(time.Minute)
+	windows = nil
+
+	r.evaluate(context.Background())
+
+	require.Len(t, windows, 1)
+	assert.Equal(t, [2]time.Time{prev, now}, windows[0])
+	assert.Equal(t, 1, collectCount(r))
+}
+
+func TestRecorder_evaluate_maxSeries(t *testing.T) {
+	profiles := map[string]*pprofProfile.Profile{
+		"svc1": newTestProfile(map[string]int64{"main.a;main.main": 3e9, "main.b;main.main": 1e9}),
+	}
+	var windows [][2]time.Time
+	sr := newTestReader(t, profiles, &windows)
+
+	conf := Config{Interval: time.Minute, MaxSeries: 3}
+	r := newTestRecorder(t, conf, sr, `
+rules:
+  - record: pprof_function_cpu_seconds
+    type: cpu
+  - record: pprof_package_cpu_seconds
+    type: cpu
+    group_by: package
+`)
+
+	r.evaluate(context.Background())
+
+	// main.main, main.a and main.b of the first rule fill the limit
+	assert.Equal(t, 3, collectCount(r))
+	assert.Equal(t, float64(1), testutil.ToFloat64(r.droppedSeriesTotal))
+}
+
+func TestRecorder_ApplyConfig_dropsRemovedRules(t *testing.T) {
+	profiles := map[string]*pprofProfile.Profile{
+		"svc1": newTestProfile(map[string]int64{"main.main": 1e9}),
+	}
+	var windows [][2]time.Time
+	sr := newTestReader(t, profiles, &windows)
+
+	conf := Config{Interval: time.Minute, MaxSeries: 100}
+	r := newTestRecorder(t, conf, sr, `
+rules:
+  - {record: pprof_function_cpu_seconds, type: cpu}
+  - {record: pprof_package_cpu_seconds, type: cpu, group_by: package}
+`)
+	r.evaluate(context.Background())
+	require.Equal(t, 2, collectCount(r))
+
+	fileConf, err := Load([]byte(`rules: [{record: pprof_package_cpu_seconds, type: cpu, group_by: package}]`))
+	require.NoError(t, err)
+	r.ApplyConfig(fileConf)
+
+	assert.Equal(t, 1, collectCount(r))
+}
+
+// returns the number of metrics the collector collects
+func collectCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var n int
+	for range ch {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,183 @@
+package recording
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+const (
+	GroupByFunction = "function"
+	GroupByPackage  = "package"
+
+	defaultTop = 10
+	maxTop     = 100
+)
+
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// the prefixes of the metrics, exported by the collector itself; the series of a rule, that collides with them,
+// would fail the metrics to be gathered
+var reservedMetricPrefixes = []string{"profefe_", "go_", "process_", "promhttp_"}
+
+// Rule is a recording rule, that exports the top functions, or packages, of every service's profiles,
+// ingested since the previous evaluation, as the series of the metric.
+type Rule struct {
+	// the name of the metric
+	Record string `yaml:"record"`
+	Tenant string `yaml:"tenant,omitempty"`
+	// the services the series are recorded for; every service of the tenant if empty
+	Services []string `yaml:"services,flow,omitempty"`
+	Type     string   `yaml:"type"`
+	// the label the values are grouped by, either "function" or "package"
+	GroupBy string `yaml:"group_by,omitempty"`
+	// whether only the samples of the functions themselves are counted; by default, the samples
+	// of every function called by the function are counted as well
+	Flat bool `yaml:"flat,omitempty"`
+	// the sample type of the profile, e.g. "alloc_space"; the default sample type of the profile if empty
+	SampleType string `yaml:"sample_type,omitempty"`
+	// the number of the largest groups recorded per service
+	Top int `yaml:"top,omitempty"`
+
+	ptype profile.ProfileType
+}
+
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return r.validate()
+}
+
+func (r *Rule) validate() error {
+	if !metricNameRe.MatchString(r.Record) {
+		return fmt.Errorf("recording rule: bad metric name %q", r.Record)
+	}
+	for _, prefix := range reservedMetricPrefixes {
+		if strings.HasPrefix(r.Record, prefix) {
+			return fmt.Errorf("recording rule %q: metric name prefix %q is reserved", r.Record, prefix)
+		}
+	}
+	if err := tenant.ValidateName(r.Tenant); err != nil {
+		return fmt.Errorf("recording rule %q: %w", r.Record, err)
+	}
+	r.ptype.FromString(r.Type)
+	if r.ptype == profile.TypeUnknown || r.ptype == profile.TypeTrace {
+		return fmt.Errorf("recording rule %q: unsupported profile type %q", r.Record, r.Type)
+	}
+	switch r.GroupBy {
+	case "":
+		r.GroupBy = GroupByFunction
+	case GroupByFunction, GroupByPackage:
+	default:
+		return fmt.Errorf("recording rule %q: unknown group_by %q", r.Record, r.GroupBy)
+	}
+	if r.Top == 0 {
+		r.Top = defaultTop
+	} else if r.Top < 0 || r.Top > maxTop {
+		return fmt.Errorf("recording rule %q: top must be in [1, %d], got %d", r.Record, maxTop, r.Top)
+	}
+	return nil
+}
+
+type group struct {
+	name  string
+	value float64
+}
+
+// groups returns the largest groups of the profile's samples, the largest first. The values
+// in nanoseconds are converted to seconds.
+func (r *Rule) groups(pp *pprofProfile.Profile) ([]group, error) {
+	idx, err := r.sampleValueIndex(pp)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int64)
+	seen := make(map[string]bool)
+	for _, s := range pp.Sample {
+		v := s.Value[idx]
+		if v == 0 || len(s.Location) == 0 {
+			continue
+		}
+		if r.Flat {
+			if name := r.groupName(s.Location[0]); name != "" {
+				values[name] += v
+			}
+			continue
+		}
+		// every group is counted once per sample, even if the stack is recursive
+		for k := range seen {
+			delete(seen, k)
+		}
+		for _, loc := range s.Location {
+			if name := r.groupName(loc); name != "" && !seen[name] {
+				seen[name] = true
+				values[name] += v
+			}
+		}
+	}
+
+	scale := 1.0
+	if pp.SampleType[idx].Unit == "nanoseconds" {
+		scale = 1e-9
+	}
+
+	groups := make([]group, 0, len(values))
+	for name, v := range values {
+		groups = append(groups, group{name: name, value: float64(v) * scale})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].value != groups[j].value {
+			return groups[i].value > groups[j].value
+		}
+		return groups[i].name < groups[j].name
+	})
+	if len(groups) > r.Top {
+		groups = groups[:r.Top]
+	}
+	return groups, nil
+}
+
+func (r *Rule) sampleValueIndex(pp *pprofProfile.Profile) (int, error) {
+	if len(pp.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample types")
+	}
+	if r.SampleType == "" {
+		return len(pp.SampleType) - 1, nil
+	}
+	for i, st := range pp.SampleType {
+		if st.Type == r.SampleType {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("profile has no sample type %q", r.SampleType)
+}
+
+// returns the name of the group of the location's innermost function
+func (r *Rule) groupName(loc *pprofProfile.Location) string {
+	if len(loc.Line) == 0 || loc.Line[0].Function == nil {
+		return ""
+	}
+	fn := loc.Line[0].Function.Name
+	if r.GroupBy == GroupByPackage {
+		return packageName(fn)
+	}
+	return fn
+}
+
+// returns the package of the function, e.g. "github.com/profefe/profefe/pkg/profefe"
+// for "github.com/profefe/profefe/pkg/profefe.(*Querier).FindProfiles"
+func packageName(fn string) string {
+	slash := strings.LastIndexByte(fn, '/')
+	if dot := strings.IndexByte(fn[slash+1:], '.'); dot >= 0 {
+		return fn[:slash+1+dot]
+	}
+	return fn
+}
@@ -0,0 +1,171 @@
+package recording
+
+import (
+	"strings"
+	"testing"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	conf, err := Load([]byte(`
+rules:
+  - record: pprof_function_cpu_seconds
+    type: cpu
+    flat: true
+  - record: pprof_package_alloc_bytes
+    tenant: team-a
+    services: [svc1, svc2]
+    type: heap
+    sample_type: alloc_space
+    group_by: package
+    top: 5
+`))
+	require.NoError(t, err)
+	require.Len(t, conf.Rules, 2)
+
+	r := conf.Rules[0]
+	assert.Equal(t, "pprof_function_cpu_seconds", r.Record)
+	assert.Equal(t, profile.TypeCPU, r.ptype)
+	assert.Equal(t, GroupByFunction, r.GroupBy)
+	assert.Equal(t, defaultTop, r.Top)
+	assert.True(t, r.Flat)
+
+	r = conf.Rules[1]
+	assert.Equal(t, "team-a", r.Tenant)
+	assert.Equal(t, []string{"svc1", "svc2"}, r.Services)
+	assert.Equal(t, profile.TypeHeap, r.ptype)
+	assert.Equal(t, GroupByPackage, r.GroupBy)
+	assert.Equal(t, 5, r.Top)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"no record":       "rules: [{type: cpu}]",
+		"bad record":      "rules: [{record: 'profefe-cpu', type: cpu}]",
+		"reserved record": "rules: [{record: profefe_recording_series_dropped_total, type: cpu}]",
+		"bad type":        "rules: [{record: m, type: trace}]",
+		"bad group_by":    "rules: [{record: m, type: cpu, group_by: line}]",
+		"top too large":   "rules: [{record: m, type: cpu, top: 1000}]",
+		"negative top":    "rules: [{record: m, type: cpu, top: -1}]",
+		"bad tenant":      "rules: [{record: m, tenant: 'a/b', type: cpu}]",
+		"duplicate":       "rules: [{record: m, type: cpu}, {record: m, type: heap}]",
+		"unknown field":   "rules: [{record: m, type: cpu, foo: bar}]",
+		"empty rule":      "rules: [~]",
+		"not rules list":  "rules: {record: m}",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+// creates CPU profile, where every stack, listed from the innermost function, takes the values
+func newTestProfile(stacks map[string]int64) *pprofProfile.Profile {
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+	}
+
+	funcs := make(map[string]*pprofProfile.Location)
+	for stack, v := range stacks {
+		var locs []*pprofProfile.Location
+		for _, name := range strings.Split(stack, ";") {
+			loc := funcs[name]
+			if loc == nil {
+				fn := &pprofProfile.Function{ID: uint64(len(funcs) + 1), Name: name}
+				loc = &pprofProfile.Location{ID: fn.ID, Line: []pprofProfile.Line{{Function: fn}}}
+				funcs[name] = loc
+				pp.Function = append(pp.Function, fn)
+				pp.Location = append(pp.Location, loc)
+			}
+			locs = append(locs, loc)
+		}
+		pp.Sample = append(pp.Sample, &pprofProfile.Sample{Location: locs, Value: []int64{1, v}})
+	}
+	return pp
+}
+
+func TestRule_groups(t *testing.T) {
+	pp := newTestProfile(map[string]int64{
+		"encoding/json.Marshal;example.com/api.(*Server).handle;main.main": 3e9,
+		"encoding/json.Unmarshal;encoding/json.Marshal;main.main":          1e9,
+		"example.com/api.(*Server).handle;main.main":                       2e9,
+		"runtime.mallocgc;runtime.mallocgc":                                5e8,
+	})
+
+	cases := []struct {
+		name    string
+		groupBy string
+		flat    bool
+		top     int
+		want    []group
+	}{
+		{
+			"flat functions",
+			GroupByFunction, true, 10,
+			[]group{
+				{"encoding/json.Marshal", 3},
+				{"example.com/api.(*Server).handle", 2},
+				{"encoding/json.Unmarshal", 1},
+				{"runtime.mallocgc", 0.5},
+			},
+		},
+		{
+			"cumulative functions",
+			GroupByFunction, false, 3,
+			[]group{
+				{"main.main", 6},
+				{"example.com/api.(*Server).handle", 5},
+				{"encoding/json.Marshal", 4},
+			},
+		},
+		{
+			"cumulative packages",
+			GroupByPackage, false, 10,
+			[]group{
+				{"main", 6},
+				{"example.com/api", 5},
+				{"encoding/json", 4},
+				{"runtime", 0.5},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &Rule{GroupBy: tc.groupBy, Flat: tc.flat, Top: tc.top}
+			groups, err := r.groups(pp)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, groups)
+		})
+	}
+}
+
+func TestRule_groups_badSampleType(t *testing.T) {
+	pp := newTestProfile(map[string]int64{"main.main": 1})
+
+	r := &Rule{GroupBy: GroupByFunction, SampleType: "alloc_space", Top: 1}
+	_, err := r.groups(pp)
+	require.Error(t, err)
+}
+
+func TestPackageName(t *testing.T) {
+	cases := map[string]string{
+		"main.main":                    "main",
+		"encoding/json.Marshal":        "encoding/json",
+		"example.com/api.(*S).handle":  "example.com/api",
+		"example.com/api.v2/x.Handler": "example.com/api.v2/x",
+		"example.com/api.func1":        "example.com/api",
+		"0x4567":                       "0x4567",
+	}
+	for fn, want := range cases {
+		assert.Equal(t, want, packageName(fn), fn)
+	}
+}
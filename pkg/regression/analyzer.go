@@ -0,0 +1,389 @@
+// Package regression implements the analyzer, that periodically compares the recent profiles of every service
+// with a baseline, and flags the functions which share of the profile grew.
+package regression
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	// the maximum number of functions flagged per service and profile type in a single run
+	maxFindingsPerProfile = 10
+
+	webhookTimeout = 10 * time.Second
+)
+
+// the sample types the functions' shares are computed from; the default sample type is used for other types
+var sampleTypes = map[profile.ProfileType]string{
+	profile.TypeCPU:  "cpu",
+	profile.TypeHeap: "alloc_space",
+}
+
+// Analyzer periodically looks for regressions in the profiles of every service.
+type Analyzer struct {
+	logger  *log.Logger
+	conf    Config
+	ptypes  []profile.ProfileType
+	tenants []string
+	querier *profefe.Querier
+	store   *Store
+	client  *http.Client
+
+	findingsTotal *prometheus.CounterVec
+	errorsTotal   prometheus.Counter
+
+	now func() time.Time
+}
+
+func NewAnalyzer(logger *log.Logger, conf Config, querier *profefe.Querier, store *Store, registry prometheus.Registerer) (*Analyzer, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+	ptypes, err := conf.profileTypes()
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := conf.tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Analyzer{
+		logger:  logger,
+		conf:    conf,
+		ptypes:  ptypes,
+		tenants: tenants,
+		querier: querier,
+		store:   store,
+		client:  &http.Client{Timeout: webhookTimeout},
+		findingsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "regression_findings_total",
+			Help:      "Number of new regressions found.",
+		}, []string{"tenant", "service", "type"}),
+		errorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "regression_analysis_errors_total",
+			Help:      "Number of failed analyses of service's profiles.",
+		}),
+		now: time.Now,
+	}
+	registry.MustRegister(a.findingsTotal, a.errorsTotal)
+
+	return a, nil
+}
+
+// Run analyzes the profiles every interval, until the context is done.
+func (a *Analyzer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.analyze(ctx)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// analyzes the profiles of every service of every tenant
+func (a *Analyzer) analyze(ctx context.Context) {
+	now := a.now().UTC()
+
+	var findings []*Finding
+	for _, tenant := range a.tenants {
+		services, err := a.querier.ListServices(ctx, tenant)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			a.logger.Errorw("could not list services", "tenant", tenant, zap.Error(err))
+			a.errorsTotal.Inc()
+			continue
+		}
+
+		for _, service := range services {
+			for _, ptype := range a.ptypes {
+				ff, err := a.analyzeService(ctx, tenant, service, ptype, now)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					a.logger.Errorw("could not analyze profiles", "tenant", tenant, "service", service, "type", ptype, zap.Error(err))
+					a.errorsTotal.Inc()
+					continue
+				}
+				findings = append(findings, ff...)
+			}
+		}
+	}
+
+	added, err := a.store.Add(now, findings)
+	if err != nil {
+		a.logger.Errorw("could not store findings", zap.Error(err))
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	for _, f := range added {
+		a.findingsTotal.WithLabelValues(f.Tenant, f.Service, f.Type).Inc()
+	}
+	a.logger.Infow("found regressions", "findings", len(added))
+
+	if a.conf.WebhookURL != "" {
+		if err := a.postWebhook(ctx, added); err != nil {
+			a.logger.Errorw("could not post findings to webhook", zap.Error(err))
+		}
+	}
+}
+
+func (a *Analyzer) analyzeService(ctx context.Context, tenant, service string, ptype profile.ProfileType, now time.Time) ([]*Finding, error) {
+	recentParams := &storage.FindProfilesParams{
+		Tenant:       tenant,
+		Service:      service,
+		Type:         ptype,
+		CreatedAtMin: now.Add(-a.conf.Window),
+		CreatedAtMax: now,
+	}
+
+	var (
+		baselineParams *storage.FindProfilesParams
+		baseline       string
+		version        string
+	)
+	switch a.conf.Baseline {
+	case BaselinePreviousDay:
+		baselineParams = &storage.FindProfilesParams{
+			Tenant:       tenant,
+			Service:      service,
+			Type:         ptype,
+			CreatedAtMin: recentParams.CreatedAtMin.Add(-24 * time.Hour),
+			CreatedAtMax: recentParams.CreatedAtMax.Add(-24 * time.Hour),
+		}
+		baseline = BaselinePreviousDay
+	case BaselinePreviousVersion:
+		var prevVersion string
+		var err error
+		version, prevVersion, err = a.findVersions(ctx, tenant, service, ptype, now)
+		if err != nil || prevVersion == "" {
+			return nil, err
+		}
+		recentParams.Labels = profile.Labels{{Key: a.conf.VersionLabel, Value: version}}
+		baselineParams = &storage.FindProfilesParams{
+			Tenant:       tenant,
+			Service:      service,
+			Type:         ptype,
+			Labels:       profile.Labels{{Key: a.conf.VersionLabel, Value: prevVersion}},
+			CreatedAtMin: now.Add(-a.conf.VersionLookback),
+			CreatedAtMax: now,
+		}
+		baseline = a.conf.VersionLabel + "=" + prevVersion
+	}
+
+	recentPP, _, err := a.querier.FindMergeProfile(ctx, recentParams)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not merge recent profiles: %w", err)
+	}
+	baselinePP, _, err := a.querier.FindMergeProfile(ctx, baselineParams)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not merge baseline profiles: %w", err)
+	}
+
+	growths := compareProfiles(baselinePP, recentPP, ptype, a.conf.MinShareGrowth, a.conf.MinGrowthRatio)
+	if len(growths) > maxFindingsPerProfile {
+		growths = growths[:maxFindingsPerProfile]
+	}
+
+	findings := make([]*Finding, 0, len(growths))
+	for _, g := range growths {
+		findings = append(findings, &Finding{
+			Tenant:        tenant,
+			Service:       service,
+			Type:          ptype.String(),
+			Function:      g.function,
+			Baseline:      baseline,
+			Version:       version,
+			BaselineShare: g.baselineShare,
+			RecentShare:   g.recentShare,
+			FirstSeenAt:   now,
+			LastSeenAt:    now,
+		})
+	}
+	return findings, nil
+}
+
+// returns the version of the most recent profile of the service and the version that ran before it
+func (a *Analyzer) findVersions(ctx context.Context, tenant, service string, ptype profile.ProfileType, now time.Time) (version, prevVersion string, err error) {
+	params := &storage.FindProfilesParams{
+		Tenant:       tenant,
+		Service:      service,
+		Type:         ptype,
+		CreatedAtMin: now.Add(-a.conf.VersionLookback),
+		CreatedAtMax: now,
+	}
+
+	// the profiles are walked from the oldest to the most recent one
+	var lastSeenAt time.Time
+	err = a.querier.WalkProfiles(ctx, params, func(metas []profile.Meta) error {
+		for _, meta := range metas {
+			v := labelValue(meta.Labels, a.conf.VersionLabel)
+			if v == "" {
+				continue
+			}
+			if v != version {
+				prevVersion, version = version, v
+			}
+			lastSeenAt = meta.CreatedAt
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not find profiles: %w", err)
+	}
+
+	// the current version must have profiles in the recent window
+	if version == "" || lastSeenAt.Before(now.Add(-a.conf.Window)) {
+		return "", "", nil
+	}
+	return version, prevVersion, nil
+}
+
+func labelValue(labels profile.Labels, key string) string {
+	for _, label := range labels {
+		if label.Key == key {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+type functionGrowth struct {
+	function      string
+	baselineShare float64
+	recentShare   float64
+}
+
+// returns the functions, which share of the recent profile grew compared with the baseline, the largest growth first
+func compareProfiles(baseline, recent *pprofProfile.Profile, ptype profile.ProfileType, minShareGrowth, minGrowthRatio float64) []functionGrowth {
+	baselineShares := functionShares(baseline, ptype)
+	recentShares := functionShares(recent, ptype)
+
+	var growths []functionGrowth
+	for fn, recentShare := range recentShares {
+		baselineShare := baselineShares[fn]
+		if recentShare-baselineShare < minShareGrowth {
+			continue
+		}
+		if baselineShare > 0 && recentShare/baselineShare < minGrowthRatio {
+			continue
+		}
+		growths = append(growths, functionGrowth{
+			function:      fn,
+			baselineShare: baselineShare,
+			recentShare:   recentShare,
+		})
+	}
+
+	sort.Slice(growths, func(i, j int) bool {
+		gi, gj := growths[i], growths[j]
+		if di, dj := gi.recentShare-gi.baselineShare, gj.recentShare-gj.baselineShare; di != dj {
+			return di > dj
+		}
+		return gi.function < gj.function
+	})
+
+	return growths
+}
+
+// returns the shares of the profile's total, that functions take by themselves (flat)
+func functionShares(pp *pprofProfile.Profile, ptype profile.ProfileType) map[string]float64 {
+	n := sampleValueIndex(pp, ptype)
+	if n < 0 {
+		return nil
+	}
+
+	var total int64
+	values := make(map[string]int64)
+	for _, s := range pp.Sample {
+		v := s.Value[n]
+		total += v
+		if len(s.Location) == 0 {
+			continue
+		}
+		values[leafFunction(s.Location[0])] += v
+	}
+	if total == 0 {
+		return nil
+	}
+
+	shares := make(map[string]float64, len(values))
+	for fn, v := range values {
+		shares[fn] = float64(v) / float64(total)
+	}
+	return shares
+}
+
+func sampleValueIndex(pp *pprofProfile.Profile, ptype profile.ProfileType) int {
+	if typ, ok := sampleTypes[ptype]; ok {
+		for i, st := range pp.SampleType {
+			if st.Type == typ {
+				return i
+			}
+		}
+	}
+	return len(pp.SampleType) - 1
+}
+
+// returns the name of the innermost function of the location
+func leafFunction(loc *pprofProfile.Location) string {
+	if len(loc.Line) > 0 && loc.Line[0].Function != nil {
+		return loc.Line[0].Function.Name
+	}
+	return fmt.Sprintf("0x%x", loc.Address)
+}
+
+func (a *Analyzer) postWebhook(ctx context.Context, findings []*Finding) error {
+	body, err := json.Marshal(struct {
+		Findings []*Finding `json:"findings"`
+	}{findings})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.conf.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
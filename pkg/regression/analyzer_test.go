@@ -0,0 +1,310 @@
+package regression
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// creates CPU profile, where the functions take the values by themselves
+func newTestProfile(funcs map[string]int64) *pprofProfile.Profile {
+	pp := &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+	}
+
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for n, name := range names {
+		fn := &pprofProfile.Function{ID: uint64(n + 1), Name: name}
+		loc := &pprofProfile.Location{ID: uint64(n + 1), Line: []pprofProfile.Line{{Function: fn}}}
+		pp.Function = append(pp.Function, fn)
+		pp.Location = append(pp.Location, loc)
+		pp.Sample = append(pp.Sample, &pprofProfile.Sample{
+			Location: []*pprofProfile.Location{loc},
+			Value:    []int64{1, funcs[name]},
+		})
+	}
+	return pp
+}
+
+func TestCompareProfiles(t *testing.T) {
+	baseline := newTestProfile(map[string]int64{"main.a": 50, "main.b": 40, "main.c": 10})
+	recent := newTestProfile(map[string]int64{"main.a": 40, "main.b": 30, "main.c": 20, "main.d": 10})
+
+	growths := compareProfiles(baseline, recent, profile.TypeCPU, 0.05, 1.5)
+	assert.Equal(t, []functionGrowth{
+		{function: "main.c", baselineShare: 0.1, recentShare: 0.2},
+		{function: "main.d", baselineShare: 0, recentShare: 0.1},
+	}, growths)
+
+	// main.c doesn't grow enough relative to its baseline share
+	growths = compareProfiles(baseline, recent, profile.TypeCPU, 0.05, 2.5)
+	assert.Equal(t, []string{"main.d"}, growthFunctions(growths))
+
+	growths = compareProfiles(baseline, recent, profile.TypeCPU, 0.2, 1.5)
+	assert.Empty(t, growths)
+}
+
+func growthFunctions(growths []functionGrowth) (funcs []string) {
+	for _, g := range growths {
+		funcs = append(funcs, g.function)
+	}
+	return funcs
+}
+
+type testProfiles struct {
+	// the profiles keyed by the version label, returned for the time window starting at CreatedAtMin
+	profiles map[time.Time]map[string]*pprofProfile.Profile
+	// the metas in the order of their creation
+	metas []profile.Meta
+}
+
+func (tp *testProfiles) reader(t *testing.T) *storage.StubReader {
+	pids := make(map[profile.ID]*pprofProfile.Profile)
+	return &storage.StubReader{
+		ListServicesFunc: func(ctx context.Context, tenant string) ([]string, error) {
+			return []string{"svc1"}, nil
+		},
+		// returns the newest profiles first, like badger does
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			var metas []profile.Meta
+			for i := len(tp.metas) - 1; i >= 0; i-- {
+				if len(metas) == params.Limit {
+					break
+				}
+				meta := tp.metas[i]
+				if !meta.CreatedAt.Before(params.CreatedAtMin) && !meta.CreatedAt.After(params.CreatedAtMax) {
+					metas = append(metas, meta)
+				}
+			}
+			if len(metas) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			return metas, nil
+		},
+		FindProfileIDsFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+			var version string
+			for _, label := range params.Labels {
+				version = label.Value
+			}
+			pp := tp.profiles[params.CreatedAtMin][version]
+			if pp == nil {
+				return nil, storage.ErrNotFound
+			}
+			pid := profile.ID(params.CreatedAtMin.Format(time.RFC3339) + version)
+			pids[pid] = pp
+			return []profile.ID{pid}, nil
+		},
+		ListProfilesFunc: func(ctx context.Context, _ string, ids []profile.ID) (storage.ProfileList, error) {
+			list := &testProfileList{}
+			for _, pid := range ids {
+				var buf bytes.Buffer
+				require.NoError(t, pids[pid].Write(&buf))
+				list.profiles = append(list.profiles, buf.Bytes())
+			}
+			return list, nil
+		},
+	}
+}
+
+type testProfileList struct {
+	profiles [][]byte
+	data     []byte
+}
+
+func (pl *testProfileList) Next() bool {
+	if len(pl.profiles) == 0 {
+		return false
+	}
+	pl.data, pl.profiles = pl.profiles[0], pl.profiles[1:]
+	return true
+}
+
+func (pl *testProfileList) Profile() (io.Reader, error) { return bytes.NewReader(pl.data), nil }
+
+func (pl *testProfileList) Close() error { return nil }
+
+func newTestAnalyzer(t *testing.T, conf Config, sr storage.Reader, now time.Time) *Analyzer {
+	testLogger := log.New(zaptest.NewLogger(t))
+	store, err := NewStore("")
+	require.NoError(t, err)
+
+	a, err := NewAnalyzer(testLogger, conf, profefe.NewQuerier(testLogger, sr), store, prometheus.NewRegistry())
+	require.NoError(t, err)
+	a.now = func() time.Time { return now }
+	return a
+}
+
+func newTestConfig() Config {
+	return Config{
+		Interval:        time.Hour,
+		Window:          time.Hour,
+		Baseline:        BaselinePreviousDay,
+		VersionLabel:    "version",
+		VersionLookback: defaultVersionLookback,
+		Types:           "cpu",
+		MinShareGrowth:  defaultMinShareGrowth,
+		MinGrowthRatio:  defaultMinGrowthRatio,
+	}
+}
+
+func TestAnalyzer_analyze_previousDay(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	recentFrom := now.Add(-time.Hour)
+
+	tp := &testProfiles{
+		profiles: map[time.Time]map[string]*pprofProfile.Profile{
+			recentFrom:                      {"": newTestProfile(map[string]int64{"main.a": 50, "main.b": 50})},
+			recentFrom.Add(-24 * time.Hour): {"": newTestProfile(map[string]int64{"main.a": 90, "main.b": 10})},
+		},
+	}
+
+	var webhookFindings []*Finding
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Findings []*Finding `json:"findings"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		webhookFindings = append(webhookFindings, body.Findings...)
+	}))
+	defer webhook.Close()
+
+	conf := newTestConfig()
+	conf.WebhookURL = webhook.URL
+	a := newTestAnalyzer(t, conf, tp.reader(t), now)
+
+	a.analyze(context.Background())
+
+	findings := a.store.Findings("", nil)
+	require.Len(t, findings, 1)
+	f := findings[0]
+	assert.Equal(t, "svc1", f.Service)
+	assert.Equal(t, "cpu", f.Type)
+	assert.Equal(t, "main.b", f.Function)
+	assert.Equal(t, BaselinePreviousDay, f.Baseline)
+	assert.InDelta(t, 0.1, f.BaselineShare, 1e-9)
+	assert.InDelta(t, 0.5, f.RecentShare, 1e-9)
+
+	require.Len(t, webhookFindings, 1)
+	assert.Equal(t, "main.b", webhookFindings[0].Function)
+
+	// the known regression isn't reported again
+	a.now = func() time.Time { return now.Add(time.Hour) }
+	tp.profiles[recentFrom.Add(time.Hour)] = tp.profiles[recentFrom]
+	tp.profiles[recentFrom.Add(-23*time.Hour)] = tp.profiles[recentFrom.Add(-24*time.Hour)]
+
+	a.analyze(context.Background())
+
+	findings = a.store.Findings("", nil)
+	require.Len(t, findings, 1)
+	assert.Equal(t, now, findings[0].FirstSeenAt)
+	assert.Equal(t, now.Add(time.Hour), findings[0].LastSeenAt)
+	assert.Len(t, webhookFindings, 1)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(a.findingsTotal.WithLabelValues("", "svc1", "cpu")))
+}
+
+func TestAnalyzer_analyze_previousVersion(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	lookbackFrom := now.Add(-defaultVersionLookback)
+	recentFrom := now.Add(-time.Hour)
+
+	tp := &testProfiles{
+		profiles: map[time.Time]map[string]*pprofProfile.Profile{
+			recentFrom:   {"1.1": newTestProfile(map[string]int64{"main.a": 50, "main.b": 50})},
+			lookbackFrom: {"1.0": newTestProfile(map[string]int64{"main.a": 90, "main.b": 10})},
+		},
+		metas: []profile.Meta{
+			{Service: "svc1", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "version", Value: "1.0"}}, CreatedAt: now.Add(-5 * time.Hour)},
+			{Service: "svc1", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "version", Value: "1.1"}}, CreatedAt: now.Add(-2 * time.Hour)},
+			{Service: "svc1", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "version", Value: "1.1"}}, CreatedAt: now.Add(-time.Minute)},
+		},
+	}
+
+	conf := newTestConfig()
+	conf.Baseline = BaselinePreviousVersion
+	a := newTestAnalyzer(t, conf, tp.reader(t), now)
+
+	a.analyze(context.Background())
+
+	findings := a.store.Findings("", nil)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "main.b", findings[0].Function)
+	assert.Equal(t, "version=1.0", findings[0].Baseline)
+	assert.Equal(t, "1.1", findings[0].Version)
+}
+
+func TestNewAnalyzer_badConfig(t *testing.T) {
+	cases := map[string]func(conf *Config){
+		"unknown baseline": func(conf *Config) { conf.Baseline = "nope" },
+		"zero window":      func(conf *Config) { conf.Window = 0 },
+		"short lookback": func(conf *Config) {
+			conf.Baseline = BaselinePreviousVersion
+			conf.VersionLookback = conf.Window
+		},
+		"bad share growth": func(conf *Config) { conf.MinShareGrowth = 1 },
+		"bad growth ratio": func(conf *Config) { conf.MinGrowthRatio = 0.5 },
+		"trace type":       func(conf *Config) { conf.Types = "cpu,trace" },
+		"bad tenant":       func(conf *Config) { conf.Tenants = "a/b" },
+	}
+	for name, fn := range cases {
+		t.Run(name, func(t *testing.T) {
+			conf := newTestConfig()
+			fn(&conf)
+			_, err := NewAnalyzer(log.New(zaptest.NewLogger(t)), conf, nil, nil, prometheus.NewRegistry())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAnalyzer_findVersions(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	newMeta := func(version string, createdAt time.Time) profile.Meta {
+		return profile.Meta{Service: "svc1", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "version", Value: version}}, CreatedAt: createdAt}
+	}
+
+	tp := &testProfiles{}
+	tp.metas = append(tp.metas, newMeta("1.0", now.Add(-5*time.Hour)))
+	// the profiles of the current version don't fit a single batch
+	for i := 300; i > 0; i-- {
+		tp.metas = append(tp.metas, newMeta("1.1", now.Add(-time.Duration(i)*time.Minute)))
+	}
+
+	a := newTestAnalyzer(t, newTestConfig(), tp.reader(t), now)
+
+	version, prevVersion, err := a.findVersions(context.Background(), "", "svc1", profile.TypeCPU, now)
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", version)
+	assert.Equal(t, "1.0", prevVersion)
+
+	// the current version has no profiles in the recent window
+	version, prevVersion, err = a.findVersions(context.Background(), "", "svc1", profile.TypeCPU, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, version)
+	assert.Empty(t, prevVersion)
+}
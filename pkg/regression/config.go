@@ -0,0 +1,120 @@
+package regression
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+const (
+	// BaselinePreviousDay compares the recent window with the same window a day before.
+	BaselinePreviousDay = "previous-day"
+	// BaselinePreviousVersion compares the recent profiles of the current version of the service
+	// with the profiles of the version that ran before it.
+	BaselinePreviousVersion = "previous-version"
+
+	defaultWindow          = time.Hour
+	defaultVersionLookback = 7 * 24 * time.Hour
+	defaultMinShareGrowth  = 0.05
+	defaultMinGrowthRatio  = 1.5
+)
+
+type Config struct {
+	Interval        time.Duration
+	Window          time.Duration
+	Baseline        string
+	VersionLabel    string
+	VersionLookback time.Duration
+	Types           string
+	Tenants         string
+	MinShareGrowth  float64
+	MinGrowthRatio  float64
+	WebhookURL      string
+	FindingsFile    string
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&conf.Interval, "regression.interval", 0, "how often to look for regressions (the analyzer is disabled if zero)")
+	f.DurationVar(&conf.Window, "regression.window", defaultWindow, "the window of recent profiles compared with the baseline")
+	f.StringVar(&conf.Baseline, "regression.baseline", BaselinePreviousDay, fmt.Sprintf("the baseline recent profiles are compared with: %s, %s", BaselinePreviousDay, BaselinePreviousVersion))
+	f.StringVar(&conf.VersionLabel, "regression.version-label", "version", "the label with the version of the service, for previous-version baseline")
+	f.DurationVar(&conf.VersionLookback, "regression.version-lookback", defaultVersionLookback, "how far back to look for the previous version of the service")
+	f.StringVar(&conf.Types, "regression.types", "cpu,heap", "comma-separated list of profile types to analyze")
+	f.StringVar(&conf.Tenants, "regression.tenants", "", "comma-separated list of tenants to analyze (only the default tenant if empty)")
+	f.Float64Var(&conf.MinShareGrowth, "regression.min-share-growth", defaultMinShareGrowth, "the minimal growth of function's share of the profile, e.g. 0.05 for 5 percentage points")
+	f.Float64Var(&conf.MinGrowthRatio, "regression.min-growth-ratio", defaultMinGrowthRatio, "the minimal ratio of function's recent share to its baseline share")
+	f.StringVar(&conf.WebhookURL, "regression.webhook-url", "", "URL to POST new findings to (disabled if empty)")
+	f.StringVar(&conf.FindingsFile, "regression.findings-file", "", "path to JSON file to persist the findings in (kept in memory only if empty)")
+}
+
+// Enabled reports whether the analyzer must run.
+func (conf *Config) Enabled() bool {
+	return conf.Interval > 0
+}
+
+func (conf *Config) validate() error {
+	if conf.Window <= 0 {
+		return fmt.Errorf("regression window must be positive, got %v", conf.Window)
+	}
+	switch conf.Baseline {
+	case BaselinePreviousDay:
+	case BaselinePreviousVersion:
+		if conf.VersionLabel == "" {
+			return fmt.Errorf("regression baseline %q requires version label", conf.Baseline)
+		}
+		if conf.VersionLookback <= conf.Window {
+			return fmt.Errorf("regression version lookback %v must be longer than window %v", conf.VersionLookback, conf.Window)
+		}
+	default:
+		return fmt.Errorf("unknown regression baseline %q", conf.Baseline)
+	}
+	if conf.MinShareGrowth <= 0 || conf.MinShareGrowth >= 1 {
+		return fmt.Errorf("regression min share growth must be in (0, 1), got %v", conf.MinShareGrowth)
+	}
+	if conf.MinGrowthRatio < 1 {
+		return fmt.Errorf("regression min growth ratio must be at least 1, got %v", conf.MinGrowthRatio)
+	}
+	return nil
+}
+
+func (conf *Config) profileTypes() ([]profile.ProfileType, error) {
+	var ptypes []profile.ProfileType
+	for _, s := range splitList(conf.Types) {
+		var ptype profile.ProfileType
+		ptype.FromString(s)
+		if ptype == profile.TypeUnknown || ptype == profile.TypeTrace {
+			return nil, fmt.Errorf("unsupported regression profile type %q", s)
+		}
+		ptypes = append(ptypes, ptype)
+	}
+	if len(ptypes) == 0 {
+		return nil, fmt.Errorf("no regression profile types")
+	}
+	return ptypes, nil
+}
+
+func (conf *Config) tenants() ([]string, error) {
+	tenants := splitList(conf.Tenants)
+	if len(tenants) == 0 {
+		return []string{tenant.Default}, nil
+	}
+	for _, name := range tenants {
+		if err := tenant.ValidateName(name); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+func splitList(s string) (list []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,66 @@
+package regression
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+const APIRegressionsPath = "/api/0/regressions"
+
+// the same format the timestamps are passed to profiles API with
+const timeFormat = "2006-01-02T15:04:05"
+
+// Handler serves the findings of the tenant, e.g. "GET /api/0/regressions?service=<service>&type=<type>&from=<last_seen_from>".
+type Handler struct {
+	logger *log.Logger
+	store  *Store
+}
+
+func NewHandler(logger *log.Logger, store *Store) *Handler {
+	return &Handler{
+		logger: logger,
+		store:  store,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.URL.Path != APIRegressionsPath {
+		err = profefe.ErrNotFound
+	} else if r.Method != http.MethodGet {
+		err = profefe.StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+	} else {
+		err = h.HandleListRegressions(w, r)
+	}
+	profefe.HandleErrorHTTP(h.logger, err, w, r)
+}
+
+func (h *Handler) HandleListRegressions(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+
+	service, ptype := q.Get("service"), q.Get("type")
+
+	var from time.Time
+	if v := q.Get("from"); v != "" {
+		tm, err := time.Parse(timeFormat, v)
+		if err != nil {
+			return profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: bad \"from\" %q: %s", v, err), nil)
+		}
+		from = tm
+	}
+
+	findings := h.store.Findings(tenant.FromContext(r.Context()), func(f *Finding) bool {
+		return (service == "" || f.Service == service) &&
+			(ptype == "" || f.Type == ptype) &&
+			!f.LastSeenAt.Before(from)
+	})
+
+	profefe.ReplyJSON(w, findings)
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package regression
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	st, err := NewStore("")
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err = st.Add(now, []*Finding{
+		{Service: "svc1", Type: "cpu", Function: "main.a", LastSeenAt: now},
+		{Service: "svc1", Type: "heap", Function: "main.b", LastSeenAt: now.Add(-2 * time.Hour)},
+		{Service: "svc2", Type: "cpu", Function: "main.c", LastSeenAt: now},
+		{Tenant: "t1", Service: "svc1", Type: "cpu", Function: "main.d", LastSeenAt: now},
+	})
+	require.NoError(t, err)
+
+	h := NewHandler(log.New(zaptest.NewLogger(t)), st)
+
+	cases := []struct {
+		tenant    string
+		query     string
+		wantFuncs []string
+	}{
+		{"", "", []string{"main.a", "main.c", "main.b"}},
+		{"", "service=svc1", []string{"main.a", "main.b"}},
+		{"", "service=svc1&type=heap", []string{"main.b"}},
+		{"", "from=2020-01-01T11:00:00", []string{"main.a", "main.c"}},
+		{"t1", "", []string{"main.d"}},
+		{"t2", "", []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.tenant+"?"+tc.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, APIRegressionsPath+"?"+tc.query, nil)
+			req = req.WithContext(tenant.ContextWithTenant(req.Context(), tc.tenant))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+			var body struct {
+				Body []*Finding `json:"body"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+			funcs := []string{}
+			for _, f := range body.Body {
+				funcs = append(funcs, f.Function)
+			}
+			assert.Equal(t, tc.wantFuncs, funcs)
+		})
+	}
+
+	t.Run("bad from", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, APIRegressionsPath+"?from=yesterday", nil)
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+}
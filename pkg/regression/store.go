@@ -0,0 +1,191 @@
+package regression
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// the findings, that weren't seen for this long, are forgotten
+	findingsTTL = 7 * 24 * time.Hour
+	// the maximum number of findings kept; the oldest ones are forgotten first
+	maxFindings = 1000
+)
+
+// Finding is a function, which share of a profile grew in the recent window, compared with the baseline.
+type Finding struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Service  string `json:"service"`
+	Type     string `json:"type"`
+	Function string `json:"function"`
+	// the baseline the recent profiles were compared with, e.g. "previous-day" or "version=1.2.3"
+	Baseline string `json:"baseline"`
+	// the version of the service in the recent window, for previous-version baseline
+	Version string `json:"version,omitempty"`
+
+	BaselineShare float64 `json:"baseline_share"`
+	RecentShare   float64 `json:"recent_share"`
+
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+func (f *Finding) key() findingKey {
+	return findingKey{
+		tenant:   f.Tenant,
+		service:  f.Service,
+		ptype:    f.Type,
+		function: f.Function,
+		baseline: f.Baseline,
+		version:  f.Version,
+	}
+}
+
+type findingKey struct {
+	tenant, service, ptype, function, baseline, version string
+}
+
+// Store keeps the findings in memory, optionally persisting them to a file.
+type Store struct {
+	fileName string
+
+	mu       sync.Mutex
+	findings map[findingKey]*Finding
+}
+
+// NewStore creates the store, loading the findings from the file. The file isn't used, if the file name is empty.
+func NewStore(fileName string) (*Store, error) {
+	st := &Store{
+		fileName: fileName,
+		findings: make(map[findingKey]*Finding),
+	}
+	if fileName == "" {
+		return st, nil
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var findings []*Finding
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, err
+	}
+	for _, f := range findings {
+		st.findings[f.key()] = f
+	}
+	return st, nil
+}
+
+// Add adds the findings to the store. The findings, that are already known, are updated. It returns
+// the findings that weren't known.
+func (st *Store) Add(now time.Time, findings []*Finding) (added []*Finding, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, f := range findings {
+		if known, ok := st.findings[f.key()]; ok {
+			known.BaselineShare = f.BaselineShare
+			known.RecentShare = f.RecentShare
+			known.LastSeenAt = f.LastSeenAt
+			continue
+		}
+		st.findings[f.key()] = f
+		added = append(added, f)
+	}
+
+	st.pruneLocked(now)
+
+	return added, st.persistLocked()
+}
+
+// Findings returns the findings of the tenant, that match the filter, the most recently seen first.
+func (st *Store) Findings(tenant string, match func(f *Finding) bool) []*Finding {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	findings := make([]*Finding, 0)
+	for _, f := range st.findings {
+		if f.Tenant != tenant || (match != nil && !match(f)) {
+			continue
+		}
+		ff := *f
+		findings = append(findings, &ff)
+	}
+	sortFindings(findings)
+	return findings
+}
+
+func (st *Store) pruneLocked(now time.Time) {
+	for key, f := range st.findings {
+		if now.Sub(f.LastSeenAt) > findingsTTL {
+			delete(st.findings, key)
+		}
+	}
+
+	if len(st.findings) <= maxFindings {
+		return
+	}
+	findings := make([]*Finding, 0, len(st.findings))
+	for _, f := range st.findings {
+		findings = append(findings, f)
+	}
+	sortFindings(findings)
+	for _, f := range findings[maxFindings:] {
+		delete(st.findings, f.key())
+	}
+}
+
+// writes the findings to the file, replacing it atomically
+func (st *Store) persistLocked() error {
+	if st.fileName == "" {
+		return nil
+	}
+
+	findings := make([]*Finding, 0, len(st.findings))
+	for _, f := range st.findings {
+		findings = append(findings, f)
+	}
+	sortFindings(findings)
+
+	data, err := json.Marshal(findings)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(st.fileName), filepath.Base(st.fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), st.fileName)
+}
+
+func sortFindings(findings []*Finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		fi, fj := findings[i], findings[j]
+		if !fi.LastSeenAt.Equal(fj.LastSeenAt) {
+			return fi.LastSeenAt.After(fj.LastSeenAt)
+		}
+		if fi.Service != fj.Service {
+			return fi.Service < fj.Service
+		}
+		return fi.RecentShare-fi.BaselineShare > fj.RecentShare-fj.BaselineShare
+	})
+}
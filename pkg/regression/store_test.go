@@ -0,0 +1,67 @@
+package regression
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "regression")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	fileName := filepath.Join(dir, "findings.json")
+	st, err := NewStore(fileName)
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	f1 := &Finding{Service: "svc1", Type: "cpu", Function: "main.a", Baseline: BaselinePreviousDay, RecentShare: 0.2, FirstSeenAt: now, LastSeenAt: now}
+	f2 := &Finding{Tenant: "t1", Service: "svc1", Type: "cpu", Function: "main.a", Baseline: BaselinePreviousDay, RecentShare: 0.3, FirstSeenAt: now, LastSeenAt: now}
+
+	added, err := st.Add(now, []*Finding{f1, f2})
+	require.NoError(t, err)
+	assert.Len(t, added, 2)
+
+	f1Again := *f1
+	f1Again.RecentShare = 0.25
+	f1Again.FirstSeenAt = now.Add(time.Hour)
+	f1Again.LastSeenAt = now.Add(time.Hour)
+	added, err = st.Add(now.Add(time.Hour), []*Finding{&f1Again})
+	require.NoError(t, err)
+	assert.Empty(t, added)
+
+	st2, err := NewStore(fileName)
+	require.NoError(t, err)
+
+	findings := st2.Findings("", nil)
+	require.Len(t, findings, 1)
+	assert.Equal(t, 0.25, findings[0].RecentShare)
+	assert.True(t, now.Equal(findings[0].FirstSeenAt))
+	assert.True(t, now.Add(time.Hour).Equal(findings[0].LastSeenAt))
+
+	assert.Len(t, st2.Findings("t1", nil), 1)
+}
+
+func TestStore_prune(t *testing.T) {
+	st, err := NewStore("")
+	require.NoError(t, err)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err = st.Add(now, []*Finding{{Service: "svc1", Function: "main.a", LastSeenAt: now}})
+	require.NoError(t, err)
+
+	_, err = st.Add(now.Add(findingsTTL+time.Second), []*Finding{{Service: "svc1", Function: "main.b", LastSeenAt: now.Add(findingsTTL)}})
+	require.NoError(t, err)
+
+	findings := st.Findings("", nil)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "main.b", findings[0].Function)
+}
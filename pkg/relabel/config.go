@@ -0,0 +1,67 @@
+package relabel
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	ConfigFile string
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "relabel.config-file", "", "path to YAML file with relabel rules applied to stored profiles (reloaded on SIGHUP)")
+}
+
+// FileConfig is the configuration of relabeling, loaded from a YAML file.
+//
+// Example:
+//
+//	relabel_configs:
+//	  # unify the name of the host label
+//	  - source_labels: [hostname]
+//	    regex: (.+)
+//	    target_label: host
+//	  - action: labeldrop
+//	    regex: hostname
+//	  # strip pod hashes from instance
+//	  - source_labels: [instance]
+//	    regex: (.+)-[0-9a-f]{8,10}-[0-9a-z]{5}
+//	    target_label: instance
+//	  # don't store goroutine profiles of the batch jobs
+//	  - source_labels: [__service__, __type__]
+//	    regex: batch-.*;goroutine
+//	    action: drop
+type FileConfig struct {
+	RelabelConfigs []*Rule `yaml:"relabel_configs"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+	for n, r := range conf.RelabelConfigs {
+		if r == nil {
+			return nil, fmt.Errorf("empty relabel config %d", n)
+		}
+	}
+	return conf, nil
+}
@@ -0,0 +1,242 @@
+// Package relabel rewrites the service, the type and the labels of the profiles on ingest,
+// the same way Prometheus relabels the targets and the series.
+package relabel
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+const (
+	// LabelService is the label the rules see and set the profile's service as.
+	LabelService = "__service__"
+	// LabelType is the label the rules see and set the profile's type as.
+	LabelType = "__type__"
+
+	// the labels, which names start with the prefix, are dropped after relabeling
+	reservedLabelPrefix = "__"
+)
+
+type Action string
+
+const (
+	// Replace sets target label to replacement, if regex matches the concatenated source labels.
+	Replace Action = "replace"
+	// Keep drops the profile, if regex doesn't match the concatenated source labels.
+	Keep Action = "keep"
+	// Drop drops the profile, if regex matches the concatenated source labels.
+	Drop Action = "drop"
+	// HashMod sets target label to the modulus of the hash of the concatenated source labels.
+	HashMod Action = "hashmod"
+	// LabelDrop removes the labels, which names match regex.
+	LabelDrop Action = "labeldrop"
+	// LabelKeep removes the labels, which names don't match regex.
+	LabelKeep Action = "labelkeep"
+)
+
+func (a *Action) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := Action(strings.ToLower(s)); act {
+	case Replace, Keep, Drop, HashMod, LabelDrop, LabelKeep:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown relabel action %q", s)
+}
+
+// Regexp is the regular expression, that is anchored on both ends.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+func NewRegexp(s string) (Regexp, error) {
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: re, original: s}, err
+}
+
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	return re.original, nil
+}
+
+func (re Regexp) String() string {
+	return re.original
+}
+
+var defaultRule = Rule{
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+	Action:      Replace,
+}
+
+// Rule is a single relabeling step.
+type Rule struct {
+	// the labels, which values are concatenated with separator and matched against regex
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        Regexp   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	// the label the result of replace and hashmod is written to; regex's capture groups are expanded in it
+	TargetLabel string `yaml:"target_label,omitempty"`
+	// the value of target label; regex's capture groups are expanded in it
+	Replacement string `yaml:"replacement,omitempty"`
+	Action      Action `yaml:"action,omitempty"`
+}
+
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*r = defaultRule
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return r.validate()
+}
+
+func (r *Rule) validate() error {
+	if r.Regex.Regexp == nil {
+		r.Regex = defaultRule.Regex
+	}
+	if r.Action == "" {
+		r.Action = Replace
+	}
+
+	switch r.Action {
+	case Replace:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action %q requires target_label", r.Action)
+		}
+	case HashMod:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action %q requires target_label", r.Action)
+		}
+		if r.Modulus == 0 {
+			return fmt.Errorf("relabel action %q requires non-zero modulus", r.Action)
+		}
+	case LabelDrop, LabelKeep:
+		if len(r.SourceLabels) != 0 || r.TargetLabel != "" {
+			return fmt.Errorf("relabel action %q doesn't support source_labels and target_label", r.Action)
+		}
+	}
+	return nil
+}
+
+// Process applies the rules to the labels in order. It returns nil, if a rule dropped the labels.
+// The input labels aren't modified.
+//
+// Labels may have several values of the same name. The rules only see the first of them, and replace
+// all of them when setting the label.
+func Process(labels profile.Labels, rules ...*Rule) profile.Labels {
+	labels = append(profile.Labels(nil), labels...)
+	for _, r := range rules {
+		var keep bool
+		labels, keep = r.apply(labels)
+		if !keep {
+			return nil
+		}
+	}
+	sort.Stable(labels)
+	return labels
+}
+
+func (r *Rule) apply(labels profile.Labels) (_ profile.Labels, keep bool) {
+	values := make([]string, 0, len(r.SourceLabels))
+	for _, name := range r.SourceLabels {
+		values = append(values, labelValue(labels, name))
+	}
+	val := strings.Join(values, r.Separator)
+
+	switch r.Action {
+	case Drop:
+		if r.Regex.MatchString(val) {
+			return nil, false
+		}
+	case Keep:
+		if !r.Regex.MatchString(val) {
+			return nil, false
+		}
+	case Replace:
+		idx := r.Regex.FindStringSubmatchIndex(val)
+		if idx == nil {
+			break
+		}
+		target := string(r.Regex.ExpandString(nil, r.TargetLabel, val, idx))
+		if target == "" {
+			break
+		}
+		res := string(r.Regex.ExpandString(nil, r.Replacement, val, idx))
+		labels = setLabel(labels, target, res)
+	case HashMod:
+		sum := md5.Sum([]byte(val))
+		mod := binary.BigEndian.Uint64(sum[8:]) % r.Modulus
+		labels = setLabel(labels, r.TargetLabel, strconv.FormatUint(mod, 10))
+	case LabelDrop, LabelKeep:
+		n := 0
+		for _, label := range labels {
+			if r.Regex.MatchString(label.Key) == (r.Action == LabelKeep) {
+				labels[n] = label
+				n++
+			}
+		}
+		labels = labels[:n]
+	default:
+		panic(fmt.Sprintf("relabel: unknown action %q", r.Action))
+	}
+	return labels, true
+}
+
+func labelValue(labels profile.Labels, name string) string {
+	for _, label := range labels {
+		if label.Key == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// replaces all values of the label with the value; the label is removed if the value is empty
+func setLabel(labels profile.Labels, name, value string) profile.Labels {
+	n := 0
+	for _, label := range labels {
+		if label.Key != name {
+			labels[n] = label
+			n++
+		}
+	}
+	labels = labels[:n]
+	if value != "" {
+		labels = append(labels, profile.Label{Key: name, Value: value})
+	}
+	return labels
+}
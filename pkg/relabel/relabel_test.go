@@ -0,0 +1,145 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseLabels(t *testing.T, s string) profile.Labels {
+	var labels profile.Labels
+	require.NoError(t, labels.FromString(s))
+	return labels
+}
+
+func TestProcess(t *testing.T) {
+	cases := map[string]struct {
+		config string
+		input  string
+		want   string
+	}{
+		"replace": {
+			config: `[{source_labels: [hostname], regex: "(.+)", target_label: host}]`,
+			input:  "hostname=h1",
+			want:   "host=h1,hostname=h1",
+		},
+		"replace not matched": {
+			config: `[{source_labels: [hostname], regex: "(.+)", target_label: host}]`,
+			input:  "env=prod",
+			want:   "env=prod",
+		},
+		"replace with several sources": {
+			config: `[{source_labels: [dc, rack], separator: "/", target_label: location}]`,
+			input:  "dc=fra,rack=r1",
+			want:   "dc=fra,location=fra/r1,rack=r1",
+		},
+		"replace with empty value removes label": {
+			config: `[{source_labels: [nope], target_label: env}]`,
+			input:  "env=prod,env=stage,host=h1",
+			want:   "host=h1",
+		},
+		"replace strips pod hash": {
+			config: `[{source_labels: [instance], regex: "(.+)-[0-9a-f]{8,10}-[0-9a-z]{5}", target_label: instance}]`,
+			input:  "instance=api-7c9f8d6b5-x2x4z",
+			want:   "instance=api",
+		},
+		"replace expands target label": {
+			config: `[{source_labels: [tag], regex: "(.+)=(.+)", target_label: "tag_$1", replacement: "$2"}]`,
+			input:  "tag=a%3Db",
+			want:   "tag=a=b,tag_a=b",
+		},
+		"keep": {
+			config: `[{source_labels: [env], regex: prod, action: keep}]`,
+			input:  "env=stage",
+			want:   "",
+		},
+		"drop": {
+			config: `[{source_labels: [env], regex: "stage|dev", action: drop}]`,
+			input:  "env=dev",
+			want:   "",
+		},
+		"drop is anchored": {
+			config: `[{source_labels: [env], regex: "dev", action: drop}]`,
+			input:  "env=devel",
+			want:   "env=devel",
+		},
+		"hashmod": {
+			config: `[{source_labels: [host], modulus: 8, target_label: shard, action: hashmod}]`,
+			input:  "host=h1",
+			want:   "host=h1,shard=0",
+		},
+		"labeldrop": {
+			config: `[{regex: "host(name)?", action: labeldrop}]`,
+			input:  "env=prod,host=h1,hostname=h1",
+			want:   "env=prod",
+		},
+		"labelkeep": {
+			config: `[{regex: "env|host", action: labelkeep}]`,
+			input:  "env=prod,host=h1,hostname=h1",
+			want:   "env=prod,host=h1",
+		},
+		"rules are applied in order": {
+			config: `[{source_labels: [hostname], target_label: host}, {regex: hostname, action: labeldrop}]`,
+			input:  "hostname=h1",
+			want:   "host=h1",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			conf, err := Load([]byte("relabel_configs: " + tc.config))
+			require.NoError(t, err)
+
+			input := mustParseLabels(t, tc.input)
+			inputStr := input.String()
+
+			got := Process(input, conf.RelabelConfigs...)
+			if tc.want == "" {
+				assert.Nil(t, got)
+			} else {
+				assert.Equal(t, tc.want, got.String())
+			}
+			assert.Equal(t, inputStr, input.String(), "input labels must not be modified")
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	conf, err := Load([]byte(`
+relabel_configs:
+  - source_labels: [hostname]
+    target_label: host
+  - action: LabelDrop
+    regex: hostname
+`))
+	require.NoError(t, err)
+	require.Len(t, conf.RelabelConfigs, 2)
+
+	r := conf.RelabelConfigs[0]
+	assert.Equal(t, Replace, r.Action)
+	assert.Equal(t, ";", r.Separator)
+	assert.Equal(t, "(.*)", r.Regex.String())
+	assert.Equal(t, "$1", r.Replacement)
+
+	assert.Equal(t, LabelDrop, conf.RelabelConfigs[1].Action)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown field":                `relabel_configs: [{target_lable: host}]`,
+		"unknown action":               `relabel_configs: [{action: nope}]`,
+		"bad regex":                    `relabel_configs: [{regex: "(", target_label: host}]`,
+		"replace without target":       `relabel_configs: [{source_labels: [host]}]`,
+		"hashmod without modulus":      `relabel_configs: [{action: hashmod, target_label: shard}]`,
+		"labeldrop with source labels": `relabel_configs: [{action: labeldrop, source_labels: [host]}]`,
+		"empty rule":                   `relabel_configs: [null]`,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
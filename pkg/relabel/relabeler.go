@@ -0,0 +1,114 @@
+package relabel
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ErrBadParams is returned when the profile's params, produced by the rules, aren't valid,
+// e.g. the rules removed the service.
+var ErrBadParams = errors.New("bad profile params after relabeling")
+
+// Relabeler applies the relabel rules to the profiles before they are stored.
+// The rules can be replaced at runtime.
+type Relabeler struct {
+	logger *log.Logger
+
+	mu    sync.RWMutex
+	rules []*Rule
+
+	droppedTotal *prometheus.CounterVec
+	reloadStatus prometheus.Gauge
+}
+
+func NewRelabeler(logger *log.Logger, registry prometheus.Registerer) *Relabeler {
+	r := &Relabeler{
+		logger: logger,
+		droppedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "relabel_dropped_profiles_total",
+			Help:      "Number of profiles dropped by relabel rules.",
+		}, []string{"tenant", "service"}),
+		reloadStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "relabel_config_last_reload_successful",
+			Help:      "Whether the last reload of relabel config was successful.",
+		}),
+	}
+	registry.MustRegister(r.droppedTotal, r.reloadStatus)
+	return r
+}
+
+// ApplyConfig replaces the rules with the rules from the config.
+func (r *Relabeler) ApplyConfig(conf *FileConfig) {
+	r.mu.Lock()
+	r.rules = conf.RelabelConfigs
+	r.mu.Unlock()
+
+	r.logger.Infow("relabel rules applied", "rules", len(conf.RelabelConfigs))
+}
+
+// LoadFile loads the config from the file and applies it. The current rules are kept, if the config
+// couldn't be loaded.
+func (r *Relabeler) LoadFile(fileName string) error {
+	conf, err := LoadFile(fileName)
+	if err != nil {
+		r.reloadStatus.Set(0)
+		return err
+	}
+	r.ApplyConfig(conf)
+	r.reloadStatus.Set(1)
+	return nil
+}
+
+// Relabel applies the rules to the service, the type and the labels of the profile, updating the params
+// in place. It returns false, if the profile must be dropped.
+func (r *Relabeler) Relabel(params *storage.WriteProfileParams) (keep bool, err error) {
+	r.mu.RLock()
+	rules := r.rules
+	r.mu.RUnlock()
+
+	if len(rules) == 0 {
+		return true, nil
+	}
+
+	labels := make(profile.Labels, 0, len(params.Labels)+2)
+	labels = append(labels, params.Labels...)
+	labels = append(labels,
+		profile.Label{Key: LabelService, Value: params.Service},
+		profile.Label{Key: LabelType, Value: params.Type.String()},
+	)
+
+	labels = Process(labels, rules...)
+	if labels == nil {
+		r.logger.Debugw("profile dropped by relabel rules", "tenant", params.Tenant, "service", params.Service, "type", params.Type)
+		r.droppedTotal.WithLabelValues(params.Tenant, params.Service).Inc()
+		return false, nil
+	}
+
+	newParams := *params
+	newParams.Service = labelValue(labels, LabelService)
+	newParams.Type.FromString(labelValue(labels, LabelType))
+	newParams.Labels = nil
+	for _, label := range labels {
+		if !strings.HasPrefix(label.Key, reservedLabelPrefix) {
+			newParams.Labels = append(newParams.Labels, label)
+		}
+	}
+
+	if err := newParams.Validate(); err != nil {
+		r.logger.Debugw("relabel rules produced bad params", "tenant", params.Tenant, "service", params.Service, zap.Error(err))
+		return false, fmt.Errorf("%w: %v", ErrBadParams, err)
+	}
+
+	*params = newParams
+	return true, nil
+}
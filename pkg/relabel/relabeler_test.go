@@ -0,0 +1,124 @@
+package relabel
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestRelabeler(t *testing.T, config string) *Relabeler {
+	r := NewRelabeler(log.New(zaptest.NewLogger(t)), prometheus.NewRegistry())
+	conf, err := Load([]byte(config))
+	require.NoError(t, err)
+	r.ApplyConfig(conf)
+	return r
+}
+
+func TestRelabeler_Relabel(t *testing.T) {
+	r := newTestRelabeler(t, `
+relabel_configs:
+  - source_labels: [__service__]
+    regex: (.+)-canary
+    target_label: __service__
+  - source_labels: [__service__, __type__]
+    regex: batch-.*;goroutine
+    action: drop
+  - source_labels: [__type__]
+    target_label: __meta_type
+  - source_labels: [__meta_type]
+    target_label: ptype
+`)
+
+	params := &storage.WriteProfileParams{
+		Tenant:  "t1",
+		Service: "api-canary",
+		Type:    profile.TypeCPU,
+		Labels:  profile.Labels{{Key: "host", Value: "h1"}},
+	}
+	keep, err := r.Relabel(params)
+	require.NoError(t, err)
+	require.True(t, keep)
+
+	assert.Equal(t, "t1", params.Tenant)
+	assert.Equal(t, "api", params.Service)
+	assert.Equal(t, profile.TypeCPU, params.Type)
+	assert.Equal(t, "host=h1,ptype=cpu", params.Labels.String())
+
+	params = &storage.WriteProfileParams{
+		Tenant:  "t1",
+		Service: "batch-1",
+		Type:    profile.TypeGoroutine,
+	}
+	keep, err = r.Relabel(params)
+	require.NoError(t, err)
+	require.False(t, keep)
+	assert.Equal(t, "batch-1", params.Service)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.droppedTotal.WithLabelValues("t1", "batch-1")))
+}
+
+func TestRelabeler_Relabel_badParams(t *testing.T) {
+	cases := map[string]string{
+		"empty service": `relabel_configs: [{source_labels: [nope], target_label: __service__}]`,
+		"bad type":      `relabel_configs: [{target_label: __type__, replacement: nope}]`,
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := newTestRelabeler(t, config)
+
+			params := &storage.WriteProfileParams{Service: "svc1", Type: profile.TypeCPU}
+			_, err := r.Relabel(params)
+			assert.True(t, errors.Is(err, ErrBadParams), "want bad params error, got %v", err)
+			assert.Equal(t, "svc1", params.Service, "params must not be modified")
+		})
+	}
+}
+
+func TestRelabeler_LoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relabel")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	fileName := filepath.Join(dir, "relabel.yml")
+	writeConfig := func(config string) {
+		require.NoError(t, ioutil.WriteFile(fileName, []byte(config), 0644))
+	}
+
+	r := NewRelabeler(log.New(zaptest.NewLogger(t)), prometheus.NewRegistry())
+
+	writeConfig(`relabel_configs: [{target_label: env, replacement: prod}]`)
+	require.NoError(t, r.LoadFile(fileName))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.reloadStatus))
+
+	relabel := func() string {
+		params := &storage.WriteProfileParams{Service: "svc1", Type: profile.TypeCPU}
+		keep, err := r.Relabel(params)
+		require.NoError(t, err)
+		require.True(t, keep)
+		return params.Labels.String()
+	}
+	assert.Equal(t, "env=prod", relabel())
+
+	// broken config keeps the current rules
+	writeConfig(`relabel_configs: [{action: nope}]`)
+	require.Error(t, r.LoadFile(fileName))
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.reloadStatus))
+	assert.Equal(t, "env=prod", relabel())
+
+	writeConfig(`relabel_configs: [{target_label: env, replacement: stage}]`)
+	require.NoError(t, r.LoadFile(fileName))
+	assert.Equal(t, "env=stage", relabel())
+}
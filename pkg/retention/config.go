@@ -0,0 +1,101 @@
+package retention
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultInterval  = time.Hour
+	defaultBatchSize = 1000
+)
+
+type Config struct {
+	ConfigFile string
+	Interval   time.Duration
+	BatchSize  int
+	DryRun     bool
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "retention.config-file", "", "path to YAML file with retention policies (reloaded on SIGHUP, retention is disabled if empty)")
+	f.DurationVar(&conf.Interval, "retention.interval", defaultInterval, "how often to sweep the profiles that outlived their retention")
+	f.IntVar(&conf.BatchSize, "retention.batch-size", defaultBatchSize, "the number of profiles looked up and deleted at once")
+	f.BoolVar(&conf.DryRun, "retention.dry-run", false, "only report the expired profiles without deleting them")
+}
+
+// Enabled reports whether the profiles must be swept.
+func (conf *Config) Enabled() bool {
+	return conf.ConfigFile != ""
+}
+
+func (conf *Config) validate() error {
+	if conf.Interval <= 0 {
+		return fmt.Errorf("retention interval must be positive, got %v", conf.Interval)
+	}
+	if conf.BatchSize <= 0 {
+		return fmt.Errorf("retention batch size must be positive, got %d", conf.BatchSize)
+	}
+	return nil
+}
+
+// FileConfig is the configuration of retention, loaded from a YAML file. The retention of a profile
+// is the retention of the first policy the profile matches. The profiles, that don't match any policy,
+// are kept for the retention of their tenant, set in the tenants config, or for the default retention.
+// Zero retention means the profiles are kept forever.
+//
+// Example:
+//
+//	default: 720h
+//	policies:
+//	  # profiles of dev environments are kept for 2 days
+//	  - labels:
+//	      env: dev
+//	    retention: 48h
+//	  # profiles of the default tenant's api services are kept forever
+//	  - tenant: ""
+//	    service: api-.*
+//	    retention: 0
+//	  - type: cpu
+//	    retention: 720h
+//	  - type: goroutine
+//	    retention: 168h
+type FileConfig struct {
+	Default  time.Duration `yaml:"default,omitempty"`
+	Policies []*Policy     `yaml:"policies,omitempty"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+
+	if conf.Default < 0 {
+		return nil, fmt.Errorf("negative default retention %v", conf.Default)
+	}
+	for n, p := range conf.Policies {
+		if p == nil {
+			return nil, fmt.Errorf("empty retention policy %d", n)
+		}
+	}
+	return conf, nil
+}
@@ -0,0 +1,120 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	conf, err := Load([]byte(`
+default: 720h
+policies:
+  - labels: {env: dev}
+    retention: 48h
+  - tenant: team-a
+    service: api-.*
+    type: cpu
+    retention: 0
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, conf.Default)
+	require.Len(t, conf.Policies, 2)
+
+	p := conf.Policies[0]
+	assert.Nil(t, p.Tenant)
+	assert.Equal(t, profile.Labels{{Key: "env", Value: "dev"}}, p.labels)
+	assert.Equal(t, 48*time.Hour, p.Retention)
+
+	p = conf.Policies[1]
+	require.NotNil(t, p.Tenant)
+	assert.Equal(t, "team-a", *p.Tenant)
+	assert.Equal(t, profile.TypeCPU, p.ptype)
+	assert.Equal(t, time.Duration(0), p.Retention)
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"negative default":   "default: -1h",
+		"negative retention": "policies: [{type: cpu, retention: -1h}]",
+		"bad type":           "policies: [{type: foo, retention: 1h}]",
+		"bad tenant":         "policies: [{tenant: 'a/b', retention: 1h}]",
+		"bad service":        "policies: [{service: '(', retention: 1h}]",
+		"unknown field":      "policies: [{retention: 1h, foo: bar}]",
+		"empty policy":       "policies: [~]",
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestFileConfig_retention(t *testing.T) {
+	conf, err := Load([]byte(`
+default: 720h
+policies:
+  - labels: {env: dev}
+    retention: 48h
+  - tenant: ""
+    service: api-.*
+    retention: 0
+  - type: cpu
+    retention: 240h
+`))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name            string
+		tenant          string
+		meta            profile.Meta
+		tenantRetention time.Duration
+		want            time.Duration
+	}{
+		{
+			"first matching policy",
+			"", profile.Meta{Service: "api-1", Type: profile.TypeCPU, Labels: profile.Labels{{Key: "env", Value: "dev"}, {Key: "host", Value: "h1"}}}, 0,
+			48 * time.Hour,
+		},
+		{
+			"kept forever",
+			"", profile.Meta{Service: "api-1", Type: profile.TypeCPU}, 0,
+			0,
+		},
+		{
+			"policy of other tenant",
+			"team-a", profile.Meta{Service: "api-1", Type: profile.TypeCPU}, 0,
+			240 * time.Hour,
+		},
+		{
+			"default",
+			"team-a", profile.Meta{Service: "api-1", Type: profile.TypeHeap}, 0,
+			720 * time.Hour,
+		},
+		{
+			"tenant's retention",
+			"team-a", profile.Meta{Service: "api-1", Type: profile.TypeHeap}, 24 * time.Hour,
+			24 * time.Hour,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, conf.retention(tc.tenant, tc.meta, tc.tenantRetention))
+		})
+	}
+
+	assert.Equal(t, 48*time.Hour, conf.minRetention("", "api-1", 0))
+	assert.Equal(t, 24*time.Hour, conf.minRetention("", "api-1", 24*time.Hour))
+}
+
+func TestFileConfig_minRetention_keptForever(t *testing.T) {
+	conf, err := Load([]byte(`policies: [{service: api-.*, retention: 48h}]`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 48*time.Hour, conf.minRetention("", "api-1", 0))
+	assert.Equal(t, time.Duration(0), conf.minRetention("", "worker", 0))
+}
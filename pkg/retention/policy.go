@@ -0,0 +1,104 @@
+package retention
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/relabel"
+	"github.com/profefe/profefe/pkg/tenant"
+)
+
+// Policy sets the retention of the profiles, that match all of the policy's selectors.
+// An empty selector matches any profile.
+type Policy struct {
+	// the tenant of the profiles; the policy applies to the profiles of every tenant if unset,
+	// and only to the profiles of the default tenant if empty
+	Tenant *string `yaml:"tenant,omitempty"`
+	// the regular expression, that matches the whole name of the service
+	Service relabel.Regexp `yaml:"service,omitempty"`
+	Type    string         `yaml:"type,omitempty"`
+	// the labels the profile must have
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// how long to keep the profiles; zero means the profiles are kept forever
+	Retention time.Duration `yaml:"retention"`
+
+	ptype  profile.ProfileType
+	labels profile.Labels
+}
+
+func (p *Policy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Policy
+	if err := unmarshal((*plain)(p)); err != nil {
+		return err
+	}
+	return p.validate()
+}
+
+func (p *Policy) validate() error {
+	if p.Tenant != nil {
+		if err := tenant.ValidateName(*p.Tenant); err != nil {
+			return fmt.Errorf("retention policy: %w", err)
+		}
+	}
+	if p.Type != "" {
+		if err := p.ptype.FromString(p.Type); err != nil || p.ptype == profile.TypeUnknown {
+			return fmt.Errorf("retention policy: unknown profile type %q", p.Type)
+		}
+	}
+	if p.Retention < 0 {
+		return fmt.Errorf("retention policy: negative retention %v", p.Retention)
+	}
+	p.labels = p.labels[:0]
+	for k, v := range p.Labels {
+		p.labels = append(p.labels, profile.Label{Key: k, Value: v})
+	}
+	return nil
+}
+
+// returns whether the policy may apply to some of the profiles of the tenant's service
+func (p *Policy) matchService(tenant, service string) bool {
+	if p.Tenant != nil && *p.Tenant != tenant {
+		return false
+	}
+	return p.Service.Regexp == nil || p.Service.MatchString(service)
+}
+
+func (p *Policy) match(tenant string, meta profile.Meta) bool {
+	if !p.matchService(tenant, meta.Service) {
+		return false
+	}
+	if p.ptype != profile.TypeUnknown && p.ptype != meta.Type {
+		return false
+	}
+	return meta.Labels.Include(p.labels)
+}
+
+// returns the retention of the tenant's profile; tenantRetention is used if no policy matches the profile
+func (conf *FileConfig) retention(tenant string, meta profile.Meta, tenantRetention time.Duration) time.Duration {
+	for _, p := range conf.Policies {
+		if p.match(tenant, meta) {
+			return p.Retention
+		}
+	}
+	return conf.fallback(tenantRetention)
+}
+
+func (conf *FileConfig) fallback(tenantRetention time.Duration) time.Duration {
+	if tenantRetention > 0 {
+		return tenantRetention
+	}
+	return conf.Default
+}
+
+// returns the shortest non-zero retention, that may apply to the profiles of the tenant's service,
+// or zero if all the profiles are kept forever
+func (conf *FileConfig) minRetention(tenant, service string, tenantRetention time.Duration) time.Duration {
+	min := conf.fallback(tenantRetention)
+	for _, p := range conf.Policies {
+		if p.Retention > 0 && p.matchService(tenant, service) && (min == 0 || p.Retention < min) {
+			min = p.Retention
+		}
+	}
+	return min
+}
@@ -0,0 +1,253 @@
+// Package retention implements the retention policies, that periodically sweep the profiles, which outlived
+// their retention, from the storages.
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Storage is the storage the profiles are swept from.
+type Storage interface {
+	storage.Reader
+	storage.Deleter
+}
+
+// the profiles are looked up type by type, as object storages list the profiles of a service
+// ordered by the type first
+var sweptTypes = []profile.ProfileType{
+	profile.TypeCPU,
+	profile.TypeHeap,
+	profile.TypeBlock,
+	profile.TypeMutex,
+	profile.TypeGoroutine,
+	profile.TypeThreadcreate,
+	profile.TypeOther,
+	profile.TypeTrace,
+}
+
+// Sweeper periodically deletes the profiles, that outlived their retention, from the storages.
+// The policies can be replaced at runtime.
+type Sweeper struct {
+	logger   *log.Logger
+	conf     Config
+	storages map[string]Storage
+	// the tenants' policies, that set the retention of the profiles no retention policy matches
+	tenants *tenant.Policies
+
+	mu       sync.Mutex
+	fileConf *FileConfig
+
+	deletedTotal       *prometheus.CounterVec
+	sweepFailuresTotal prometheus.Counter
+	reloadStatus       prometheus.Gauge
+
+	now func() time.Time
+}
+
+// NewSweeper creates the sweeper of the storages, keyed by the storage type.
+func NewSweeper(logger *log.Logger, conf Config, storages map[string]Storage, tenants *tenant.Policies, registry prometheus.Registerer) (*Sweeper, error) {
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	if tenants == nil {
+		tenants = &tenant.Policies{}
+	}
+
+	s := &Sweeper{
+		logger:   logger,
+		conf:     conf,
+		storages: storages,
+		tenants:  tenants,
+		fileConf: &FileConfig{},
+		deletedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "retention_deleted_profiles_total",
+			Help:      "Number of profiles deleted by retention policies.",
+		}, []string{"storage", "tenant", "service", "type"}),
+		sweepFailuresTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "retention_sweep_failures_total",
+			Help:      "Number of failed sweeps of services' profiles.",
+		}),
+		reloadStatus: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "retention_config_last_reload_successful",
+			Help:      "Whether the last reload of retention config was successful.",
+		}),
+		now: time.Now,
+	}
+	registry.MustRegister(s.deletedTotal, s.sweepFailuresTotal, s.reloadStatus)
+	return s, nil
+}
+
+// ApplyConfig replaces the policies with the policies from the config.
+func (s *Sweeper) ApplyConfig(conf *FileConfig) {
+	s.mu.Lock()
+	s.fileConf = conf
+	s.mu.Unlock()
+
+	s.logger.Infow("retention policies applied", "policies", len(conf.Policies), "default", conf.Default)
+}
+
+// LoadFile loads the config from the file and applies it. The current policies are kept, if the config
+// couldn't be loaded.
+func (s *Sweeper) LoadFile(fileName string) error {
+	conf, err := LoadFile(fileName)
+	if err != nil {
+		s.reloadStatus.Set(0)
+		return err
+	}
+	s.ApplyConfig(conf)
+	s.reloadStatus.Set(1)
+	return nil
+}
+
+// Run sweeps the storages right away and then every interval, until the context is done.
+func (s *Sweeper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sweeps the profiles of every service of every stored tenant from the storages
+func (s *Sweeper) sweep(ctx context.Context) {
+	s.mu.Lock()
+	fileConf := s.fileConf
+	s.mu.Unlock()
+
+	start := s.now()
+
+	var total int
+	for name, st := range s.storages {
+		// the tenants are listed from the storage, as the profiles of any tenant may be written
+		tenants, err := st.ListTenants(ctx)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			s.logger.Errorw("could not list tenants", "storage", name, zap.Error(err))
+			s.sweepFailuresTotal.Inc()
+			continue
+		}
+
+		for _, tn := range tenants {
+			services, err := st.ListServices(ctx, tn)
+			if err == storage.ErrNotFound {
+				continue
+			} else if err != nil {
+				s.logger.Errorw("could not list services", "storage", name, "tenant", tn, zap.Error(err))
+				s.sweepFailuresTotal.Inc()
+				continue
+			}
+
+			for _, service := range services {
+				n, err := s.sweepService(ctx, fileConf, name, st, tn, service, start)
+				total += n
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					s.logger.Errorw("could not sweep profiles", "storage", name, "tenant", tn, "service", service, zap.Error(err))
+					s.sweepFailuresTotal.Inc()
+				}
+			}
+		}
+	}
+
+	if !s.conf.DryRun {
+		s.collectGarbage(ctx)
+	}
+
+	s.logger.Infow("retention sweep done", "deleted", total, "dry_run", s.conf.DryRun, "took", s.now().Sub(start))
+}
+
+// deletes the data, shared by the deleted profiles, from the storages, that keep it separately from the profiles
+func (s *Sweeper) collectGarbage(ctx context.Context) {
+	for name, st := range s.storages {
+		gc, ok := st.(storage.GarbageCollector)
+		if !ok {
+			continue
+		}
+		if err := gc.CollectGarbage(ctx); err != nil {
+			s.logger.Errorw("could not collect garbage", "storage", name, zap.Error(err))
+			s.sweepFailuresTotal.Inc()
+		}
+	}
+}
+
+// deletes the expired profiles of the service; returns the number of deleted profiles
+func (s *Sweeper) sweepService(ctx context.Context, fileConf *FileConfig, name string, st Storage, tn, service string, now time.Time) (total int, err error) {
+	tenantRetention := s.tenants.Retention(tn)
+
+	minRetention := fileConf.minRetention(tn, service, tenantRetention)
+	if minRetention == 0 {
+		// the service's profiles are kept forever
+		return 0, nil
+	}
+
+	for _, ptype := range sweptTypes {
+		params := &storage.FindProfilesParams{
+			Tenant:       tn,
+			Service:      service,
+			Type:         ptype,
+			CreatedAtMin: time.Unix(0, 0).UTC(),
+			CreatedAtMax: now.Add(-minRetention),
+			Limit:        s.conf.BatchSize,
+		}
+		n, err := s.sweepProfiles(ctx, fileConf, st, params, tenantRetention, now)
+		total += n
+		if n > 0 {
+			s.logger.Infow("deleted expired profiles", "storage", name, "tenant", tn, "service", service, "type", ptype, "deleted", n, "dry_run", s.conf.DryRun)
+			if !s.conf.DryRun {
+				s.deletedTotal.WithLabelValues(name, tn, service, ptype.String()).Add(float64(n))
+			}
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// deletes the expired profiles, found by the params; the profiles are looked up and deleted batch by batch
+func (s *Sweeper) sweepProfiles(ctx context.Context, fileConf *FileConfig, st Storage, params *storage.FindProfilesParams, tenantRetention time.Duration, now time.Time) (total int, err error) {
+	err = storage.WalkProfiles(ctx, st, params, func(metas []profile.Meta) error {
+		var pids []profile.ID
+		for _, meta := range metas {
+			retention := fileConf.retention(params.Tenant, meta, tenantRetention)
+			if retention > 0 && meta.CreatedAt.Before(now.Add(-retention)) {
+				pids = append(pids, meta.ProfileID)
+			}
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+
+		if !s.conf.DryRun {
+			if err := st.DeleteProfiles(ctx, params.Tenant, pids); err != nil {
+				return err
+			}
+		}
+		total += len(pids)
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,290 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/tenant"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// stores the profiles' metas, keyed by the tenant, and finds the newest of them first, the way badger does
+type testStorage struct {
+	*storage.StubReader
+	*storage.StubDeleter
+
+	metas map[string][]profile.Meta
+}
+
+func newTestStorage() *testStorage {
+	st := &testStorage{
+		metas: make(map[string][]profile.Meta),
+	}
+	st.StubReader = &storage.StubReader{
+		ListTenantsFunc: func(ctx context.Context) ([]string, error) {
+			var tenants []string
+			for tn, metas := range st.metas {
+				if len(metas) > 0 {
+					tenants = append(tenants, tn)
+				}
+			}
+			if len(tenants) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			sort.Strings(tenants)
+			return tenants, nil
+		},
+		ListServicesFunc: func(ctx context.Context, tn string) ([]string, error) {
+			seen := make(map[string]bool)
+			var services []string
+			for _, meta := range st.metas[tn] {
+				if !seen[meta.Service] {
+					seen[meta.Service] = true
+					services = append(services, meta.Service)
+				}
+			}
+			if len(services) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			return services, nil
+		},
+		FindProfilesFunc: func(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+			var metas []profile.Meta
+			for _, meta := range st.metas[params.Tenant] {
+				if meta.Service != params.Service || meta.Type != params.Type {
+					continue
+				}
+				if meta.CreatedAt.Before(params.CreatedAtMin) || !meta.CreatedAt.Before(params.CreatedAtMax) {
+					continue
+				}
+				metas = append(metas, meta)
+			}
+			sort.SliceStable(metas, func(i, j int) bool {
+				return metas[i].CreatedAt.After(metas[j].CreatedAt)
+			})
+			if len(metas) > params.Limit {
+				metas = metas[:params.Limit]
+			}
+			if len(metas) == 0 {
+				return nil, storage.ErrNotFound
+			}
+			return metas, nil
+		},
+	}
+	st.StubDeleter = &storage.StubDeleter{
+		DeleteProfilesFunc: func(ctx context.Context, tn string, pids []profile.ID) error {
+			deleted := make(map[profile.ID]bool, len(pids))
+			for _, pid := range pids {
+				deleted[pid] = true
+			}
+			metas := st.metas[tn][:0]
+			for _, meta := range st.metas[tn] {
+				if !deleted[meta.ProfileID] {
+					metas = append(metas, meta)
+				}
+			}
+			st.metas[tn] = metas
+			return nil
+		},
+	}
+	return st
+}
+
+func (st *testStorage) add(tn, service string, ptype profile.ProfileType, labels profile.Labels, createdAt time.Time) {
+	st.metas[tn] = append(st.metas[tn], profile.Meta{
+		ProfileID: profile.ID(fmt.Sprintf("%s-%d", service, len(st.metas[tn]))),
+		Tenant:    tn,
+		Service:   service,
+		Type:      ptype,
+		Labels:    labels,
+		CreatedAt: createdAt,
+	})
+}
+
+// returns the ages of the profiles of the tenant's service in days
+func (st *testStorage) ages(tn, service string, now time.Time) (ages []int) {
+	for _, meta := range st.metas[tn] {
+		if meta.Service == service {
+			ages = append(ages, int(now.Sub(meta.CreatedAt)/(24*time.Hour)))
+		}
+	}
+	sort.Ints(ages)
+	return ages
+}
+
+func newTestSweeper(t *testing.T, conf Config, st Storage, tenants *tenant.Policies, policies string) *Sweeper {
+	s, err := NewSweeper(log.New(zaptest.NewLogger(t)), conf, map[string]Storage{"test": st}, tenants, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	fileConf, err := Load([]byte(policies))
+	require.NoError(t, err)
+	s.ApplyConfig(fileConf)
+
+	return s
+}
+
+func TestSweeper_sweep(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	st := newTestStorage()
+	for age := 0; age < 10; age++ {
+		createdAt := now.Add(-time.Duration(age)*day - time.Hour)
+		st.add("", "svc1", profile.TypeCPU, nil, createdAt)
+		st.add("", "svc1", profile.TypeHeap, profile.Labels{{Key: "env", Value: "dev"}}, createdAt)
+		st.add("", "svc2", profile.TypeCPU, nil, createdAt)
+		st.add("team-a", "svc1", profile.TypeHeap, nil, createdAt)
+		// the tenant, that no config names
+		st.add("team-b", "svc1", profile.TypeCPU, nil, createdAt)
+	}
+
+	tenants := &tenant.Policies{
+		Tenants: map[string]tenant.Policy{"team-a": {Retention: 5 * day}},
+	}
+	// the small batches make the sweeper page through the profiles
+	conf := Config{Interval: time.Hour, BatchSize: 3}
+	s := newTestSweeper(t, conf, st, tenants, `
+default: 168h
+policies:
+  - labels: {env: dev}
+    retention: 48h
+  - tenant: ""
+    service: svc2
+    retention: 0
+`)
+	s.now = func() time.Time { return now }
+
+	s.sweep(context.Background())
+
+	assert.Equal(t, []int{0, 0, 1, 1, 2, 3, 4, 5, 6}, st.ages("", "svc1", now))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, st.ages("", "svc2", now))
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, st.ages("team-a", "svc1", now))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6}, st.ages("team-b", "svc1", now))
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(s.deletedTotal.WithLabelValues("test", "", "svc1", "cpu")))
+	assert.Equal(t, float64(8), testutil.ToFloat64(s.deletedTotal.WithLabelValues("test", "", "svc1", "heap")))
+	assert.Equal(t, float64(5), testutil.ToFloat64(s.deletedTotal.WithLabelValues("test", "team-a", "svc1", "heap")))
+}
+
+func TestSweeper_sweep_dryRun(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	st := newTestStorage()
+	st.add("", "svc1", profile.TypeCPU, nil, now.Add(-48*time.Hour))
+	st.StubDeleter.DeleteProfilesFunc = func(ctx context.Context, tn string, pids []profile.ID) error {
+		t.Fatalf("profiles deleted in dry run: %v", pids)
+		return nil
+	}
+
+	conf := Config{Interval: time.Hour, BatchSize: 10, DryRun: true}
+	s := newTestSweeper(t, conf, st, nil, `default: 24h`)
+	s.now = func() time.Time { return now }
+
+	s.sweep(context.Background())
+
+	assert.Len(t, st.metas[""], 1)
+}
+
+func TestSweeper_sweep_sameCreatedAt(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := now.Add(-48 * time.Hour)
+
+	st := newTestStorage()
+	for i := 0; i < 5; i++ {
+		st.add("", "svc1", profile.TypeCPU, profile.Labels{{Key: "n", Value: fmt.Sprint(i % 2)}}, createdAt)
+	}
+
+	var deleted [][]profile.ID
+	st.StubDeleter.DeleteProfilesFunc = func(ctx context.Context, tn string, pids []profile.ID) error {
+		// the deletion isn't visible right away
+		deleted = append(deleted, pids)
+		return nil
+	}
+
+	conf := Config{Interval: time.Hour, BatchSize: 2}
+	s := newTestSweeper(t, conf, st, nil, `
+default: 24h
+policies:
+  - labels: {n: "1"}
+    retention: 0
+`)
+	s.now = func() time.Time { return now }
+
+	s.sweep(context.Background())
+
+	// the profiles, created at once, are found despite the batch size, and deleted at once
+	require.Len(t, deleted, 1)
+	assert.ElementsMatch(t, []profile.ID{"svc1-0", "svc1-2", "svc1-4"}, deleted[0])
+}
+
+type testCollectingStorage struct {
+	*testStorage
+	collected int
+}
+
+func (st *testCollectingStorage) CollectGarbage(ctx context.Context) error {
+	st.collected++
+	return nil
+}
+
+func TestSweeper_sweep_collectGarbage(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	st := &testCollectingStorage{testStorage: newTestStorage()}
+	st.add("", "svc1", profile.TypeCPU, nil, now.Add(-48*time.Hour))
+
+	s := newTestSweeper(t, Config{Interval: time.Hour, BatchSize: 10, DryRun: true}, st, nil, `default: 24h`)
+	s.now = func() time.Time { return now }
+
+	s.sweep(context.Background())
+	assert.Equal(t, 0, st.collected)
+
+	s.conf.DryRun = false
+	s.sweep(context.Background())
+
+	// the shared data is collected after the profiles are deleted
+	assert.Empty(t, st.metas[""])
+	assert.Equal(t, 1, st.collected)
+}
+
+func TestSweeper_sweep_batches(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	st := newTestStorage()
+	for i := 0; i < 10; i++ {
+		st.add("", "svc1", profile.TypeCPU, nil, now.Add(-48*time.Hour-time.Duration(i)*time.Minute))
+	}
+
+	var deleted [][]profile.ID
+	deleteProfiles := st.StubDeleter.DeleteProfilesFunc
+	st.StubDeleter.DeleteProfilesFunc = func(ctx context.Context, tn string, pids []profile.ID) error {
+		deleted = append(deleted, pids)
+		return deleteProfiles(ctx, tn, pids)
+	}
+
+	conf := Config{Interval: time.Hour, BatchSize: 3}
+	s := newTestSweeper(t, conf, st, nil, `default: 24h`)
+	s.now = func() time.Time { return now }
+
+	s.sweep(context.Background())
+
+	// the profiles are deleted batch by batch, as they are looked up
+	var total int
+	for _, pids := range deleted {
+		assert.True(t, len(pids) <= conf.BatchSize, "deleted %d profiles at once", len(pids))
+		total += len(pids)
+	}
+	assert.True(t, len(deleted) > 1)
+	assert.Equal(t, 10, total)
+	assert.Empty(t, st.metas[""])
+}
@@ -0,0 +1,250 @@
+package scrape
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultInterval    = time.Minute
+	defaultTimeout     = 10 * time.Second
+	defaultCPUDuration = 10 * time.Second
+	defaultPathPrefix  = "/debug/pprof"
+
+	// the label that is set to target's host:port, if not set by the config
+	labelInstance = "instance"
+)
+
+var defaultProfileTypes = []string{"cpu", "heap"}
+
+type Config struct {
+	ConfigFile string
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "scrape.config-file", "", "path to scrape targets config file (pull-mode collection is disabled if empty)")
+}
+
+// FileConfig is the configuration of pull-mode collection, loaded from a YAML file.
+//
+// Example:
+//
+//	scrape_configs:
+//	  - service: api-backend
+//	    interval: 1m
+//	    cpu_duration: 10s
+//	    profile_types: [cpu, heap, goroutine]
+//	    labels:
+//	      env: prod
+//	    static_configs:
+//	      - targets: ["10.0.0.1:6060", "http://10.0.0.2:6060"]
+//	        labels:
+//	          dc: fra
+//	    file_sd_configs:
+//	      - files: ["/etc/profefe/targets/*.json"]
+//	    dns_sd_configs:
+//	      - names: ["_pprof._tcp.api-backend.service.consul"]
+type FileConfig struct {
+	ScrapeConfigs []*ScrapeConfig `yaml:"scrape_configs"`
+}
+
+// LoadFile reads and validates the config from the file.
+func LoadFile(fileName string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := Load(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load config %q: %w", fileName, err)
+	}
+	return conf, nil
+}
+
+// Load parses and validates the YAML config.
+func Load(data []byte) (*FileConfig, error) {
+	conf := &FileConfig{}
+	if err := yaml.UnmarshalStrict(data, conf); err != nil {
+		return nil, err
+	}
+	for n, sc := range conf.ScrapeConfigs {
+		if err := sc.validate(); err != nil {
+			return nil, fmt.Errorf("bad scrape config %d: %w", n, err)
+		}
+	}
+	return conf, nil
+}
+
+// ScrapeConfig describes a set of targets, that belong to a service, and how to scrape them.
+type ScrapeConfig struct {
+	Service string `yaml:"service"`
+	// the tenant to store the scraped profiles under
+	Tenant string `yaml:"tenant,omitempty"`
+	// how often to scrape the targets
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// timeout of a single request to the target, on top of CPUDuration for CPU profiles and traces
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// duration of CPU profiles and traces
+	CPUDuration  time.Duration     `yaml:"cpu_duration,omitempty"`
+	ProfileTypes []string          `yaml:"profile_types,omitempty"`
+	PathPrefix   string            `yaml:"path_prefix,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty"`
+
+	StaticConfigs []*TargetGroup  `yaml:"static_configs,omitempty"`
+	FileSDConfigs []*FileSDConfig `yaml:"file_sd_configs,omitempty"`
+	DNSSDConfigs  []*DNSSDConfig  `yaml:"dns_sd_configs,omitempty"`
+
+	ptypes []profile.ProfileType
+}
+
+func (sc *ScrapeConfig) validate() error {
+	if sc.Service == "" {
+		return fmt.Errorf("empty service")
+	}
+	if err := tenant.ValidateName(sc.Tenant); err != nil {
+		return err
+	}
+
+	if sc.Interval == 0 {
+		sc.Interval = defaultInterval
+	}
+	if sc.Timeout == 0 {
+		sc.Timeout = defaultTimeout
+	}
+	if sc.CPUDuration == 0 {
+		sc.CPUDuration = defaultCPUDuration
+	}
+	if sc.PathPrefix == "" {
+		sc.PathPrefix = defaultPathPrefix
+	}
+	if len(sc.ProfileTypes) == 0 {
+		sc.ProfileTypes = defaultProfileTypes
+	}
+
+	// the seconds of cpu profiles and traces are whole; zero seconds are treated as the default 30s by net/http/pprof
+	if sc.CPUDuration < time.Second {
+		return fmt.Errorf("service %q: cpu_duration %v must be at least 1s", sc.Service, sc.CPUDuration)
+	}
+	if sc.CPUDuration >= sc.Interval {
+		return fmt.Errorf("service %q: cpu_duration %v must be less than interval %v", sc.Service, sc.CPUDuration, sc.Interval)
+	}
+
+	sc.ptypes = sc.ptypes[:0]
+	for _, s := range sc.ProfileTypes {
+		var ptype profile.ProfileType
+		ptype.FromString(s)
+		if ptype == profile.TypeUnknown || ptype == profile.TypeOther {
+			return fmt.Errorf("service %q: unsupported profile type %q", sc.Service, s)
+		}
+		sc.ptypes = append(sc.ptypes, ptype)
+	}
+
+	for _, tg := range sc.StaticConfigs {
+		if tg == nil {
+			return fmt.Errorf("service %q: empty static config", sc.Service)
+		}
+	}
+	for _, c := range sc.FileSDConfigs {
+		if c == nil {
+			return fmt.Errorf("service %q: empty file_sd config", sc.Service)
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("service %q: %w", sc.Service, err)
+		}
+	}
+	for _, c := range sc.DNSSDConfigs {
+		if c == nil {
+			return fmt.Errorf("service %q: empty dns_sd config", sc.Service)
+		}
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("service %q: %w", sc.Service, err)
+		}
+	}
+
+	return nil
+}
+
+// TargetGroup is a set of targets that share the same labels.
+type TargetGroup struct {
+	Targets []string          `yaml:"targets" json:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+}
+
+// Target is a single application, which exposes net/http/pprof endpoints.
+type Target struct {
+	// base URL of the target, e.g. "http://10.0.0.1:6060"
+	URL     string
+	Service string
+	Labels  profile.Labels
+
+	config *ScrapeConfig
+}
+
+func (t *Target) key() string {
+	return t.config.Tenant + "|" + t.Service + "|" + t.URL + "|" + t.Labels.String()
+}
+
+// returns the URL of the pprof endpoint that serves profiles of the type
+func (t *Target) profileURL(ptype profile.ProfileType) string {
+	base := t.URL + t.config.PathPrefix
+	seconds := fmt.Sprintf("?seconds=%d", int(t.config.CPUDuration.Seconds()))
+	switch ptype {
+	case profile.TypeCPU:
+		return base + "/profile" + seconds
+	case profile.TypeTrace:
+		return base + "/trace" + seconds
+	}
+	return base + "/" + ptype.String()
+}
+
+// creates targets of the target group; the labels of the group take precedence over the labels of the scrape config
+func targetsFromGroup(sc *ScrapeConfig, tg *TargetGroup) ([]*Target, error) {
+	targets := make([]*Target, 0, len(tg.Targets))
+	for _, addr := range tg.Targets {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("service %q: bad target address %q", sc.Service, addr)
+		}
+
+		lmap := make(map[string]string, len(sc.Labels)+len(tg.Labels)+1)
+		lmap[labelInstance] = u.Host
+		for k, v := range sc.Labels {
+			lmap[k] = v
+		}
+		for k, v := range tg.Labels {
+			lmap[k] = v
+		}
+
+		targets = append(targets, &Target{
+			URL:     strings.TrimSuffix(u.String(), "/"),
+			Service: sc.Service,
+			Labels:  labelsFromMap(lmap),
+			config:  sc,
+		})
+	}
+	return targets, nil
+}
+
+func labelsFromMap(m map[string]string) profile.Labels {
+	labels := make(profile.Labels, 0, len(m))
+	for k, v := range m {
+		if k == "" {
+			continue
+		}
+		labels = append(labels, profile.Label{Key: k, Value: v})
+	}
+	sort.Sort(labels)
+	return labels
+}
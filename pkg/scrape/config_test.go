@@ -0,0 +1,65 @@
+package scrape
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	data := []byte(`
+scrape_configs:
+  - service: api-backend
+    interval: 30s
+    profile_types: [cpu, heap, goroutine]
+    labels:
+      env: prod
+      dc: ams
+    static_configs:
+      - targets: ["10.0.0.1:6060", "https://10.0.0.2:6060/"]
+        labels:
+          dc: fra
+`)
+
+	conf, err := Load(data)
+	require.NoError(t, err)
+	require.Len(t, conf.ScrapeConfigs, 1)
+
+	sc := conf.ScrapeConfigs[0]
+	assert.Equal(t, 30*time.Second, sc.Interval)
+	assert.Equal(t, defaultTimeout, sc.Timeout)
+	assert.Equal(t, defaultCPUDuration, sc.CPUDuration)
+	assert.Equal(t, defaultPathPrefix, sc.PathPrefix)
+	assert.Equal(t, []profile.ProfileType{profile.TypeCPU, profile.TypeHeap, profile.TypeGoroutine}, sc.ptypes)
+
+	targets, err := targetsFromGroup(sc, sc.StaticConfigs[0])
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+
+	assert.Equal(t, "http://10.0.0.1:6060", targets[0].URL)
+	assert.Equal(t, "api-backend", targets[0].Service)
+	assert.Equal(t, "dc=fra,env=prod,instance=10.0.0.1:6060", targets[0].Labels.String())
+
+	assert.Equal(t, "https://10.0.0.2:6060", targets[1].URL)
+	assert.Equal(t, "http://10.0.0.1:6060/debug/pprof/profile?seconds=10", targets[0].profileURL(profile.TypeCPU))
+	assert.Equal(t, "http://10.0.0.1:6060/debug/pprof/heap", targets[0].profileURL(profile.TypeHeap))
+}
+
+func TestLoad_invalid(t *testing.T) {
+	cases := map[string]string{
+		"empty service":          `scrape_configs: [{interval: 1m}]`,
+		"unknown field":          `scrape_configs: [{service: svc, intreval: 1m}]`,
+		"unknown type":           `scrape_configs: [{service: svc, profile_types: [cpu, nope]}]`,
+		"cpu_duration too long":  `scrape_configs: [{service: svc, interval: 10s, cpu_duration: 10s}]`,
+		"cpu_duration too short": `scrape_configs: [{service: svc, cpu_duration: 500ms}]`,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load([]byte(data))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultFileSDRefreshInterval = 30 * time.Second
+	defaultDNSSDRefreshInterval  = 30 * time.Second
+
+	dnsTypeSRV = "SRV"
+	dnsTypeA   = "A"
+
+	// the label that is set to the DNS name, the target was discovered from
+	labelDNSName = "dns_name"
+)
+
+// discoverer periodically sends the up-to-date list of target groups to the channel.
+type discoverer interface {
+	Run(ctx context.Context, up chan<- []*TargetGroup)
+}
+
+// FileSDConfig describes the files, in Prometheus file_sd format, to read the target groups from.
+//
+// Example of a target file:
+//
+//   - targets: ["10.0.0.1:6060", "10.0.0.2:6060"]
+//     labels:
+//     dc: fra
+type FileSDConfig struct {
+	// paths to JSON or YAML files; the last element of a path may contain a glob pattern, e.g. "targets/*.json"
+	Files           []string      `yaml:"files"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+func (c *FileSDConfig) validate() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf("file_sd config: no files")
+	}
+	for _, name := range c.Files {
+		switch ext := filepath.Ext(name); ext {
+		case ".json", ".yml", ".yaml":
+		default:
+			return fmt.Errorf("file_sd config: unsupported file extension %q", name)
+		}
+		if _, err := filepath.Match(name, ""); err != nil {
+			return fmt.Errorf("file_sd config: bad pattern %q: %w", name, err)
+		}
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = defaultFileSDRefreshInterval
+	}
+	return nil
+}
+
+// fileDiscoverer rereads target files on every refresh, sending the target groups only when the files have changed.
+type fileDiscoverer struct {
+	logger *log.Logger
+	config *FileSDConfig
+
+	lastData []byte
+}
+
+func newFileDiscoverer(logger *log.Logger, config *FileSDConfig) *fileDiscoverer {
+	return &fileDiscoverer{
+		logger: logger,
+		config: config,
+	}
+}
+
+func (d *fileDiscoverer) Run(ctx context.Context, up chan<- []*TargetGroup) {
+	runDiscoverer(ctx, d.config.RefreshInterval, d.refresh, up)
+}
+
+func (d *fileDiscoverer) refresh() ([]*TargetGroup, bool) {
+	fileNames, err := d.listFiles()
+	if err != nil {
+		d.logger.Errorw("file_sd: failed to list files", zap.Error(err))
+		return nil, false
+	}
+
+	var (
+		groups  []*TargetGroup
+		allData [][]byte
+	)
+	for _, fileName := range fileNames {
+		data, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			d.logger.Errorw("file_sd: failed to read file", "file", fileName, zap.Error(err))
+			return nil, false
+		}
+		tgs, err := parseTargetGroups(fileName, data)
+		if err != nil {
+			d.logger.Errorw("file_sd: failed to parse file", "file", fileName, zap.Error(err))
+			return nil, false
+		}
+		groups = append(groups, tgs...)
+		allData = append(allData, []byte(fileName), data)
+	}
+
+	// comparing the contents is cheap enough, as target files are expected to be small
+	data := bytes.Join(allData, []byte{0})
+	if d.lastData != nil && bytes.Equal(d.lastData, data) {
+		return nil, false
+	}
+	d.lastData = data
+
+	return groups, true
+}
+
+func (d *fileDiscoverer) listFiles() ([]string, error) {
+	var fileNames []string
+	for _, pattern := range d.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		fileNames = append(fileNames, matches...)
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func parseTargetGroups(fileName string, data []byte) (tgs []*TargetGroup, err error) {
+	switch filepath.Ext(fileName) {
+	case ".json":
+		err = json.Unmarshal(data, &tgs)
+	default:
+		err = yaml.UnmarshalStrict(data, &tgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for n, tg := range tgs {
+		if tg == nil {
+			return nil, fmt.Errorf("empty target group %d", n)
+		}
+	}
+	return tgs, nil
+}
+
+// DNSSDConfig describes the DNS names to discover the targets from.
+type DNSSDConfig struct {
+	Names []string `yaml:"names"`
+	// the type of DNS query: SRV (default) or A
+	Type string `yaml:"type,omitempty"`
+	// the port of the targets; required for A queries
+	Port            int           `yaml:"port,omitempty"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+func (c *DNSSDConfig) validate() error {
+	if len(c.Names) == 0 {
+		return fmt.Errorf("dns_sd config: no names")
+	}
+	if c.Type == "" {
+		c.Type = dnsTypeSRV
+	}
+	c.Type = strings.ToUpper(c.Type)
+	switch c.Type {
+	case dnsTypeSRV:
+	case dnsTypeA:
+		if c.Port == 0 {
+			return fmt.Errorf("dns_sd config: port required for %s query", c.Type)
+		}
+	default:
+		return fmt.Errorf("dns_sd config: unsupported query type %q", c.Type)
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = defaultDNSSDRefreshInterval
+	}
+	return nil
+}
+
+// Resolver looks up DNS records. It's implemented by net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// dnsDiscoverer resolves the names on every refresh, creating a target group per name.
+type dnsDiscoverer struct {
+	logger   *log.Logger
+	config   *DNSSDConfig
+	resolver Resolver
+
+	lastGroups []*TargetGroup
+}
+
+func newDNSDiscoverer(logger *log.Logger, config *DNSSDConfig, resolver Resolver) *dnsDiscoverer {
+	return &dnsDiscoverer{
+		logger:   logger,
+		config:   config,
+		resolver: resolver,
+	}
+}
+
+func (d *dnsDiscoverer) Run(ctx context.Context, up chan<- []*TargetGroup) {
+	runDiscoverer(ctx, d.config.RefreshInterval, func() ([]*TargetGroup, bool) {
+		return d.refresh(ctx)
+	}, up)
+}
+
+func (d *dnsDiscoverer) refresh(ctx context.Context) ([]*TargetGroup, bool) {
+	groups := make([]*TargetGroup, 0, len(d.config.Names))
+	for _, name := range d.config.Names {
+		targets, err := d.lookup(ctx, name)
+		if err != nil {
+			// keep the targets from the previous lookup, if the name failed to resolve
+			d.logger.Errorw("dns_sd: failed to resolve name", "name", name, zap.Error(err))
+			if tg := findTargetGroup(d.lastGroups, name); tg != nil {
+				groups = append(groups, tg)
+			}
+			continue
+		}
+		sort.Strings(targets)
+		groups = append(groups, &TargetGroup{
+			Targets: targets,
+			Labels: map[string]string{
+				labelDNSName: name,
+			},
+		})
+	}
+
+	if d.lastGroups != nil && equalTargetGroups(d.lastGroups, groups) {
+		return nil, false
+	}
+	d.lastGroups = groups
+
+	return groups, true
+}
+
+func (d *dnsDiscoverer) lookup(ctx context.Context, name string) ([]string, error) {
+	var targets []string
+	switch d.config.Type {
+	case dnsTypeSRV:
+		_, srvs, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			targets = append(targets, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	case dnsTypeA:
+		addrs, err := d.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			targets = append(targets, net.JoinHostPort(addr.String(), strconv.Itoa(d.config.Port)))
+		}
+	}
+	return targets, nil
+}
+
+func findTargetGroup(groups []*TargetGroup, name string) *TargetGroup {
+	for _, tg := range groups {
+		if tg.Labels[labelDNSName] == name {
+			return tg
+		}
+	}
+	return nil
+}
+
+func equalTargetGroups(a, b []*TargetGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Labels[labelDNSName] != b[i].Labels[labelDNSName] || len(a[i].Targets) != len(b[i].Targets) {
+			return false
+		}
+		for j := range a[i].Targets {
+			if a[i].Targets[j] != b[i].Targets[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// calls refresh right away and then on every interval, sending the updated target groups to the channel
+func runDiscoverer(ctx context.Context, interval time.Duration, refresh func() ([]*TargetGroup, bool), up chan<- []*TargetGroup) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if groups, ok := refresh(); ok {
+			select {
+			case up <- groups:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
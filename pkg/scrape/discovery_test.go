@@ -0,0 +1,198 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFileDiscoverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profefe-file-sd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, data string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	writeFile("a.json", `[{"targets": ["10.0.0.1:6060"], "labels": {"dc": "fra"}}]`)
+	writeFile("b.yml", "- targets: [\"10.0.0.2:6060\", \"10.0.0.3:6060\"]\n")
+
+	conf := &FileSDConfig{
+		Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yml")},
+	}
+	require.NoError(t, conf.validate())
+
+	d := newFileDiscoverer(log.New(zaptest.NewLogger(t)), conf)
+
+	groups, ok := d.refresh()
+	require.True(t, ok)
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"10.0.0.1:6060"}, groups[0].Targets)
+	assert.Equal(t, map[string]string{"dc": "fra"}, groups[0].Labels)
+	assert.Equal(t, []string{"10.0.0.2:6060", "10.0.0.3:6060"}, groups[1].Targets)
+
+	// files didn't change
+	_, ok = d.refresh()
+	assert.False(t, ok)
+
+	// malformed file keeps the previous targets
+	writeFile("a.json", `[{"targets": `)
+	_, ok = d.refresh()
+	assert.False(t, ok)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.json")))
+	groups, ok = d.refresh()
+	require.True(t, ok)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"10.0.0.2:6060", "10.0.0.3:6060"}, groups[0].Targets)
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	resolver := &testResolver{
+		srv: map[string][]*net.SRV{
+			"_pprof._tcp.svc1": {
+				{Target: "host2.svc1.", Port: 6060},
+				{Target: "host1.svc1.", Port: 6060},
+			},
+		},
+		ip: map[string][]net.IPAddr{
+			"svc2": {{IP: net.ParseIP("10.0.0.1")}},
+		},
+	}
+
+	testLogger := log.New(zaptest.NewLogger(t))
+
+	t.Run("SRV", func(t *testing.T) {
+		conf := &DNSSDConfig{Names: []string{"_pprof._tcp.svc1"}}
+		require.NoError(t, conf.validate())
+
+		d := newDNSDiscoverer(testLogger, conf, resolver)
+		groups, ok := d.refresh(context.Background())
+		require.True(t, ok)
+		require.Len(t, groups, 1)
+		assert.Equal(t, []string{"host1.svc1:6060", "host2.svc1:6060"}, groups[0].Targets)
+		assert.Equal(t, map[string]string{labelDNSName: "_pprof._tcp.svc1"}, groups[0].Labels)
+
+		// nothing changed
+		_, ok = d.refresh(context.Background())
+		assert.False(t, ok)
+
+		// failed lookup keeps the previous targets
+		resolver.setErr(errors.New("dns failure"))
+		defer resolver.setErr(nil)
+		_, ok = d.refresh(context.Background())
+		assert.False(t, ok)
+	})
+
+	t.Run("A", func(t *testing.T) {
+		conf := &DNSSDConfig{Names: []string{"svc2"}, Type: "a", Port: 8080}
+		require.NoError(t, conf.validate())
+
+		d := newDNSDiscoverer(testLogger, conf, resolver)
+		groups, ok := d.refresh(context.Background())
+		require.True(t, ok)
+		require.Len(t, groups, 1)
+		assert.Equal(t, []string{"10.0.0.1:8080"}, groups[0].Targets)
+	})
+
+	t.Run("A without port", func(t *testing.T) {
+		conf := &DNSSDConfig{Names: []string{"svc2"}, Type: "A"}
+		assert.Error(t, conf.validate())
+	})
+}
+
+func TestManager_fileSD(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profefe-file-sd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "targets.yml")
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("- targets: [\"10.0.0.1:6060\"]\n"), 0644))
+
+	conf, err := Load([]byte(`
+scrape_configs:
+  - service: test-service
+    interval: 1h
+    file_sd_configs:
+      - files: ["` + fileName + `"]
+        refresh_interval: 10ms
+`))
+	require.NoError(t, err)
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	mgr := NewManager(testLogger, profefe.NewCollector(testLogger, &storage.StubWriter{}), prometheus.NewRegistry())
+	require.NoError(t, mgr.ApplyConfig(conf))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mgr.Run(ctx)
+
+	targetURLs := func() (urls []string) {
+		for _, st := range mgr.Targets() {
+			urls = append(urls, st.URL)
+		}
+		return urls
+	}
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"http://10.0.0.1:6060"}, targetURLs())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("- targets: [\"10.0.0.2:6060\", \"10.0.0.3:6060\"]\n"), 0644))
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"http://10.0.0.2:6060", "http://10.0.0.3:6060"}, targetURLs())
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// applying the config without discovery removes the discovered targets
+	conf, err = Load([]byte(`scrape_configs: [{service: test-service}]`))
+	require.NoError(t, err)
+	require.NoError(t, mgr.ApplyConfig(conf))
+	assert.Empty(t, mgr.Targets())
+}
+
+type testResolver struct {
+	mu  sync.Mutex
+	err error
+	srv map[string][]*net.SRV
+	ip  map[string][]net.IPAddr
+}
+
+func (r *testResolver) setErr(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+}
+
+func (r *testResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srv[name], nil
+}
+
+func (r *testResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.ip[host], nil
+}
@@ -0,0 +1,194 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// the maximum size of the profile the scraper reads from a target
+const maxProfileSize = 64 << 20
+
+const (
+	healthUnknown = "unknown"
+	healthUp      = "up"
+	healthDown    = "down"
+)
+
+// scrapeLoop periodically scrapes profiles of a single target.
+type scrapeLoop struct {
+	logger    *log.Logger
+	collector *profefe.Collector
+	client    *http.Client
+	metrics   *metrics
+	target    *Target
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu           sync.Mutex
+	health       string
+	lastScrape   time.Time
+	lastDuration time.Duration
+	lastErr      error
+}
+
+func newScrapeLoop(logger *log.Logger, collector *profefe.Collector, client *http.Client, metrics *metrics, target *Target) *scrapeLoop {
+	return &scrapeLoop{
+		logger:    logger,
+		collector: collector,
+		client:    client,
+		metrics:   metrics,
+		target:    target,
+		done:      make(chan struct{}),
+		health:    healthUnknown,
+	}
+}
+
+func (sl *scrapeLoop) run(ctx context.Context) {
+	defer close(sl.done)
+
+	interval := sl.target.config.Interval
+
+	// spread the scrapes of different targets over the interval
+	offset := time.Duration(rand.Int63n(int64(interval)))
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(offset):
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sl.scrapeAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sl *scrapeLoop) stop() {
+	sl.cancel()
+	<-sl.done
+}
+
+func (sl *scrapeLoop) scrapeAll(ctx context.Context) {
+	start := time.Now()
+
+	var lastErr error
+	for _, ptype := range sl.target.config.ptypes {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ts := time.Now()
+		err := sl.scrape(ctx, ptype)
+		sl.metrics.observeScrape(sl.target, ptype.String(), time.Since(ts), err)
+		if err != nil {
+			logScrapeError(sl.logger, sl.target, ptype.String(), err)
+			lastErr = fmt.Errorf("%s: %w", ptype, err)
+		}
+	}
+
+	up := 1.0
+	if lastErr != nil {
+		up = 0
+	}
+	sl.metrics.targetUp.WithLabelValues(sl.target.Service, sl.target.URL).Set(up)
+
+	sl.mu.Lock()
+	sl.lastScrape = start
+	sl.lastDuration = time.Since(start)
+	sl.lastErr = lastErr
+	if lastErr != nil {
+		sl.health = healthDown
+	} else {
+		sl.health = healthUp
+	}
+	sl.mu.Unlock()
+}
+
+func (sl *scrapeLoop) scrape(ctx context.Context, ptype profile.ProfileType) error {
+	timeout := sl.target.config.Timeout
+	if ptype == profile.TypeCPU || ptype == profile.TypeTrace {
+		timeout += sl.target.config.CPUDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := sl.fetch(ctx, sl.target.profileURL(ptype))
+	if err != nil {
+		return err
+	}
+
+	params := &storage.WriteProfileParams{
+		Tenant:  sl.target.config.Tenant,
+		Service: sl.target.Service,
+		Type:    ptype,
+		Labels:  sl.target.Labels,
+	}
+	_, err = sl.collector.WriteProfile(ctx, params, bytes.NewReader(data))
+	return err
+}
+
+func (sl *scrapeLoop) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := sl.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errUnexpectedStatus(url, resp.StatusCode)
+	}
+
+	// read one byte over the limit, to tell the profile, that exceeds the limit, from the one, that fits it
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(resp.Body, maxProfileSize+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxProfileSize {
+		return nil, fmt.Errorf("profile from %s exceeds the maximum size of %d bytes", url, maxProfileSize)
+	}
+	return buf.Bytes(), nil
+}
+
+func (sl *scrapeLoop) status() TargetStatus {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	st := TargetStatus{
+		URL:          sl.target.URL,
+		Tenant:       sl.target.config.Tenant,
+		Service:      sl.target.Service,
+		Labels:       sl.target.Labels.String(),
+		Health:       sl.health,
+		LastScrape:   sl.lastScrape,
+		LastDuration: sl.lastDuration,
+	}
+	if sl.lastErr != nil {
+		st.LastError = sl.lastErr.Error()
+	}
+	return st
+}
@@ -0,0 +1,354 @@
+// Package scrape implements pull-mode collection of profiles, where the collector periodically
+// fetches profiling data from applications' net/http/pprof endpoints.
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const APITargetsPath = "/api/0/scrape/targets"
+
+// Manager maintains the set of scrape targets, running a scrape loop for every target.
+type Manager struct {
+	logger    *log.Logger
+	collector *profefe.Collector
+	client    *http.Client
+	resolver  Resolver
+	metrics   *metrics
+
+	mu  sync.Mutex
+	ctx context.Context
+	// targets grouped by the name of the source that provided them
+	targetSets map[string][]*Target
+	loops      map[string]*scrapeLoop
+
+	providers       []*provider
+	cancelProviders context.CancelFunc
+}
+
+// provider is a discoverer of the targets of a scrape config.
+type provider struct {
+	name   string
+	config *ScrapeConfig
+	d      discoverer
+}
+
+func NewManager(logger *log.Logger, collector *profefe.Collector, registry prometheus.Registerer) *Manager {
+	return &Manager{
+		logger:     logger,
+		collector:  collector,
+		client:     &http.Client{},
+		resolver:   net.DefaultResolver,
+		metrics:    newMetrics(registry),
+		targetSets: make(map[string][]*Target),
+		loops:      make(map[string]*scrapeLoop),
+	}
+}
+
+// ApplyConfig replaces the targets of the previously applied config with the targets from the config.
+// The discovery of targets, described by the config, starts when the manager is running.
+func (m *Manager) ApplyConfig(conf *FileConfig) error {
+	sets := make(map[string][]*Target, len(conf.ScrapeConfigs))
+	var providers []*provider
+	for n, sc := range conf.ScrapeConfigs {
+		var targets []*Target
+		for _, tg := range sc.StaticConfigs {
+			tt, err := targetsFromGroup(sc, tg)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, tt...)
+		}
+		sets[configSetName("static", n, 0)] = targets
+
+		for i, c := range sc.FileSDConfigs {
+			providers = append(providers, &provider{
+				name:   configSetName("file_sd", n, i),
+				config: sc,
+				d:      newFileDiscoverer(m.logger, c),
+			})
+		}
+		for i, c := range sc.DNSSDConfigs {
+			providers = append(providers, &provider{
+				name:   configSetName("dns_sd", n, i),
+				config: sc,
+				d:      newDNSDiscoverer(m.logger, c, m.resolver),
+			})
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cancelProviders != nil {
+		m.cancelProviders()
+		m.cancelProviders = nil
+	}
+
+	for name := range m.targetSets {
+		if isConfigSetName(name) {
+			delete(m.targetSets, name)
+		}
+	}
+	for name, targets := range sets {
+		m.targetSets[name] = targets
+	}
+	m.providers = providers
+
+	m.startProvidersLocked()
+	m.syncLocked()
+
+	return nil
+}
+
+// SetTargets replaces the targets provided by the named source.
+func (m *Manager) SetTargets(setName string, targets []*Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(targets) == 0 {
+		delete(m.targetSets, setName)
+	} else {
+		m.targetSets[setName] = targets
+	}
+	m.syncLocked()
+}
+
+// Run starts scraping the targets and blocks until the context is canceled.
+func (m *Manager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.startProvidersLocked()
+	m.syncLocked()
+	m.mu.Unlock()
+
+	<-ctx.Done()
+
+	m.mu.Lock()
+	if m.cancelProviders != nil {
+		m.cancelProviders()
+		m.cancelProviders = nil
+	}
+	loops := m.loops
+	m.loops = make(map[string]*scrapeLoop)
+	m.ctx = nil
+	m.mu.Unlock()
+
+	for _, sl := range loops {
+		sl.stop()
+	}
+	return nil
+}
+
+func (m *Manager) startProvidersLocked() {
+	if m.ctx == nil || len(m.providers) == 0 {
+		return
+	}
+
+	var ctx context.Context
+	ctx, m.cancelProviders = context.WithCancel(m.ctx)
+	for _, p := range m.providers {
+		go m.runProvider(ctx, p)
+	}
+}
+
+// runs the discoverer, updating the provider's targets every time the discoverer sends new target groups
+func (m *Manager) runProvider(ctx context.Context, p *provider) {
+	up := make(chan []*TargetGroup)
+	go p.d.Run(ctx, up)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case groups := <-up:
+			var targets []*Target
+			for _, tg := range groups {
+				tt, err := targetsFromGroup(p.config, tg)
+				if err != nil {
+					m.logger.Errorw("scrape: bad discovered targets", "provider", p.name, zap.Error(err))
+					continue
+				}
+				targets = append(targets, tt...)
+			}
+			m.setProviderTargets(ctx, p.name, targets)
+		}
+	}
+}
+
+func (m *Manager) setProviderTargets(ctx context.Context, setName string, targets []*Target) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the provider was stopped by config reload, while it was discovering the targets
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.logger.Debugw("scrape: discovered targets", "provider", setName, "targets", len(targets))
+	if len(targets) == 0 {
+		delete(m.targetSets, setName)
+	} else {
+		m.targetSets[setName] = targets
+	}
+	m.syncLocked()
+}
+
+// starts scrape loops for new targets and stops the loops of the targets that have gone
+func (m *Manager) syncLocked() {
+	if m.ctx == nil {
+		// not running yet
+		return
+	}
+
+	active := make(map[string]*Target)
+	for _, targets := range m.targetSets {
+		for _, t := range targets {
+			active[t.key()] = t
+		}
+	}
+
+	for key, sl := range m.loops {
+		if _, ok := active[key]; !ok {
+			m.logger.Infow("scrape: removing target", "service", sl.target.Service, "url", sl.target.URL)
+			sl.stop()
+			m.metrics.targetUp.DeleteLabelValues(sl.target.Service, sl.target.URL)
+			delete(m.loops, key)
+		}
+	}
+
+	for key, t := range active {
+		if _, ok := m.loops[key]; ok {
+			continue
+		}
+		m.logger.Infow("scrape: adding target", "service", t.Service, "url", t.URL, "labels", t.Labels)
+		sl := newScrapeLoop(m.logger, m.collector, m.client, m.metrics, t)
+		m.loops[key] = sl
+
+		var ctx context.Context
+		ctx, sl.cancel = context.WithCancel(m.ctx)
+		go sl.run(ctx)
+	}
+
+	m.metrics.targets.Set(float64(len(m.loops)))
+}
+
+// TargetStatus is the JSON representation of target's health returned with API response.
+type TargetStatus struct {
+	URL          string        `json:"url"`
+	Tenant       string        `json:"tenant,omitempty"`
+	Service      string        `json:"service"`
+	Labels       string        `json:"labels,omitempty"`
+	Health       string        `json:"health"`
+	LastScrape   time.Time     `json:"last_scrape,omitempty"`
+	LastDuration time.Duration `json:"last_duration,omitempty"`
+	LastError    string        `json:"last_error,omitempty"`
+}
+
+// Targets returns the health of the active targets.
+func (m *Manager) Targets() []TargetStatus {
+	m.mu.Lock()
+	statuses := make([]TargetStatus, 0, len(m.loops))
+	for _, sl := range m.loops {
+		statuses = append(statuses, sl.status())
+	}
+	m.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Service != statuses[j].Service {
+			return statuses[i].Service < statuses[j].Service
+		}
+		return statuses[i].URL < statuses[j].URL
+	})
+
+	return statuses
+}
+
+// ServeHTTP replies with the health of the active targets.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != APITargetsPath {
+		profefe.HandleErrorHTTP(m.logger, profefe.ErrNotFound, w, r)
+		return
+	}
+	profefe.ReplyJSON(w, m.Targets())
+}
+
+// the names of target sets, created from the config, start with "config/"
+func configSetName(kind string, n, i int) string {
+	return "config/" + kind + "/" + strconv.Itoa(n) + "/" + strconv.Itoa(i)
+}
+
+func isConfigSetName(name string) bool {
+	return strings.HasPrefix(name, "config/")
+}
+
+type metrics struct {
+	targets       prometheus.Gauge
+	targetUp      *prometheus.GaugeVec
+	scrapesTotal  *prometheus.CounterVec
+	scrapeLatency *prometheus.HistogramVec
+}
+
+func newMetrics(registry prometheus.Registerer) *metrics {
+	m := &metrics{
+		targets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "scrape_targets",
+			Help:      "Number of active scrape targets.",
+		}),
+		targetUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "scrape_target_up",
+			Help:      "Whether the last scrape of the target succeeded.",
+		}, []string{"service", "url"}),
+		scrapesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "scrape_profiles_total",
+			Help:      "Number of scraped profiles by result.",
+		}, []string{"service", "type", "result"}),
+		scrapeLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "profefe",
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of scraping and storing a profile.",
+		}, []string{"service", "type"}),
+	}
+
+	registry.MustRegister(
+		m.targets,
+		m.targetUp,
+		m.scrapesTotal,
+		m.scrapeLatency,
+	)
+
+	return m
+}
+
+func (m *metrics) observeScrape(t *Target, ptype string, d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.scrapesTotal.WithLabelValues(t.Service, ptype, result).Inc()
+	m.scrapeLatency.WithLabelValues(t.Service, ptype).Observe(d.Seconds())
+}
+
+func logScrapeError(logger *log.Logger, t *Target, ptype string, err error) {
+	logger.Errorw("scrape failed", "service", t.Service, "url", t.URL, "type", ptype, zap.Error(err))
+}
+
+func errUnexpectedStatus(url string, code int) error {
+	return fmt.Errorf("unexpected response from %s: %s", url, http.StatusText(code))
+}
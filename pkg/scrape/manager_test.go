@@ -0,0 +1,132 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestManager(t *testing.T) {
+	cpuData, err := ioutil.ReadFile("../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	pprofMux := http.NewServeMux()
+	pprofMux.HandleFunc("/debug/pprof/profile", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(cpuData)
+	})
+	pprofMux.HandleFunc("/debug/pprof/heap", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})
+	target := httptest.NewServer(pprofMux)
+	defer target.Close()
+
+	var (
+		mu      sync.Mutex
+		written []*storage.WriteProfileParams
+	)
+	sw := &storage.StubWriter{
+		WriteProfileFunc: func(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+			mu.Lock()
+			written = append(written, params)
+			mu.Unlock()
+			return profile.Meta{ProfileID: profile.TestID, Service: params.Service, Type: params.Type}, nil
+		},
+	}
+
+	conf, err := Load([]byte(`
+scrape_configs:
+  - service: test-service
+    interval: 2s
+    cpu_duration: 1s
+    labels:
+      env: test
+    static_configs:
+      - targets: ["` + target.URL + `"]
+`))
+	require.NoError(t, err)
+
+	testLogger := log.New(zaptest.NewLogger(t))
+	mgr := NewManager(testLogger, profefe.NewCollector(testLogger, sw), prometheus.NewRegistry())
+	require.NoError(t, mgr.ApplyConfig(conf))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		mgr.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		targets := mgr.Targets()
+		return len(targets) == 1 && targets[0].Health != healthUnknown
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	mgr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, APITargetsPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Body []TargetStatus `json:"body"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.Len(t, resp.Body, 1)
+	assert.Equal(t, target.URL, resp.Body[0].URL)
+	assert.Equal(t, "test-service", resp.Body[0].Service)
+	assert.Equal(t, healthDown, resp.Body[0].Health)
+	assert.Contains(t, resp.Body[0].LastError, "heap")
+
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.NotEmpty(t, written)
+	assert.Equal(t, "test-service", written[0].Service)
+	assert.Equal(t, profile.TypeCPU, written[0].Type)
+	assert.True(t, written[0].Labels.Include(profile.Labels{{Key: "env", Value: "test"}}))
+	assert.Empty(t, mgr.Targets())
+}
+
+func TestManager_SetTargets(t *testing.T) {
+	testLogger := log.New(zaptest.NewLogger(t))
+	mgr := NewManager(testLogger, profefe.NewCollector(testLogger, &storage.StubWriter{}), prometheus.NewRegistry())
+
+	conf, err := Load([]byte(`scrape_configs: [{service: test-service, interval: 1h}]`))
+	require.NoError(t, err)
+
+	targets, err := targetsFromGroup(conf.ScrapeConfigs[0], &TargetGroup{Targets: []string{"10.0.0.1:6060", "10.0.0.2:6060"}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgr.SetTargets("test", targets)
+	go mgr.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		return len(mgr.Targets()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	mgr.SetTargets("test", targets[1:])
+	require.Len(t, mgr.Targets(), 1)
+	assert.Equal(t, "http://10.0.0.2:6060", mgr.Targets()[0].URL)
+
+	mgr.SetTargets("test", nil)
+	assert.Empty(t, mgr.Targets())
+}
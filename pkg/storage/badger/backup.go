@@ -0,0 +1,115 @@
+package badger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"go.uber.org/zap"
+)
+
+const (
+	APIBackupPath  = "/api/0/admin/badger/backup"
+	APIRestorePath = "/api/0/admin/badger/restore"
+
+	// BackupSinceTrailer is the HTTP trailer, that holds the version to pass as "since" to the next incremental backup.
+	BackupSinceTrailer = "X-Profefe-Backup-Since"
+)
+
+// the number of pending writes badger keeps while loading a backup
+const maxPendingWrites = 256
+
+// Backup writes the consistent snapshot of the keys, that were changed after the version since, to the writer;
+// zero since takes the full backup. It returns the version to take the next incremental backup since.
+func (st *Storage) Backup(w io.Writer, since uint64) (uint64, error) {
+	return st.db.Backup(w, since)
+}
+
+// Restore loads the backup, taken by Backup, into the storage. Restore must not run concurrently
+// with other writes to the storage.
+func (st *Storage) Restore(r io.Reader) error {
+	if err := st.db.Load(r, maxPendingWrites); err != nil {
+		return err
+	}
+	// the restored services are added to the cache
+	return st.cache.prefillServices(st.db)
+}
+
+// BackupHandler serves the backups of the storage, e.g. "GET /api/0/admin/badger/backup?since=<version>", and restores
+// them, e.g. "POST /api/0/admin/badger/restore" with the backup in the request's body.
+type BackupHandler struct {
+	logger  *log.Logger
+	storage *Storage
+}
+
+func NewBackupHandler(logger *log.Logger, st *Storage) *BackupHandler {
+	return &BackupHandler{
+		logger:  logger,
+		storage: st,
+	}
+}
+
+func (h *BackupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	switch r.URL.Path {
+	case APIBackupPath:
+		if r.Method != http.MethodGet {
+			err = profefe.StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+		} else {
+			err = h.HandleBackup(w, r)
+		}
+	case APIRestorePath:
+		if r.Method != http.MethodPost {
+			err = profefe.StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+		} else {
+			err = h.HandleRestore(w, r)
+		}
+	default:
+		err = profefe.ErrNotFound
+	}
+	profefe.HandleErrorHTTP(h.logger, err, w, r)
+}
+
+func (h *BackupHandler) HandleBackup(w http.ResponseWriter, r *http.Request) error {
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		since, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: bad \"since\" %q", v), err)
+		}
+	}
+
+	// the next version is known once the backup is written, thus it's sent in the trailer
+	w.Header().Set("Trailer", BackupSinceTrailer)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=badger.bak")
+	w.WriteHeader(http.StatusOK)
+
+	next, err := h.storage.Backup(w, since)
+	if err != nil {
+		// the response was already started, the client sees the missing trailer
+		h.logger.Errorw("failed to backup badger", "since", since, zap.Error(err))
+		return nil
+	}
+	w.Header().Set(BackupSinceTrailer, strconv.FormatUint(next, 10))
+
+	h.logger.Infow("badger backup taken", "since", since, "next_since", next)
+
+	return nil
+}
+
+func (h *BackupHandler) HandleRestore(w http.ResponseWriter, r *http.Request) error {
+	if err := h.storage.Restore(r.Body); err != nil {
+		return profefe.StatusError(http.StatusInternalServerError, "failed to restore backup", err)
+	}
+
+	h.logger.Infow("badger backup restored")
+
+	profefe.ReplyJSON(w, nil)
+
+	return nil
+}
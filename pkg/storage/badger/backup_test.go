@@ -0,0 +1,148 @@
+package badger_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storageBadger "github.com/profefe/profefe/pkg/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestStorage(t *testing.T) *storageBadger.Storage {
+	dbPath, err := ioutil.TempDir("", "badger")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		os.RemoveAll(dbPath)
+	})
+
+	db, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	testLogger := zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))
+	return storageBadger.NewStorage(log.New(testLogger), db, 0)
+}
+
+func TestBackupHandler(t *testing.T) {
+	src := newTestStorage(t)
+	dst := newTestStorage(t)
+
+	testLogger := log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel)))
+	srcServer := httptest.NewServer(storageBadger.NewBackupHandler(testLogger, src))
+	defer srcServer.Close()
+	dstServer := httptest.NewServer(storageBadger.NewBackupHandler(testLogger, dst))
+	defer dstServer.Close()
+
+	ctx := context.Background()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	writeProfile := func(tenant, service string) {
+		params := &storage.WriteProfileParams{
+			Tenant:    tenant,
+			Service:   service,
+			Type:      profile.TypeCPU,
+			CreatedAt: createdAt,
+		}
+		_, err := src.WriteProfile(ctx, params, bytes.NewReader([]byte("profile data")))
+		require.NoError(t, err)
+	}
+
+	backupRestore := func(since uint64) uint64 {
+		resp, err := http.Get(srcServer.URL + storageBadger.APIBackupPath + "?since=" + strconv.FormatUint(since, 10))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		next, err := strconv.ParseUint(resp.Trailer.Get(storageBadger.BackupSinceTrailer), 10, 64)
+		require.NoError(t, err)
+		assert.Greater(t, next, since)
+
+		resp, err = http.Post(dstServer.URL+storageBadger.APIRestorePath, "application/octet-stream", bytes.NewReader(data))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		return next
+	}
+
+	findProfiles := func(tenant, service string) []profile.Meta {
+		params := &storage.FindProfilesParams{
+			Tenant:       tenant,
+			Service:      service,
+			Type:         profile.TypeCPU,
+			CreatedAtMin: createdAt,
+			CreatedAtMax: createdAt.Add(time.Second),
+		}
+		metas, err := dst.FindProfiles(ctx, params)
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		require.NoError(t, err)
+		return metas
+	}
+
+	writeProfile("", "svc1")
+	writeProfile("team1", "svc1")
+	since := backupRestore(0)
+
+	assert.Len(t, findProfiles("", "svc1"), 1)
+	assert.Len(t, findProfiles("team1", "svc1"), 1)
+	services, err := dst.ListServices(ctx, "team1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"svc1"}, services)
+
+	// the incremental backup holds only the new profiles
+	writeProfile("", "svc2")
+	backupRestore(since)
+
+	assert.Len(t, findProfiles("", "svc1"), 1)
+	assert.Len(t, findProfiles("", "svc2"), 1)
+	services, err = dst.ListServices(ctx, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"svc1", "svc2"}, services)
+}
+
+func TestBackupHandler_badRequest(t *testing.T) {
+	st := newTestStorage(t)
+	h := storageBadger.NewBackupHandler(log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))), st)
+
+	cases := []struct {
+		method   string
+		path     string
+		wantCode int
+	}{
+		{http.MethodPost, storageBadger.APIBackupPath, http.StatusMethodNotAllowed},
+		{http.MethodGet, storageBadger.APIBackupPath + "?since=abc", http.StatusBadRequest},
+		{http.MethodGet, storageBadger.APIRestorePath, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/0/admin/badger/unknown", http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
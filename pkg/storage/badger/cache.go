@@ -11,13 +11,14 @@ import (
 )
 
 type cache struct {
-	mu       sync.Mutex
-	services map[string]uint64
+	mu sync.Mutex
+	// services by tenant
+	services map[string]map[string]uint64
 }
 
 func newCache(logger *log.Logger, db *badger.DB) *cache {
 	c := &cache{
-		services: make(map[string]uint64),
+		services: make(map[string]map[string]uint64),
 	}
 
 	if err := c.prefillServices(db); err != nil {
@@ -38,40 +39,60 @@ func (cache *cache) prefillServices(db *badger.DB) error {
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
+		// services of the default tenant
 		prefix := []byte{serviceIndexID}
-
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			key := it.Item().Key()
-			keyTTL := it.Item().ExpiresAt()
 			service := key[1 : len(key)-sizeOfProfileID-8] // 8 is for ts-nanos
-			if v, ok := cache.services[string(service)]; ok {
-				if v > keyTTL {
-					continue
-				}
+			cache.putServiceLocked("", string(service), it.Item().ExpiresAt())
+		}
+
+		// services of other tenants
+		prefix = []byte{tenantPrefix}
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := it.Item().Key()
+			n := 2 + int(key[1])
+			if len(key) <= n || key[n] != serviceIndexID {
+				continue
 			}
-			cache.services[string(service)] = keyTTL
+			tenant := key[2:n]
+			service := key[n+1 : len(key)-sizeOfProfileID-8]
+			cache.putServiceLocked(string(tenant), string(service), it.Item().ExpiresAt())
 		}
 		return nil
 	})
 }
 
-func (cache *cache) PutService(service string, expiresAt uint64) {
+func (cache *cache) PutService(tenant, service string, expiresAt uint64) {
 	cache.mu.Lock()
-	cache.services[service] = expiresAt
+	cache.putServiceLocked(tenant, service, expiresAt)
 	cache.mu.Unlock()
 }
 
-func (cache *cache) Services() []string {
+func (cache *cache) putServiceLocked(tenant, service string, expiresAt uint64) {
+	services := cache.services[tenant]
+	if services == nil {
+		services = make(map[string]uint64)
+		cache.services[tenant] = services
+	}
+	// zero expiresAt means the key never expires
+	if v, ok := services[service]; ok && (v == 0 || (expiresAt != 0 && v > expiresAt)) {
+		return
+	}
+	services[service] = expiresAt
+}
+
+func (cache *cache) Services(tenant string) []string {
 	now := time.Now().Unix()
-	services := make([]string, 0, len(cache.services))
 
 	cache.mu.Lock()
-	for s, v := range cache.services {
+	services := make([]string, 0, len(cache.services[tenant]))
+	for s, v := range cache.services[tenant] {
 		if v > uint64(now) || v == 0 {
 			services = append(services, s)
 		} else {
 			// the key has expired
-			delete(cache.services, s)
+			delete(cache.services[tenant], s)
 		}
 	}
 	cache.mu.Unlock()
@@ -80,3 +101,24 @@ func (cache *cache) Services() []string {
 
 	return services
 }
+
+// Tenants returns the tenants, that have services, which keys haven't expired.
+func (cache *cache) Tenants() []string {
+	now := time.Now().Unix()
+
+	cache.mu.Lock()
+	tenants := make([]string, 0, len(cache.services))
+	for tenant, services := range cache.services {
+		for _, v := range services {
+			if v > uint64(now) || v == 0 {
+				tenants = append(tenants, tenant)
+				break
+			}
+		}
+	}
+	cache.mu.Unlock()
+
+	sort.Strings(tenants)
+
+	return tenants
+}
@@ -20,6 +20,10 @@ import (
 )
 
 const (
+	// the keys of binaries and debug information, used to symbolize profiles, are symbolsPrefix<build id>
+	symbolsPrefix byte = 1 << 4 // 0b00010000
+	// the keys of non-default tenants start with tenantPrefix<len(tenant)><tenant>, followed by the regular key
+	tenantPrefix  byte = 1 << 5 // 0b00100000
 	metaPrefix    byte = 1 << 6 // 0b01000000
 	profilePrefix byte = 1 << 7 // 0b10000000
 )
@@ -61,6 +65,8 @@ type Storage struct {
 	logger *log.Logger
 	db     *badger.DB
 	ttl    time.Duration
+	// returns the retention period of a tenant, overriding ttl if not zero
+	tenantTTL func(tenant string) time.Duration
 	// holds the cache of stored services
 	cache *cache
 }
@@ -76,6 +82,20 @@ func NewStorage(logger *log.Logger, db *badger.DB, ttl time.Duration) *Storage {
 	}
 }
 
+// SetTenantTTL sets the function that returns per-tenant retention period.
+func (st *Storage) SetTenantTTL(tenantTTL func(tenant string) time.Duration) {
+	st.tenantTTL = tenantTTL
+}
+
+func (st *Storage) ttlFor(tenant string) time.Duration {
+	if st.tenantTTL != nil {
+		if ttl := st.tenantTTL(tenant); ttl > 0 {
+			return ttl
+		}
+	}
+	return st.ttl
+}
+
 func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
 	data, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -89,6 +109,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 	}
 	meta := profile.Meta{
 		ProfileID: encodeProfileID(id),
+		Tenant:    params.Tenant,
 		Service:   params.Service,
 		Type:      params.Type,
 		Labels:    params.Labels,
@@ -101,22 +122,24 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 }
 
 func (st *Storage) writeProfileData(ctx context.Context, meta profile.Meta, id, data []byte) error {
+	ttl := st.ttlFor(meta.Tenant)
+
 	var expiresAt uint64
-	if st.ttl > 0 {
-		expiresAt = uint64(time.Now().Add(st.ttl).Unix())
+	if ttl > 0 {
+		expiresAt = uint64(time.Now().Add(ttl).Unix())
 	}
 
 	createdAt := meta.CreatedAt.UnixNano()
 
 	entries := make([]*badger.Entry, 0, 1+1+2+len(meta.Labels)) // 1 for profile entry, 1 for meta entry, 2 for general indexes
 
-	entries = append(entries, st.newBadgerEntry(createProfilePK(id, createdAt), data))
+	entries = append(entries, newBadgerEntry(createProfilePK(meta.Tenant, id, createdAt), data, ttl))
 
 	mk, mv, err := createMetaKV(id, meta)
 	if err != nil {
 		return fmt.Errorf("could not encode meta %v: %w", meta, err)
 	}
-	entries = append(entries, st.newBadgerEntry(mk, mv))
+	entries = append(entries, newBadgerEntry(mk, mv, ttl))
 
 	// indexes
 	indexVal := make([]byte, 0, len(meta.Service)+64)
@@ -124,14 +147,14 @@ func (st *Storage) writeProfileData(ctx context.Context, meta profile.Meta, id,
 	// by-service index
 	{
 		indexVal = append(indexVal, meta.Service...)
-		entries = append(entries, st.newBadgerEntry(createIndexKey(serviceIndexID, indexVal, id, createdAt), nil))
+		entries = append(entries, newBadgerEntry(createIndexKey(meta.Tenant, serviceIndexID, indexVal, id, createdAt), nil, ttl))
 	}
 
 	// by-service-type index
 	{
 		indexVal = append(indexVal[:0], meta.Service...)
 		indexVal = append(indexVal, byte(meta.Type))
-		entries = append(entries, st.newBadgerEntry(createIndexKey(typeIndexID, indexVal, id, createdAt), nil))
+		entries = append(entries, newBadgerEntry(createIndexKey(meta.Tenant, typeIndexID, indexVal, id, createdAt), nil, ttl))
 	}
 
 	// by-labels index
@@ -139,7 +162,7 @@ func (st *Storage) writeProfileData(ctx context.Context, meta profile.Meta, id,
 		for _, label := range meta.Labels {
 			indexVal = append(indexVal[:0], meta.Service...)
 			indexVal = appendLabelKV(indexVal, label.Key, label.Value)
-			entries = append(entries, st.newBadgerEntry(createIndexKey(labelsIndexID, indexVal, id, createdAt), nil))
+			entries = append(entries, newBadgerEntry(createIndexKey(meta.Tenant, labelsIndexID, indexVal, id, createdAt), nil, ttl))
 		}
 	}
 
@@ -156,22 +179,41 @@ func (st *Storage) writeProfileData(ctx context.Context, meta profile.Meta, id,
 		return err
 	}
 
-	st.cache.PutService(meta.Service, expiresAt)
+	st.cache.PutService(meta.Tenant, meta.Service, expiresAt)
 
 	return nil
 }
 
-func (st *Storage) newBadgerEntry(key, val []byte) *badger.Entry {
+func newBadgerEntry(key, val []byte, ttl time.Duration) *badger.Entry {
 	entry := badger.NewEntry(key, val)
-	if st.ttl > 0 {
-		entry = entry.WithTTL(st.ttl)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
 	}
 	return entry
 }
 
-// profile primary key profilePrefix<id><created-at>
-func createProfilePK(id []byte, createdAt int64) []byte {
+// writes the tenant part of the key; the keys of the default tenant don't have the tenant part
+func writeTenantKey(buf *bytes.Buffer, tenant string) {
+	if tenant == "" {
+		return
+	}
+	buf.WriteByte(tenantPrefix)
+	buf.WriteByte(byte(len(tenant)))
+	buf.WriteString(tenant)
+}
+
+func appendTenantKey(b []byte, tenant string) []byte {
+	if tenant == "" {
+		return b
+	}
+	b = append(b, tenantPrefix, byte(len(tenant)))
+	return append(b, tenant...)
+}
+
+// profile primary key [tenant]profilePrefix<id><created-at>
+func createProfilePK(tenant string, id []byte, createdAt int64) []byte {
 	var buf bytes.Buffer
+	writeTenantKey(&buf, tenant)
 	buf.WriteByte(profilePrefix)
 	buf.Write(id)
 	// special case to re-use the function for both write and read
@@ -181,20 +223,26 @@ func createProfilePK(id []byte, createdAt int64) []byte {
 	return buf.Bytes()
 }
 
-// meta primary key metaPrefix<id>, value json-encoded
+// meta primary key [tenant]metaPrefix<id>, value json-encoded
 func createMetaKV(id []byte, meta profile.Meta) ([]byte, []byte, error) {
-	key := make([]byte, 0, len(id)+1)
-	key = append(key, metaPrefix)
-	key = append(key, id...)
+	key := createMetaKey(meta.Tenant, id)
 
 	val, err := json.Marshal(meta)
 
 	return key, val, err
 }
 
-// index key <index-id><index-val><created-at><id>
-func createIndexKey(indexID byte, indexVal []byte, id []byte, createdAt int64) []byte {
+func createMetaKey(tenant string, id []byte) []byte {
+	key := make([]byte, 0, 2+len(tenant)+1+len(id))
+	key = appendTenantKey(key, tenant)
+	key = append(key, metaPrefix)
+	return append(key, id...)
+}
+
+// index key [tenant]<index-id><index-val><created-at><id>
+func createIndexKey(tenant string, indexID byte, indexVal []byte, id []byte, createdAt int64) []byte {
 	var buf bytes.Buffer
+	writeTenantKey(&buf, tenant)
 	buf.WriteByte(indexID)
 	buf.Write(indexVal)
 	binary.Write(&buf, binary.BigEndian, createdAt)
@@ -210,15 +258,23 @@ func appendLabelKV(b []byte, key, val string) []byte {
 	return h.Sum(b)
 }
 
-func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
-	services := st.cache.Services()
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	services := st.cache.Services(tenant)
 	if len(services) == 0 {
 		return nil, storage.ErrNotFound
 	}
 	return services, nil
 }
 
-func (st *Storage) ListProfiles(ctx context.Context, pids []profile.ID) (storage.ProfileList, error) {
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	tenants := st.cache.Tenants()
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return tenants, nil
+}
+
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
 	if len(pids) == 0 {
 		return nil, fmt.Errorf("empty profile ids")
 	}
@@ -229,7 +285,7 @@ func (st *Storage) ListProfiles(ctx context.Context, pids []profile.ID) (storage
 		if err != nil {
 			return nil, err
 		}
-		key := createProfilePK(id, 0)
+		key := createProfilePK(tenant, id, 0)
 		st.logger.Debugw("listProfiles: create key", "pid", pid, log.ByteString("key", key))
 		prefixes = append(prefixes, key)
 	}
@@ -328,8 +384,7 @@ func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfile
 
 	prefixes := make([][]byte, 0, len(rawIds))
 	for _, pid := range rawIds {
-		key := append(make([]byte, 0, 1+len(pid)), metaPrefix)
-		key = append(key, pid...)
+		key := createMetaKey(params.Tenant, pid)
 		st.logger.Debugw("findProfiles: create key", log.ByteString("key", key))
 		prefixes = append(prefixes, key)
 	}
@@ -400,6 +455,7 @@ func (st *Storage) findRawProfileIDs(ctx context.Context, params *storage.FindPr
 	indexesToScan := make([][]byte, 0, 1)
 	{
 		indexKey := make([]byte, 0, 64)
+		indexKey = appendTenantKey(indexKey, params.Tenant)
 		if params.Type != profile.TypeUnknown {
 			// by-service-type
 			indexKey = append(indexKey, typeIndexID)
@@ -416,7 +472,8 @@ func (st *Storage) findRawProfileIDs(ctx context.Context, params *storage.FindPr
 		// by-service-labels
 		if len(params.Labels) != 0 {
 			for _, label := range params.Labels {
-				indexKey := make([]byte, 0, 2+len(params.Service)+len(label.Key)+len(label.Value))
+				indexKey := make([]byte, 0, 2+len(params.Tenant)+2+len(params.Service)+len(label.Key)+len(label.Value))
+				indexKey = appendTenantKey(indexKey, params.Tenant)
 				indexKey = append(indexKey, labelsIndexID)
 				indexKey = append(indexKey, params.Service...)
 				indexKey = appendLabelKV(indexKey, label.Key, label.Value)
@@ -543,3 +600,67 @@ func mergeJoinIDs(ids [][][]byte, params *storage.FindProfilesParams) [][]byte {
 
 	return mergedIDs
 }
+
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the profiles, together with their metas and index keys, of the tenant.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	for _, pid := range pids {
+		id, err := decodeProfileID(pid)
+		if err != nil {
+			return err
+		}
+		if err := st.deleteProfile(tenant, id); err != nil {
+			return fmt.Errorf("could not delete profile %q: %w", pid, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *Storage) deleteProfile(tenant string, id []byte) error {
+	return st.db.Update(func(txn *badger.Txn) error {
+		mk := createMetaKey(tenant, id)
+		item, err := txn.Get(mk)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var meta profile.Meta
+		err = item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &meta)
+		})
+		if err != nil {
+			return err
+		}
+
+		createdAt := meta.CreatedAt.UnixNano()
+
+		keys := make([][]byte, 0, 1+1+2+len(meta.Labels))
+		keys = append(keys, createProfilePK(tenant, id, createdAt), mk)
+
+		indexVal := append([]byte{}, meta.Service...)
+		keys = append(keys, createIndexKey(tenant, serviceIndexID, indexVal, id, createdAt))
+
+		indexVal = append(indexVal, byte(meta.Type))
+		keys = append(keys, createIndexKey(tenant, typeIndexID, indexVal, id, createdAt))
+
+		for _, label := range meta.Labels {
+			indexVal = append(indexVal[:0], meta.Service...)
+			indexVal = appendLabelKV(indexVal, label.Key, label.Value)
+			keys = append(keys, createIndexKey(tenant, labelsIndexID, indexVal, id, createdAt))
+		}
+
+		for _, key := range keys {
+			st.logger.Debugw("deleteProfile: delete key", log.ByteString("key", key))
+			if err := txn.Delete(key); err != nil {
+				return fmt.Errorf("could not delete key: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,53 @@
+package badger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/dgraph-io/badger"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+var _ storage.SymbolStore = (*Storage)(nil)
+
+// PutSymbols stores the binary or debug information. Unlike profiles, the symbols never expire.
+func (st *Storage) PutSymbols(ctx context.Context, buildID string, r io.Reader) error {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return st.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(createSymbolsKey(buildID), data)
+	})
+}
+
+func (st *Storage) GetSymbols(ctx context.Context, buildID string) (data []byte, err error) {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return nil, err
+	}
+
+	err = st.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(createSymbolsKey(buildID))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return nil, storage.ErrNotFound
+	}
+	return data, err
+}
+
+func createSymbolsKey(buildID string) []byte {
+	key := make([]byte, 0, 1+len(buildID))
+	key = append(key, symbolsPrefix)
+	return append(key, buildID...)
+}
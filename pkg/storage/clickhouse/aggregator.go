@@ -0,0 +1,288 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// samples don't have a tenant, thus only the samples of the tenant's profiles are aggregated
+const (
+	sqlSelectMergedProfiles = `
+		SELECT
+			count(),
+			any(profile_type),
+			min(created_at),
+			any(period_type),
+			any(period_unit),
+			max(period),
+			sum(duration_nanos)
+		FROM (
+			SELECT profile_key, profile_type, created_at, period_type, period_unit, period, duration_nanos
+			FROM pprof_profiles
+			WHERE tenant = ? AND profile_key IN (%s)
+			LIMIT 1 BY profile_key
+		);`
+
+	sqlSelectMergedSamples = `
+		SELECT
+			any(if(stack_fingerprint = 0, locations.func_name, stack_func_name)),
+			any(if(stack_fingerprint = 0, locations.file_name, stack_file_name)),
+			any(if(stack_fingerprint = 0, locations.lineno, stack_lineno)),
+			sumForEach(values),
+			any(values_type),
+			any(values_unit),
+			any(labels.key),
+			any(labels.value)
+		FROM pprof_samples FINAL
+		%s
+		WHERE %s
+		GROUP BY fingerprint;`
+
+	// every function is counted once per sample, even if the stack is recursive
+	sqlSelectTopFunctions = `
+		WITH %s AS value
+		SELECT
+			func,
+			sum(if(func = funcs[1], value, 0)) AS flat,
+			sum(value) AS cum
+		FROM (
+			SELECT
+				if(stack_fingerprint = 0, locations.func_name, stack_func_name) AS funcs,
+				values,
+				values_type
+			FROM pprof_samples FINAL
+			%s
+			WHERE %s
+		)
+		ARRAY JOIN arrayDistinct(funcs) AS func
+		GROUP BY func
+		ORDER BY flat DESC, func
+		LIMIT %d;`
+)
+
+var _ storage.Aggregator = (*Storage)(nil)
+
+// MergeProfiles merges the samples of the profiles, that have the same fingerprint, i.e. the same stack and labels.
+// The merged profile has no mappings, and the addresses of its locations are lost.
+func (st *Storage) MergeProfiles(ctx context.Context, params *storage.AggregateParams) (*pprofProfile.Profile, error) {
+	keys, keyArgs, err := sqlProfileKeys(params.ProfileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	prec, err := st.selectMergedProfiles(ctx, params.Tenant, keys, keyArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	labelsCond, labelsArgs := sqlHasAllSampleLabels(params.SampleLabels)
+	cond, condArgs := sqlTenantSamples(params.Tenant, keys, keyArgs, labelsCond, labelsArgs)
+	query := fmt.Sprintf(sqlSelectMergedSamples, fmt.Sprintf(sqlJoinStacks, cond), cond)
+
+	// the condition goes to the stacks' join as well
+	args := make([]interface{}, 0, 2*len(condArgs))
+	args = append(args, condArgs...)
+	args = append(args, condArgs...)
+
+	st.logger.Debugw("mergeProfiles: query samples", log.MultiLine("query", query), "args", args)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pb := newProfileBuilder(prec)
+	for rows.Next() {
+		var srec sampleRecord
+		err := rows.Scan(
+			&srec.funcs,
+			&srec.files,
+			&srec.lines,
+			&srec.values,
+			&srec.valueTypes,
+			&srec.valueUnits,
+			&srec.labelKeys,
+			&srec.labelVals,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := pb.AddSample(&srec); err != nil {
+			return nil, fmt.Errorf("could not merge %d profiles: %w", len(params.ProfileIDs), err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pp, err := pb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not merge %d profiles: %w", len(params.ProfileIDs), err)
+	}
+	return pp, nil
+}
+
+func (st *Storage) selectMergedProfiles(ctx context.Context, tenant string, keys string, keyArgs []interface{}) (*profileRecord, error) {
+	query := fmt.Sprintf(sqlSelectMergedProfiles, keys)
+
+	args := make([]interface{}, 0, 1+len(keyArgs))
+	args = append(args, tenant)
+	args = append(args, keyArgs...)
+
+	st.logger.Debugw("mergeProfiles: query profiles", log.MultiLine("query", query), "args", args)
+
+	var (
+		prec  profileRecord
+		n     uint64
+		ptype string // clickhouse returns string value for enums
+	)
+	err := st.db.QueryRowContext(ctx, query, args...).Scan(
+		&n,
+		&ptype,
+		&prec.createdAt,
+		&prec.periodType,
+		&prec.periodUnit,
+		&prec.period,
+		&prec.durationNanos,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	if err := prec.ptype.FromString(ptype); err != nil {
+		return nil, err
+	}
+
+	return &prec, nil
+}
+
+// TopFunctions returns the functions of the innermost frames of the samples, the functions of the inlined frames
+// aren't counted.
+func (st *Storage) TopFunctions(ctx context.Context, params *storage.AggregateParams, sampleType string, limit int) ([]storage.FunctionTotal, error) {
+	query, args, err := buildSQLSelectTopFunctions(params, sampleType, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	st.logger.Debugw("topFunctions: query functions", log.MultiLine("query", query), "args", args)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]storage.FunctionTotal, 0, limit)
+	for rows.Next() {
+		var ft storage.FunctionTotal
+		if err := rows.Scan(&ft.Name, &ft.Flat, &ft.Cum); err != nil {
+			return nil, err
+		}
+		totals = append(totals, ft)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// builds SELECT top functions SQL query and its corresponding arguments
+func buildSQLSelectTopFunctions(params *storage.AggregateParams, sampleType string, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, fmt.Errorf("bad limit %d", limit)
+	}
+
+	keys, keyArgs, err := sqlProfileKeys(params.ProfileIDs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	labelsCond, labelsArgs := sqlHasAllSampleLabels(params.SampleLabels)
+	if sampleType != "" {
+		labelsCond += " AND has(values_type, ?)"
+		labelsArgs = append(labelsArgs, sampleType)
+	}
+	cond, condArgs := sqlTenantSamples(params.Tenant, keys, keyArgs, labelsCond, labelsArgs)
+
+	args := make([]interface{}, 0, 1+2*len(condArgs))
+
+	// the default sample type is the last one
+	valueExpr := "values[-1]"
+	if sampleType != "" {
+		valueExpr = "values[indexOf(values_type, ?)]"
+		args = append(args, sampleType)
+	}
+
+	// the condition goes to the stacks' join as well
+	args = append(args, condArgs...)
+	args = append(args, condArgs...)
+
+	query := fmt.Sprintf(sqlSelectTopFunctions, valueExpr, fmt.Sprintf(sqlJoinStacks, cond), cond, limit)
+
+	return query, args, nil
+}
+
+// returns the placeholders of the profile keys, for "profile_key IN (...)" condition, and their arguments
+func sqlProfileKeys(pids []profile.ID) (string, []interface{}, error) {
+	if len(pids) == 0 {
+		return "", nil, fmt.Errorf("empty profile ids")
+	}
+
+	args := make([]interface{}, 0, len(pids))
+	for _, pid := range pids {
+		pk, err := decodeProfileKey(pid)
+		if err != nil {
+			return "", nil, err
+		}
+		// profile keys are binary, pass them as hex-encoded strings
+		args = append(args, hex.EncodeToString(pk[:]))
+	}
+
+	keys := strings.TrimSuffix(strings.Repeat("unhex(?), ", len(pids)), ", ")
+
+	return keys, args, nil
+}
+
+// returns the condition, that selects the samples of the tenant's profiles, further filtered by the labels' condition,
+// and its arguments
+func sqlTenantSamples(tenant string, keys string, keyArgs []interface{}, labelsCond string, labelsArgs []interface{}) (string, []interface{}) {
+	cond := fmt.Sprintf("profile_key IN (SELECT profile_key FROM pprof_profiles WHERE tenant = ? AND profile_key IN (%s)) %s", keys, labelsCond)
+
+	args := make([]interface{}, 0, 1+len(keyArgs)+len(labelsArgs))
+	args = append(args, tenant)
+	args = append(args, keyArgs...)
+	args = append(args, labelsArgs...)
+
+	return cond, args
+}
+
+// returns the condition, that selects the samples having all the labels, and its arguments
+func sqlHasAllSampleLabels(labels profile.Labels) (string, []interface{}) {
+	if len(labels) == 0 {
+		return "", nil
+	}
+
+	// AND hasAll(arrayZip(labels.key, labels.value), [('endpoint', '/checkout')])
+	placeholders := make([]string, 0, len(labels))
+	args := make([]interface{}, 0, 2*len(labels))
+	for _, label := range labels {
+		placeholders = append(placeholders, "(?, ?)")
+		args = append(args, label.Key, label.Value)
+	}
+	cond := fmt.Sprintf("AND hasAll(arrayZip(labels.key, labels.value), [%s])", strings.Join(placeholders, ","))
+
+	return cond, args
+}
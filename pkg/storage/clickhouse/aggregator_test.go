@@ -0,0 +1,51 @@
+package clickhouse
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSQLSelectTopFunctions(t *testing.T) {
+	pk := NewProfileKey(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	params := &storage.AggregateParams{
+		Tenant:       "t1",
+		ProfileIDs:   []profile.ID{profile.ID(pk.String())},
+		SampleLabels: profile.Labels{{Key: "endpoint", Value: "/checkout"}},
+	}
+
+	t.Run("default sample type", func(t *testing.T) {
+		query, args, err := buildSQLSelectTopFunctions(params, "", 10)
+		require.NoError(t, err)
+
+		assert.Contains(t, query, "WITH values[-1] AS value")
+		assert.Contains(t, query, "profile_key IN (unhex(?))")
+		assert.Contains(t, query, "AND hasAll(arrayZip(labels.key, labels.value), [(?, ?)])")
+		assert.Contains(t, query, "LEFT JOIN (")
+		assert.Contains(t, query, "LIMIT 10;")
+
+		// the arguments of the samples' condition go to the stacks' join first
+		condArgs := []interface{}{"t1", hex.EncodeToString(pk[:]), "endpoint", "/checkout"}
+		assert.Equal(t, append(condArgs, condArgs...), args)
+	})
+
+	t.Run("sample type", func(t *testing.T) {
+		query, args, err := buildSQLSelectTopFunctions(params, "alloc_space", 5)
+		require.NoError(t, err)
+
+		assert.Contains(t, query, "WITH values[indexOf(values_type, ?)] AS value")
+		assert.Contains(t, query, "AND has(values_type, ?)")
+		condArgs := []interface{}{"t1", hex.EncodeToString(pk[:]), "endpoint", "/checkout", "alloc_space"}
+		assert.Equal(t, append([]interface{}{"alloc_space"}, append(condArgs, condArgs...)...), args)
+	})
+
+	t.Run("no profile ids", func(t *testing.T) {
+		_, _, err := buildSQLSelectTopFunctions(&storage.AggregateParams{}, "", 10)
+		assert.Error(t, err)
+	})
+}
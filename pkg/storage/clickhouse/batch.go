@@ -0,0 +1,402 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	tablePprofProfiles = "pprof_profiles"
+	tablePprofSamples  = "pprof_samples"
+	tablePprofStacks   = "pprof_stacks"
+
+	// an insert of a batch mustn't block the writer forever, e.g. if the db is unavailable
+	batchFlushTimeout = time.Minute
+)
+
+// the delay before the first retry of a failed insert of a batch, doubled by every next retry
+var batchRetryBackoff = time.Second
+
+var errBatchWriterClosed = errors.New("batch writer is closed")
+
+var sqlInsertBatch = map[string]string{
+	tablePprofProfiles: sqlInsertPprofProfiles,
+	tablePprofSamples:  sqlInsertPprofSamples,
+	tablePprofStacks:   sqlInsertPprofStacks,
+}
+
+// the order the tables of a batch are flushed in; the rows of a table reference the rows of the previous tables
+var batchTables = []string{tablePprofStacks, tablePprofSamples, tablePprofProfiles}
+
+type BatchConfig struct {
+	// number of rows, the batch is flushed once it has (zero means don't batch the writes)
+	Size int
+	// interval the batch is flushed in, even if it isn't full
+	FlushInterval time.Duration
+	// number of the writes waiting to be batched; the writes block once the queue is full
+	QueueSize int
+	// number of times a failed insert of a batch is retried, before the batch is dropped
+	FlushRetries int
+}
+
+func (conf BatchConfig) validate() error {
+	if conf.FlushInterval <= 0 {
+		return fmt.Errorf("batch flush interval must be positive, got %v", conf.FlushInterval)
+	}
+	if conf.QueueSize <= 0 {
+		return fmt.Errorf("batch queue size must be positive, got %d", conf.QueueSize)
+	}
+	if conf.FlushRetries < 0 {
+		return fmt.Errorf("batch flush retries must not be negative, got %d", conf.FlushRetries)
+	}
+	return nil
+}
+
+type batchMetrics struct {
+	queueDepth    *prometheus.GaugeVec
+	flushDuration *prometheus.HistogramVec
+	flushedRows   *prometheus.CounterVec
+}
+
+func newBatchMetrics(registry prometheus.Registerer) *batchMetrics {
+	m := &batchMetrics{
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "profefe",
+			Name:      "clickhouse_batch_queue_depth",
+			Help:      "Number of rows waiting to be flushed to ClickHouse.",
+		}, []string{"table"}),
+		flushDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "profefe",
+			Name:      "clickhouse_batch_flush_duration_seconds",
+			Help:      "Duration of flushing a batch of rows to ClickHouse.",
+		}, []string{"table"}),
+		flushedRows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "profefe",
+			Name:      "clickhouse_batch_flushed_rows_total",
+			Help:      "Number of rows flushed to ClickHouse by result.",
+		}, []string{"table", "result"}),
+	}
+	registry.MustRegister(m.queueDepth, m.flushDuration, m.flushedRows)
+	return m
+}
+
+// a write of a profile's rows to a table, waiting to be batched
+type batchItem struct {
+	table  string
+	pk     ProfileKey
+	rows   int
+	insert func(ctx context.Context, stmt *sql.Stmt) error
+	// if not nil, called once the rows of the item are stored
+	stored func()
+
+	// if not nil, the item isn't a write, but a request to flush the batch, that gets the result of the flush
+	flushed chan error
+}
+
+// inserts the batched rows of the table
+type batchInserter func(ctx context.Context, table string, items []batchItem) error
+
+// inserts the batched rows of the table within a single transaction, i.e. as a single block
+func insertBatch(db *sql.DB) batchInserter {
+	return func(ctx context.Context, table string, items []batchItem) error {
+		return withinTx(ctx, db, func(tx *sql.Tx) error {
+			stmt, err := tx.PrepareContext(ctx, sqlInsertBatch[table])
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err := item.insert(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			return stmt.Close()
+		})
+	}
+}
+
+// batchWriter accumulates the rows of the profiles and their samples, and inserts them in batches, once the batch
+// is full or every flush interval. The tables of a batch are flushed in the order of batchTables; the rows of
+// a table aren't flushed if the rows of a previous table couldn't be.
+//
+// The writes are acknowledged before their rows are stored. A failed insert is retried with the backoff, and
+// the rows of the batch are dropped, once the retries are exhausted; Flush waits for the rows to be stored.
+// The insert, that failed after the rows were stored, stores them again, thus the reads skip the duplicate rows.
+type batchWriter struct {
+	logger  *log.Logger
+	conf    BatchConfig
+	metrics *batchMetrics
+	insert  batchInserter
+
+	pw *profilesWriter
+	sw *samplesWriter
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan batchItem
+	done   chan struct{}
+}
+
+var (
+	_ ProfilesWriter = (*batchWriter)(nil)
+	_ SamplesWriter  = (*batchWriter)(nil)
+)
+
+func withBatching(conf BatchConfig, logger *log.Logger, metrics *batchMetrics, insert batchInserter) *batchWriter {
+	w := &batchWriter{
+		logger:  logger,
+		conf:    conf,
+		metrics: metrics,
+		insert:  insert,
+
+		pw: &profilesWriter{logger: logger},
+		sw: &samplesWriter{logger: logger, written: newWrittenStacks(writtenStacksCacheSize)},
+
+		queue: make(chan batchItem, conf.QueueSize),
+		done:  make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *batchWriter) WriteProfile(
+	ctx context.Context,
+	pk ProfileKey,
+	ptype ProfileType,
+	createdAt time.Time,
+	params *storage.WriteProfileParams,
+	pp *pprofProfile.Profile,
+) error {
+	return w.enqueue(ctx, batchItem{
+		table: tablePprofProfiles,
+		pk:    pk,
+		rows:  1,
+		insert: func(ctx context.Context, stmt *sql.Stmt) error {
+			return w.pw.execInsertPprofProfiles(ctx, stmt, pk, ptype, createdAt, params, pp)
+		},
+	})
+}
+
+func (w *batchWriter) WriteSamples(ctx context.Context, pk ProfileKey, samples []*pprofProfile.Sample, sampleTypes []*pprofProfile.ValueType) error {
+	stacks := stackFingerprints(samples)
+
+	// the stacks are remembered as written, once they are flushed; until then, the following writes may write
+	// them again
+	if newStacks := w.sw.newStacks(pk, stacks); len(newStacks) > 0 {
+		err := w.enqueue(ctx, batchItem{
+			table: tablePprofStacks,
+			pk:    pk,
+			rows:  len(newStacks),
+			insert: func(ctx context.Context, stmt *sql.Stmt) error {
+				return w.sw.execInsertPprofStacks(ctx, stmt, pk, samples, stacks, newStacks)
+			},
+			stored: func() {
+				w.sw.stacksWritten(pk, stacks, newStacks)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return w.enqueue(ctx, batchItem{
+		table: tablePprofSamples,
+		pk:    pk,
+		rows:  len(samples),
+		insert: func(ctx context.Context, stmt *sql.Stmt) error {
+			return w.sw.execInsertPprofSamples(ctx, stmt, pk, samples, sampleTypes, stacks)
+		},
+	})
+}
+
+// blocks until the item is queued, i.e. the writes are slowed down, if the batches aren't flushed fast enough
+func (w *batchWriter) enqueue(ctx context.Context, item batchItem) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return errBatchWriterClosed
+	}
+
+	select {
+	case w.queue <- item:
+		if item.flushed == nil {
+			w.metrics.queueDepth.WithLabelValues(item.table).Add(float64(item.rows))
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *batchWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.conf.FlushInterval)
+	defer ticker.Stop()
+
+	var (
+		batch []batchItem
+		rows  int
+	)
+	for {
+		select {
+		case item, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			if item.flushed != nil {
+				item.flushed <- w.flush(batch)
+				batch, rows = nil, 0
+				continue
+			}
+			batch = append(batch, item)
+			rows += item.rows
+			if rows < w.conf.Size {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		w.flush(batch)
+		batch, rows = nil, 0
+	}
+}
+
+// flushes the batch; returns the error, the rows of the batch were dropped with
+func (w *batchWriter) flush(batch []batchItem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	items := make(map[string][]batchItem, len(batchTables))
+	for _, item := range batch {
+		items[item.table] = append(items[item.table], item)
+	}
+
+	var err error
+	for _, table := range batchTables {
+		if err != nil {
+			w.dropTable(table, items[table], err)
+			continue
+		}
+		err = w.flushTable(table, items[table])
+	}
+	return err
+}
+
+func (w *batchWriter) flushTable(table string, items []batchItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	var rows int
+	for _, item := range items {
+		rows += item.rows
+	}
+	defer w.metrics.queueDepth.WithLabelValues(table).Sub(float64(rows))
+
+	start := time.Now()
+	err := w.insertWithRetries(table, items)
+	w.metrics.flushDuration.WithLabelValues(table).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		w.metrics.flushedRows.WithLabelValues(table, "failure").Add(float64(rows))
+		w.logger.Errorw("batchWriter failed to flush batch", "table", table, "profiles_total", len(items), "rows_total", rows, zap.Error(err))
+		return err
+	}
+
+	w.metrics.flushedRows.WithLabelValues(table, "success").Add(float64(rows))
+	for _, item := range items {
+		if item.stored != nil {
+			item.stored()
+		}
+	}
+	w.logger.Debugw("batchWriter: flushed batch", "table", table, "profiles_total", len(items), "rows_total", rows, "duration", time.Since(start))
+
+	return nil
+}
+
+// inserts the rows of the table, retrying the failed inserts with the backoff
+func (w *batchWriter) insertWithRetries(table string, items []batchItem) (err error) {
+	backoff := batchRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err = w.insertOnce(table, items); err == nil || attempt == w.conf.FlushRetries {
+			return err
+		}
+
+		w.logger.Warnw("batchWriter: insert failed, retrying", "table", table, "attempt", attempt+1, "backoff", backoff, zap.Error(err))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (w *batchWriter) insertOnce(table string, items []batchItem) error {
+	// the insert mustn't be bound to the context of any write, as the batch has the rows of many writes
+	ctx, cancel := context.WithTimeout(context.Background(), batchFlushTimeout)
+	defer cancel()
+
+	return w.insert(ctx, table, items)
+}
+
+func (w *batchWriter) dropTable(table string, items []batchItem, err error) {
+	if len(items) == 0 {
+		return
+	}
+
+	var rows int
+	for _, item := range items {
+		rows += item.rows
+	}
+	w.metrics.queueDepth.WithLabelValues(table).Sub(float64(rows))
+	w.metrics.flushedRows.WithLabelValues(table, "failure").Add(float64(rows))
+
+	w.logger.Errorw("batchWriter dropped batch, as the rows it references weren't flushed", "table", table, "profiles_total", len(items), "rows_total", rows, zap.Error(err))
+}
+
+// Flush flushes the rows of the writes, acknowledged before the call; returns the error if the rows of the batch
+// were dropped.
+func (w *batchWriter) Flush(ctx context.Context) error {
+	flushed := make(chan error, 1)
+	if err := w.enqueue(ctx, batchItem{flushed: flushed}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-flushed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting the writes, and flushes the queued rows.
+func (w *batchWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	w.logger.Infow("batchWriter: waiting queued rows to be flushed")
+	<-w.done
+
+	return nil
+}
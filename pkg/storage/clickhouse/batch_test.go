@@ -0,0 +1,284 @@
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type flushedBatch struct {
+	table string
+	pks   []ProfileKey
+	rows  int
+}
+
+type testInserter struct {
+	mu      sync.Mutex
+	batches []flushedBatch
+	// fails the inserts of the table
+	failTable string
+	// the number of the inserts of the table, that fail; every insert fails if zero
+	failTimes int
+	// the number of the inserts of the table, that fail after the rows are stored
+	failStoredTimes int
+}
+
+func (ins *testInserter) insert(ctx context.Context, table string, items []batchItem) error {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+
+	if table == ins.failTable {
+		if ins.failTimes > 0 {
+			ins.failTimes--
+			if ins.failTimes == 0 {
+				ins.failTable = ""
+			}
+		}
+		return errors.New("insert failed")
+	}
+
+	b := flushedBatch{table: table}
+	for _, item := range items {
+		b.pks = append(b.pks, item.pk)
+		b.rows += item.rows
+	}
+	ins.batches = append(ins.batches, b)
+
+	if table == tablePprofSamples && ins.failStoredTimes > 0 {
+		ins.failStoredTimes--
+		return errors.New("insert timed out")
+	}
+	return nil
+}
+
+func (ins *testInserter) Batches() []flushedBatch {
+	ins.mu.Lock()
+	defer ins.mu.Unlock()
+	return append([]flushedBatch(nil), ins.batches...)
+}
+
+func newTestBatchWriter(t *testing.T, conf BatchConfig, insert batchInserter) (*batchWriter, *batchMetrics) {
+	metrics := newBatchMetrics(prometheus.NewRegistry())
+	return withBatching(conf, log.New(zaptest.NewLogger(t)), metrics, insert), metrics
+}
+
+// returns the samples, every sample has its own stack
+func newTestSamples(n int) []*pprofProfile.Sample {
+	samples := make([]*pprofProfile.Sample, n)
+	for i := range samples {
+		samples[i] = &pprofProfile.Sample{
+			Location: []*pprofProfile.Location{{Address: uint64(i + 1)}},
+			Value:    []int64{1},
+		}
+	}
+	return samples
+}
+
+func writeTestProfile(t *testing.T, w *batchWriter, pk ProfileKey, nsamples int) {
+	require.NoError(t, w.WriteSamples(context.Background(), pk, newTestSamples(nsamples), nil))
+	require.NoError(t, w.WriteProfile(context.Background(), pk, TypeCPU, time.Now(), &storage.WriteProfileParams{}, &pprofProfile.Profile{}))
+}
+
+func TestBatchWriter_flushBySize(t *testing.T) {
+	ins := &testInserter{}
+	w, metrics := newTestBatchWriter(t, BatchConfig{Size: 10, FlushInterval: time.Hour, QueueSize: 10}, ins.insert)
+
+	pk1, pk2 := NewProfileKey(time.Now()), NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk1, 2)
+	writeTestProfile(t, w, pk2, 2)
+
+	require.Eventually(t, func() bool {
+		return len(ins.Batches()) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// stacks are flushed before the samples, and the samples before the profiles
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk1, pk2}, rows: 4},
+		{table: tablePprofSamples, pks: []ProfileKey{pk1, pk2}, rows: 4},
+		{table: tablePprofProfiles, pks: []ProfileKey{pk1, pk2}, rows: 2},
+	}, ins.Batches())
+
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.queueDepth.WithLabelValues(tablePprofSamples)))
+	assert.Equal(t, float64(4), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofSamples, "success")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofProfiles, "success")))
+}
+
+func TestBatchWriter_flushByInterval(t *testing.T) {
+	ins := &testInserter{}
+	w, _ := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: 10 * time.Millisecond, QueueSize: 10}, ins.insert)
+	defer w.Close()
+
+	pk := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk, 2)
+
+	require.Eventually(t, func() bool {
+		return len(ins.Batches()) == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBatchWriter_Close(t *testing.T) {
+	ins := &testInserter{}
+	w, _ := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10}, ins.insert)
+
+	pk := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk, 2)
+
+	require.NoError(t, w.Close())
+
+	// queued rows are flushed on close
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk}, rows: 2},
+		{table: tablePprofSamples, pks: []ProfileKey{pk}, rows: 2},
+		{table: tablePprofProfiles, pks: []ProfileKey{pk}, rows: 1},
+	}, ins.Batches())
+
+	err := w.WriteSamples(context.Background(), pk, nil, nil)
+	assert.Equal(t, errBatchWriterClosed, err)
+
+	require.NoError(t, w.Close())
+}
+
+func TestBatchWriter_samplesFailed(t *testing.T) {
+	ins := &testInserter{failTable: tablePprofSamples}
+	w, metrics := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10}, ins.insert)
+
+	pk := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk, 2)
+
+	require.NoError(t, w.Close())
+
+	// profiles aren't flushed without their samples
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk}, rows: 2},
+	}, ins.Batches())
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.queueDepth.WithLabelValues(tablePprofProfiles)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofSamples, "failure")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofProfiles, "failure")))
+}
+
+func TestBatchWriter_retry(t *testing.T) {
+	defer func(backoff time.Duration) { batchRetryBackoff = backoff }(batchRetryBackoff)
+	batchRetryBackoff = time.Millisecond
+
+	ins := &testInserter{failTable: tablePprofSamples, failTimes: 2}
+	w, metrics := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10, FlushRetries: 2}, ins.insert)
+
+	pk := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk, 2)
+
+	require.NoError(t, w.Close())
+
+	// the failed inserts are retried
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk}, rows: 2},
+		{table: tablePprofSamples, pks: []ProfileKey{pk}, rows: 2},
+		{table: tablePprofProfiles, pks: []ProfileKey{pk}, rows: 1},
+	}, ins.Batches())
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofSamples, "failure")))
+}
+
+func TestBatchWriter_retryStored(t *testing.T) {
+	defer func(backoff time.Duration) { batchRetryBackoff = backoff }(batchRetryBackoff)
+	batchRetryBackoff = time.Millisecond
+
+	ins := &testInserter{failStoredTimes: 1}
+	w, metrics := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10, FlushRetries: 2}, ins.insert)
+
+	pk1, pk2 := NewProfileKey(time.Now()), NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk1, 2)
+	writeTestProfile(t, w, pk2, 3)
+
+	require.NoError(t, w.Close())
+
+	// the insert, that failed after the rows were stored, is retried with the same rows, which the reads
+	// skip as the duplicates
+	samples := flushedBatch{table: tablePprofSamples, pks: []ProfileKey{pk1, pk2}, rows: 5}
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk1, pk2}, rows: 5},
+		samples,
+		samples,
+		{table: tablePprofProfiles, pks: []ProfileKey{pk1, pk2}, rows: 2},
+	}, ins.Batches())
+	assert.Equal(t, float64(5), testutil.ToFloat64(metrics.flushedRows.WithLabelValues(tablePprofSamples, "success")))
+}
+
+func TestBatchWriter_Flush(t *testing.T) {
+	ins := &testInserter{}
+	w, _ := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10}, ins.insert)
+	defer w.Close()
+
+	pk := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk, 2)
+
+	// the acknowledged writes are stored once the flush returns
+	require.NoError(t, w.Flush(context.Background()))
+	assert.Len(t, ins.Batches(), 3)
+
+	ins.mu.Lock()
+	ins.failTable = tablePprofProfiles
+	ins.mu.Unlock()
+
+	writeTestProfile(t, w, pk, 2)
+	assert.Error(t, w.Flush(context.Background()))
+}
+
+func TestBatchWriter_writtenStacks(t *testing.T) {
+	ins := &testInserter{}
+	w, _ := newTestBatchWriter(t, BatchConfig{Size: 1000, FlushInterval: time.Hour, QueueSize: 10}, ins.insert)
+	defer w.Close()
+
+	// the stacks aren't remembered until they are flushed
+	pk1, pk2 := NewProfileKey(time.Now()), NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk1, 2)
+	writeTestProfile(t, w, pk2, 2)
+	require.NoError(t, w.Flush(context.Background()))
+
+	// only the stacks, that weren't written yet, are written
+	pk3 := NewProfileKey(time.Now())
+	writeTestProfile(t, w, pk3, 3)
+	require.NoError(t, w.Flush(context.Background()))
+
+	assert.Equal(t, []flushedBatch{
+		{table: tablePprofStacks, pks: []ProfileKey{pk1, pk2}, rows: 4},
+		{table: tablePprofSamples, pks: []ProfileKey{pk1, pk2}, rows: 4},
+		{table: tablePprofProfiles, pks: []ProfileKey{pk1, pk2}, rows: 2},
+		{table: tablePprofStacks, pks: []ProfileKey{pk3}, rows: 1},
+		{table: tablePprofSamples, pks: []ProfileKey{pk3}, rows: 3},
+		{table: tablePprofProfiles, pks: []ProfileKey{pk3}, rows: 1},
+	}, ins.Batches())
+}
+
+func TestBatchWriter_backpressure(t *testing.T) {
+	unblock := make(chan struct{})
+	insert := func(ctx context.Context, table string, items []batchItem) error {
+		<-unblock
+		return nil
+	}
+	w, _ := newTestBatchWriter(t, BatchConfig{Size: 1, FlushInterval: time.Hour, QueueSize: 1}, insert)
+
+	pk := NewProfileKey(time.Now())
+	// the stacks of the first write are being flushed, its samples fill the queue
+	require.NoError(t, w.WriteSamples(context.Background(), pk, newTestSamples(1), nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := w.WriteSamples(ctx, pk, newTestSamples(1), nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(unblock)
+	require.NoError(t, w.Close())
+}
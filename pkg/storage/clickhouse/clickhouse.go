@@ -1,27 +1,55 @@
 package clickhouse
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"io"
+	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go"
 	"github.com/profefe/profefe/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Config struct {
 	DSN string
 
 	SamplesWriterPoolSize int
+
+	Batch BatchConfig
+
+	Migrate bool
+	Schema  SchemaConfig
 }
 
 func (conf *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&conf.DSN, "clickhouse.dsn", "", "clickhouse dsn")
 	f.IntVar(&conf.SamplesWriterPoolSize, "clickhouse.samples-writer.pool-size", 0, "samples writer workers pool size (zero means don't use pool)")
+
+	f.IntVar(&conf.Batch.Size, "clickhouse.batch.size", 0, "number of rows the writes are batched by (zero means don't batch the writes)")
+	f.DurationVar(&conf.Batch.FlushInterval, "clickhouse.batch.flush-interval", time.Second, "interval the batched rows are flushed in")
+	f.IntVar(&conf.Batch.QueueSize, "clickhouse.batch.queue-size", 1000, "number of the writes waiting to be batched, the writes are blocked once the queue is full")
+	f.IntVar(&conf.Batch.FlushRetries, "clickhouse.batch.flush-retries", 3, "number of times a failed insert of a batch is retried with exponential backoff, before the batch is dropped")
+
+	f.BoolVar(&conf.Migrate, "clickhouse.schema.migrate", true, "create and migrate the schema on start (the schema is only checked if false)")
+	f.StringVar(&conf.Schema.Cluster, "clickhouse.schema.cluster", "", "cluster the replicated tables are created on (tables aren't replicated if empty)")
+	f.StringVar(&conf.Schema.ReplicaPath, "clickhouse.schema.replica-path", defaultReplicaPath, "zookeeper path of the replicated tables")
+	f.StringVar(&conf.Schema.PartitionBy, "clickhouse.schema.partition-by", defaultPartitionBy, "partition key of the profiles table, applied when the table is created")
+	f.DurationVar(&conf.Schema.TTL, "clickhouse.data-ttl", 0, "clickhouse data ttl (data is kept forever if zero)")
 }
 
-func (conf *Config) CreateStorage(logger *log.Logger) (*Storage, io.Closer, error) {
+func (conf *Config) CreateStorage(logger *log.Logger, registry prometheus.Registerer) (*Storage, io.Closer, error) {
+	if conf.Batch.Size > 0 {
+		if conf.SamplesWriterPoolSize > 0 {
+			return nil, nil, fmt.Errorf("samples writer pool can't be used with batched writes")
+		}
+		if err := conf.Batch.validate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	db, err := sql.Open("clickhouse", conf.DSN)
 	if err != nil {
 		return nil, nil, err
@@ -31,7 +59,19 @@ func (conf *Config) CreateStorage(logger *log.Logger) (*Storage, io.Closer, erro
 		return nil, nil, fmt.Errorf("failed to ping db: %w", err)
 	}
 
-	closers := multiCloser{db}
+	if conf.Migrate {
+		if err := Migrate(context.Background(), logger, db, &conf.Schema); err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("could not migrate db schema: %w", err)
+		}
+	}
+	if err := CheckSchema(context.Background(), db); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	// the writers are closed before the db, letting them finish the writes
+	var closers multiCloser
 
 	profilesWriter := NewProfilesWriter(logger, db)
 	samplesWriter := NewSamplesWriter(logger, db)
@@ -42,6 +82,14 @@ func (conf *Config) CreateStorage(logger *log.Logger) (*Storage, io.Closer, erro
 		samplesWriter = writer
 	}
 
+	if conf.Batch.Size > 0 {
+		writer := withBatching(conf.Batch, logger, newBatchMetrics(registry), insertBatch(db))
+		closers = append(closers, writer)
+		profilesWriter, samplesWriter = writer, writer
+	}
+
+	closers = append(closers, db)
+
 	st, err := NewStorage(logger, db, profilesWriter, samplesWriter)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,244 @@
+package clickhouse
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// samples don't have a tenant, thus the keys of the tenant's profiles are selected first; the samples and the profiles
+// are deleted by the selected keys, as the mutations, that delete them, are executed independently
+const (
+	sqlSelectTenantProfileKeys = `
+		SELECT hex(profile_key)
+		FROM pprof_profiles
+		WHERE tenant = ? AND profile_key IN (%s);`
+
+	sqlDeletePprofSamples = `
+		ALTER TABLE pprof_samples
+		DELETE WHERE profile_key IN (%s);`
+
+	sqlDeletePprofProfiles = `
+		ALTER TABLE pprof_profiles
+		DELETE WHERE profile_key IN (%s);`
+
+	sqlSelectStacksCreatedAtMin = `
+		SELECT min(created_at), count()
+		FROM pprof_stacks
+		WHERE created_at < ?;`
+
+	// the stacks, written within the time range and not after it, that no sample references; a stack is written
+	// again with the profiles, created writtenStacksWindow after it was written, thus only the samples, created
+	// before the end of the range plus the window, may reference the stacks; of the samples, only the stacks
+	// of the range are kept in memory
+	sqlSelectUnreferencedStacks = `
+		SELECT stack_fingerprint
+		FROM pprof_stacks
+		WHERE stack_fingerprint IN (
+			SELECT stack_fingerprint FROM pprof_stacks WHERE created_at >= ? AND created_at < ?
+		) AND stack_fingerprint NOT IN (
+			SELECT DISTINCT stack_fingerprint
+			FROM pprof_samples
+			WHERE created_at < ? AND stack_fingerprint IN (
+				SELECT stack_fingerprint FROM pprof_stacks WHERE created_at >= ? AND created_at < ?
+			)
+		)
+		GROUP BY stack_fingerprint
+		HAVING max(created_at) < ?;`
+
+	sqlDeletePprofStacks = `
+		ALTER TABLE pprof_stacks
+		DELETE WHERE stack_fingerprint IN (%s);`
+)
+
+const (
+	// the number of profiles or stacks deleted by a mutation; the keys are passed within the query, which size
+	// is limited
+	deleteProfilesLimit = 4000
+	// the stacks, written less than the delay ago, aren't collected, even if no sample references them: either
+	// their samples aren't written yet, or the writers skip the stacks, as they were recently written
+	collectStacksDelay = 2 * writtenStacksWindow
+)
+
+var (
+	_ storage.Deleter          = (*Storage)(nil)
+	_ storage.GarbageCollector = (*Storage)(nil)
+)
+
+// DeleteProfiles deletes the profiles of the tenant and their samples. The data is deleted by ClickHouse
+// mutations, that are executed asynchronously, in batches of up to deleteProfilesLimit profiles. The stacks
+// of the samples are shared by the profiles, thus they aren't deleted, but are collected by CollectGarbage.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	for len(pids) > 0 {
+		n := len(pids)
+		if n > deleteProfilesLimit {
+			n = deleteProfilesLimit
+		}
+		if err := st.deleteProfiles(ctx, tenant, pids[:n]); err != nil {
+			return err
+		}
+		pids = pids[n:]
+	}
+	return nil
+}
+
+func (st *Storage) deleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	keyArgs, err := st.selectTenantProfileKeys(ctx, tenant, pids)
+	if err != nil {
+		return fmt.Errorf("could not find profiles: %w", err)
+	}
+	if len(keyArgs) == 0 {
+		return nil
+	}
+
+	keys := strings.TrimSuffix(strings.Repeat("unhex(?), ", len(keyArgs)), ", ")
+
+	// samples go first, so that no samples are left without their profile, if the profiles couldn't be deleted
+	for _, query := range []string{sqlDeletePprofSamples, sqlDeletePprofProfiles} {
+		query = fmt.Sprintf(query, keys)
+		st.logger.Debugw("deleteProfiles: delete", log.MultiLine("query", query), "args", keyArgs)
+		if _, err := st.db.ExecContext(ctx, query, keyArgs...); err != nil {
+			return fmt.Errorf("could not delete profiles: %w", err)
+		}
+	}
+	return nil
+}
+
+// returns the hex-encoded keys of the tenant's profiles among the profiles
+func (st *Storage) selectTenantProfileKeys(ctx context.Context, tenant string, pids []profile.ID) ([]interface{}, error) {
+	keys, keyArgs, err := sqlProfileKeys(pids)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0, 1+len(keyArgs))
+	args = append(args, tenant)
+	args = append(args, keyArgs...)
+
+	query := fmt.Sprintf(sqlSelectTenantProfileKeys, keys)
+	st.logger.Debugw("deleteProfiles: select keys", log.MultiLine("query", query), "args", args)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenantKeys := make([]interface{}, 0, len(pids))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		tenantKeys = append(tenantKeys, key)
+	}
+	return tenantKeys, rows.Err()
+}
+
+// CollectGarbage deletes the stacks, that no sample references, unless they were written recently. The stacks
+// are looked through month by month of their creation time, so that only the samples, that may reference
+// the month's stacks, are read. The stacks of the samples, which deletion isn't executed yet, are collected
+// by the following calls.
+func (st *Storage) CollectGarbage(ctx context.Context) error {
+	before := time.Now().Add(-collectStacksDelay)
+
+	createdAtMin, err := st.selectStacksCreatedAtMin(ctx, before)
+	if err != nil {
+		return fmt.Errorf("could not find unreferenced stacks: %w", err)
+	}
+	if createdAtMin.IsZero() {
+		return nil
+	}
+
+	createdAtMin = createdAtMin.UTC()
+	from := time.Date(createdAtMin.Year(), createdAtMin.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for from.Before(before) {
+		to := from.AddDate(0, 1, 0)
+		if to.After(before) {
+			to = before
+		}
+		if err := st.collectStacks(ctx, from, to); err != nil {
+			return err
+		}
+		from = to
+	}
+	return nil
+}
+
+// deletes the stacks, written within the time range, that no sample references
+func (st *Storage) collectStacks(ctx context.Context, from, to time.Time) error {
+	stacks, err := st.selectUnreferencedStacks(ctx, from, to)
+	if err != nil {
+		return fmt.Errorf("could not find unreferenced stacks: %w", err)
+	}
+
+	for len(stacks) > 0 {
+		n := len(stacks)
+		if n > deleteProfilesLimit {
+			n = deleteProfilesLimit
+		}
+
+		// the fingerprints are read from the table, thus they are safe to be put within the query
+		query := fmt.Sprintf(sqlDeletePprofStacks, strings.Join(stacks[:n], ", "))
+		st.logger.Debugw("collectGarbage: delete stacks", log.MultiLine("query", query))
+		if _, err := st.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("could not delete stacks: %w", err)
+		}
+		stacks = stacks[n:]
+	}
+	return nil
+}
+
+// returns the creation time of the oldest stack, written before the time, or zero time if there is none
+func (st *Storage) selectStacksCreatedAtMin(ctx context.Context, before time.Time) (time.Time, error) {
+	var (
+		createdAtMin time.Time
+		count        uint64
+	)
+	err := st.db.QueryRowContext(ctx, sqlSelectStacksCreatedAtMin, before).Scan(&createdAtMin, &count)
+	if err != nil || count == 0 {
+		return time.Time{}, err
+	}
+	return createdAtMin, nil
+}
+
+// returns the fingerprints of the stacks, written within the time range and not after it, that no sample references
+func (st *Storage) selectUnreferencedStacks(ctx context.Context, from, to time.Time) ([]string, error) {
+	args := []interface{}{from, to, to.Add(writtenStacksWindow), from, to, to}
+	st.logger.Debugw("collectGarbage: select stacks", log.MultiLine("query", sqlSelectUnreferencedStacks), "args", args)
+
+	rows, err := st.db.QueryContext(ctx, sqlSelectUnreferencedStacks, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stacks []string
+	for rows.Next() {
+		var stack uint64
+		if err := rows.Scan(&stack); err != nil {
+			return nil, err
+		}
+		stacks = append(stacks, strconv.FormatUint(stack, 10))
+	}
+	return stacks, rows.Err()
+}
+
+func decodeProfileKey(pid profile.ID) (pk ProfileKey, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(string(pid))
+	if err != nil {
+		return pk, fmt.Errorf("could not decode profile id %q: %w", pid, err)
+	}
+	if len(b) != len(pk) {
+		return pk, fmt.Errorf("could not decode profile id %q: bad length %d", pid, len(b))
+	}
+	copy(pk[:], b)
+	return pk, nil
+}
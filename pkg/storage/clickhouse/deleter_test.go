@@ -0,0 +1,23 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeProfileKey(t *testing.T) {
+	pk := NewProfileKey(time.Now())
+
+	got, err := decodeProfileKey(profile.ID(pk.String()))
+	require.NoError(t, err)
+	assert.Equal(t, pk, got)
+
+	for _, pid := range []profile.ID{"", "not+base64", "c2hvcnQ"} {
+		_, err := decodeProfileKey(pid)
+		assert.Error(t, err, pid)
+	}
+}
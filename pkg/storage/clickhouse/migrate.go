@@ -0,0 +1,452 @@
+package clickhouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+)
+
+const (
+	defaultPartitionBy = "(toYYYYMM(created_at), service_name)"
+	defaultReplicaPath = "/clickhouse/tables/{shard}/{database}"
+)
+
+// SchemaConfig configures the tables the storage creates. The partitioning of the tables can't be changed
+// after the tables are created.
+type SchemaConfig struct {
+	// the cluster the tables are created on, with ON CLUSTER clause, as Replicated*MergeTree tables
+	Cluster string
+	// the path of the replicated tables in ZooKeeper, the name of the table is appended to
+	ReplicaPath string
+	// the partition key of pprof_profiles table
+	PartitionBy string
+	// the time the profiles are kept for; the profiles are kept forever if zero
+	TTL time.Duration
+}
+
+// returns ON CLUSTER clause of a distributed DDL query
+func (sc *SchemaConfig) onCluster() string {
+	if sc.Cluster == "" {
+		return ""
+	}
+	return " ON CLUSTER " + quoteIdentifier(sc.Cluster)
+}
+
+// returns the engine of the table, e.g. "ReplicatedMergeTree(...)" on a cluster, with the parameters of the engine
+func (sc *SchemaConfig) engine(engine, table string, params ...string) string {
+	if sc.Cluster == "" {
+		return engine + "(" + strings.Join(params, ", ") + ")"
+	}
+	params = append([]string{
+		fmt.Sprintf("'%s/%s'", strings.TrimSuffix(sc.ReplicaPath, "/"), table),
+		"'{replica}'",
+	}, params...)
+	return "Replicated" + engine + "(" + strings.Join(params, ", ") + ")"
+}
+
+func (sc *SchemaConfig) partitionBy() string {
+	if sc.PartitionBy == "" {
+		return ""
+	}
+	return "PARTITION BY " + sc.PartitionBy
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// migrations are the versions of the schema, every migration returns the list of statements, run in the order;
+// new migrations are appended, the applied ones must never change. As ClickHouse doesn't have transactional DDL,
+// nor locks, the statements must be safe to be rerun, e.g. by the collectors starting concurrently.
+var migrations = []func(sc *SchemaConfig) []string{
+	// 1: the profiles and their samples; the tables might be created before the migrations were introduced
+	func(sc *SchemaConfig) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS pprof_profiles` + sc.onCluster() + ` (
+				profile_key FixedString(12),
+				profile_type Enum8(
+					'cpu' = 1,
+					'heap' = 2,
+					'block' = 3,
+					'mutex' = 4,
+					'goroutine' = 5,
+					'threadcreate' = 6,
+					'other' = 100
+				),
+				external_id String,
+				tenant LowCardinality(String) DEFAULT '',
+				service_name LowCardinality(String),
+				created_at DateTime,
+				labels Nested (
+					key LowCardinality(String),
+					value String
+				)
+			)
+			ENGINE = ` + sc.engine("MergeTree", "pprof_profiles") + `
+			` + sc.partitionBy() + `
+			ORDER BY (tenant, service_name, profile_type, created_at)`,
+			`ALTER TABLE pprof_profiles` + sc.onCluster() + `
+				ADD COLUMN IF NOT EXISTS tenant LowCardinality(String) DEFAULT '' AFTER external_id`,
+			`CREATE TABLE IF NOT EXISTS pprof_samples` + sc.onCluster() + ` (
+				profile_key FixedString(12),
+				fingerprint UInt64,
+				locations Nested (
+					func_name LowCardinality(String),
+					file_name LowCardinality(String),
+					lineno UInt16
+				),
+				values Array(Int64),
+				values_type Array(LowCardinality(String)),
+				values_unit Array(LowCardinality(String)),
+				labels Nested (
+					key String,
+					value String
+				)
+			)
+			ENGINE = ` + sc.engine("ReplacingMergeTree", "pprof_samples") + `
+			ORDER BY (profile_key, fingerprint)`,
+		}
+	},
+	// 2: the fields the profiles are rebuilt with
+	func(sc *SchemaConfig) []string {
+		return []string{
+			`ALTER TABLE pprof_profiles` + sc.onCluster() + `
+				ADD COLUMN IF NOT EXISTS period_type LowCardinality(String) DEFAULT '',
+				ADD COLUMN IF NOT EXISTS period_unit LowCardinality(String) DEFAULT '',
+				ADD COLUMN IF NOT EXISTS period Int64 DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS duration_nanos Int64 DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS mappings.id Array(UInt64),
+				ADD COLUMN IF NOT EXISTS mappings.memory_start Array(UInt64),
+				ADD COLUMN IF NOT EXISTS mappings.memory_limit Array(UInt64),
+				ADD COLUMN IF NOT EXISTS mappings.file_offset Array(UInt64),
+				ADD COLUMN IF NOT EXISTS mappings.file_name Array(String),
+				ADD COLUMN IF NOT EXISTS mappings.build_id Array(String),
+				ADD COLUMN IF NOT EXISTS mappings.has_functions Array(UInt8)`,
+			`ALTER TABLE pprof_samples` + sc.onCluster() + `
+				ADD COLUMN IF NOT EXISTS locations.address Array(UInt64),
+				ADD COLUMN IF NOT EXISTS locations.mapping_id Array(UInt64)`,
+		}
+	},
+	// 3: the creation time of the samples, taken from the profile key, for the samples to be expired
+	func(sc *SchemaConfig) []string {
+		return []string{
+			`ALTER TABLE pprof_samples` + sc.onCluster() + `
+				ADD COLUMN IF NOT EXISTS created_at DateTime MATERIALIZED ` + sqlProfileKeyTime,
+		}
+	},
+	// 4: the stacks of the samples, shared by the samples of all profiles; the samples reference them by
+	// the fingerprint, the locations of the samples are only kept for the samples written before
+	func(sc *SchemaConfig) []string {
+		return []string{
+			`CREATE TABLE IF NOT EXISTS pprof_stacks` + sc.onCluster() + ` (
+				stack_fingerprint UInt64,
+				locations Nested (
+					func_name LowCardinality(String),
+					file_name LowCardinality(String),
+					lineno UInt16,
+					address UInt64,
+					mapping_id UInt64
+				),
+				created_at DateTime
+			)
+			ENGINE = ` + sc.engine("ReplacingMergeTree", "pprof_stacks", "created_at") + `
+			ORDER BY stack_fingerprint`,
+			`ALTER TABLE pprof_samples` + sc.onCluster() + `
+				ADD COLUMN IF NOT EXISTS stack_fingerprint UInt64 DEFAULT 0 AFTER fingerprint`,
+		}
+	},
+}
+
+// the creation time of the profile, the first 4 bytes of the profile key hold, as big-endian seconds
+const sqlProfileKeyTime = `toDateTime(reinterpretAsUInt32(reverse(substring(profile_key, 1, 4))))`
+
+// Migrate applies the migrations, that weren't applied to the db yet, adds the new profile types to
+// `pprof_profiles.profile_type` enum, and updates the TTL of the tables.
+func Migrate(ctx context.Context, logger *log.Logger, db *sql.DB, sc *SchemaConfig) error {
+	stmt := `CREATE TABLE IF NOT EXISTS profefe_schema_migrations` + sc.onCluster() + ` (
+		version UInt32,
+		applied_at DateTime DEFAULT now()
+	)
+	ENGINE = ` + sc.engine("ReplacingMergeTree", "profefe_schema_migrations") + `
+	ORDER BY version`
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("could not create migrations table: %w", err)
+	}
+
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		for _, stmt := range migrations[i](sc) {
+			if _, err := db.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("could not apply migration %d: %w", i+1, err)
+			}
+		}
+		err := withinTx(ctx, db, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO profefe_schema_migrations (version) VALUES (?)`, uint32(i+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not record migration %d: %w", i+1, err)
+		}
+		logger.Infow("clickhouse schema migrated", "version", i+1)
+	}
+
+	if err := migrateProfileTypes(ctx, logger, db, sc); err != nil {
+		return err
+	}
+
+	return migrateTTL(ctx, logger, db, sc)
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version uint32
+	if err := db.QueryRowContext(ctx, `SELECT max(version) FROM profefe_schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not get schema version: %w", err)
+	}
+	if int(version) > len(migrations) {
+		return 0, fmt.Errorf("schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+	return int(version), nil
+}
+
+// adds the profile types, that the enum doesn't have, to the enum
+func migrateProfileTypes(ctx context.Context, logger *log.Logger, db *sql.DB, sc *SchemaConfig) error {
+	columns, err := selectSchemaColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	enum := columns["pprof_profiles"]["profile_type"]
+	missing, err := checkProfileTypeEnum(enum)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	stmt := `ALTER TABLE pprof_profiles` + sc.onCluster() + ` MODIFY COLUMN profile_type ` + addEnumValues(enum, missing)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("could not add profile types %v: %w", missing, err)
+	}
+	logger.Infow("clickhouse profile types added", "types", missing)
+
+	return nil
+}
+
+// sets the TTL of the tables, unless the tables already have it; the stacks are kept for writtenStacksWindow longer,
+// as the stack isn't written again with the profiles created within the window
+func migrateTTL(ctx context.Context, logger *log.Logger, db *sql.DB, sc *SchemaConfig) error {
+	if sc.TTL <= 0 {
+		return nil
+	}
+
+	for _, table := range []string{"pprof_profiles", "pprof_samples", "pprof_stacks"} {
+		tableTTL := sc.TTL
+		if table == "pprof_stacks" {
+			tableTTL += writtenStacksWindow
+		}
+		ttl := fmt.Sprintf("created_at + toIntervalSecond(%d)", int64(tableTTL.Seconds()))
+
+		var engine string
+		err := db.QueryRowContext(ctx, `SELECT engine_full FROM system.tables WHERE database = currentDatabase() AND name = ?`, table).Scan(&engine)
+		if err != nil {
+			return fmt.Errorf("could not get engine of table %s: %w", table, err)
+		}
+		if strings.Contains(engine, "TTL "+ttl) {
+			continue
+		}
+
+		stmt := `ALTER TABLE ` + table + sc.onCluster() + ` MODIFY TTL ` + ttl
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not set ttl of table %s: %w", table, err)
+		}
+		logger.Infow("clickhouse table ttl set", "table", table, "ttl", tableTTL)
+	}
+
+	return nil
+}
+
+// the columns the storage reads and writes, by table
+var schemaColumns = map[string][]string{
+	"pprof_profiles": {
+		"profile_key",
+		"profile_type",
+		"external_id",
+		"tenant",
+		"service_name",
+		"created_at",
+		"labels.key",
+		"labels.value",
+		"period_type",
+		"period_unit",
+		"period",
+		"duration_nanos",
+		"mappings.id",
+		"mappings.memory_start",
+		"mappings.memory_limit",
+		"mappings.file_offset",
+		"mappings.file_name",
+		"mappings.build_id",
+		"mappings.has_functions",
+	},
+	"pprof_samples": {
+		"profile_key",
+		"fingerprint",
+		"stack_fingerprint",
+		"locations.func_name",
+		"locations.file_name",
+		"locations.lineno",
+		"locations.address",
+		"locations.mapping_id",
+		"values",
+		"values_type",
+		"values_unit",
+		"labels.key",
+		"labels.value",
+		"created_at",
+	},
+	"pprof_stacks": {
+		"stack_fingerprint",
+		"locations.func_name",
+		"locations.file_name",
+		"locations.lineno",
+		"locations.address",
+		"locations.mapping_id",
+		"created_at",
+	},
+}
+
+// CheckSchema checks that the tables have all the columns the storage uses, and that the profile types enum
+// has all the types the storage writes.
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	// the tables, created by hand, don't have the migrations
+	var hasMigrations uint8
+	if err := db.QueryRowContext(ctx, `EXISTS TABLE profefe_schema_migrations`).Scan(&hasMigrations); err != nil {
+		return fmt.Errorf("could not check migrations table: %w", err)
+	}
+	if hasMigrations != 0 {
+		if _, err := schemaVersion(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	columns, err := selectSchemaColumns(ctx, db)
+	if err != nil {
+		return err
+	}
+	return checkSchemaColumns(columns)
+}
+
+func checkSchemaColumns(columns map[string]map[string]string) error {
+	for table, names := range schemaColumns {
+		for _, name := range names {
+			if _, ok := columns[table][name]; !ok {
+				return fmt.Errorf("incompatible schema: table %s has no column %s", table, name)
+			}
+		}
+	}
+
+	missing, err := checkProfileTypeEnum(columns["pprof_profiles"]["profile_type"])
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("incompatible schema: profile_type has no values for profile types %v", missing)
+	}
+	return nil
+}
+
+// returns the types of the columns of the tables in the current database, by table and column
+func selectSchemaColumns(ctx context.Context, db *sql.DB) (map[string]map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT table, name, type
+		FROM system.columns
+		WHERE database = currentDatabase() AND table IN ('pprof_profiles', 'pprof_samples', 'pprof_stacks');`)
+	if err != nil {
+		return nil, fmt.Errorf("could not get schema columns: %w", err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string]map[string]string)
+	for rows.Next() {
+		var table, name, typ string
+		if err := rows.Scan(&table, &name, &typ); err != nil {
+			return nil, err
+		}
+		if columns[table] == nil {
+			columns[table] = make(map[string]string)
+		}
+		columns[table][name] = typ
+	}
+	return columns, rows.Err()
+}
+
+// returns the enum, e.g. "Enum8('cpu' = 1)", with the values of the profile types added
+func addEnumValues(enum string, ptypes []ProfileType) string {
+	values := make([]string, 0, len(ptypes))
+	for _, ptype := range ptypes {
+		name, _ := ProfileTypeFromDBModel(ptype)
+		values = append(values, fmt.Sprintf("'%s' = %d", name, ptype))
+	}
+	return strings.TrimSuffix(enum, ")") + ", " + strings.Join(values, ", ") + ")"
+}
+
+var enumValueRe = regexp.MustCompile(`'([^']*)' = (-?\d+)`)
+
+// returns the profile types, that the enum, e.g. "Enum8('cpu' = 1, 'heap' = 2)", doesn't have; the enum must not
+// have the values, that differ from the values of the types
+func checkProfileTypeEnum(enum string) ([]ProfileType, error) {
+	if !strings.HasPrefix(enum, "Enum8(") {
+		return nil, fmt.Errorf("incompatible schema: profile_type of unexpected type %q", enum)
+	}
+
+	values := make(map[string]int)
+	for _, m := range enumValueRe.FindAllStringSubmatch(enum, -1) {
+		v, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("incompatible schema: bad profile_type %q: %w", enum, err)
+		}
+		values[m[1]] = v
+	}
+
+	var missing []ProfileType
+	for _, ptype := range profileTypes {
+		name, _ := ProfileTypeFromDBModel(ptype)
+		v, ok := values[name.String()]
+		if !ok {
+			missing = append(missing, ptype)
+			continue
+		}
+		if v != int(ptype) {
+			return nil, fmt.Errorf("incompatible schema: profile_type has value %d for %q, want %d", v, name, ptype)
+		}
+		delete(values, name.String())
+	}
+
+	// the values of the types, the storage doesn't know, e.g. added by a newer version of the collector, are
+	// kept, as long as they don't clash with the known types
+	known := make(map[int]bool, len(profileTypes))
+	for _, ptype := range profileTypes {
+		known[int(ptype)] = true
+	}
+	unknown := make([]string, 0, len(values))
+	for name, v := range values {
+		if known[v] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("incompatible schema: profile_type has unknown values %v", unknown)
+	}
+
+	return missing, nil
+}
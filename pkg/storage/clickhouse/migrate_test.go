@@ -0,0 +1,117 @@
+package clickhouse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProfileTypeEnum = "Enum8('cpu' = 1, 'heap' = 2, 'block' = 3, 'mutex' = 4, 'goroutine' = 5, 'threadcreate' = 6, 'other' = 100)"
+
+func TestMigrations_cluster(t *testing.T) {
+	t.Run("single node", func(t *testing.T) {
+		sc := &SchemaConfig{PartitionBy: defaultPartitionBy}
+		for _, migration := range migrations {
+			for _, stmt := range migration(sc) {
+				assert.NotContains(t, stmt, "ON CLUSTER")
+				assert.NotContains(t, stmt, "Replicated")
+			}
+		}
+		assert.Contains(t, migrations[0](sc)[0], "ENGINE = MergeTree()")
+		assert.Contains(t, migrations[0](sc)[0], "PARTITION BY "+defaultPartitionBy)
+		assert.Contains(t, migrations[3](sc)[0], "ENGINE = ReplacingMergeTree(created_at)")
+	})
+
+	t.Run("cluster", func(t *testing.T) {
+		sc := &SchemaConfig{Cluster: "profefe", ReplicaPath: "/clickhouse/tables/{shard}/"}
+		for _, migration := range migrations {
+			for _, stmt := range migration(sc) {
+				assert.Contains(t, stmt, "ON CLUSTER `profefe`")
+			}
+		}
+		stmts := migrations[0](sc)
+		assert.Contains(t, stmts[0], "ENGINE = ReplicatedMergeTree('/clickhouse/tables/{shard}/pprof_profiles', '{replica}')")
+		assert.NotContains(t, stmts[0], "PARTITION BY")
+		assert.Contains(t, stmts[2], "ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/pprof_samples', '{replica}')")
+		assert.Contains(t, migrations[3](sc)[0], "ENGINE = ReplicatedReplacingMergeTree('/clickhouse/tables/{shard}/pprof_stacks', '{replica}', created_at)")
+	})
+}
+
+func TestCheckProfileTypeEnum(t *testing.T) {
+	t.Run("all types", func(t *testing.T) {
+		missing, err := checkProfileTypeEnum(testProfileTypeEnum)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+	})
+
+	t.Run("missing types", func(t *testing.T) {
+		missing, err := checkProfileTypeEnum("Enum8('cpu' = 1, 'heap' = 2, 'block' = 3, 'mutex' = 4, 'goroutine' = 5)")
+		require.NoError(t, err)
+		assert.Equal(t, []ProfileType{TypeThreadcreate, TypeOther}, missing)
+	})
+
+	t.Run("unknown types", func(t *testing.T) {
+		enum := strings.TrimSuffix(testProfileTypeEnum, ")") + ", 'wall' = 7)"
+		missing, err := checkProfileTypeEnum(enum)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+	})
+
+	t.Run("clashing values", func(t *testing.T) {
+		_, err := checkProfileTypeEnum("Enum8('cpu' = 2, 'heap' = 1)")
+		assert.Error(t, err)
+
+		_, err = checkProfileTypeEnum("Enum8('cpu' = 1, 'wall' = 2)")
+		assert.Error(t, err)
+	})
+
+	t.Run("not enum", func(t *testing.T) {
+		_, err := checkProfileTypeEnum("String")
+		assert.Error(t, err)
+	})
+}
+
+func TestAddEnumValues(t *testing.T) {
+	enum := addEnumValues("Enum8('cpu' = 1, 'heap' = 2)", []ProfileType{TypeOther})
+	assert.Equal(t, "Enum8('cpu' = 1, 'heap' = 2, 'other' = 100)", enum)
+
+	missing, err := checkProfileTypeEnum(addEnumValues("Enum8('cpu' = 1)", profileTypes[1:]))
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+}
+
+func TestCheckSchemaColumns(t *testing.T) {
+	newColumns := func() map[string]map[string]string {
+		columns := make(map[string]map[string]string)
+		for table, names := range schemaColumns {
+			columns[table] = make(map[string]string)
+			for _, name := range names {
+				columns[table][name] = "String"
+			}
+		}
+		columns["pprof_profiles"]["profile_type"] = testProfileTypeEnum
+		return columns
+	}
+
+	require.NoError(t, checkSchemaColumns(newColumns()))
+
+	t.Run("missing column", func(t *testing.T) {
+		columns := newColumns()
+		delete(columns["pprof_samples"], "locations.address")
+		assert.Error(t, checkSchemaColumns(columns))
+	})
+
+	t.Run("missing table", func(t *testing.T) {
+		columns := newColumns()
+		delete(columns, "pprof_profiles")
+		assert.Error(t, checkSchemaColumns(columns))
+	})
+
+	t.Run("missing profile type", func(t *testing.T) {
+		columns := newColumns()
+		columns["pprof_profiles"]["profile_type"] = "Enum8('cpu' = 1)"
+		assert.Error(t, checkSchemaColumns(columns))
+	})
+}
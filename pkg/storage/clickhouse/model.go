@@ -44,7 +44,7 @@ func (pk ProfileKey) String() string {
 type ProfileType uint8
 
 // Profile types supported by ClickHouse writer.
-// Must match with values defined in `pprof_profiles.profile_type` SQL enum.
+// The values of `pprof_profiles.profile_type` SQL enum are generated from the types, see profileTypes.
 const (
 	TypeCPU          ProfileType = 1
 	TypeHeap         ProfileType = 2
@@ -56,6 +56,18 @@ const (
 	TypeOther ProfileType = 100
 )
 
+// profileTypes are the values of `pprof_profiles.profile_type` SQL enum; the schema is migrated
+// to have the new types added on start
+var profileTypes = []ProfileType{
+	TypeCPU,
+	TypeHeap,
+	TypeBlock,
+	TypeMutex,
+	TypeGoroutine,
+	TypeThreadcreate,
+	TypeOther,
+}
+
 func ProfileTypeToDBModel(ptype profile.ProfileType) (ProfileType, error) {
 	switch ptype {
 	case profile.TypeCPU:
@@ -0,0 +1,164 @@
+package clickhouse
+
+import (
+	"fmt"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/pprofutil"
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+// profile-level fields of a row in pprof_profiles table, required to rebuild the profile
+type profileRecord struct {
+	ptype         profile.ProfileType
+	createdAt     time.Time
+	periodType    string
+	periodUnit    string
+	period        int64
+	durationNanos int64
+	mappings      []*pprofProfile.Mapping
+}
+
+// a row in pprof_samples table
+type sampleRecord struct {
+	funcs      []string
+	files      []string
+	lines      []uint16
+	addrs      []uint64
+	mappingIDs []uint64
+	values     []int64
+	valueTypes []string
+	valueUnits []string
+	labelKeys  []string
+	labelVals  []string
+}
+
+type functionKey struct {
+	name string
+	file string
+}
+
+type locationKey struct {
+	mappingID uint64
+	addr      uint64
+	fn        functionKey
+	line      uint16
+}
+
+// rebuilds pprof profile from the rows of pprof_profiles and pprof_samples tables
+type profileBuilder struct {
+	pb        *pprofutil.ProfileBuilder
+	mappings  map[uint64]*pprofProfile.Mapping
+	functions map[functionKey]*pprofProfile.Function
+	locations map[locationKey]*pprofProfile.Location
+
+	hasSampleType bool
+}
+
+func newProfileBuilder(prec *profileRecord) *profileBuilder {
+	b := &profileBuilder{
+		pb:        pprofutil.NewProfileBuilder(prec.ptype),
+		mappings:  make(map[uint64]*pprofProfile.Mapping, len(prec.mappings)),
+		functions: make(map[functionKey]*pprofProfile.Function),
+		locations: make(map[locationKey]*pprofProfile.Location),
+	}
+
+	b.pb.SetTime(prec.createdAt.UnixNano(), prec.durationNanos)
+	// the profiles, written before the period was stored, have empty period type
+	if prec.periodType != "" {
+		b.pb.SetPeriod(&pprofProfile.ValueType{Type: prec.periodType, Unit: prec.periodUnit}, prec.period)
+	}
+
+	for _, m := range prec.mappings {
+		b.pb.AddMapping(m)
+		b.mappings[m.ID] = m
+	}
+
+	return b
+}
+
+func (b *profileBuilder) AddSample(srec *sampleRecord) error {
+	nlocs := len(srec.funcs)
+	if len(srec.files) != nlocs || len(srec.lines) != nlocs {
+		return fmt.Errorf("inconsistent sample locations: %d funcs, %d files, %d lines", nlocs, len(srec.files), len(srec.lines))
+	}
+	if len(srec.valueTypes) != len(srec.values) || len(srec.valueUnits) != len(srec.values) {
+		return fmt.Errorf("inconsistent sample values: %d values, %d types, %d units", len(srec.values), len(srec.valueTypes), len(srec.valueUnits))
+	}
+	if len(srec.labelVals) != len(srec.labelKeys) {
+		return fmt.Errorf("inconsistent sample labels: %d keys, %d values", len(srec.labelKeys), len(srec.labelVals))
+	}
+
+	// all samples of a profile have the same value types
+	if !b.hasSampleType {
+		sampleType := make([]*pprofProfile.ValueType, len(srec.valueTypes))
+		for i := range srec.valueTypes {
+			sampleType[i] = &pprofProfile.ValueType{Type: srec.valueTypes[i], Unit: srec.valueUnits[i]}
+		}
+		b.pb.SetSampleType(sampleType)
+		b.hasSampleType = true
+	}
+
+	sample := &pprofProfile.Sample{
+		Location: make([]*pprofProfile.Location, 0, nlocs),
+		Value:    srec.values,
+	}
+
+	for i := 0; i < nlocs; i++ {
+		lk := locationKey{
+			fn:   functionKey{name: srec.funcs[i], file: srec.files[i]},
+			line: srec.lines[i],
+		}
+		// the samples, written before the addresses were stored, have empty addresses
+		if i < len(srec.addrs) && i < len(srec.mappingIDs) {
+			lk.addr = srec.addrs[i]
+			lk.mappingID = srec.mappingIDs[i]
+		}
+		sample.Location = append(sample.Location, b.location(lk))
+	}
+
+	for i, key := range srec.labelKeys {
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string)
+		}
+		sample.Label[key] = append(sample.Label[key], srec.labelVals[i])
+	}
+
+	b.pb.AddSample(sample)
+
+	return nil
+}
+
+func (b *profileBuilder) location(lk locationKey) *pprofProfile.Location {
+	if loc, ok := b.locations[lk]; ok {
+		return loc
+	}
+
+	fn, ok := b.functions[lk.fn]
+	if !ok {
+		fn = &pprofProfile.Function{
+			Name:       lk.fn.name,
+			SystemName: lk.fn.name,
+			Filename:   lk.fn.file,
+		}
+		b.pb.AddFunction(fn)
+		b.functions[lk.fn] = fn
+	}
+
+	loc := &pprofProfile.Location{
+		Mapping: b.mappings[lk.mappingID],
+		Address: lk.addr,
+		Line: []pprofProfile.Line{
+			{Function: fn, Line: int64(lk.line)},
+		},
+	}
+	b.pb.AddLocation(loc)
+	b.locations[lk] = loc
+
+	return loc
+}
+
+func (b *profileBuilder) Build() (*pprofProfile.Profile, error) {
+	return b.pb.Build()
+}
@@ -0,0 +1,91 @@
+package clickhouse
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/pprofutil"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileBuilder(t *testing.T) {
+	data, err := ioutil.ReadFile("../../../testdata/collector_cpu_1.prof")
+	require.NoError(t, err)
+
+	wantPP, err := pprofProfile.ParseData(data)
+	require.NoError(t, err)
+
+	// mimic what the writers store in the tables
+	prec := &profileRecord{
+		ptype:         profile.TypeCPU,
+		createdAt:     time.Unix(0, wantPP.TimeNanos).Truncate(time.Second),
+		periodType:    wantPP.PeriodType.Type,
+		periodUnit:    wantPP.PeriodType.Unit,
+		period:        wantPP.Period,
+		durationNanos: wantPP.DurationNanos,
+	}
+	for _, m := range wantPP.Mapping {
+		mcopy := *m
+		prec.mappings = append(prec.mappings, &mcopy)
+	}
+
+	var valueTypes, valueUnits []string
+	for _, st := range wantPP.SampleType {
+		valueTypes = append(valueTypes, st.Type)
+		valueUnits = append(valueUnits, st.Unit)
+	}
+
+	var wantSamples []*pprofProfile.Sample
+
+	b := newProfileBuilder(prec)
+	for _, sample := range wantPP.Sample {
+		if isEmptySample(sample) {
+			continue
+		}
+		wantSamples = append(wantSamples, sample)
+
+		srec := sampleRecord{
+			values:     sample.Value,
+			valueTypes: valueTypes,
+			valueUnits: valueUnits,
+		}
+		locs := make([]string, len(sample.Location)*2)
+		srec.funcs, srec.files, srec.lines = collectLocations(sample, locs, nil)
+		srec.addrs, srec.mappingIDs = collectAddresses(sample, nil, nil)
+		srec.labelKeys, srec.labelVals = collectLabels(sample, nil, nil)
+
+		require.NoError(t, b.AddSample(&srec))
+	}
+
+	gotPP, err := b.Build()
+	require.NoError(t, err)
+
+	assert.True(t, pprofutil.ProfilesEqual(wantPP, gotPP))
+	assert.Equal(t, wantPP.SampleType, gotPP.SampleType)
+	assert.Equal(t, wantPP.PeriodType, gotPP.PeriodType)
+	assert.Equal(t, wantPP.Period, gotPP.Period)
+	assert.Equal(t, wantPP.DurationNanos, gotPP.DurationNanos)
+	require.Len(t, gotPP.Mapping, len(wantPP.Mapping))
+	assert.Equal(t, wantPP.Mapping[0].File, gotPP.Mapping[0].File)
+	require.Len(t, gotPP.Sample, len(wantSamples))
+	for i, loc := range gotPP.Sample[0].Location {
+		wantLoc := wantSamples[0].Location[i]
+		assert.Equal(t, wantLoc.Address, loc.Address)
+		assert.Equal(t, wantLoc.Mapping.ID, loc.Mapping.ID)
+	}
+}
+
+func TestProfileBuilder_inconsistentSample(t *testing.T) {
+	b := newProfileBuilder(&profileRecord{ptype: profile.TypeCPU})
+
+	err := b.AddSample(&sampleRecord{
+		funcs: []string{"main.main", "runtime.main"},
+		files: []string{"main.go"},
+		lines: []uint16{1, 2},
+	})
+	assert.Error(t, err)
+}
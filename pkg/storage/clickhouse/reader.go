@@ -1,23 +1,90 @@
 package clickhouse
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/profile"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/rs/xid"
 )
 
+// a retried insert of a batch may store the rows, that were already stored, again: the profiles are read once
+// by their key, and the samples are read FINAL, i.e. once by their profile key and fingerprint
 const (
-	sqlSelectProfiles = `SELECT %s FROM pprof_profiles WHERE service_name = ? %s;`
+	sqlSelectProfiles = `SELECT %s FROM pprof_profiles WHERE tenant = ? AND service_name = ? %s;`
+
+	sqlSelectProfile = `
+		SELECT
+			profile_type,
+			created_at,
+			period_type,
+			period_unit,
+			period,
+			duration_nanos,
+			mappings.id,
+			mappings.memory_start,
+			mappings.memory_limit,
+			mappings.file_offset,
+			mappings.file_name,
+			mappings.build_id,
+			mappings.has_functions
+		FROM pprof_profiles
+		WHERE tenant = ? AND profile_key = unhex(?) AND created_at >= ? AND created_at < ?
+		LIMIT 1;`
+
+	sqlSelectSamples = `
+		SELECT
+			if(stack_fingerprint = 0, locations.func_name, stack_func_name),
+			if(stack_fingerprint = 0, locations.file_name, stack_file_name),
+			if(stack_fingerprint = 0, locations.lineno, stack_lineno),
+			if(stack_fingerprint = 0, locations.address, stack_address),
+			if(stack_fingerprint = 0, locations.mapping_id, stack_mapping_id),
+			values,
+			values_type,
+			values_unit,
+			labels.key,
+			labels.value
+		FROM pprof_samples FINAL
+		%s
+		WHERE profile_key = unhex(?);`
+
+	// joins the stacks of the samples, that match the condition, to the samples; the samples, written before
+	// the stacks were stored in pprof_stacks, have zero stack_fingerprint and keep their locations
+	sqlJoinStacks = `
+		LEFT JOIN (
+			SELECT
+				stack_fingerprint,
+				any(locations.func_name) AS stack_func_name,
+				any(locations.file_name) AS stack_file_name,
+				any(locations.lineno) AS stack_lineno,
+				any(locations.address) AS stack_address,
+				any(locations.mapping_id) AS stack_mapping_id
+			FROM pprof_stacks
+			WHERE stack_fingerprint IN (
+				SELECT stack_fingerprint FROM pprof_samples WHERE %s
+			)
+			GROUP BY stack_fingerprint
+		) USING stack_fingerprint`
 
 	sqlSelectServiceNames = `
 		SELECT DISTINCT service_name
 		FROM pprof_profiles
+		WHERE tenant = ?
 		ORDER BY service_name;`
+
+	sqlSelectTenants = `
+		SELECT DISTINCT tenant
+		FROM pprof_profiles
+		ORDER BY tenant;`
 )
 
 var selectProfilesColumns = []string{
@@ -31,6 +98,10 @@ var selectProfilesColumns = []string{
 }
 
 func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) (metas []profile.Meta, err error) {
+	if !isSupportedType(params.Type) {
+		return nil, storage.ErrNotFound
+	}
+
 	query, args, err := buildSQLSelectProfiles(selectProfilesColumns, params)
 	if err != nil {
 		return nil, err
@@ -59,6 +130,7 @@ func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfile
 		}
 
 		meta.ProfileID = profile.ID(pk.String())
+		meta.Tenant = params.Tenant
 
 		if err := meta.Type.FromString(ptype); err != nil {
 			return nil, err
@@ -82,6 +154,10 @@ func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfile
 }
 
 func (st *Storage) FindProfileIDs(ctx context.Context, params *storage.FindProfilesParams) (pids []profile.ID, err error) {
+	if !isSupportedType(params.Type) {
+		return nil, storage.ErrNotFound
+	}
+
 	// profile_key is the first column in the slice
 	query, args, err := buildSQLSelectProfiles(selectProfilesColumns[:1], params)
 	if err != nil {
@@ -114,18 +190,220 @@ func (st *Storage) FindProfileIDs(ctx context.Context, params *storage.FindProfi
 	return pids, nil
 }
 
-func (st *Storage) ListProfiles(ctx context.Context, pid []profile.ID) (storage.ProfileList, error) {
-	return nil, storage.ErrNotImplemented
+// ListProfiles returns the profiles, rebuilt from their samples. The profiles keep the values, the stacks and
+// the string labels of the samples, but lose the numeric labels and the inlined functions of the locations.
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("empty profile ids")
+	}
+
+	pks := make([]ProfileKey, len(pids))
+	for i, pid := range pids {
+		pk, err := decodeProfileKey(pid)
+		if err != nil {
+			return nil, storage.ErrNotFound
+		}
+		pks[i] = pk
+	}
+
+	pl := &profileList{
+		ctx:    ctx,
+		st:     st,
+		tenant: tenant,
+		pks:    pks,
+	}
+	return pl, nil
+}
+
+type profileList struct {
+	ctx    context.Context
+	st     *Storage
+	tenant string
+	pks    []ProfileKey
+	// points to the current profile in the iteration
+	pk *ProfileKey
+	// first error preserved and always returned
+	err error
+}
+
+func (pl *profileList) Next() bool {
+	if pl.err != nil {
+		return false
+	}
+
+	if err := pl.ctx.Err(); err != nil {
+		pl.setErr(err)
+		return false
+	}
+
+	if len(pl.pks) == 0 {
+		return false
+	}
+
+	pl.pk, pl.pks = &pl.pks[0], pl.pks[1:]
+
+	return true
+}
+
+func (pl *profileList) Profile() (io.Reader, error) {
+	if err := pl.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if pl.err != nil {
+		return nil, pl.err
+	}
+
+	if pl.pk == nil {
+		// this must never happen
+		panic("clickhouse profileList: profile out of range")
+	}
+
+	pp, err := pl.st.readProfile(pl.ctx, pl.tenant, *pl.pk)
+	if err != nil {
+		pl.setErr(err)
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pp.Write(&buf); err != nil {
+		pl.setErr(err)
+		return nil, err
+	}
+	return &buf, nil
+}
+
+func (pl *profileList) Close() error {
+	// prevent any use of this list
+	pl.setErr(fmt.Errorf("clickhouse profileList: list closed"))
+	return nil
 }
 
-func (st *Storage) ListServices(ctx context.Context) (services []string, err error) {
-	rows, err := st.db.QueryContext(ctx, sqlSelectServiceNames)
+func (pl *profileList) setErr(err error) {
+	if pl.err == nil {
+		pl.err = err
+	}
+}
+
+func (st *Storage) readProfile(ctx context.Context, tenant string, pk ProfileKey) (*pprofProfile.Profile, error) {
+	prec, err := st.selectProfile(ctx, tenant, pk)
+	if err != nil {
+		return nil, err
+	}
+
+	key := hex.EncodeToString(pk[:])
+	query := fmt.Sprintf(sqlSelectSamples, fmt.Sprintf(sqlJoinStacks, "profile_key = unhex(?)"))
+
+	st.logger.Debugw("readProfile: query samples", log.MultiLine("query", query), "pk", key)
+
+	// the first key is of the stacks' join
+	rows, err := st.db.QueryContext(ctx, query, key, key)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	st.logger.Debugw("listServices: query services", log.MultiLine("query", sqlSelectServiceNames))
+	pb := newProfileBuilder(prec)
+	for rows.Next() {
+		var srec sampleRecord
+		err := rows.Scan(
+			&srec.funcs,
+			&srec.files,
+			&srec.lines,
+			&srec.addrs,
+			&srec.mappingIDs,
+			&srec.values,
+			&srec.valueTypes,
+			&srec.valueUnits,
+			&srec.labelKeys,
+			&srec.labelVals,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := pb.AddSample(&srec); err != nil {
+			return nil, fmt.Errorf("could not rebuild profile with pk %v: %w", pk, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pp, err := pb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("could not rebuild profile with pk %v: %w", pk, err)
+	}
+	return pp, nil
+}
+
+func (st *Storage) selectProfile(ctx context.Context, tenant string, pk ProfileKey) (*profileRecord, error) {
+	// the profile key holds the time of profile's creation, truncated to the second
+	createdAt := xid.ID(pk).Time().UTC()
+	args := []interface{}{
+		tenant,
+		hex.EncodeToString(pk[:]),
+		createdAt,
+		createdAt.Add(time.Second),
+	}
+
+	st.logger.Debugw("readProfile: query profile", log.MultiLine("query", sqlSelectProfile), "args", args)
+
+	var (
+		prec  profileRecord
+		ptype string // clickhouse returns string value for enums
+
+		mappingIDs, starts, limits, offsets []uint64
+		files, buildIDs                     []string
+		hasFuncs                            []uint8
+	)
+	err := st.db.QueryRowContext(ctx, sqlSelectProfile, args...).Scan(
+		&ptype,
+		&prec.createdAt,
+		&prec.periodType,
+		&prec.periodUnit,
+		&prec.period,
+		&prec.durationNanos,
+		&mappingIDs,
+		&starts,
+		&limits,
+		&offsets,
+		&files,
+		&buildIDs,
+		&hasFuncs,
+	)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := prec.ptype.FromString(ptype); err != nil {
+		return nil, err
+	}
+
+	for i, id := range mappingIDs {
+		prec.mappings = append(prec.mappings, &pprofProfile.Mapping{
+			ID:           id,
+			Start:        starts[i],
+			Limit:        limits[i],
+			Offset:       offsets[i],
+			File:         files[i],
+			BuildID:      buildIDs[i],
+			HasFunctions: hasFuncs[i] != 0,
+		})
+	}
+
+	return &prec, nil
+}
+
+func (st *Storage) ListServices(ctx context.Context, tenant string) (services []string, err error) {
+	rows, err := st.db.QueryContext(ctx, sqlSelectServiceNames, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	st.logger.Debugw("listServices: query services", log.MultiLine("query", sqlSelectServiceNames), "tenant", tenant)
 
 	for rows.Next() {
 		var name string
@@ -145,6 +423,42 @@ func (st *Storage) ListServices(ctx context.Context) (services []string, err err
 	return services, nil
 }
 
+func (st *Storage) ListTenants(ctx context.Context) (tenants []string, err error) {
+	st.logger.Debugw("listTenants: query tenants", log.MultiLine("query", sqlSelectTenants))
+
+	rows, err := st.db.QueryContext(ctx, sqlSelectTenants)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return tenants, nil
+}
+
+// profiles of the types, ClickHouse doesn't support, are never written, thus never found
+func isSupportedType(ptype profile.ProfileType) bool {
+	if ptype == profile.TypeUnknown {
+		return true
+	}
+	_, err := ProfileTypeToDBModel(ptype)
+	return err == nil
+}
+
 // builds SELECT profiles SQL query and its corresponding arguments
 func buildSQLSelectProfiles(columns []string, params *storage.FindProfilesParams) (string, []interface{}, error) {
 	if params.Service == "" {
@@ -164,9 +478,10 @@ func buildSQLSelectProfiles(columns []string, params *storage.FindProfilesParams
 	}
 
 	whereClause := make([]string, 0, 4)
-	args := make([]interface{}, 1, 4)
+	args := make([]interface{}, 2, 5)
 
-	args[0] = params.Service
+	args[0] = params.Tenant
+	args[1] = params.Service
 
 	if params.Type != profile.TypeUnknown {
 		ptype, err := ProfileTypeToDBModel(params.Type)
@@ -193,11 +508,29 @@ func buildSQLSelectProfiles(columns []string, params *storage.FindProfilesParams
 		whereClause = append(whereClause, fmt.Sprintf("hasAll(arrayZip(labels.key, labels.value), [%s])", strings.Join(labels, ",")))
 	}
 
-	conds := make([]string, 0, 3)
+	if len(params.SampleLabels) > 0 {
+		// skip the profiles, that don't have a sample with all the labels; the samples are looked up within the time
+		// range of the profiles, the samples' created_at is the creation time of their profile
+		// AND profile_key IN (SELECT profile_key FROM pprof_samples WHERE (created_at >= ?) AND (created_at < ?)
+		//   AND hasAll(arrayZip(labels.key, labels.value), [('endpoint', '/checkout')]))
+		args = append(args, params.CreatedAtMin, createdAtMax)
+		labels := make([]string, 0, len(params.SampleLabels))
+		for _, label := range params.SampleLabels {
+			labels = append(labels, "(?, ?)")
+			args = append(args, label.Key, label.Value)
+		}
+		whereClause = append(whereClause, fmt.Sprintf(
+			"profile_key IN (SELECT profile_key FROM pprof_samples WHERE (created_at >= ?) AND (created_at < ?) AND hasAll(arrayZip(labels.key, labels.value), [%s]))",
+			strings.Join(labels, ","),
+		))
+	}
+
+	conds := make([]string, 0, 4)
 	if len(whereClause) > 0 {
 		conds = append(conds, "AND "+strings.Join(whereClause, " AND "))
 	}
 	conds = append(conds, "ORDER BY created_at, profile_type")
+	conds = append(conds, "LIMIT 1 BY profile_key")
 	if params.Limit > 0 {
 		conds = append(conds, fmt.Sprintf("LIMIT %d", params.Limit))
 	}
@@ -0,0 +1,36 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildSQLSelectProfiles_sampleLabels(t *testing.T) {
+	createdAtMin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAtMax := createdAtMin.Add(time.Hour)
+
+	params := &storage.FindProfilesParams{
+		Tenant:       "t1",
+		Service:      "svc1",
+		Labels:       profile.Labels{{Key: "region", Value: "eu"}},
+		SampleLabels: profile.Labels{{Key: "endpoint", Value: "/checkout"}},
+		CreatedAtMin: createdAtMin,
+		CreatedAtMax: createdAtMax,
+	}
+
+	query, args, err := buildSQLSelectProfiles(selectProfilesColumns[:1], params)
+	require.NoError(t, err)
+
+	wantQuery := `SELECT profile_key FROM pprof_profiles WHERE tenant = ? AND service_name = ? ` +
+		`AND (created_at >= ?) AND (created_at < ?) ` +
+		`AND hasAll(arrayZip(labels.key, labels.value), [(?, ?)]) ` +
+		`AND profile_key IN (SELECT profile_key FROM pprof_samples WHERE (created_at >= ?) AND (created_at < ?) AND hasAll(arrayZip(labels.key, labels.value), [(?, ?)])) ` +
+		`ORDER BY created_at, profile_type LIMIT 1 BY profile_key;`
+	assert.Equal(t, wantQuery, query)
+	assert.Equal(t, []interface{}{"t1", "svc1", createdAtMin, createdAtMax, "region", "eu", createdAtMin, createdAtMax, "endpoint", "/checkout"}, args)
+}
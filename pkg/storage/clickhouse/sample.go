@@ -37,17 +37,7 @@ var samplesFingerprinterPool = sync.Pool{
 func (sfp *samplesFingerprinter) Fingerprint(sample *pprofProfile.Sample) uint64 {
 	defer sfp.hash.Reset()
 
-	// locations
-	for _, loc := range sample.Location {
-		sfp.buf = strconv.AppendUint(sfp.buf, loc.Address, 16)
-		for _, line := range loc.Line {
-			sfp.buf = append(sfp.buf, '|')
-			sfp.buf = append(sfp.buf, line.Function.Filename...)
-			sfp.buf = append(sfp.buf, ':')
-			sfp.buf = strconv.AppendInt(sfp.buf, line.Line, 10)
-			sfp.buf = append(sfp.buf, line.Function.Name...)
-		}
-	}
+	sfp.appendLocations(sample)
 
 	sfp.hash.Write(sfp.buf)
 	sfp.buf = sfp.buf[:0]
@@ -82,3 +72,51 @@ func (sfp *samplesFingerprinter) Fingerprint(sample *pprofProfile.Sample) uint64
 
 	return sfp.hash.Sum64()
 }
+
+// StackFingerprint calculates a hash for the stack of a single profile sample, i.e. for its locations and
+// their mappings; the samples of all profiles, that have the same stack, share it
+func (sfp *samplesFingerprinter) StackFingerprint(sample *pprofProfile.Sample) uint64 {
+	defer sfp.hash.Reset()
+
+	sfp.appendLocations(sample)
+	for _, loc := range sample.Location {
+		sfp.buf = append(sfp.buf, '|')
+		if loc.Mapping != nil {
+			sfp.buf = strconv.AppendUint(sfp.buf, loc.Mapping.ID, 16)
+		}
+	}
+
+	sfp.hash.Write(sfp.buf)
+	sfp.buf = sfp.buf[:0]
+
+	return sfp.hash.Sum64()
+}
+
+func (sfp *samplesFingerprinter) appendLocations(sample *pprofProfile.Sample) {
+	for _, loc := range sample.Location {
+		sfp.buf = strconv.AppendUint(sfp.buf, loc.Address, 16)
+		for _, line := range loc.Line {
+			sfp.buf = append(sfp.buf, '|')
+			sfp.buf = append(sfp.buf, line.Function.Filename...)
+			sfp.buf = append(sfp.buf, ':')
+			sfp.buf = strconv.AppendInt(sfp.buf, line.Line, 10)
+			sfp.buf = append(sfp.buf, line.Function.Name...)
+		}
+	}
+}
+
+// returns the stack fingerprints of the samples, in the order of the samples; the empty samples aren't written,
+// thus their stacks are zero
+func stackFingerprints(samples []*pprofProfile.Sample) []uint64 {
+	fingerprinter := samplesFingerprinterPool.Get().(*samplesFingerprinter)
+	defer samplesFingerprinterPool.Put(fingerprinter)
+
+	stacks := make([]uint64, len(samples))
+	for i, sample := range samples {
+		if isEmptySample(sample) {
+			continue
+		}
+		stacks[i] = fingerprinter.StackFingerprint(sample)
+	}
+	return stacks
+}
@@ -0,0 +1,38 @@
+package clickhouse
+
+import (
+	"testing"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplesFingerprinter_StackFingerprint(t *testing.T) {
+	fn := &pprofProfile.Function{Name: "main.main", Filename: "main.go"}
+	newSample := func(mapping *pprofProfile.Mapping, labels map[string][]string) *pprofProfile.Sample {
+		return &pprofProfile.Sample{
+			Location: []*pprofProfile.Location{
+				{Mapping: mapping, Address: 0x1000, Line: []pprofProfile.Line{{Function: fn, Line: 10}}},
+			},
+			Value: []int64{1},
+			Label: labels,
+		}
+	}
+
+	sfp := samplesFingerprinterPool.Get().(*samplesFingerprinter)
+	defer samplesFingerprinterPool.Put(sfp)
+
+	m1 := &pprofProfile.Mapping{ID: 1}
+	s1 := newSample(m1, nil)
+	s2 := newSample(m1, map[string][]string{"endpoint": {"/checkout"}})
+
+	// the samples with different labels share the stack
+	assert.NotEqual(t, sfp.Fingerprint(s1), sfp.Fingerprint(s2))
+	assert.Equal(t, sfp.StackFingerprint(s1), sfp.StackFingerprint(s2))
+
+	// the locations of different mappings are different stacks
+	assert.NotEqual(t, sfp.StackFingerprint(s1), sfp.StackFingerprint(newSample(&pprofProfile.Mapping{ID: 2}, nil)))
+	assert.NotEqual(t, sfp.StackFingerprint(s1), sfp.StackFingerprint(newSample(nil, nil)))
+
+	assert.Equal(t, []uint64{sfp.StackFingerprint(s1), 0}, stackFingerprints([]*pprofProfile.Sample{s1, {Value: []int64{0}}}))
+}
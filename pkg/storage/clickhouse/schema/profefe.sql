@@ -1,3 +1,7 @@
+-- The collector creates the tables and migrates them to the latest version of the schema on start
+-- (see migrate.go), unless started with -clickhouse.schema.migrate=false. The file is the reference
+-- of the latest schema of a single-node setup.
+
 CREATE TABLE IF NOT EXISTS pprof_profiles (
     profile_key FixedString(12),
     profile_type Enum8(
@@ -10,24 +14,56 @@ CREATE TABLE IF NOT EXISTS pprof_profiles (
         'other' = 100
     ),
     external_id String,
+    tenant LowCardinality(String) DEFAULT '',
     service_name LowCardinality(String),
     created_at DateTime,
     labels Nested (
         key LowCardinality(String),
         value String
+    ),
+    period_type LowCardinality(String) DEFAULT '',
+    period_unit LowCardinality(String) DEFAULT '',
+    period Int64 DEFAULT 0,
+    duration_nanos Int64 DEFAULT 0,
+    mappings Nested (
+        id UInt64,
+        memory_start UInt64,
+        memory_limit UInt64,
+        file_offset UInt64,
+        file_name String,
+        build_id String,
+        has_functions UInt8
     )
 )
 ENGINE=MergeTree()
 PARTITION BY (toYYYYMM(created_at), service_name)
-ORDER BY (service_name, profile_type, created_at);
+ORDER BY (tenant, service_name, profile_type, created_at);
+
+CREATE TABLE IF NOT EXISTS pprof_stacks (
+    stack_fingerprint UInt64,
+    locations Nested (
+        func_name LowCardinality(String),
+        file_name LowCardinality(String),
+        lineno UInt16,
+        address UInt64,
+        mapping_id UInt64
+    ),
+    created_at DateTime
+)
+ENGINE=ReplacingMergeTree(created_at)
+ORDER BY stack_fingerprint;
 
 CREATE TABLE IF NOT EXISTS pprof_samples (
     profile_key FixedString(12),
     fingerprint UInt64,
+    stack_fingerprint UInt64 DEFAULT 0,
+    -- the locations of the samples, written before the stacks were stored in pprof_stacks
     locations Nested (
         func_name LowCardinality(String),
         file_name LowCardinality(String),
-        lineno UInt16
+        lineno UInt16,
+        address UInt64,
+        mapping_id UInt64
     ),
     values Array(Int64),
     values_type Array(LowCardinality(String)),
@@ -35,7 +71,8 @@ CREATE TABLE IF NOT EXISTS pprof_samples (
     labels Nested (
         key String,
         value String
-    )
+    ),
+    created_at DateTime MATERIALIZED toDateTime(reinterpretAsUInt32(reverse(substring(profile_key, 1, 4))))
 )
 ENGINE=ReplacingMergeTree()
 ORDER BY (profile_key, fingerprint);
@@ -0,0 +1,77 @@
+package clickhouse
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// the number of the written stacks remembered by a writer
+	writtenStacksCacheSize = 100000
+	// a stack isn't written again for the profiles created within the window after the stack was written with;
+	// pprof_stacks keeps the stacks for the window longer than the samples, thus the skipped stacks don't expire
+	// before the samples, that reference them
+	writtenStacksWindow = time.Hour
+)
+
+// writtenStacks remembers the fingerprints of the recently written stacks, so that the stacks, shared by
+// the profiles, aren't written with every profile.
+type writtenStacks struct {
+	mu        sync.Mutex
+	cacheSize int
+	cache     map[uint64]*list.Element
+	lru       *list.List
+}
+
+type writtenStack struct {
+	fingerprint uint64
+	// the latest creation time of the profiles the stack was written with
+	createdAt time.Time
+}
+
+func newWrittenStacks(cacheSize int) *writtenStacks {
+	return &writtenStacks{
+		cacheSize: cacheSize,
+		cache:     make(map[uint64]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// Written reports whether the stack was written with a profile, created less than writtenStacksWindow
+// before the createdAt.
+func (ws *writtenStacks) Written(fingerprint uint64, createdAt time.Time) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	el, ok := ws.cache[fingerprint]
+	if !ok {
+		return false
+	}
+	ws.lru.MoveToFront(el)
+	return createdAt.Before(el.Value.(*writtenStack).createdAt.Add(writtenStacksWindow))
+}
+
+// Add remembers the stacks as written with the profile created at the createdAt.
+func (ws *writtenStacks) Add(fingerprints []uint64, createdAt time.Time) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for _, fingerprint := range fingerprints {
+		if el, ok := ws.cache[fingerprint]; ok {
+			entry := el.Value.(*writtenStack)
+			if createdAt.After(entry.createdAt) {
+				entry.createdAt = createdAt
+			}
+			ws.lru.MoveToFront(el)
+			continue
+		}
+
+		ws.cache[fingerprint] = ws.lru.PushFront(&writtenStack{fingerprint: fingerprint, createdAt: createdAt})
+		for ws.lru.Len() > ws.cacheSize {
+			el := ws.lru.Back()
+			ws.lru.Remove(el)
+			delete(ws.cache, el.Value.(*writtenStack).fingerprint)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrittenStacks(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ws := newWrittenStacks(2)
+	ws.Add([]uint64{1, 2}, createdAt)
+
+	assert.True(t, ws.Written(1, createdAt))
+	// the older profiles reference the stack, that expires later than their samples
+	assert.True(t, ws.Written(1, createdAt.Add(-time.Hour)))
+	assert.True(t, ws.Written(1, createdAt.Add(writtenStacksWindow-time.Second)))
+	// the stack is written again, so that it doesn't expire before the samples of the profile
+	assert.False(t, ws.Written(1, createdAt.Add(writtenStacksWindow)))
+	assert.False(t, ws.Written(3, createdAt))
+
+	ws.Add([]uint64{1}, createdAt.Add(writtenStacksWindow))
+	assert.True(t, ws.Written(1, createdAt.Add(writtenStacksWindow)))
+
+	// the least recently used stack is forgotten
+	ws.Add([]uint64{3}, createdAt)
+	assert.True(t, ws.Written(3, createdAt))
+	assert.True(t, ws.Written(1, createdAt))
+	assert.False(t, ws.Written(2, createdAt))
+}
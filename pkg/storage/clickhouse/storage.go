@@ -21,7 +21,10 @@ type Storage struct {
 	samplesWriter  SamplesWriter
 }
 
-var _ storage.Storage = (*Storage)(nil)
+var (
+	_ storage.Storage = (*Storage)(nil)
+	_ storage.Flusher = (*Storage)(nil)
+)
 
 func NewStorage(logger *log.Logger, db *sql.DB, profilesWriter ProfilesWriter, samplesWriter SamplesWriter) (*Storage, error) {
 	st := &Storage{
@@ -33,6 +36,14 @@ func NewStorage(logger *log.Logger, db *sql.DB, profilesWriter ProfilesWriter, s
 	return st, nil
 }
 
+// Flush waits for the batched writes to be stored; it does nothing, if the writes aren't batched.
+func (st *Storage) Flush(ctx context.Context) error {
+	if f, ok := st.profilesWriter.(storage.Flusher); ok {
+		return f.Flush(ctx)
+	}
+	return nil
+}
+
 func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
 	ptype, err := ProfileTypeToDBModel(params.Type)
 	if err != nil {
@@ -63,6 +74,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 	meta := profile.Meta{
 		ProfileID:  profile.ID(pid),
 		ExternalID: params.ExternalID,
+		Tenant:     params.Tenant,
 		Service:    params.Service,
 		Type:       params.Type,
 		Labels:     params.Labels,
@@ -82,7 +94,7 @@ func (st *Storage) writeProfile(
 	if err := st.samplesWriter.WriteSamples(ctx, pk, pp.Sample, pp.SampleType); err != nil {
 		return err
 	}
-	if err := st.profilesWriter.WriteProfile(ctx, pk, ptype, createdAt, params); err != nil {
+	if err := st.profilesWriter.WriteProfile(ctx, pk, ptype, createdAt, params, pp); err != nil {
 		return err
 	}
 	return nil
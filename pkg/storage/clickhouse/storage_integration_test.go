@@ -1,14 +1,22 @@
 package clickhouse_test
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/ClickHouse/clickhouse-go"
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/pprofutil"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
 	storageCH "github.com/profefe/profefe/pkg/storage/clickhouse"
 	"github.com/profefe/profefe/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap/zapcore"
@@ -26,6 +34,15 @@ func TestStorage(t *testing.T) {
 
 	logger := log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel)))
 
+	t.Run("Migrate", func(t *testing.T) {
+		sc := &storageCH.SchemaConfig{PartitionBy: "(toYYYYMM(created_at), service_name)"}
+		// the tables, created by schema/profefe.sql, are migrated as well; migrating twice is a no-op
+		for n := 0; n < 2; n++ {
+			require.NoError(t, storageCH.Migrate(context.Background(), logger, db, sc))
+		}
+		require.NoError(t, storageCH.CheckSchema(context.Background(), db))
+	})
+
 	profilesWriter := storageCH.NewProfilesWriter(logger, db)
 	samplesWriter := storageCH.NewSamplesWriter(logger, db)
 
@@ -43,6 +60,109 @@ func TestStorage(t *testing.T) {
 		}
 		suite.Run(t, ts)
 	})
+
+	t.Run("Writer", func(t *testing.T) {
+		suite.Run(t, &storagetest.WriterTestSuite{
+			Reader: st,
+			Writer: st,
+		})
+	})
+
+	t.Run("Aggregator", func(t *testing.T) {
+		testAggregator(t, st)
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		testDuplicates(t, db, st)
+	})
+
+	t.Run("WriteProfile tenant", func(t *testing.T) {
+		params := &storage.WriteProfileParams{
+			Tenant:  "tenant1",
+			Service: fmt.Sprintf("test-service-%x", time.Now().Nanosecond()),
+			Type:    profile.TypeCPU,
+		}
+		meta, _ := storagetest.WriteProfile(t, st, params, "../../../testdata/collector_cpu_1.prof")
+		assert.Equal(t, "tenant1", meta.Tenant)
+	})
+}
+
+func testAggregator(t *testing.T, st *storageCH.Storage) {
+	service := fmt.Sprintf("test-service-%x", time.Now().Nanosecond())
+
+	var (
+		pids []profile.ID
+		pps  []*pprofProfile.Profile
+	)
+	for n := 1; n <= 3; n++ {
+		params := &storage.WriteProfileParams{
+			Service: service,
+			Type:    profile.TypeCPU,
+		}
+		meta, data := storagetest.WriteProfile(t, st, params, fmt.Sprintf("../../../testdata/collector_cpu_%d.prof", n))
+		pids = append(pids, meta.ProfileID)
+
+		pp, err := pprofProfile.ParseData(data)
+		require.NoError(t, err)
+		pps = append(pps, pp)
+	}
+
+	params := &storage.AggregateParams{ProfileIDs: pids}
+
+	t.Run("merge", func(t *testing.T) {
+		want, err := pprofProfile.Merge(pps)
+		require.NoError(t, err)
+
+		got, err := st.MergeProfiles(context.Background(), params)
+		require.NoError(t, err)
+		assert.True(t, pprofutil.ProfilesEqual(want, got))
+	})
+
+	t.Run("merge other tenant", func(t *testing.T) {
+		_, err := st.MergeProfiles(context.Background(), &storage.AggregateParams{Tenant: "tenant2", ProfileIDs: pids})
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("top", func(t *testing.T) {
+		funcs, err := st.TopFunctions(context.Background(), params, "cpu", 5)
+		require.NoError(t, err)
+		require.Len(t, funcs, 5)
+		for i, ft := range funcs {
+			assert.True(t, ft.Flat <= ft.Cum, "flat must not exceed cum: %v", ft)
+			if i > 0 {
+				assert.True(t, funcs[i-1].Flat >= ft.Flat, "functions must be sorted by flat: %v", funcs)
+			}
+		}
+	})
+}
+
+// the rows, stored again by a retried insert of a batch, are read once
+func testDuplicates(t *testing.T, db *sql.DB, st *storageCH.Storage) {
+	params := &storage.WriteProfileParams{
+		Service: fmt.Sprintf("test-service-%x", time.Now().Nanosecond()),
+		Type:    profile.TypeCPU,
+	}
+	meta, data := storagetest.WriteProfile(t, st, params, "../../../testdata/collector_cpu_1.prof")
+
+	want, err := pprofProfile.ParseData(data)
+	require.NoError(t, err)
+
+	for _, table := range []string{"pprof_profiles", "pprof_samples"} {
+		_, err := db.Exec(`INSERT INTO `+table+` SELECT * FROM `+table+` WHERE profile_key IN (SELECT profile_key FROM pprof_profiles WHERE service_name = ?)`, params.Service)
+		require.NoError(t, err)
+	}
+
+	metas, err := st.FindProfiles(context.Background(), &storage.FindProfilesParams{
+		Service:      params.Service,
+		CreatedAtMin: meta.CreatedAt,
+		CreatedAtMax: meta.CreatedAt.Add(time.Second),
+	})
+	require.NoError(t, err)
+	require.Len(t, metas, 1)
+
+	got, err := st.MergeProfiles(context.Background(), &storage.AggregateParams{ProfileIDs: []profile.ID{meta.ProfileID}})
+	require.NoError(t, err)
+	assert.True(t, pprofutil.ProfilesEqual(want, got))
 }
 
 type ReaderTestSuite struct {
@@ -57,11 +177,9 @@ func (ts *ReaderTestSuite) SetupTest() {
 
 	_, err = ts.DB.Exec(`TRUNCATE TABLE pprof_samples`)
 	ts.Require().NoError(err)
-}
 
-// not supported by storage
-func (ts *ReaderTestSuite) TestListProfiles() {
-	ts.T().SkipNow()
+	_, err = ts.DB.Exec(`TRUNCATE TABLE pprof_stacks`)
+	ts.Require().NoError(err)
 }
 
 func setupDB(t *testing.T, dsn string) *sql.DB {
@@ -12,6 +12,7 @@ import (
 	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/log"
 	"github.com/profefe/profefe/pkg/storage"
+	"github.com/rs/xid"
 	"go.uber.org/zap"
 )
 
@@ -21,10 +22,34 @@ const (
 			profile_key,
 			profile_type,
 			external_id,
+			tenant,
 			service_name,
 			created_at,
 			labels.key,
-			labels.value
+			labels.value,
+			period_type,
+			period_unit,
+			period,
+			duration_nanos,
+			mappings.id,
+			mappings.memory_start,
+			mappings.memory_limit,
+			mappings.file_offset,
+			mappings.file_name,
+			mappings.build_id,
+			mappings.has_functions
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	sqlInsertPprofStacks = `
+		INSERT INTO pprof_stacks (
+			stack_fingerprint,
+			locations.func_name,
+			locations.file_name,
+			locations.lineno,
+			locations.address,
+			locations.mapping_id,
+			created_at
 		)
 		VALUES (?, ?, ?, ?, ?, ?, ?)`
 
@@ -32,20 +57,18 @@ const (
 		INSERT INTO pprof_samples (
 			profile_key,
 			fingerprint,
-			locations.func_name,
-			locations.file_name,
-			locations.lineno,
+			stack_fingerprint,
 			values,
 			values_type,
 			values_unit,
 			labels.key,
 			labels.value
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 )
 
 type ProfilesWriter interface {
-	WriteProfile(ctx context.Context, pk ProfileKey, ptype ProfileType, createdAt time.Time, params *storage.WriteProfileParams) error
+	WriteProfile(ctx context.Context, pk ProfileKey, ptype ProfileType, createdAt time.Time, params *storage.WriteProfileParams, pp *pprofProfile.Profile) error
 }
 
 type SamplesWriter interface {
@@ -92,9 +115,10 @@ func (pw *profilesWriter) WriteProfile(
 	ptype ProfileType,
 	createdAt time.Time,
 	params *storage.WriteProfileParams,
+	pp *pprofProfile.Profile,
 ) error {
 	return withinTx(ctx, pw.db, func(tx *sql.Tx) error {
-		return pw.insertPprofProfiles(ctx, tx, pk, ptype, createdAt, params)
+		return pw.insertPprofProfiles(ctx, tx, pk, ptype, createdAt, params, pp)
 	})
 }
 
@@ -105,12 +129,29 @@ func (pw *profilesWriter) insertPprofProfiles(
 	ptype ProfileType,
 	createdAt time.Time,
 	params *storage.WriteProfileParams,
+	pp *pprofProfile.Profile,
 ) error {
 	stmt, err := tx.PrepareContext(ctx, sqlInsertPprofProfiles)
 	if err != nil {
 		return err
 	}
 
+	if err := pw.execInsertPprofProfiles(ctx, stmt, pk, ptype, createdAt, params, pp); err != nil {
+		return err
+	}
+
+	return stmt.Close()
+}
+
+func (pw *profilesWriter) execInsertPprofProfiles(
+	ctx context.Context,
+	stmt *sql.Stmt,
+	pk ProfileKey,
+	ptype ProfileType,
+	createdAt time.Time,
+	params *storage.WriteProfileParams,
+	pp *pprofProfile.Profile,
+) error {
 	ln := len(params.Labels)
 	labels := make([]string, ln*2)
 	for i, label := range params.Labels {
@@ -123,72 +164,129 @@ func (pw *profilesWriter) insertPprofProfiles(
 		pk,
 		ptype,
 		params.ExternalID,
+		params.Tenant,
 		params.Service,
 		clickhouse.DateTime(createdAt),
 		clickhouse.Array(labels[:ln]),
 		clickhouse.Array(labels[ln:]),
 	}
 
+	var periodType, periodUnit string
+	if pp.PeriodType != nil {
+		periodType = pp.PeriodType.Type
+		periodUnit = pp.PeriodType.Unit
+	}
+	args = append(args, periodType, periodUnit, pp.Period, pp.DurationNanos)
+	args = append(args, collectMappings(pp.Mapping)...)
+
 	pw.logger.Debugw("insertPprofProfiles: insert profile", log.ByteString("pk", pk[:]), log.MultiLine("query", sqlInsertPprofProfiles), "args", args)
 
 	if _, err := stmt.ExecContext(ctx, args...); err != nil {
 		return fmt.Errorf("could not insert profile: %w", err)
 	}
 
-	return stmt.Close()
+	return nil
 }
 
 type samplesWriter struct {
 	db     *sql.DB
 	logger *log.Logger
+	// the stacks, that were recently written, aren't written again
+	written *writtenStacks
 }
 
 func NewSamplesWriter(logger *log.Logger, db *sql.DB) SamplesWriter {
 	return &samplesWriter{
-		logger: logger,
-		db:     db,
+		logger:  logger,
+		db:      db,
+		written: newWrittenStacks(writtenStacksCacheSize),
 	}
 }
 
 func (sw *samplesWriter) WriteSamples(ctx context.Context, pk ProfileKey, samples []*pprofProfile.Sample, sampleTypes []*pprofProfile.ValueType) error {
+	stacks := stackFingerprints(samples)
+
+	// the stacks go first, thus the samples never reference the stacks, that weren't written
+	if newStacks := sw.newStacks(pk, stacks); len(newStacks) > 0 {
+		err := withinTx(ctx, sw.db, func(tx *sql.Tx) error {
+			return sw.insertPprofStacks(ctx, tx, pk, samples, stacks, newStacks)
+		})
+		if err != nil {
+			return err
+		}
+		sw.stacksWritten(pk, stacks, newStacks)
+	}
 	return withinTx(ctx, sw.db, func(tx *sql.Tx) error {
-		return sw.insertPprofSamples(ctx, tx, pk, samples, sampleTypes)
+		return sw.insertPprofSamples(ctx, tx, pk, samples, sampleTypes, stacks)
 	})
 }
 
-func (sw *samplesWriter) insertPprofSamples(ctx context.Context, tx *sql.Tx, pk ProfileKey, samples []*pprofProfile.Sample, sampleTypes []*pprofProfile.ValueType) error {
-	stmt, err := tx.PrepareContext(ctx, sqlInsertPprofSamples)
+// returns the indexes of the samples, which stacks must be written: every stack once, except the stacks
+// of the empty samples, and the stacks, that were recently written
+func (sw *samplesWriter) newStacks(pk ProfileKey, stacks []uint64) []int {
+	createdAt := xid.ID(pk).Time()
+
+	var newStacks []int
+	seen := make(map[uint64]struct{}, len(stacks))
+	for n, stack := range stacks {
+		// the empty samples have no stacks
+		if stack == 0 {
+			continue
+		}
+		if _, ok := seen[stack]; ok {
+			continue
+		}
+		seen[stack] = struct{}{}
+
+		if sw.written.Written(stack, createdAt) {
+			continue
+		}
+		newStacks = append(newStacks, n)
+	}
+	return newStacks
+}
+
+// remembers the stacks of the samples as written, once they are stored
+func (sw *samplesWriter) stacksWritten(pk ProfileKey, stacks []uint64, newStacks []int) {
+	fingerprints := make([]uint64, len(newStacks))
+	for i, n := range newStacks {
+		fingerprints[i] = stacks[n]
+	}
+	sw.written.Add(fingerprints, xid.ID(pk).Time())
+}
+
+func (sw *samplesWriter) insertPprofStacks(ctx context.Context, tx *sql.Tx, pk ProfileKey, samples []*pprofProfile.Sample, stacks []uint64, newStacks []int) error {
+	stmt, err := tx.PrepareContext(ctx, sqlInsertPprofStacks)
 	if err != nil {
 		return err
 	}
 
-	args := make([]interface{}, 10) // size of the slice is from number of inserted values in the query
-	args[0] = pk
+	if err := sw.execInsertPprofStacks(ctx, stmt, pk, samples, stacks, newStacks); err != nil {
+		return err
+	}
 
-	fingerprinter := samplesFingerprinterPool.Get().(*samplesFingerprinter)
-	defer samplesFingerprinterPool.Put(fingerprinter)
+	return stmt.Close()
+}
 
-	valueTypes := make([]string, len(sampleTypes))
-	valueUnits := make([]string, len(sampleTypes))
-	for i := 0; i < len(sampleTypes); i++ {
-		valueTypes[i] = sampleTypes[i].Type
-		valueUnits[i] = sampleTypes[i].Unit
-	}
+// inserts the stacks of the samples with the indexes; the stacks, written again, are replaced by the latest ones,
+// that expire the last
+func (sw *samplesWriter) execInsertPprofStacks(ctx context.Context, stmt *sql.Stmt, pk ProfileKey, samples []*pprofProfile.Sample, stacks []uint64, newStacks []int) error {
+	args := make([]interface{}, 7) // size of the slice is from number of inserted values in the query
+	args[6] = clickhouse.DateTime(xid.ID(pk).Time())
 
 	// reusable slice buffers
 	var (
-		lines                                    []uint16
-		locs, funcs, files, labelKeys, labelVals []string
+		lines              []uint16
+		addrs, mappingIDs  []uint64
+		locs, funcs, files []string
 	)
-	for n, sample := range samples {
+	for _, n := range newStacks {
 		// exit quickly on cancelled context
 		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if isEmptySample(sample) {
-			continue
-		}
+		sample := samples[n]
 
 		nlocs := len(sample.Location)
 		sz := nlocs * 2
@@ -201,20 +299,76 @@ func (sw *samplesWriter) insertPprofSamples(ctx context.Context, tx *sql.Tx, pk
 			lines = make([]uint16, 0, nlocs)
 		}
 
-		args[1] = fingerprinter.Fingerprint(sample)
+		args[0] = stacks[n]
 
 		funcs, files, lines = collectLocations(sample, locs, lines)
-		args[2] = clickhouse.Array(funcs)
-		args[3] = clickhouse.Array(files)
-		args[4] = clickhouse.Array(lines)
+		args[1] = clickhouse.Array(funcs)
+		args[2] = clickhouse.Array(files)
+		args[3] = clickhouse.Array(lines)
+
+		addrs, mappingIDs = collectAddresses(sample, addrs, mappingIDs)
+		args[4] = clickhouse.Array(addrs)
+		args[5] = clickhouse.Array(mappingIDs)
+
+		sw.logger.Debugw("insertPprofStacks: insert stack", log.ByteString("pk", pk[:]), log.MultiLine("query", sqlInsertPprofStacks), "args", args)
+
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("could not insert stack of sample %d: %w", n, err)
+		}
+	}
+
+	return nil
+}
+
+func (sw *samplesWriter) insertPprofSamples(ctx context.Context, tx *sql.Tx, pk ProfileKey, samples []*pprofProfile.Sample, sampleTypes []*pprofProfile.ValueType, stacks []uint64) error {
+	stmt, err := tx.PrepareContext(ctx, sqlInsertPprofSamples)
+	if err != nil {
+		return err
+	}
+
+	if err := sw.execInsertPprofSamples(ctx, stmt, pk, samples, sampleTypes, stacks); err != nil {
+		return err
+	}
+
+	return stmt.Close()
+}
+
+func (sw *samplesWriter) execInsertPprofSamples(ctx context.Context, stmt *sql.Stmt, pk ProfileKey, samples []*pprofProfile.Sample, sampleTypes []*pprofProfile.ValueType, stacks []uint64) error {
+	args := make([]interface{}, 8) // size of the slice is from number of inserted values in the query
+	args[0] = pk
+
+	fingerprinter := samplesFingerprinterPool.Get().(*samplesFingerprinter)
+	defer samplesFingerprinterPool.Put(fingerprinter)
+
+	valueTypes := make([]string, len(sampleTypes))
+	valueUnits := make([]string, len(sampleTypes))
+	for i := 0; i < len(sampleTypes); i++ {
+		valueTypes[i] = sampleTypes[i].Type
+		valueUnits[i] = sampleTypes[i].Unit
+	}
+
+	// reusable slice buffers
+	var labelKeys, labelVals []string
+	for n, sample := range samples {
+		// exit quickly on cancelled context
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if isEmptySample(sample) {
+			continue
+		}
 
-		args[5] = clickhouse.Array(sample.Value)
-		args[6] = clickhouse.Array(valueTypes)
-		args[7] = clickhouse.Array(valueUnits)
+		args[1] = fingerprinter.Fingerprint(sample)
+		args[2] = stacks[n]
+
+		args[3] = clickhouse.Array(sample.Value)
+		args[4] = clickhouse.Array(valueTypes)
+		args[5] = clickhouse.Array(valueUnits)
 
 		labelKeys, labelVals = collectLabels(sample, labelKeys, labelVals)
-		args[8] = clickhouse.Array(labelKeys)
-		args[9] = clickhouse.Array(labelVals)
+		args[6] = clickhouse.Array(labelKeys)
+		args[7] = clickhouse.Array(labelVals)
 
 		sw.logger.Debugw("insertPprofSamples: insert sample", log.ByteString("pk", pk[:]), log.MultiLine("query", sqlInsertPprofSamples), "args", args)
 
@@ -223,7 +377,7 @@ func (sw *samplesWriter) insertPprofSamples(ctx context.Context, tx *sql.Tx, pk
 		}
 	}
 
-	return stmt.Close()
+	return nil
 }
 
 func collectLocations(sample *pprofProfile.Sample, locs []string, lines []uint16) ([]string, []string, []uint16) {
@@ -245,6 +399,52 @@ func collectLocations(sample *pprofProfile.Sample, locs []string, lines []uint16
 	return funcs, files, lines
 }
 
+// returns the addresses of sample's locations and the ids of locations' mappings (zero if a location has no mapping)
+func collectAddresses(sample *pprofProfile.Sample, addrs []uint64, mappingIDs []uint64) ([]uint64, []uint64) {
+	addrs = addrs[:0]
+	mappingIDs = mappingIDs[:0]
+	for _, loc := range sample.Location {
+		var mappingID uint64
+		if loc.Mapping != nil {
+			mappingID = loc.Mapping.ID
+		}
+		addrs = append(addrs, loc.Address)
+		mappingIDs = append(mappingIDs, mappingID)
+	}
+	return addrs, mappingIDs
+}
+
+// returns the values for mappings.* columns
+func collectMappings(mappings []*pprofProfile.Mapping) []interface{} {
+	var (
+		ids, starts, limits, offsets []uint64
+		files, buildIDs              []string
+		hasFuncs                     []uint8
+	)
+	for _, m := range mappings {
+		ids = append(ids, m.ID)
+		starts = append(starts, m.Start)
+		limits = append(limits, m.Limit)
+		offsets = append(offsets, m.Offset)
+		files = append(files, m.File)
+		buildIDs = append(buildIDs, m.BuildID)
+		var hasFunc uint8
+		if m.HasFunctions {
+			hasFunc = 1
+		}
+		hasFuncs = append(hasFuncs, hasFunc)
+	}
+	return []interface{}{
+		clickhouse.Array(ids),
+		clickhouse.Array(starts),
+		clickhouse.Array(limits),
+		clickhouse.Array(offsets),
+		clickhouse.Array(files),
+		clickhouse.Array(buildIDs),
+		clickhouse.Array(hasFuncs),
+	}
+}
+
 // supports only profiles string labels
 func collectLabels(sample *pprofProfile.Sample, keys []string, svals []string) ([]string, []string) {
 	keys = keys[:0]
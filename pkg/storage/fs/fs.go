@@ -0,0 +1,66 @@
+package fs
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRetentionPeriod = 5 * 24 * time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+type Config struct {
+	Dir             string
+	ProfileTTL      time.Duration
+	CleanupInterval time.Duration
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.Dir, "fs.dir", "", "directory profiles are stored in")
+	f.DurationVar(&conf.ProfileTTL, "fs.data-ttl", defaultRetentionPeriod, "fs data ttl (data is kept forever if zero)")
+	f.DurationVar(&conf.CleanupInterval, "fs.cleanup-interval", defaultCleanupInterval, "interval in which the expired data is removed")
+}
+
+func (conf *Config) CreateStorage(logger *log.Logger) (*Storage, io.Closer, error) {
+	if conf.Dir == "" {
+		return nil, nil, fmt.Errorf("empty fs dir")
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("could not create dir: %w", err)
+	}
+
+	st := NewStorage(logger, conf.Dir, conf.ProfileTTL)
+
+	// remove the expired profiles periodically
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(conf.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			if err := st.Cleanup(time.Now()); err != nil {
+				logger.Errorw("failed to remove expired profiles", zap.Error(err))
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return st, closerFunc(func() error {
+		close(done)
+		return nil
+	}), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
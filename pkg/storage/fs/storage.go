@@ -0,0 +1,775 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/rs/xid"
+)
+
+const (
+	// the directory of the default tenant's profiles
+	defaultTenantDir = "default"
+	// the directory of the non-default tenants, each tenant's profiles are stored in the sub-directory
+	tenantsDir = "tenants"
+	// the name of the index file of a day directory
+	indexFile = "index"
+
+	pprofExt = ".pb.gz"
+	traceExt = ".trace"
+
+	// the maximum length of a file name most file systems allow
+	maxFileNameLen = 255
+	defaultLimit   = 100
+)
+
+// Storage stores profiles as files in a directory.
+//
+// The layout of the directory:
+// default/service/profile_type/yyyy/mm/dd/digest,label1=value1,label2=value2.pb.gz
+//
+// The layout of the directory for a non-default tenant:
+// tenants/tenant/service/profile_type/yyyy/mm/dd/digest,label1=value1,label2=value2.pb.gz
+//
+// Where
+// "digest" uniquely describes the profile, it also includes profile's creation time;
+// the labels are left out of the file name if it gets too long. The services and the labels are escaped
+// by escapeName.
+//
+// Every day directory holds the index file, that lists the day's profiles with their creation time and labels,
+// one JSON object per line. The profile ID is the path of profile's file relative to the storage directory.
+type Storage struct {
+	logger *log.Logger
+	dir    string
+	ttl    time.Duration
+	// returns the retention period of a tenant, overriding ttl if not zero
+	tenantTTL func(tenant string) time.Duration
+
+	// guards the index files, the profiles are written and deleted under write lock
+	mu sync.RWMutex
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func NewStorage(logger *log.Logger, dir string, ttl time.Duration) *Storage {
+	return &Storage{
+		logger: logger,
+		dir:    dir,
+		ttl:    ttl,
+	}
+}
+
+// SetTenantTTL sets the function that returns per-tenant retention period.
+func (st *Storage) SetTenantTTL(tenantTTL func(tenant string) time.Duration) {
+	st.tenantTTL = tenantTTL
+}
+
+func (st *Storage) ttlFor(tenant string) time.Duration {
+	if st.tenantTTL != nil {
+		if ttl := st.tenantTTL(tenant); ttl > 0 {
+			return ttl
+		}
+	}
+	return st.ttl
+}
+
+// the entry of the index file
+type indexEntry struct {
+	Name      string         `json:"name"`
+	CreatedAt time.Time      `json:"created_at"`
+	Labels    profile.Labels `json:"labels,omitempty"`
+}
+
+func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return profile.Meta{}, fmt.Errorf("could not read data, params %v: %w", params, err)
+	}
+
+	createdAt := params.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	createdAt = createdAt.UTC()
+
+	dayPath := path.Join(serviceDir(params.Tenant, params.Service), params.Type.String(), createdAt.Format("2006/01/02"))
+	entry := indexEntry{
+		Name:      fileName(xid.NewWithTime(createdAt), params.Type, params.Labels),
+		CreatedAt: createdAt,
+		Labels:    params.Labels,
+	}
+
+	st.mu.Lock()
+	err = st.writeProfileLocked(dayPath, entry, data)
+	st.mu.Unlock()
+	if err != nil {
+		return profile.Meta{}, fmt.Errorf("could not write profile, params %v: %w", params, err)
+	}
+
+	meta := profile.Meta{
+		ProfileID: profile.ID(path.Join(dayPath, entry.Name)),
+		Tenant:    params.Tenant,
+		Service:   params.Service,
+		Type:      params.Type,
+		Labels:    params.Labels,
+		CreatedAt: createdAt,
+	}
+
+	st.logger.Debugw("writeProfile: fs write", "pid", meta.ProfileID, "meta", meta)
+
+	return meta, nil
+}
+
+func (st *Storage) writeProfileLocked(dayPath string, entry indexEntry, data []byte) error {
+	dir := st.path(dayPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// the profile is written to a temporary file first, so the readers never see a partially written profile
+	f, err := ioutil.TempFile(dir, "."+entry.Name+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, entry.Name)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, indexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := index.Write(append(line, '\n')); err != nil {
+		index.Close()
+		return err
+	}
+	return index.Close()
+}
+
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("empty profile ids")
+	}
+
+	// profile ids are the files' paths, make sure they all belong to the tenant
+	prefix := tenantDir(tenant) + "/"
+	for _, pid := range pids {
+		if !validProfileID(pid) || !strings.HasPrefix(string(pid), prefix) {
+			return nil, storage.ErrNotFound
+		}
+	}
+
+	pl := &profileList{
+		ctx:  ctx,
+		st:   st,
+		pids: pids,
+	}
+	return pl, nil
+}
+
+// checks that the profile id is a clean relative path, that doesn't escape the storage directory
+func validProfileID(pid profile.ID) bool {
+	p := string(pid)
+	if p == "" || path.IsAbs(p) || path.Clean(p) != p || strings.Contains(p, "\\") {
+		return false
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." || elem == "." {
+			return false
+		}
+	}
+	return true
+}
+
+type profileList struct {
+	ctx  context.Context
+	st   *Storage
+	pids []profile.ID
+	// points to the current profile in the iteration
+	pid profile.ID
+	// first error preserved and always returned
+	err error
+}
+
+func (pl *profileList) Next() bool {
+	if pl.err != nil {
+		return false
+	}
+
+	if err := pl.ctx.Err(); err != nil {
+		pl.setErr(err)
+		return false
+	}
+
+	if len(pl.pids) == 0 {
+		return false
+	}
+
+	pl.pid, pl.pids = pl.pids[0], pl.pids[1:]
+
+	return true
+}
+
+func (pl *profileList) Profile() (io.Reader, error) {
+	if err := pl.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if pl.err != nil {
+		return nil, pl.err
+	}
+
+	if pl.pid == "" {
+		// this must never happen
+		panic("fs profileList: profile out of range")
+	}
+
+	data, err := ioutil.ReadFile(pl.st.path(string(pl.pid)))
+	if os.IsNotExist(err) {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		pl.setErr(err)
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (pl *profileList) Close() error {
+	// prevent any use of this list's Profile or Next fn
+	pl.err = fmt.Errorf("profile list closed")
+	return nil
+}
+
+func (pl *profileList) setErr(err error) {
+	if pl.err == nil {
+		pl.err = err
+	}
+}
+
+// ListServices returns the list of distinct services for which profiles are stored in the directory.
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	names, err := readDirNames(st.path(tenantDir(tenant)))
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]string, 0, len(names))
+	for _, name := range names {
+		service, err := unescapeName(name)
+		if err != nil {
+			st.logger.Debugw("listServices: bad service dir", "name", name)
+			continue
+		}
+		services = append(services, service)
+	}
+	if len(services) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return services, nil
+}
+
+// ListTenants returns the list of distinct tenants for which profiles are stored in the directory.
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+
+	names, err := readDirNames(st.path(defaultTenantDir))
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	if len(names) > 0 {
+		tenants = append(tenants, "")
+	}
+
+	names, err = readDirNames(st.path(tenantsDir))
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	tenants = append(tenants, names...)
+
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return tenants, nil
+}
+
+// FindProfiles reads the index files for profile metas matched searched criteria.
+func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	return st.findProfiles(ctx, params)
+}
+
+// FindProfileIDs reads the index files for profile IDs matched searched criteria.
+func (st *Storage) FindProfileIDs(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+	metas, err := st.findProfiles(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]profile.ID, len(metas))
+	for i := range metas {
+		ids[i] = metas[i].ProfileID
+	}
+	return ids, nil
+}
+
+func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	if params.Service == "" {
+		return nil, fmt.Errorf("empty service")
+	}
+
+	if params.CreatedAtMin.IsZero() {
+		return nil, fmt.Errorf("empty created_at min")
+	}
+
+	createdAtMin := params.CreatedAtMin.UTC()
+	createdAtMax := params.CreatedAtMax.UTC()
+	if params.CreatedAtMax.IsZero() {
+		createdAtMax = time.Now().UTC()
+	}
+	if createdAtMin.After(createdAtMax) {
+		createdAtMax = createdAtMin
+	}
+
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultLimit
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	svcPath := serviceDir(params.Tenant, params.Service)
+
+	var ptypes []profile.ProfileType
+	if params.Type != profile.TypeUnknown {
+		ptypes = []profile.ProfileType{params.Type}
+	} else {
+		names, err := readDirNames(st.path(svcPath))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			var ptype profile.ProfileType
+			if err := ptype.FromString(name); err == nil && ptype != profile.TypeUnknown {
+				ptypes = append(ptypes, ptype)
+			}
+		}
+		// the profiles are ordered by the type first, the way object storages order them
+		sort.Slice(ptypes, func(i, j int) bool { return ptypes[i] < ptypes[j] })
+	}
+
+	var metas []profile.Meta
+	for _, ptype := range ptypes {
+		typePath := path.Join(svcPath, ptype.String())
+		days, err := st.listDays(typePath, createdAtMin, createdAtMax)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dayPath := range days {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			entries, err := st.readIndex(dayPath)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if entry.CreatedAt.Before(createdAtMin) || entry.CreatedAt.After(createdAtMax) {
+					continue
+				}
+				if !entry.Labels.Include(params.Labels) {
+					continue
+				}
+				metas = append(metas, profile.Meta{
+					ProfileID: profile.ID(path.Join(dayPath, entry.Name)),
+					Tenant:    params.Tenant,
+					Service:   params.Service,
+					Type:      ptype,
+					Labels:    entry.Labels,
+					CreatedAt: entry.CreatedAt,
+				})
+				if len(metas) == limit {
+					return metas, nil
+				}
+			}
+		}
+	}
+
+	if len(metas) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return metas, nil
+}
+
+// returns the paths of the day directories of the profile type, that overlap with the time range, in the time order
+func (st *Storage) listDays(typePath string, createdAtMin, createdAtMax time.Time) ([]string, error) {
+	minDay := createdAtMin.Truncate(24 * time.Hour)
+
+	var days []string
+	years, err := readDirNames(st.path(typePath))
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range years {
+		months, err := readDirNames(st.path(path.Join(typePath, year)))
+		if err != nil {
+			return nil, err
+		}
+		for _, month := range months {
+			dd, err := readDirNames(st.path(path.Join(typePath, year, month)))
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range dd {
+				day, err := time.Parse("2006/01/02", path.Join(year, month, d))
+				if err != nil {
+					continue
+				}
+				if day.Before(minDay) || day.After(createdAtMax) {
+					continue
+				}
+				days = append(days, path.Join(typePath, year, month, d))
+			}
+		}
+	}
+	return days, nil
+}
+
+// reads the entries of the day's index file, ordered by the creation time
+func (st *Storage) readIndex(dayPath string) ([]indexEntry, error) {
+	f, err := os.Open(filepath.Join(st.path(dayPath), indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var entry indexEntry
+		if err := json.Unmarshal(s.Bytes(), &entry); err != nil {
+			st.logger.Errorw("fs failed to parse index entry", "path", dayPath, "entry", s.Text())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("could not read index %q: %w", dayPath, err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the files of the profiles of the tenant, and removes them from the index files.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	// profile ids are the files' paths, skip those that don't belong to the tenant
+	prefix := tenantDir(tenant) + "/"
+	byDay := make(map[string]map[string]bool)
+	for _, pid := range pids {
+		if !validProfileID(pid) || !strings.HasPrefix(string(pid), prefix) {
+			continue
+		}
+		dayPath, name := path.Split(string(pid))
+		dayPath = strings.TrimSuffix(dayPath, "/")
+		if byDay[dayPath] == nil {
+			byDay[dayPath] = make(map[string]bool)
+		}
+		byDay[dayPath][name] = true
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for dayPath, names := range byDay {
+		if err := st.deleteProfilesLocked(dayPath, names); err != nil {
+			return fmt.Errorf("could not delete profiles of %q: %w", dayPath, err)
+		}
+	}
+	return nil
+}
+
+func (st *Storage) deleteProfilesLocked(dayPath string, names map[string]bool) error {
+	entries, err := st.readIndex(dayPath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	var n int
+	for _, entry := range entries {
+		if names[entry.Name] {
+			continue
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		n++
+	}
+
+	dir := st.path(dayPath)
+	for name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if n == 0 {
+		// the day has no profiles left
+		if err := os.Remove(filepath.Join(dir, indexFile)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return st.removeEmptyDirs(dayPath)
+	}
+
+	f, err := ioutil.TempFile(dir, "."+indexFile+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, indexFile))
+}
+
+// Cleanup removes the profiles, that outlived the retention period of their tenant. The profiles are removed
+// by whole days, once the day's last profile expires.
+func (st *Storage) Cleanup(now time.Time) error {
+	tenants := []string{""}
+	names, err := readDirNames(st.path(tenantsDir))
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	tenants = append(tenants, names...)
+
+	for _, tenant := range tenants {
+		ttl := st.ttlFor(tenant)
+		if ttl <= 0 {
+			continue
+		}
+		if err := st.cleanupTenant(tenant, now.Add(-ttl)); err != nil {
+			return fmt.Errorf("could not cleanup tenant %q: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+func (st *Storage) cleanupTenant(tenant string, expiresAt time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	tenantPath := tenantDir(tenant)
+	services, err := readDirNames(st.path(tenantPath))
+	if err == storage.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		ptypes, err := readDirNames(st.path(path.Join(tenantPath, service)))
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, ptype := range ptypes {
+			typePath := path.Join(tenantPath, service, ptype)
+			days, err := st.listDays(typePath, time.Unix(0, 0), expiresAt)
+			if err != nil && err != storage.ErrNotFound {
+				return err
+			}
+			for _, dayPath := range days {
+				day, _ := time.Parse("2006/01/02", strings.TrimPrefix(dayPath, typePath+"/"))
+				if day.Add(24 * time.Hour).After(expiresAt) {
+					continue
+				}
+				if err := os.RemoveAll(st.path(dayPath)); err != nil {
+					return err
+				}
+				if err := st.removeEmptyDirs(dayPath); err != nil {
+					return err
+				}
+				st.logger.Debugw("cleanup: fs removed expired day", "path", dayPath)
+			}
+		}
+	}
+	return nil
+}
+
+// removes the empty parent directories of the day directory, up to the tenant's directory
+func (st *Storage) removeEmptyDirs(dayPath string) error {
+	// the day, month, year, type and service directories
+	p := dayPath
+	for i := 0; i < 5; i++ {
+		err := os.Remove(st.path(p))
+		if err != nil && !os.IsNotExist(err) {
+			// the directory isn't empty
+			return nil
+		}
+		p = path.Dir(p)
+	}
+	return nil
+}
+
+// returns the local path of the slash-separated path, relative to the storage directory
+func (st *Storage) path(p string) string {
+	return filepath.Join(st.dir, filepath.FromSlash(p))
+}
+
+// returns the sorted names of the directory's entries, except for the hidden ones;
+// ErrNotFound is returned if the directory doesn't exist
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, ".") {
+			names[n] = name
+			n++
+		}
+	}
+	names = names[:n]
+	sort.Strings(names)
+	return names, nil
+}
+
+// returns the directory of the tenant's profiles
+func tenantDir(tenant string) string {
+	if tenant == "" {
+		return defaultTenantDir
+	}
+	return path.Join(tenantsDir, tenant)
+}
+
+func serviceDir(tenant, service string) string {
+	return path.Join(tenantDir(tenant), escapeName(service))
+}
+
+// escapes the name to be used as a single path element and as a part of the profile ID: every char, except
+// for the letters, digits, '-', '_' and the dots that don't start the name, is escaped as '~' and its hex code.
+// The IDs never have '+', that joins the IDs, nor '%', that is unescaped by the HTTP API.
+func escapeName(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || (c == '.' && i > 0) {
+			buf.WriteByte(c)
+			continue
+		}
+		buf.WriteByte('~')
+		buf.WriteByte(hexDigits[c>>4])
+		buf.WriteByte(hexDigits[c&0xf])
+	}
+	return buf.String()
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+// unescapes the name, escaped by escapeName
+func unescapeName(s string) (string, error) {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '~' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("bad escaped name %q", s)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("bad escaped name %q: %w", s, err)
+		}
+		buf.WriteByte(byte(b))
+		i += 2
+	}
+	return buf.String(), nil
+}
+
+func fileName(digest xid.ID, ptype profile.ProfileType, labels profile.Labels) string {
+	ext := pprofExt
+	if ptype == profile.TypeTrace {
+		ext = traceExt
+	}
+
+	name := digest.String()
+	if labels.Len() == 0 {
+		return name + ext
+	}
+
+	var buf strings.Builder
+	buf.WriteString(name)
+	for _, label := range labels {
+		buf.WriteByte(',')
+		buf.WriteString(escapeName(label.Key))
+		buf.WriteByte('=')
+		buf.WriteString(escapeName(label.Value))
+	}
+	// the index keeps the labels, they are only added to the name for the humans' convenience
+	if buf.Len()+len(ext) > maxFileNameLen {
+		return name + ext
+	}
+	return buf.String() + ext
+}
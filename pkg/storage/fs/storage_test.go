@@ -0,0 +1,157 @@
+package fs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storageFS "github.com/profefe/profefe/pkg/storage/fs"
+	"github.com/profefe/profefe/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestStorage(t *testing.T) (*storageFS.Storage, string) {
+	dir, err := ioutil.TempDir("", "profefe-fs")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	testLogger := zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))
+	return storageFS.NewStorage(log.New(testLogger), dir, 0), dir
+}
+
+func TestStorage(t *testing.T) {
+	st, _ := newTestStorage(t)
+
+	t.Run("Reader", func(t *testing.T) {
+		ts := &storagetest.ReaderTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		ts := &storagetest.WriterTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Deleter", func(t *testing.T) {
+		ts := &storagetest.DeleterTestSuite{
+			Reader:  st,
+			Writer:  st,
+			Deleter: st,
+		}
+		suite.Run(t, ts)
+	})
+}
+
+func TestStorage_layout(t *testing.T) {
+	st, dir := newTestStorage(t)
+
+	createdAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	meta, _ := storagetest.WriteProfile(t, st, &storage.WriteProfileParams{
+		Tenant:    "tenant1",
+		Service:   "svc/1 .~",
+		Type:      profile.TypeCPU,
+		Labels:    profile.Labels{{"key1", "val/1"}, {"key 2", "val+2%"}},
+		CreatedAt: createdAt,
+	}, "../../../testdata/collector_cpu_1.prof")
+
+	dayDir := filepath.Join(dir, "tenants", "tenant1", "svc~2F1~20.~7E", "cpu", "2020", "06", "01")
+	assert.FileExists(t, filepath.Join(dir, filepath.FromSlash(string(meta.ProfileID))))
+	assert.Equal(t, dayDir, filepath.Dir(filepath.Join(dir, filepath.FromSlash(string(meta.ProfileID)))))
+	assert.Regexp(t, `^[0-9a-v]{20},key1=val~2F1,key~202=val~2B2~25\.pb\.gz$`, filepath.Base(string(meta.ProfileID)))
+
+	// the profile ids can be joined
+	joined, err := profile.JoinIDs(meta.ProfileID, meta.ProfileID)
+	require.NoError(t, err)
+	pids, err := profile.SplitIDs(joined)
+	require.NoError(t, err)
+	assert.Equal(t, []profile.ID{meta.ProfileID, meta.ProfileID}, pids)
+	assert.FileExists(t, filepath.Join(dayDir, "index"))
+
+	services, err := st.ListServices(context.Background(), "tenant1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"svc/1 .~"}, services)
+
+	params := &storage.FindProfilesParams{
+		Tenant:       "tenant1",
+		Service:      "svc/1 .~",
+		CreatedAtMin: createdAt,
+		CreatedAtMax: createdAt,
+	}
+	metas, err := st.FindProfiles(context.Background(), params)
+	require.NoError(t, err)
+	assert.Equal(t, []profile.Meta{meta}, metas)
+
+	// the profile ids can't escape the storage directory
+	for _, pid := range []profile.ID{"tenants/tenant1/../../../etc/passwd", "/etc/passwd", meta.ProfileID + "/.."} {
+		_, err := st.ListProfiles(context.Background(), "tenant1", []profile.ID{pid})
+		assert.Equal(t, storage.ErrNotFound, err, "pid %q", pid)
+	}
+}
+
+func TestStorage_Cleanup(t *testing.T) {
+	st, dir := newTestStorage(t)
+	st.SetTenantTTL(func(tenant string) time.Duration {
+		if tenant == "tenant1" {
+			return 48 * time.Hour
+		}
+		return 0
+	})
+
+	now := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	write := func(tenant string, createdAt time.Time) profile.Meta {
+		meta, _ := storagetest.WriteProfile(t, st, &storage.WriteProfileParams{
+			Tenant:    tenant,
+			Service:   "svc1",
+			Type:      profile.TypeCPU,
+			CreatedAt: createdAt,
+		}, "../../../testdata/collector_cpu_1.prof")
+		return meta
+	}
+
+	expired := write("tenant1", now.Add(-5*24*time.Hour))
+	// the day of the profile hasn't expired yet
+	kept := write("tenant1", now.Add(-49*time.Hour))
+	recent := write("tenant1", now.Add(-time.Hour))
+	// the default tenant keeps the profiles forever
+	forever := write("", now.Add(-30*24*time.Hour))
+
+	require.NoError(t, st.Cleanup(now))
+
+	for _, meta := range []profile.Meta{kept, recent, forever} {
+		assert.FileExists(t, filepath.Join(dir, filepath.FromSlash(string(meta.ProfileID))))
+	}
+	_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(string(expired.ProfileID))))
+	assert.True(t, os.IsNotExist(err))
+	// the empty directories of the expired day are removed
+	_, err = os.Stat(filepath.Join(dir, "tenants", "tenant1", "svc1", "cpu", "2020", "06", "05"))
+	assert.True(t, os.IsNotExist(err))
+
+	params := &storage.FindProfilesParams{
+		Tenant:       "tenant1",
+		Service:      "svc1",
+		CreatedAtMin: now.Add(-30 * 24 * time.Hour),
+		CreatedAtMax: now,
+	}
+	ids, err := st.FindProfileIDs(context.Background(), params)
+	require.NoError(t, err)
+	assert.Equal(t, []profile.ID{kept.ProfileID, recent.ProfileID}, ids)
+}
@@ -20,7 +20,11 @@ import (
 )
 
 // gcs objects' key prefix indicates the key's naming schema
-const profefeSchema = `P0.`
+const (
+	profefeSchema = `P0.`
+	// the schema of the keys of non-default tenants
+	profefeTenantSchema = `P1.`
+)
 
 const (
 	defaultListObjectsLimit = 100
@@ -31,6 +35,9 @@ const (
 // The schema for the object key:
 // schemaV.service/profile_type/digest,label1=value1,label2=value2
 //
+// The schema for the object key of a non-default tenant:
+// schemaV.tenant/service/profile_type/digest,label1=value1,label2=value2
+//
 // Where
 // "schemaV" indicates the naming schema that was used when the profile was stored;
 // "digests" uniquely describes the profile, it also includes profiles creation time.
@@ -56,7 +63,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 		createdAt = time.Now().UTC()
 	}
 
-	key := createProfileKey(params.Service, params.Type, createdAt, params.Labels)
+	key := createProfileKey(params.Tenant, params.Service, params.Type, createdAt, params.Labels)
 
 	wc := st.client.Bucket(st.bucket).Object(key).NewWriter(ctx)
 	if _, err := io.Copy(wc, r); err != nil {
@@ -68,6 +75,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 
 	meta := profile.Meta{
 		ProfileID: profile.ID(key),
+		Tenant:    params.Tenant,
 		Service:   params.Service,
 		Type:      params.Type,
 		Labels:    params.Labels,
@@ -79,11 +87,19 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 	return meta, nil
 }
 
-func (st *Storage) ListProfiles(ctx context.Context, pids []profile.ID) (storage.ProfileList, error) {
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
 	if len(pids) == 0 {
 		return nil, fmt.Errorf("empty profile ids")
 	}
 
+	// profile ids are the objects' keys, make sure they all belong to the tenant
+	prefix := schemaPrefix(tenant)
+	for _, pid := range pids {
+		if !strings.HasPrefix(string(pid), prefix) {
+			return nil, storage.ErrNotFound
+		}
+	}
+
 	pl := &profileList{
 		ctx:    ctx,
 		pids:   pids,
@@ -157,9 +173,10 @@ func (pl *profileList) setErr(err error) {
 }
 
 // ListServices returns the list of distinct services for which profiles are stored in the bucket.
-func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	prefix := schemaPrefix(tenant)
 	query := &gcs.Query{
-		Prefix:    profefeSchema,
+		Prefix:    prefix,
 		Delimiter: "/",
 	}
 	var services []string
@@ -172,7 +189,7 @@ func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
 		if err != nil {
 			return nil, fmt.Errorf("it.Next: %v", err)
 		}
-		s := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, profefeSchema), "/")
+		s := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/")
 		if s != "" {
 			services = append(services, s)
 		}
@@ -184,6 +201,44 @@ func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
 	return services, nil
 }
 
+// ListTenants returns the list of distinct tenants for which profiles are stored in the bucket.
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+
+	// the keys of the default tenant have their own schema prefix
+	it := st.client.Bucket(st.bucket).Objects(ctx, &gcs.Query{Prefix: profefeSchema})
+	_, err := it.Next()
+	if err == nil {
+		tenants = append(tenants, "")
+	} else if err != iterator.Done {
+		return nil, fmt.Errorf("it.Next: %v", err)
+	}
+
+	query := &gcs.Query{
+		Prefix:    profefeTenantSchema,
+		Delimiter: "/",
+	}
+	it = st.client.Bucket(st.bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("it.Next: %v", err)
+		}
+		s := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, profefeTenantSchema), "/")
+		if s != "" {
+			tenants = append(tenants, s)
+		}
+	}
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return tenants, nil
+}
+
 // FindProfiles queries gcs for profile metas matched searched criteria.
 func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
 	return st.findProfiles(ctx, params)
@@ -225,7 +280,7 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 		limit = defaultListObjectsLimit
 	}
 
-	prefix := profileKeyPrefix(params.Service)
+	prefix := profileKeyPrefix(params.Tenant, params.Service)
 	if params.Type != profile.TypeUnknown {
 		prefix += strconv.Itoa(int(params.Type)) + "/"
 	}
@@ -256,7 +311,7 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 			continue
 		}
 
-		meta, err := metaFromProfileKey(profefeSchema, attrs.Name)
+		meta, err := metaFromProfileKey(params.Tenant, attrs.Name)
 		if err != nil {
 			st.logger.Errorw("storage gcs failed to parse profile meta from object key", "key", attrs.Name, zap.Error(err))
 			continue
@@ -289,6 +344,26 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 	return metas, nil
 }
 
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the objects of the profiles of the tenant.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	// profile ids are the objects' keys, skip those that don't belong to the tenant
+	prefix := schemaPrefix(tenant)
+	bucket := st.client.Bucket(st.bucket)
+	for _, pid := range pids {
+		if !strings.HasPrefix(string(pid), prefix) {
+			continue
+		}
+		err := bucket.Object(string(pid)).Delete(ctx)
+		if err != nil && err != gcs.ErrObjectNotExist {
+			return fmt.Errorf("could not delete object %q: %w", pid, err)
+		}
+		st.logger.Debugw("gcs deleted object", "bucket", st.bucket, "key", pid)
+	}
+	return nil
+}
+
 // getObject downloads a value from a key. Context can be canceled.
 // This is safe for multiple go routines.
 func (st *Storage) getObject(ctx context.Context, key string) (io.Reader, error) {
@@ -302,9 +377,9 @@ func (st *Storage) getObject(ctx context.Context, key string) (io.Reader, error)
 	return reader, nil
 }
 
-func createProfileKey(service string, ptype profile.ProfileType, createdAt time.Time, labels profile.Labels) string {
+func createProfileKey(tenant, service string, ptype profile.ProfileType, createdAt time.Time, labels profile.Labels) string {
 	var buf bytes.Buffer
-	buf.WriteString(profileKeyPrefix(service))
+	buf.WriteString(profileKeyPrefix(tenant, service))
 	buf.WriteString(strconv.Itoa(int(ptype)))
 	buf.WriteByte('/')
 
@@ -318,23 +393,34 @@ func createProfileKey(service string, ptype profile.ProfileType, createdAt time.
 	return buf.String()
 }
 
-func profileKeyPrefix(service string) string {
+func profileKeyPrefix(tenant, service string) string {
 	service = strings.ReplaceAll(service, "/", "__")
-	return profefeSchema + service + "/"
+	return schemaPrefix(tenant) + service + "/"
+}
+
+// returns the prefix of all keys of the tenant
+func schemaPrefix(tenant string) string {
+	if tenant == "" {
+		return profefeSchema
+	}
+	return profefeTenantSchema + tenant + "/"
 }
 
 // parses the gcs key by splitting by / to create a profile.Meta.
 // The format of the key is:
 // schemaV.service/profile_type/digest,label1,label2
-func metaFromProfileKey(schemaV, key string) (meta profile.Meta, err error) {
-	if !strings.HasPrefix(key, schemaV) {
-		return meta, fmt.Errorf("invalid key format %q: schema version mismatch, want %s", key, schemaV)
+// or, for a non-default tenant:
+// schemaV.tenant/service/profile_type/digest,label1,label2
+func metaFromProfileKey(tenant, key string) (meta profile.Meta, err error) {
+	prefix := schemaPrefix(tenant)
+	if !strings.HasPrefix(key, prefix) {
+		return meta, fmt.Errorf("invalid key format %q: schema version mismatch, want %s", key, prefix)
 	}
 
 	// create profile ID from the original object's key
 	pid := profile.ID(key)
 
-	key = strings.TrimPrefix(key, schemaV)
+	key = strings.TrimPrefix(key, prefix)
 	ks := strings.SplitN(key, "/", 3)
 	if len(ks) != 3 {
 		return meta, fmt.Errorf("invalid key format %q", key)
@@ -368,6 +454,7 @@ func metaFromProfileKey(schemaV, key string) (meta profile.Meta, err error) {
 
 	meta = profile.Meta{
 		ProfileID: pid,
+		Tenant:    tenant,
 		Service:   service,
 		Type:      ptype,
 		Labels:    labels,
@@ -64,6 +64,19 @@ func TestStorage(t *testing.T) {
 		}
 		suite.Run(t, ts)
 	})
+
+	t.Run("Deleter", func(t *testing.T) {
+		ts := &storagetest.DeleterTestSuite{
+			Reader:  st,
+			Writer:  st,
+			Deleter: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("SymbolStore", func(t *testing.T) {
+		suite.Run(t, &storagetest.SymbolStoreTestSuite{Store: st})
+	})
 }
 
 func setupGCSBucket(ctx context.Context, t *testing.T, client *gcs.Client, bucket string) {
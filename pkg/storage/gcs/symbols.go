@@ -0,0 +1,50 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// the key prefix of the objects that hold binaries and debug information
+const symbolsSchema = `S0.`
+
+var _ storage.SymbolStore = (*Storage)(nil)
+
+// PutSymbols uploads the binary or debug information to gcs.
+func (st *Storage) PutSymbols(ctx context.Context, buildID string, r io.Reader) error {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return err
+	}
+
+	wc := st.client.Bucket(st.bucket).Object(symbolsSchema + buildID).NewWriter(ctx)
+	if _, err := io.Copy(wc, r); err != nil {
+		wc.Close()
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("wc.Close: %v", err)
+	}
+	return nil
+}
+
+// GetSymbols downloads the binary or debug information from gcs.
+func (st *Storage) GetSymbols(ctx context.Context, buildID string) ([]byte, error) {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return nil, err
+	}
+
+	rc, err := st.client.Bucket(st.bucket).Object(symbolsSchema + buildID).NewReader(ctx)
+	if err == gcs.ErrObjectNotExist {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("gcs NewReader: %v", err)
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
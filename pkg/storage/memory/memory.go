@@ -0,0 +1,22 @@
+package memory
+
+import (
+	"flag"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+)
+
+type Config struct {
+	MaxSize    int64
+	ProfileTTL time.Duration
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Int64Var(&conf.MaxSize, "memory.max-size", 0, "maximum total size of stored profiles in bytes, the oldest profiles are evicted to fit in (unlimited if zero)")
+	f.DurationVar(&conf.ProfileTTL, "memory.data-ttl", 0, "memory data ttl (data is kept until evicted if zero)")
+}
+
+func (conf *Config) CreateStorage(logger *log.Logger) (*Storage, error) {
+	return NewStorage(logger, conf.MaxSize, conf.ProfileTTL), nil
+}
@@ -0,0 +1,357 @@
+package memory
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/rs/xid"
+)
+
+const defaultLimit = 100
+
+// Storage keeps profiles in memory, e.g. for development or tests. The profiles are evicted in the order
+// they were written, when the total size of the profiles exceeds the limit, or once they outlive the TTL.
+type Storage struct {
+	logger  *log.Logger
+	maxSize int64
+	ttl     time.Duration
+
+	mu sync.RWMutex
+	// profiles by id
+	profiles map[profile.ID]*entry
+	// profiles in the order they were written, the oldest first
+	order *list.List
+	// the total size of the profiles' data
+	size int64
+
+	now func() time.Time
+}
+
+type entry struct {
+	meta      profile.Meta
+	data      []byte
+	expiresAt time.Time
+	elem      *list.Element
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// NewStorage creates the storage, that holds up to maxSize bytes of profiles' data, for ttl;
+// zero maxSize or ttl means no limit.
+func NewStorage(logger *log.Logger, maxSize int64, ttl time.Duration) *Storage {
+	return &Storage{
+		logger:   logger,
+		maxSize:  maxSize,
+		ttl:      ttl,
+		profiles: make(map[profile.ID]*entry),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return profile.Meta{}, fmt.Errorf("could not read data, params %v: %w", params, err)
+	}
+
+	if st.maxSize > 0 && int64(len(data)) > st.maxSize {
+		return profile.Meta{}, fmt.Errorf("profile of %d bytes exceeds storage size %d", len(data), st.maxSize)
+	}
+
+	now := st.now()
+	createdAt := params.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now.UTC()
+	}
+
+	e := &entry{
+		meta: profile.Meta{
+			ProfileID:  profile.ID(xid.NewWithTime(createdAt).String()),
+			ExternalID: params.ExternalID,
+			Tenant:     params.Tenant,
+			Service:    params.Service,
+			Type:       params.Type,
+			Labels:     params.Labels,
+			CreatedAt:  createdAt,
+		},
+		data: data,
+	}
+	if st.ttl > 0 {
+		e.expiresAt = now.Add(st.ttl)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.evictLocked(now, int64(len(data)))
+
+	e.elem = st.order.PushBack(e)
+	st.profiles[e.meta.ProfileID] = e
+	st.size += int64(len(data))
+
+	st.logger.Debugw("writeProfile: memory store", "pid", e.meta.ProfileID, "meta", e.meta)
+
+	return e.meta, nil
+}
+
+// evicts the expired profiles, and the oldest profiles, until there is the room for n more bytes
+func (st *Storage) evictLocked(now time.Time, n int64) {
+	for elem := st.order.Front(); elem != nil; elem = st.order.Front() {
+		e := elem.Value.(*entry)
+		if !e.expired(now) && (st.maxSize == 0 || st.size+n <= st.maxSize) {
+			return
+		}
+		st.removeLocked(e)
+	}
+}
+
+func (st *Storage) removeLocked(e *entry) {
+	st.order.Remove(e.elem)
+	delete(st.profiles, e.meta.ProfileID)
+	st.size -= int64(len(e.data))
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// returns the profile of the tenant, unless it's missing or expired
+func (st *Storage) get(tenant string, pid profile.ID) *entry {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	e := st.profiles[pid]
+	if e == nil || e.meta.Tenant != tenant || e.expired(st.now()) {
+		return nil
+	}
+	return e
+}
+
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("empty profile ids")
+	}
+
+	pl := &profileList{
+		ctx:    ctx,
+		st:     st,
+		tenant: tenant,
+		pids:   pids,
+	}
+	return pl, nil
+}
+
+type profileList struct {
+	ctx    context.Context
+	st     *Storage
+	tenant string
+	pids   []profile.ID
+	// points to the current profile in the iteration
+	pid profile.ID
+	// first error preserved and always returned
+	err error
+}
+
+func (pl *profileList) Next() bool {
+	if pl.err != nil {
+		return false
+	}
+
+	if err := pl.ctx.Err(); err != nil {
+		pl.setErr(err)
+		return false
+	}
+
+	if len(pl.pids) == 0 {
+		return false
+	}
+
+	pl.pid, pl.pids = pl.pids[0], pl.pids[1:]
+
+	return true
+}
+
+func (pl *profileList) Profile() (io.Reader, error) {
+	if err := pl.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if pl.err != nil {
+		return nil, pl.err
+	}
+
+	if pl.pid == "" {
+		// this must never happen
+		panic("memory profileList: profile out of range")
+	}
+
+	e := pl.st.get(pl.tenant, pl.pid)
+	if e == nil {
+		pl.setErr(storage.ErrNotFound)
+		return nil, storage.ErrNotFound
+	}
+	// the data is never modified, once stored
+	return bytes.NewReader(e.data), nil
+}
+
+func (pl *profileList) Close() error {
+	// prevent any use of this list's Profile or Next fn
+	pl.err = fmt.Errorf("profile list closed")
+	return nil
+}
+
+func (pl *profileList) setErr(err error) {
+	if pl.err == nil {
+		pl.err = err
+	}
+}
+
+// ListServices returns the list of distinct services for which profiles are stored.
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	now := st.now()
+	seen := make(map[string]bool)
+	var services []string
+	for _, e := range st.profiles {
+		if e.meta.Tenant != tenant || e.expired(now) || seen[e.meta.Service] {
+			continue
+		}
+		seen[e.meta.Service] = true
+		services = append(services, e.meta.Service)
+	}
+	if len(services) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	sort.Strings(services)
+
+	return services, nil
+}
+
+// ListTenants returns the list of distinct tenants for which profiles are stored.
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	now := st.now()
+	seen := make(map[string]bool)
+	var tenants []string
+	for _, e := range st.profiles {
+		if e.expired(now) || seen[e.meta.Tenant] {
+			continue
+		}
+		seen[e.meta.Tenant] = true
+		tenants = append(tenants, e.meta.Tenant)
+	}
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	sort.Strings(tenants)
+
+	return tenants, nil
+}
+
+// FindProfiles returns profile metas matched searched criteria.
+func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	return st.findProfiles(ctx, params)
+}
+
+// FindProfileIDs returns profile IDs matched searched criteria.
+func (st *Storage) FindProfileIDs(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+	metas, err := st.findProfiles(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]profile.ID, len(metas))
+	for i := range metas {
+		ids[i] = metas[i].ProfileID
+	}
+	return ids, nil
+}
+
+func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	if params.Service == "" {
+		return nil, fmt.Errorf("empty service")
+	}
+
+	if params.CreatedAtMin.IsZero() {
+		return nil, fmt.Errorf("empty created_at min")
+	}
+
+	createdAtMax := params.CreatedAtMax
+	if createdAtMax.IsZero() {
+		createdAtMax = st.now().UTC()
+	}
+
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultLimit
+	}
+
+	st.mu.RLock()
+	now := st.now()
+	var metas []profile.Meta
+	for _, e := range st.profiles {
+		meta := e.meta
+		if meta.Tenant != params.Tenant || meta.Service != params.Service || e.expired(now) {
+			continue
+		}
+		if params.Type != profile.TypeUnknown && meta.Type != params.Type {
+			continue
+		}
+		if meta.CreatedAt.Before(params.CreatedAtMin) || meta.CreatedAt.After(createdAtMax) {
+			continue
+		}
+		if !meta.Labels.Include(params.Labels) {
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	st.mu.RUnlock()
+
+	if len(metas) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		if !metas[i].CreatedAt.Equal(metas[j].CreatedAt) {
+			return metas[i].CreatedAt.Before(metas[j].CreatedAt)
+		}
+		return metas[i].ProfileID < metas[j].ProfileID
+	})
+	if len(metas) > limit {
+		metas = metas[:limit]
+	}
+
+	return metas, nil
+}
+
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the profiles of the tenant.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, pid := range pids {
+		if e := st.profiles[pid]; e != nil && e.meta.Tenant == tenant {
+			st.removeLocked(e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/profefe/profefe/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+func newTestStorage(t *testing.T, maxSize int64, ttl time.Duration) *Storage {
+	testLogger := zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel))
+	return NewStorage(log.New(testLogger), maxSize, ttl)
+}
+
+func TestStorage(t *testing.T) {
+	st := newTestStorage(t, 0, 0)
+
+	t.Run("Reader", func(t *testing.T) {
+		ts := &storagetest.ReaderTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		ts := &storagetest.WriterTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Deleter", func(t *testing.T) {
+		ts := &storagetest.DeleterTestSuite{
+			Reader:  st,
+			Writer:  st,
+			Deleter: st,
+		}
+		suite.Run(t, ts)
+	})
+}
+
+func writeTestProfile(t *testing.T, st *Storage, service string, size int) profile.Meta {
+	params := &storage.WriteProfileParams{
+		Service: service,
+		Type:    profile.TypeCPU,
+	}
+	meta, err := st.WriteProfile(context.Background(), params, bytes.NewReader(make([]byte, size)))
+	require.NoError(t, err)
+	return meta
+}
+
+func findTestProfiles(t *testing.T, st *Storage, service string) []profile.ID {
+	params := &storage.FindProfilesParams{
+		Service:      service,
+		CreatedAtMin: time.Unix(0, 0),
+	}
+	ids, err := st.FindProfileIDs(context.Background(), params)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	require.NoError(t, err)
+	return ids
+}
+
+func TestStorage_maxSize(t *testing.T) {
+	st := newTestStorage(t, 100, 0)
+
+	m1 := writeTestProfile(t, st, "svc1", 40)
+	m2 := writeTestProfile(t, st, "svc1", 40)
+	assert.Equal(t, []profile.ID{m1.ProfileID, m2.ProfileID}, findTestProfiles(t, st, "svc1"))
+
+	// the oldest profile is evicted to fit the new one
+	m3 := writeTestProfile(t, st, "svc2", 40)
+	assert.Equal(t, []profile.ID{m2.ProfileID}, findTestProfiles(t, st, "svc1"))
+	assert.Equal(t, []profile.ID{m3.ProfileID}, findTestProfiles(t, st, "svc2"))
+	assert.EqualValues(t, 80, st.size)
+
+	_, err := st.WriteProfile(context.Background(), &storage.WriteProfileParams{Service: "svc1", Type: profile.TypeCPU}, bytes.NewReader(make([]byte, 101)))
+	assert.Error(t, err)
+}
+
+func TestStorage_ttl(t *testing.T) {
+	st := newTestStorage(t, 0, time.Minute)
+
+	now := time.Now()
+	st.now = func() time.Time { return now }
+
+	m1 := writeTestProfile(t, st, "svc1", 10)
+
+	now = now.Add(30 * time.Second)
+	m2 := writeTestProfile(t, st, "svc1", 10)
+	assert.Equal(t, []profile.ID{m1.ProfileID, m2.ProfileID}, findTestProfiles(t, st, "svc1"))
+
+	// the expired profile isn't found, even before it's evicted
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []profile.ID{m2.ProfileID}, findTestProfiles(t, st, "svc1"))
+
+	list, err := st.ListProfiles(context.Background(), "", []profile.ID{m1.ProfileID})
+	require.NoError(t, err)
+	require.True(t, list.Next())
+	_, err = list.Profile()
+	assert.Equal(t, storage.ErrNotFound, err)
+
+	writeTestProfile(t, st, "svc2", 10)
+	assert.Len(t, st.profiles, 2)
+	assert.EqualValues(t, 20, st.size)
+
+	now = now.Add(time.Minute)
+	_, err = st.ListServices(context.Background(), "")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestStorage_concurrent(t *testing.T) {
+	st := newTestStorage(t, 1000, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				writeTestProfile(t, st, "svc1", 10)
+				findTestProfiles(t, st, "svc1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, findTestProfiles(t, st, "svc1"), 100)
+	assert.EqualValues(t, 1000, st.size)
+}
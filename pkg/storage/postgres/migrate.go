@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/profefe/profefe/pkg/log"
+)
+
+// the key of the advisory lock, that serializes the migrations of concurrently starting collectors
+const migrationsLockKey = 0x70726f66656665 // "profefe"
+
+// migrations are the versions of the schema, every migration is the list of statements, run in the order;
+// new migrations are appended, the applied ones must never change
+var migrations = [][]string{
+	// 1: the profiles, partitioned by the day they were created at
+	{
+		`CREATE TABLE pprof_profiles (
+			profile_id  text        NOT NULL,
+			tenant      text        NOT NULL DEFAULT '',
+			service     text        NOT NULL,
+			type        smallint    NOT NULL,
+			labels      jsonb       NOT NULL DEFAULT '[]',
+			external_id text        NOT NULL DEFAULT '',
+			created_at  timestamptz NOT NULL,
+			data        bytea       NOT NULL,
+			PRIMARY KEY (profile_id, created_at)
+		) PARTITION BY RANGE (created_at)`,
+		`CREATE INDEX pprof_profiles_service_idx ON pprof_profiles (tenant, service, type, created_at)`,
+		`CREATE INDEX pprof_profiles_labels_idx ON pprof_profiles USING GIN (labels jsonb_path_ops)`,
+	},
+}
+
+// Migrate applies the migrations, that weren't applied to the db yet.
+func Migrate(ctx context.Context, logger *log.Logger, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationsLockKey); err != nil {
+		return fmt.Errorf("could not lock migrations: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS profefe_schema_migrations (
+		version    integer     PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("could not create migrations table: %w", err)
+	}
+
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT coalesce(max(version), 0) FROM profefe_schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("could not get schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		for _, stmt := range migrations[i] {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("could not apply migration %d: %w", i+1, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO profefe_schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("could not record migration %d: %w", i+1, err)
+		}
+		logger.Infow("postgres schema migrated", "version", i+1)
+	}
+
+	return tx.Commit()
+}
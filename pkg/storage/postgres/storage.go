@@ -0,0 +1,590 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/rs/xid"
+)
+
+const (
+	// the prefix of the names of the daily partitions, e.g. pprof_profiles_20200601
+	partitionPrefix = "pprof_profiles_"
+	partitionLayout = "20060102"
+)
+
+// the errors postgres returns, when the partition is created concurrently
+const (
+	pqErrDuplicateTable  = "42P07"
+	pqErrUniqueViolation = "23505"
+)
+
+// Storage stores profiles in postgres.
+//
+// The profiles, with their data, are stored in pprof_profiles table, partitioned by the day the profiles were
+// created at; the partitions are created on write, and are dropped once the whole day outlived the TTL of every
+// tenant. The profiles of the tenants, which retention is shorter, are deleted from the partitions before.
+// The labels are stored as JSONB array of {"key", "value"} objects, matched with the containment operator.
+//
+// The profile ID is the xid, created with profile's creation time, which helps postgres to skip
+// the partitions, that can't hold the profile.
+type Storage struct {
+	logger *log.Logger
+	db     *sql.DB
+	ttl    time.Duration
+
+	mu sync.Mutex
+	// the partitions known to exist
+	partitions map[string]bool
+	// returns the retention period of a tenant, overriding ttl if not zero
+	tenantTTL func(tenant string) time.Duration
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+func NewStorage(logger *log.Logger, db *sql.DB, ttl time.Duration) *Storage {
+	return &Storage{
+		logger:     logger,
+		db:         db,
+		ttl:        ttl,
+		partitions: make(map[string]bool),
+	}
+}
+
+// SetTenantTTL sets the function that returns per-tenant retention period.
+func (st *Storage) SetTenantTTL(tenantTTL func(tenant string) time.Duration) {
+	st.mu.Lock()
+	st.tenantTTL = tenantTTL
+	st.mu.Unlock()
+}
+
+func (st *Storage) ttlFor(tenant string) time.Duration {
+	st.mu.Lock()
+	tenantTTL := st.tenantTTL
+	st.mu.Unlock()
+
+	if tenantTTL != nil {
+		if ttl := tenantTTL(tenant); ttl > 0 {
+			return ttl
+		}
+	}
+	return st.ttl
+}
+
+func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfileParams, r io.Reader) (profile.Meta, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return profile.Meta{}, fmt.Errorf("could not read data, params %v: %w", params, err)
+	}
+
+	createdAt := params.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	// postgres keeps the timestamps with microseconds precision
+	createdAt = createdAt.UTC().Truncate(time.Microsecond)
+
+	labels, err := encodeLabels(params.Labels)
+	if err != nil {
+		return profile.Meta{}, err
+	}
+
+	if err := st.ensurePartition(ctx, createdAt); err != nil {
+		return profile.Meta{}, fmt.Errorf("could not create partition for %v: %w", createdAt, err)
+	}
+
+	pid := xid.NewWithTime(createdAt).String()
+	_, err = st.db.ExecContext(
+		ctx,
+		`INSERT INTO pprof_profiles (profile_id, tenant, service, type, labels, external_id, created_at, data) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		pid,
+		params.Tenant,
+		params.Service,
+		int(params.Type),
+		labels,
+		string(params.ExternalID),
+		createdAt,
+		data,
+	)
+	if err != nil {
+		return profile.Meta{}, fmt.Errorf("could not insert profile, params %v: %w", params, err)
+	}
+
+	meta := profile.Meta{
+		ProfileID:  profile.ID(pid),
+		ExternalID: params.ExternalID,
+		Tenant:     params.Tenant,
+		Service:    params.Service,
+		Type:       params.Type,
+		Labels:     params.Labels,
+		CreatedAt:  createdAt,
+	}
+
+	st.logger.Debugw("writeProfile: postgres insert", "pid", meta.ProfileID, "meta", meta)
+
+	return meta, nil
+}
+
+// creates the daily partition, that holds the profiles created at the time, unless it's known to exist
+func (st *Storage) ensurePartition(ctx context.Context, createdAt time.Time) error {
+	day := createdAt.UTC().Truncate(24 * time.Hour)
+	name := partitionName(day)
+
+	st.mu.Lock()
+	ok := st.partitions[name]
+	st.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	stmt := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF pprof_profiles FOR VALUES FROM ('%s') TO ('%s')`,
+		pq.QuoteIdentifier(name),
+		day.Format(time.RFC3339),
+		day.Add(24*time.Hour).Format(time.RFC3339),
+	)
+	_, err := st.db.ExecContext(ctx, stmt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == pqErrDuplicateTable || pqErr.Code == pqErrUniqueViolation) {
+		// the partition was created concurrently
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	st.partitions[name] = true
+	st.mu.Unlock()
+
+	return nil
+}
+
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("empty profile ids")
+	}
+
+	ids := make([]xid.ID, len(pids))
+	for i, pid := range pids {
+		id, err := xid.FromString(string(pid))
+		if err != nil {
+			return nil, storage.ErrNotFound
+		}
+		ids[i] = id
+	}
+
+	pl := &profileList{
+		ctx:    ctx,
+		st:     st,
+		tenant: tenant,
+		ids:    ids,
+	}
+	return pl, nil
+}
+
+type profileList struct {
+	ctx    context.Context
+	st     *Storage
+	tenant string
+	ids    []xid.ID
+	// points to the current profile in the iteration
+	id xid.ID
+	// first error preserved and always returned
+	err error
+}
+
+func (pl *profileList) Next() bool {
+	if pl.err != nil {
+		return false
+	}
+
+	if err := pl.ctx.Err(); err != nil {
+		pl.setErr(err)
+		return false
+	}
+
+	if len(pl.ids) == 0 {
+		return false
+	}
+
+	pl.id, pl.ids = pl.ids[0], pl.ids[1:]
+
+	return true
+}
+
+func (pl *profileList) Profile() (io.Reader, error) {
+	if err := pl.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if pl.err != nil {
+		return nil, pl.err
+	}
+
+	if pl.id.IsNil() {
+		// this must never happen
+		panic("postgres profileList: profile out of range")
+	}
+
+	// the profile was created within the second, the profile id holds
+	createdAt := pl.id.Time().UTC()
+
+	var data []byte
+	err := pl.st.db.QueryRowContext(
+		pl.ctx,
+		`SELECT data FROM pprof_profiles WHERE tenant = $1 AND profile_id = $2 AND created_at >= $3 AND created_at < $4`,
+		pl.tenant,
+		pl.id.String(),
+		createdAt,
+		createdAt.Add(time.Second),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		err = storage.ErrNotFound
+	}
+	if err != nil {
+		pl.setErr(err)
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (pl *profileList) Close() error {
+	// prevent any use of this list's Profile or Next fn
+	pl.err = fmt.Errorf("profile list closed")
+	return nil
+}
+
+func (pl *profileList) setErr(err error) {
+	if pl.err == nil {
+		pl.err = err
+	}
+}
+
+// ListServices returns the list of distinct services for which profiles are stored.
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT DISTINCT service FROM pprof_profiles WHERE tenant = $1 ORDER BY service`, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []string
+	for rows.Next() {
+		var service string
+		if err := rows.Scan(&service); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(services) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return services, nil
+}
+
+// ListTenants returns the list of distinct tenants for which profiles are stored.
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT DISTINCT tenant FROM pprof_profiles ORDER BY tenant`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return tenants, nil
+}
+
+// FindProfiles queries postgres for profile metas matched searched criteria.
+func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	return st.findProfiles(ctx, params)
+}
+
+// FindProfileIDs queries postgres for profile IDs matched searched criteria.
+func (st *Storage) FindProfileIDs(ctx context.Context, params *storage.FindProfilesParams) ([]profile.ID, error) {
+	metas, err := st.findProfiles(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]profile.ID, len(metas))
+	for i := range metas {
+		ids[i] = metas[i].ProfileID
+	}
+	return ids, nil
+}
+
+func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
+	if params.Service == "" {
+		return nil, fmt.Errorf("empty service")
+	}
+
+	if params.CreatedAtMin.IsZero() {
+		return nil, fmt.Errorf("empty created_at min")
+	}
+
+	query, args, err := buildFindProfilesQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	st.logger.Debugw("findProfiles: postgres query", "query", query, "args", args)
+
+	rows, err := st.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metas []profile.Meta
+	for rows.Next() {
+		var (
+			pid, externalID string
+			ptype           int
+			labels          []byte
+			createdAt       time.Time
+		)
+		if err := rows.Scan(&pid, &ptype, &labels, &externalID, &createdAt); err != nil {
+			return nil, err
+		}
+		meta := profile.Meta{
+			ProfileID:  profile.ID(pid),
+			ExternalID: profile.ID(externalID),
+			Tenant:     params.Tenant,
+			Service:    params.Service,
+			Type:       profile.ProfileType(ptype),
+			CreatedAt:  createdAt.UTC(),
+		}
+		if meta.Labels, err = decodeLabels(labels); err != nil {
+			return nil, fmt.Errorf("could not decode labels of profile %q: %w", pid, err)
+		}
+		metas = append(metas, meta)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(metas) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return metas, nil
+}
+
+func buildFindProfilesQuery(params *storage.FindProfilesParams) (string, []interface{}, error) {
+	createdAtMax := params.CreatedAtMax
+	if createdAtMax.IsZero() {
+		createdAtMax = time.Now().UTC()
+	}
+	if params.CreatedAtMin.After(createdAtMax) {
+		createdAtMax = params.CreatedAtMin
+	}
+
+	var query strings.Builder
+	args := []interface{}{params.Tenant, params.Service, params.CreatedAtMin.UTC(), createdAtMax.UTC()}
+
+	query.WriteString(`SELECT profile_id, type, labels, external_id, created_at FROM pprof_profiles WHERE tenant = $1 AND service = $2 AND created_at >= $3 AND created_at <= $4`)
+	if params.Type != profile.TypeUnknown {
+		args = append(args, int(params.Type))
+		query.WriteString(` AND type = $` + strconv.Itoa(len(args)))
+	}
+	if len(params.Labels) > 0 {
+		labels, err := encodeLabels(params.Labels)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, labels)
+		query.WriteString(` AND labels @> $` + strconv.Itoa(len(args)) + `::jsonb`)
+	}
+	query.WriteString(` ORDER BY created_at, profile_id`)
+	if params.Limit > 0 {
+		args = append(args, params.Limit)
+		query.WriteString(` LIMIT $` + strconv.Itoa(len(args)))
+	}
+	return query.String(), args, nil
+}
+
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the profiles of the tenant.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	ids := make([]string, 0, len(pids))
+	for _, pid := range pids {
+		// the profile ids, that aren't xids, can't be stored
+		if _, err := xid.FromString(string(pid)); err == nil {
+			ids = append(ids, string(pid))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	res, err := st.db.ExecContext(ctx, `DELETE FROM pprof_profiles WHERE tenant = $1 AND profile_id = ANY($2)`, tenant, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("could not delete profiles: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	st.logger.Debugw("postgres deleted profiles", "tenant", tenant, "n", n)
+
+	return nil
+}
+
+// Cleanup deletes the profiles, that outlived the retention period of their tenant, and drops the daily partitions,
+// which profiles outlived the retention period of every tenant. The profiles are kept forever, if the retention
+// period of their tenant is zero.
+func (st *Storage) Cleanup(ctx context.Context, now time.Time) error {
+	tenants, err := st.ListTenants(ctx)
+	if err == storage.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not list tenants: %w", err)
+	}
+
+	maxTTL := st.partitionsTTL(tenants)
+
+	// the profiles of the tenants, which retention is shorter, are deleted from the partitions, that are kept
+	for _, tenant := range tenants {
+		ttl := st.ttlFor(tenant)
+		if ttl <= 0 || ttl == maxTTL {
+			continue
+		}
+		res, err := st.db.ExecContext(ctx, `DELETE FROM pprof_profiles WHERE tenant = $1 AND created_at < $2`, tenant, now.Add(-ttl))
+		if err != nil {
+			return fmt.Errorf("could not delete expired profiles of tenant %q: %w", tenant, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			st.logger.Infow("postgres deleted expired profiles", "tenant", tenant, "n", n)
+		}
+	}
+
+	if maxTTL <= 0 {
+		return nil
+	}
+	return st.dropPartitions(ctx, now.Add(-maxTTL))
+}
+
+// returns the TTL of the partitions, the longest retention period of the tenants; the partitions are never dropped,
+// if zero, as the profiles of some tenant are kept forever
+func (st *Storage) partitionsTTL(tenants []string) time.Duration {
+	var maxTTL time.Duration
+	for _, tenant := range tenants {
+		ttl := st.ttlFor(tenant)
+		if ttl <= 0 {
+			return 0
+		}
+		if ttl > maxTTL {
+			maxTTL = ttl
+		}
+	}
+	return maxTTL
+}
+
+// drops the daily partitions, which whole day is before the expiresAt
+func (st *Storage) dropPartitions(ctx context.Context, expiresAt time.Time) error {
+	rows, err := st.db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent WHERE p.relname = 'pprof_profiles'`)
+	if err != nil {
+		return fmt.Errorf("could not list partitions: %w", err)
+	}
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		day, ok := partitionDay(name)
+		if ok && !day.Add(24*time.Hour).After(expiresAt) {
+			expired = append(expired, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range expired {
+		if _, err := st.db.ExecContext(ctx, `DROP TABLE IF EXISTS `+pq.QuoteIdentifier(name)); err != nil {
+			return fmt.Errorf("could not drop partition %q: %w", name, err)
+		}
+
+		st.mu.Lock()
+		delete(st.partitions, name)
+		st.mu.Unlock()
+
+		st.logger.Infow("postgres dropped expired partition", "partition", name)
+	}
+	return nil
+}
+
+func partitionName(day time.Time) string {
+	return partitionPrefix + day.Format(partitionLayout)
+}
+
+// returns the day of the partition's profiles
+func partitionDay(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, partitionPrefix) {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(partitionLayout, strings.TrimPrefix(name, partitionPrefix))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+func encodeLabels(labels profile.Labels) (string, error) {
+	if len(labels) == 0 {
+		return "[]", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("could not encode labels %v: %w", labels, err)
+	}
+	return string(data), nil
+}
+
+func decodeLabels(data []byte) (profile.Labels, error) {
+	var labels profile.Labels
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
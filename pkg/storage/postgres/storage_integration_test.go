@@ -0,0 +1,132 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	storagePG "github.com/profefe/profefe/pkg/storage/postgres"
+	"github.com/profefe/profefe/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestStorage(t *testing.T) {
+	pgDSN := os.Getenv("POSTGRES_DSN")
+	if pgDSN == "" {
+		t.SkipNow()
+	}
+
+	db := setupDB(t, pgDSN)
+	defer db.Close()
+
+	logger := log.New(zaptest.NewLogger(t, zaptest.Level(zapcore.FatalLevel)))
+
+	// the migrations are applied only once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, storagePG.Migrate(context.Background(), logger, db))
+	}
+
+	st := storagePG.NewStorage(logger, db, 24*time.Hour)
+
+	t.Run("Reader", func(t *testing.T) {
+		ts := &storagetest.ReaderTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		ts := &storagetest.WriterTestSuite{
+			Reader: st,
+			Writer: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Deleter", func(t *testing.T) {
+		ts := &storagetest.DeleterTestSuite{
+			Reader:  st,
+			Writer:  st,
+			Deleter: st,
+		}
+		suite.Run(t, ts)
+	})
+
+	t.Run("Cleanup", func(t *testing.T) {
+		createdAt := time.Now().UTC().Add(-72 * time.Hour)
+		meta, _ := storagetest.WriteProfile(t, st, &storage.WriteProfileParams{
+			Service:   "test-cleanup",
+			Type:      profile.TypeCPU,
+			CreatedAt: createdAt,
+		}, "../../../testdata/collector_cpu_1.prof")
+
+		require.NoError(t, st.Cleanup(context.Background(), time.Now()))
+
+		list, err := st.ListProfiles(context.Background(), "", []profile.ID{meta.ProfileID})
+		require.NoError(t, err)
+		defer list.Close()
+
+		require.True(t, list.Next())
+		_, err = list.Profile()
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+
+	t.Run("Cleanup tenant retention", func(t *testing.T) {
+		// the tenant's retention is longer than the ttl
+		st.SetTenantTTL(func(tenant string) time.Duration {
+			if tenant == "tenant-long" {
+				return 7 * 24 * time.Hour
+			}
+			return 0
+		})
+		defer st.SetTenantTTL(nil)
+
+		createdAt := time.Now().UTC().Add(-72 * time.Hour)
+		pids := make(map[string]profile.ID)
+		for _, tenant := range []string{"", "tenant-long"} {
+			meta, _ := storagetest.WriteProfile(t, st, &storage.WriteProfileParams{
+				Tenant:    tenant,
+				Service:   "test-cleanup",
+				Type:      profile.TypeCPU,
+				CreatedAt: createdAt,
+			}, "../../../testdata/collector_cpu_1.prof")
+			pids[tenant] = meta.ProfileID
+		}
+
+		require.NoError(t, st.Cleanup(context.Background(), time.Now()))
+
+		params := &storage.FindProfilesParams{
+			Tenant:       "tenant-long",
+			Service:      "test-cleanup",
+			CreatedAtMin: createdAt,
+			CreatedAtMax: createdAt,
+		}
+		ids, err := st.FindProfileIDs(context.Background(), params)
+		require.NoError(t, err)
+		assert.Equal(t, []profile.ID{pids["tenant-long"]}, ids)
+
+		params.Tenant = ""
+		_, err = st.FindProfileIDs(context.Background(), params)
+		assert.Equal(t, storage.ErrNotFound, err)
+	})
+}
+
+func setupDB(t *testing.T, dsn string) *sql.DB {
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Ping())
+
+	return db
+}
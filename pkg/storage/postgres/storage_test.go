@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildFindProfilesQuery(t *testing.T) {
+	createdAtMin := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	createdAtMax := createdAtMin.Add(time.Hour)
+
+	cases := []struct {
+		params    *storage.FindProfilesParams
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{
+			&storage.FindProfilesParams{
+				Service:      "svc1",
+				CreatedAtMin: createdAtMin,
+				CreatedAtMax: createdAtMax,
+			},
+			`SELECT profile_id, type, labels, external_id, created_at FROM pprof_profiles WHERE tenant = $1 AND service = $2 AND created_at >= $3 AND created_at <= $4 ORDER BY created_at, profile_id`,
+			[]interface{}{"", "svc1", createdAtMin, createdAtMax},
+		},
+		{
+			&storage.FindProfilesParams{
+				Tenant:       "tenant1",
+				Service:      "svc1",
+				Type:         profile.TypeHeap,
+				Labels:       profile.Labels{{"key1", "val1"}},
+				CreatedAtMin: createdAtMin,
+				CreatedAtMax: createdAtMax,
+				Limit:        10,
+			},
+			`SELECT profile_id, type, labels, external_id, created_at FROM pprof_profiles WHERE tenant = $1 AND service = $2 AND created_at >= $3 AND created_at <= $4 AND type = $5 AND labels @> $6::jsonb ORDER BY created_at, profile_id LIMIT $7`,
+			[]interface{}{"tenant1", "svc1", createdAtMin, createdAtMax, int(profile.TypeHeap), `[{"key":"key1","value":"val1"}]`, 10},
+		},
+		{
+			// the time range is never empty
+			&storage.FindProfilesParams{
+				Service:      "svc1",
+				CreatedAtMin: createdAtMax,
+				CreatedAtMax: createdAtMin,
+			},
+			`SELECT profile_id, type, labels, external_id, created_at FROM pprof_profiles WHERE tenant = $1 AND service = $2 AND created_at >= $3 AND created_at <= $4 ORDER BY created_at, profile_id`,
+			[]interface{}{"", "svc1", createdAtMax, createdAtMax},
+		},
+	}
+
+	for _, tc := range cases {
+		query, args, err := buildFindProfilesQuery(tc.params)
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantQuery, query)
+		assert.Equal(t, tc.wantArgs, args)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	for _, labels := range []profile.Labels{nil, {{"key1", "val1"}, {"key2", "val,2"}}} {
+		s, err := encodeLabels(labels)
+		require.NoError(t, err)
+
+		got, err := decodeLabels([]byte(s))
+		require.NoError(t, err)
+		assert.Equal(t, labels, got)
+	}
+}
+
+func TestPartitionDay(t *testing.T) {
+	day := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	name := partitionName(day)
+	assert.Equal(t, "pprof_profiles_20200601", name)
+
+	got, ok := partitionDay(name)
+	require.True(t, ok)
+	assert.Equal(t, day, got)
+
+	for _, name := range []string{"pprof_profiles", "pprof_profiles_service_idx", "other_20200601"} {
+		_, ok := partitionDay(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestStorage_partitionsTTL(t *testing.T) {
+	day := 24 * time.Hour
+
+	st := NewStorage(nil, nil, day)
+	assert.Equal(t, day, st.partitionsTTL([]string{"", "tenant1"}))
+
+	retention := map[string]time.Duration{"tenant1": 7 * day, "tenant2": time.Hour}
+	st.SetTenantTTL(func(tenant string) time.Duration { return retention[tenant] })
+
+	// the partitions are kept for the tenant, which retention is longer than the ttl
+	assert.Equal(t, 7*day, st.partitionsTTL([]string{"", "tenant1", "tenant2"}))
+	assert.Equal(t, day, st.partitionsTTL([]string{"", "tenant2"}))
+
+	// the partitions are never dropped, if some tenant's profiles are kept forever
+	st = NewStorage(nil, nil, 0)
+	st.SetTenantTTL(func(tenant string) time.Duration { return retention[tenant] })
+	assert.Equal(t, time.Duration(0), st.partitionsTTL([]string{"", "tenant1"}))
+}
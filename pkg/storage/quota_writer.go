@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+// QuotaWriter rejects the profiles of a tenant, whose total size written during the current day (UTC)
+// would exceed the tenant's daily quota.
+type QuotaWriter struct {
+	w Writer
+	// returns the daily quota of the tenant in bytes, zero means no quota
+	quota func(tenant string) int64
+	usage *DailyUsage
+}
+
+var _ Writer = (*QuotaWriter)(nil)
+
+func NewQuotaWriter(w Writer, quota func(tenant string) int64) *QuotaWriter {
+	return &QuotaWriter{
+		w:     w,
+		quota: quota,
+		usage: NewDailyUsage(time.Now),
+	}
+}
+
+func (qw *QuotaWriter) WriteProfile(ctx context.Context, params *WriteProfileParams, r io.Reader) (profile.Meta, error) {
+	quota := qw.quota(params.Tenant)
+	if quota <= 0 {
+		return qw.w.WriteProfile(ctx, params, r)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return profile.Meta{}, err
+	}
+	size := int64(buf.Len())
+
+	if !qw.usage.Add(params.Tenant, size, quota) {
+		return profile.Meta{}, fmt.Errorf("tenant %q: daily quota of %d bytes: %w", params.Tenant, quota, ErrQuotaExceeded)
+	}
+
+	meta, err := qw.w.WriteProfile(ctx, params, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		// the profile wasn't stored, give the reserved bytes back
+		qw.usage.Add(params.Tenant, -size, 0)
+	}
+	return meta, err
+}
+
+// DailyUsage counts the bytes used by the keys during the current day (UTC).
+type DailyUsage struct {
+	mu    sync.Mutex
+	day   time.Time
+	usage map[string]int64
+
+	now func() time.Time
+}
+
+func NewDailyUsage(now func() time.Time) *DailyUsage {
+	return &DailyUsage{
+		usage: make(map[string]int64),
+		now:   now,
+	}
+}
+
+// Add adds the size to the key's usage, unless the usage would exceed the quota.
+// Zero quota means no quota. It reports whether the size was added.
+func (du *DailyUsage) Add(key string, size, quota int64) bool {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+
+	day := du.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(du.day) {
+		du.day = day
+		du.usage = make(map[string]int64)
+	}
+
+	used := du.usage[key]
+	if quota > 0 && used+size > quota {
+		return false
+	}
+	if used+size > 0 {
+		du.usage[key] = used + size
+	} else {
+		delete(du.usage, key)
+	}
+	return true
+}
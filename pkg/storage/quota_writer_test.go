@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaWriter_WriteProfile(t *testing.T) {
+	var writeErr error
+	sw := &StubWriter{
+		WriteProfileFunc: func(ctx context.Context, _ *WriteProfileParams, _ io.Reader) (profile.Meta, error) {
+			return profile.Meta{}, writeErr
+		},
+	}
+
+	quotas := map[string]int64{"t1": 10}
+	qw := NewQuotaWriter(sw, func(tenant string) int64 { return quotas[tenant] })
+
+	now := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+	qw.usage.now = func() time.Time { return now }
+
+	write := func(tenant, data string) error {
+		_, err := qw.WriteProfile(context.Background(), &WriteProfileParams{Tenant: tenant}, strings.NewReader(data))
+		return err
+	}
+
+	require.NoError(t, write("t1", "123456"))
+
+	// the failed write doesn't use the quota
+	writeErr = errors.New("the error")
+	require.Equal(t, writeErr, write("t1", "1234"))
+	writeErr = nil
+
+	require.NoError(t, write("t1", "1234"))
+
+	err := write("t1", "1")
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "want quota exceeded, got %v", err)
+
+	// no quota for other tenants
+	require.NoError(t, write("t2", strings.Repeat("1", 100)))
+
+	// the quota resets the next day
+	now = now.Add(2 * time.Hour)
+	require.NoError(t, write("t1", "1"))
+}
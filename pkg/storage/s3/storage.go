@@ -22,12 +22,18 @@ import (
 )
 
 // s3 objects' key prefix indicates the key's naming schema
-const profefeSchema = `P0.`
+const (
+	profefeSchema = `P0.`
+	// the schema of the keys of non-default tenants
+	profefeTenantSchema = `P1.`
+)
 
 const (
 	// initial size of buffer pre-allocated for the s3 object
 	getObjectBufferSize     = 16384
 	defaultListObjectsLimit = 100
+	// the maximum number of keys s3 deletes in a single request
+	deleteObjectsLimit = 1000
 )
 
 // Storage stores and loads profiles from s3.
@@ -35,6 +41,9 @@ const (
 // The schema for the object key:
 // schemaV.service/profile_type/digest,label1=value1,label2=value2
 //
+// The schema for the object key of a non-default tenant:
+// schemaV.tenant/service/profile_type/digest,label1=value1,label2=value2
+//
 // Where
 // "schemaV" indicates the naming schema that was used when the profile was stored;
 // "digests" uniquely describes the profile, it also includes profiles creation time.
@@ -68,7 +77,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 		createdAt = time.Now().UTC()
 	}
 
-	key := createProfileKey(params.Service, params.Type, createdAt, params.Labels)
+	key := createProfileKey(params.Tenant, params.Service, params.Type, createdAt, params.Labels)
 
 	resp, err := st.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 		Bucket: aws.String(st.bucket),
@@ -87,6 +96,7 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 
 	meta := profile.Meta{
 		ProfileID: profile.ID(key),
+		Tenant:    params.Tenant,
 		Service:   params.Service,
 		Type:      params.Type,
 		Labels:    params.Labels,
@@ -98,11 +108,19 @@ func (st *Storage) WriteProfile(ctx context.Context, params *storage.WriteProfil
 	return meta, nil
 }
 
-func (st *Storage) ListProfiles(ctx context.Context, pids []profile.ID) (storage.ProfileList, error) {
+func (st *Storage) ListProfiles(ctx context.Context, tenant string, pids []profile.ID) (storage.ProfileList, error) {
 	if len(pids) == 0 {
 		return nil, fmt.Errorf("empty profile ids")
 	}
 
+	// profile ids are the objects' keys, make sure they all belong to the tenant
+	prefix := schemaPrefix(tenant)
+	for _, pid := range pids {
+		if !strings.HasPrefix(string(pid), prefix) {
+			return nil, storage.ErrNotFound
+		}
+	}
+
 	pl := &profileList{
 		ctx:    ctx,
 		pids:   pids,
@@ -185,10 +203,10 @@ func (pl *profileList) setErr(err error) {
 }
 
 // ListServices returns the list of distinct services for which profiles are stored in the bucket.
-func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
+func (st *Storage) ListServices(ctx context.Context, tenant string) ([]string, error) {
 	input := &s3.ListObjectsV2Input{
 		Bucket:    &st.bucket,
-		Prefix:    aws.String(profefeSchema),
+		Prefix:    aws.String(schemaPrefix(tenant)),
 		Delimiter: aws.String("/"), // delimiter makes ListObjects to return only unique common prefixes
 	}
 
@@ -222,6 +240,47 @@ func (st *Storage) ListServices(ctx context.Context) ([]string, error) {
 	return services, nil
 }
 
+// ListTenants returns the list of distinct tenants for which profiles are stored in the bucket.
+func (st *Storage) ListTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+
+	// the keys of the default tenant have their own schema prefix
+	resp, err := st.svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  &st.bucket,
+		Prefix:  aws.String(profefeSchema),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Contents) > 0 {
+		tenants = append(tenants, "")
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    &st.bucket,
+		Prefix:    aws.String(profefeTenantSchema),
+		Delimiter: aws.String("/"),
+	}
+	err = st.svc.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, cp := range page.CommonPrefixes {
+			s := strings.TrimPrefix(aws.StringValue(cp.Prefix), profefeTenantSchema)
+			if s = strings.TrimSuffix(s, "/"); s != "" {
+				tenants = append(tenants, s)
+			}
+		}
+		return *page.IsTruncated
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return tenants, nil
+}
+
 // FindProfiles queries s3 for profile metas matched searched criteria.
 func (st *Storage) FindProfiles(ctx context.Context, params *storage.FindProfilesParams) ([]profile.Meta, error) {
 	return st.findProfiles(ctx, params)
@@ -263,7 +322,7 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 		limit = defaultListObjectsLimit
 	}
 
-	prefix := profileKeyPrefix(params.Service)
+	prefix := profileKeyPrefix(params.Tenant, params.Service)
 	if params.Type != profile.TypeUnknown {
 		prefix += strconv.Itoa(int(params.Type)) + "/"
 	}
@@ -284,7 +343,7 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 				continue
 			}
 
-			meta, err := metaFromProfileKey(profefeSchema, key)
+			meta, err := metaFromProfileKey(params.Tenant, key)
 			if err != nil {
 				st.logger.Errorw("storage s3 failed to parse profile meta from object key", "key", key, zap.Error(err))
 				continue
@@ -329,6 +388,48 @@ func (st *Storage) findProfiles(ctx context.Context, params *storage.FindProfile
 	return metas, nil
 }
 
+var _ storage.Deleter = (*Storage)(nil)
+
+// DeleteProfiles deletes the objects of the profiles of the tenant, in batches of up to deleteObjectsLimit keys.
+func (st *Storage) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	// profile ids are the objects' keys, skip those that don't belong to the tenant
+	prefix := schemaPrefix(tenant)
+	objects := make([]*s3.ObjectIdentifier, 0, len(pids))
+	for _, pid := range pids {
+		if strings.HasPrefix(string(pid), prefix) {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(string(pid))})
+		}
+	}
+
+	for len(objects) > 0 {
+		n := len(objects)
+		if n > deleteObjectsLimit {
+			n = deleteObjectsLimit
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(st.bucket),
+			Delete: &s3.Delete{
+				Objects: objects[:n],
+				Quiet:   aws.Bool(true),
+			},
+		}
+		resp, err := st.svc.DeleteObjectsWithContext(ctx, input)
+		if err != nil {
+			return fmt.Errorf("could not delete objects: %w", err)
+		}
+		// deleting a missing object isn't an error in s3
+		if len(resp.Errors) > 0 {
+			e := resp.Errors[0]
+			return fmt.Errorf("could not delete object %q: %s", aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+
+		st.logger.Debugw("s3 deleted objects", "bucket", st.bucket, "n", n)
+
+		objects = objects[n:]
+	}
+	return nil
+}
+
 // getObject downloads a value from a key. Context can be canceled.
 // This is safe for multiple go routines.
 func (st *Storage) getObject(ctx context.Context, w io.WriterAt, key string) error {
@@ -346,9 +447,9 @@ func (st *Storage) getObject(ctx context.Context, w io.WriterAt, key string) err
 	return nil
 }
 
-func createProfileKey(service string, ptype profile.ProfileType, createdAt time.Time, labels profile.Labels) string {
+func createProfileKey(tenant, service string, ptype profile.ProfileType, createdAt time.Time, labels profile.Labels) string {
 	var buf bytes.Buffer
-	buf.WriteString(profileKeyPrefix(service))
+	buf.WriteString(profileKeyPrefix(tenant, service))
 	buf.WriteString(strconv.Itoa(int(ptype)))
 	buf.WriteByte('/')
 
@@ -362,23 +463,34 @@ func createProfileKey(service string, ptype profile.ProfileType, createdAt time.
 	return buf.String()
 }
 
-func profileKeyPrefix(service string) string {
+func profileKeyPrefix(tenant, service string) string {
 	service = strings.ReplaceAll(service, "/", "__")
-	return profefeSchema + service + "/"
+	return schemaPrefix(tenant) + service + "/"
+}
+
+// returns the prefix of all keys of the tenant
+func schemaPrefix(tenant string) string {
+	if tenant == "" {
+		return profefeSchema
+	}
+	return profefeTenantSchema + tenant + "/"
 }
 
 // parses the s3 key by splitting by / to create a profile.Meta.
 // The format of the key is:
 // schemaV.service/profile_type/digest,label1,label2
-func metaFromProfileKey(schemaV, key string) (meta profile.Meta, err error) {
-	if !strings.HasPrefix(key, schemaV) {
-		return meta, fmt.Errorf("invalid key format %q: schema version mismatch, want %s", key, schemaV)
+// or, for a non-default tenant:
+// schemaV.tenant/service/profile_type/digest,label1,label2
+func metaFromProfileKey(tenant, key string) (meta profile.Meta, err error) {
+	prefix := schemaPrefix(tenant)
+	if !strings.HasPrefix(key, prefix) {
+		return meta, fmt.Errorf("invalid key format %q: schema version mismatch, want %s", key, prefix)
 	}
 
 	// create profile ID from the original object's key
 	pid := profile.ID(key)
 
-	key = strings.TrimPrefix(key, schemaV)
+	key = strings.TrimPrefix(key, prefix)
 	ks := strings.SplitN(key, "/", 3)
 	if len(ks) != 3 {
 		return meta, fmt.Errorf("invalid key format %q", key)
@@ -412,6 +524,7 @@ func metaFromProfileKey(schemaV, key string) (meta profile.Meta, err error) {
 
 	meta = profile.Meta{
 		ProfileID: pid,
+		Tenant:    tenant,
 		Service:   service,
 		Type:      ptype,
 		Labels:    labels,
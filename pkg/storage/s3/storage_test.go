@@ -87,13 +87,34 @@ func TestMetaFromProfileKey(t *testing.T) {
 	}
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := metaFromProfileKey(profefeSchema, tt.key)
+			got, err := metaFromProfileKey("", tt.key)
 			require.Equal(t, tt.wantErr, err != nil, "error = %v, wantErr %v", err, tt.wantErr)
 			assert.Equal(t, tt.want, got)
 		})
 	}
 }
 
+func TestMetaFromProfileKey_Tenant(t *testing.T) {
+	key := "P1.team1/svc1/1/9bsv0s3ipt32jfck6kt0,k1=v1"
+
+	got, err := metaFromProfileKey("team1", key)
+	require.NoError(t, err)
+	assert.Equal(t, profile.Meta{
+		ProfileID: profile.ID(key),
+		Tenant:    "team1",
+		Service:   "svc1",
+		Type:      profile.TypeCPU,
+		Labels:    profile.Labels{{"k1", "v1"}},
+		CreatedAt: time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC),
+	}, got)
+
+	_, err = metaFromProfileKey("team2", key)
+	require.Error(t, err)
+
+	_, err = metaFromProfileKey("", key)
+	require.Error(t, err)
+}
+
 type mockUploaderAPI struct {
 	s3manageriface.UploaderAPI
 	err error
@@ -166,7 +187,7 @@ func TestStorage_WriteProfile(t *testing.T) {
 
 			for i, input := range tt.uploader.inputs {
 				assert.Equal(t, tt.want[i].bucket, *input.Bucket)
-				_, err := metaFromProfileKey(profefeSchema, *input.Key)
+				_, err := metaFromProfileKey("", *input.Key)
 				require.NoError(t, err)
 				b, _ := ioutil.ReadAll(input.Body)
 				assert.Equal(t, tt.want[i].body, string(b))
@@ -196,9 +217,9 @@ func TestStorage_ListProfiles(t *testing.T) {
 			logger:     log.New(zaptest.NewLogger(t)),
 			downloader: &mockDownloaderAPI{},
 		}
-		ids := []profile.ID{"1", "2"}
+		ids := []profile.ID{"P0.svc1/1/9bsv0s3ipt32jfck6kt0", "P0.svc1/1/9bsv0s3ipt32jfck6kt1"}
 
-		itr, err := s.ListProfiles(context.Background(), ids)
+		itr, err := s.ListProfiles(context.Background(), "", ids)
 		require.NoError(t, err)
 
 		defer itr.Close()
@@ -216,6 +237,21 @@ func TestStorage_ListProfiles(t *testing.T) {
 		assert.Equal(t, len(ids), count, "must have found %d profiles", len(ids))
 		assert.Len(t, profiles, len(ids))
 	})
+
+	t.Run("profiles of other tenant", func(t *testing.T) {
+		s := &Storage{
+			bucket:     "b1",
+			logger:     log.New(zaptest.NewLogger(t)),
+			downloader: &mockDownloaderAPI{},
+		}
+		ids := []profile.ID{"P1.team1/svc1/1/9bsv0s3ipt32jfck6kt0"}
+
+		_, err := s.ListProfiles(context.Background(), "team2", ids)
+		require.Equal(t, storage.ErrNotFound, err)
+
+		_, err = s.ListProfiles(context.Background(), "", ids)
+		require.Equal(t, storage.ErrNotFound, err)
+	})
 }
 
 type mockService struct {
@@ -386,7 +422,7 @@ func TestStorage_ListServices(t *testing.T) {
 			},
 		}
 
-		services, err := s.ListServices(context.Background())
+		services, err := s.ListServices(context.Background(), "")
 		require.NoError(t, err)
 		assert.ElementsMatch(t, []string{"svc1", "svc2"}, services)
 	})
@@ -400,7 +436,44 @@ func TestStorage_ListServices(t *testing.T) {
 			},
 		}
 
-		_, err := s.ListServices(context.Background())
+		_, err := s.ListServices(context.Background(), "")
 		require.Equal(t, storage.ErrNotFound, err)
 	})
 }
+
+type mockDeleteService struct {
+	s3iface.S3API
+
+	// data sent to DeleteObjectsWithContext
+	inputs []*s3.DeleteObjectsInput
+}
+
+func (s *mockDeleteService) DeleteObjectsWithContext(ctx aws.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	s.inputs = append(s.inputs, input)
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestStorage_DeleteProfiles(t *testing.T) {
+	svc := &mockDeleteService{}
+	s := &Storage{
+		bucket: "b1",
+		svc:    svc,
+		logger: log.New(zaptest.NewLogger(t)),
+	}
+
+	pids := make([]profile.ID, 0, deleteObjectsLimit+2)
+	for i := 0; i < deleteObjectsLimit+1; i++ {
+		pids = append(pids, profile.ID(fmt.Sprintf("P1.team1/svc1/1/%d", i)))
+	}
+	// keys of other tenants are skipped
+	pids = append(pids, "P0.svc1/1/9bsv0s3ipt32jfck6kt0", "P1.team2/svc1/1/9bsv0s3ipt32jfck6kt0")
+
+	err := s.DeleteProfiles(context.Background(), "team1", pids)
+	require.NoError(t, err)
+
+	require.Len(t, svc.inputs, 2)
+	assert.Equal(t, "b1", aws.StringValue(svc.inputs[0].Bucket))
+	assert.Len(t, svc.inputs[0].Delete.Objects, deleteObjectsLimit)
+	require.Len(t, svc.inputs[1].Delete.Objects, 1)
+	assert.Equal(t, string(pids[deleteObjectsLimit]), aws.StringValue(svc.inputs[1].Delete.Objects[0].Key))
+}
@@ -0,0 +1,49 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+// the key prefix of the objects that hold binaries and debug information
+const symbolsSchema = `S0.`
+
+var _ storage.SymbolStore = (*Storage)(nil)
+
+// PutSymbols uploads the binary or debug information to s3.
+func (st *Storage) PutSymbols(ctx context.Context, buildID string, r io.Reader) error {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return err
+	}
+
+	_, err := st.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(symbolsSchema + buildID),
+		Body:   r,
+	})
+	return err
+}
+
+// GetSymbols downloads the binary or debug information from s3.
+func (st *Storage) GetSymbols(ctx context.Context, buildID string) ([]byte, error) {
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return nil, err
+	}
+
+	w := aws.NewWriteAtBuffer(nil)
+	err := st.getObject(ctx, w, symbolsSchema+buildID)
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, storage.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
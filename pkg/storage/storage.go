@@ -7,13 +7,16 @@ import (
 	"io"
 	"time"
 
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/profile"
+	"github.com/profefe/profefe/pkg/tenant"
 )
 
 var (
 	ErrNotFound       = errors.New("not found")
 	ErrNoResults      = errors.New("no results")
 	ErrNotImplemented = errors.New("method not implemented")
+	ErrQuotaExceeded  = errors.New("quota exceeded")
 )
 
 type Storage interface {
@@ -27,6 +30,7 @@ type Writer interface {
 
 type WriteProfileParams struct {
 	ExternalID profile.ID
+	Tenant     string
 	Service    string
 	Type       profile.ProfileType
 	Labels     profile.Labels
@@ -37,6 +41,9 @@ func (params *WriteProfileParams) Validate() error {
 	if params == nil {
 		return errors.New("nil params")
 	}
+	if err := tenant.ValidateName(params.Tenant); err != nil {
+		return err
+	}
 	if params.Service == "" {
 		return errors.New("empty service")
 	}
@@ -49,23 +56,35 @@ func (params *WriteProfileParams) Validate() error {
 type Reader interface {
 	FindProfiles(ctx context.Context, params *FindProfilesParams) ([]profile.Meta, error)
 	FindProfileIDs(ctx context.Context, params *FindProfilesParams) ([]profile.ID, error)
-	ListProfiles(ctx context.Context, pid []profile.ID) (ProfileList, error)
-	ListServices(ctx context.Context) ([]string, error)
+	// ListProfiles returns the profiles of the tenant; profiles of other tenants are not found
+	ListProfiles(ctx context.Context, tenant string, pid []profile.ID) (ProfileList, error)
+	ListServices(ctx context.Context, tenant string) ([]string, error)
+	// ListTenants returns the tenants, which profiles are stored, the default tenant included; returns ErrNotFound
+	// if no profiles are stored
+	ListTenants(ctx context.Context) ([]string, error)
 }
 
 type FindProfilesParams struct {
+	Tenant       string
 	Service      string
 	Type         profile.ProfileType
 	Labels       profile.Labels
 	CreatedAtMin time.Time
 	CreatedAtMax time.Time
 	Limit        int
+
+	// labels of profile's samples, the merged profiles are filtered by; storages may use them
+	// to skip the profiles that don't have such samples
+	SampleLabels profile.Labels
 }
 
 func (params *FindProfilesParams) Validate() error {
 	if params == nil {
 		return errors.New("nil params")
 	}
+	if err := tenant.ValidateName(params.Tenant); err != nil {
+		return err
+	}
 	if params.Service == "" {
 		return errors.New("empty service")
 	}
@@ -78,8 +97,85 @@ func (params *FindProfilesParams) Validate() error {
 	return nil
 }
 
+// Deleter deletes the stored profiles, e.g. the profiles that outlived their retention.
+type Deleter interface {
+	// DeleteProfiles deletes the profiles of the tenant; the profiles that aren't found are ignored,
+	// profiles of other tenants are not deleted
+	DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error
+}
+
+// Flusher is implemented by the storages, that acknowledge the writes before the profiles are stored,
+// e.g. the storages that batch the writes.
+type Flusher interface {
+	// Flush waits for the profiles, written before the call, to be stored; an error is returned
+	// if some of the profiles couldn't be stored
+	Flush(ctx context.Context) error
+}
+
+// GarbageCollector is implemented by the storages, that keep the data shared by the profiles, e.g. the stacks
+// of the samples, separately from the profiles; the shared data isn't deleted with the profiles.
+type GarbageCollector interface {
+	// CollectGarbage deletes the shared data, that no stored profile references any longer
+	CollectGarbage(ctx context.Context) error
+}
+
+// Aggregator aggregates the samples of the stored profiles on the storage side, sparing the caller from
+// listing and merging the profiles in memory.
+type Aggregator interface {
+	// MergeProfiles returns the profiles merged into a single profile; returns ErrNotFound if none of the profiles
+	// is found.
+	MergeProfiles(ctx context.Context, params *AggregateParams) (*pprofProfile.Profile, error)
+	// TopFunctions returns the functions with the largest flat values of the sample type, the largest first;
+	// the last sample type of the profiles is used if the sample type is empty.
+	TopFunctions(ctx context.Context, params *AggregateParams, sampleType string, limit int) ([]FunctionTotal, error)
+}
+
+// AggregateParams selects the samples of the profiles, that are aggregated.
+type AggregateParams struct {
+	Tenant     string
+	ProfileIDs []profile.ID
+	// only the samples, that have all the labels, are aggregated
+	SampleLabels profile.Labels
+}
+
+// FunctionTotal is the sum of the values of a function's samples.
+type FunctionTotal struct {
+	Name string
+	// the values of the samples, the function is the innermost frame of
+	Flat int64
+	// the values of the samples, the function is in the stack of
+	Cum int64
+}
+
 type ProfileList interface {
 	Next() bool
 	Profile() (io.Reader, error)
 	Close() error
 }
+
+// SymbolStore stores the binaries and debug information, used to symbolize profiles, by build ID.
+type SymbolStore interface {
+	PutSymbols(ctx context.Context, buildID string, r io.Reader) error
+	// GetSymbols returns ErrNotFound if nothing was stored for the build ID.
+	GetSymbols(ctx context.Context, buildID string) ([]byte, error)
+}
+
+const maxBuildIDLen = 256
+
+// ValidateBuildID checks that the build ID is safe to be used as a part of storage keys.
+func ValidateBuildID(buildID string) error {
+	if buildID == "" {
+		return errors.New("empty build id")
+	}
+	if len(buildID) > maxBuildIDLen {
+		return fmt.Errorf("build id longer than %d characters", maxBuildIDLen)
+	}
+	for i := 0; i < len(buildID); i++ {
+		c := buildID[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_' || c == '.' {
+			continue
+		}
+		return fmt.Errorf("bad build id %q", buildID)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package storagetest
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"testing"
@@ -36,7 +37,7 @@ func (ts *WriterTestSuite) TestWriteProfile() {
 	wantPP, err := pprofProfile.ParseData(data)
 	ts.Require().NoError(err)
 
-	list, err := ts.Reader.ListProfiles(context.Background(), []profile.ID{meta.ProfileID})
+	list, err := ts.Reader.ListProfiles(context.Background(), "", []profile.ID{meta.ProfileID})
 	ts.Require().NoError(err)
 
 	ts.T().Cleanup(func() {
@@ -74,6 +75,10 @@ func (ts *ReaderTestSuite) TestListServices() {
 	testListServices(ts.T(), ts.Reader, ts.Writer)
 }
 
+func (ts *ReaderTestSuite) TestTenants() {
+	testTenants(ts.T(), ts.Reader, ts.Writer)
+}
+
 func testFindProfileIDs(t *testing.T, sr storage.Reader, sw storage.Writer) {
 	service1 := genServiceName()
 	service2 := genServiceName()
@@ -263,7 +268,7 @@ func testListProfiles(t *testing.T, sr storage.Reader, sw storage.Writer) {
 	require.Len(t, pids, 3)
 
 	t.Run("found", func(t *testing.T) {
-		list, err := sr.ListProfiles(context.Background(), pids[1:])
+		list, err := sr.ListProfiles(context.Background(), "", pids[1:])
 		require.NoError(t, err)
 		defer list.Close()
 
@@ -281,7 +286,7 @@ func testListProfiles(t *testing.T, sr storage.Reader, sw storage.Writer) {
 	})
 
 	t.Run("no ids", func(t *testing.T) {
-		_, err := sr.ListProfiles(context.Background(), nil)
+		_, err := sr.ListProfiles(context.Background(), "", nil)
 		require.Error(t, err)
 	})
 
@@ -289,7 +294,7 @@ func testListProfiles(t *testing.T, sr storage.Reader, sw storage.Writer) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		list, err := sr.ListProfiles(ctx, pids[1:])
+		list, err := sr.ListProfiles(ctx, "", pids[1:])
 		require.NoError(t, err)
 		defer list.Close()
 
@@ -324,7 +329,7 @@ func testListServices(t *testing.T, sr storage.Reader, sw storage.Writer) {
 		Labels:  profile.Labels{{"key1", "val1"}},
 	}, "../../../testdata/collector_cpu_3.prof")
 
-	services, err := sr.ListServices(context.Background())
+	services, err := sr.ListServices(context.Background(), "")
 	require.NoError(t, err)
 
 	sset := make(map[string]struct{})
@@ -334,3 +339,155 @@ func testListServices(t *testing.T, sr storage.Reader, sw storage.Writer) {
 		sset[s] = struct{}{}
 	}
 }
+
+func testTenants(t *testing.T, sr storage.Reader, sw storage.Writer) {
+	service1 := genServiceName()
+
+	meta, _ := WriteProfile(t, sw, &storage.WriteProfileParams{
+		Tenant:  "tenant1",
+		Service: service1,
+		Type:    profile.TypeCPU,
+	}, "../../../testdata/collector_cpu_1.prof")
+
+	createdAtMin := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("find by tenant", func(t *testing.T) {
+		params := &storage.FindProfilesParams{
+			Tenant:       "tenant1",
+			Service:      service1,
+			CreatedAtMin: createdAtMin,
+		}
+		ids, err := sr.FindProfileIDs(context.Background(), params)
+		require.NoError(t, err)
+		require.Equal(t, []profile.ID{meta.ProfileID}, ids)
+	})
+
+	t.Run("not found in other tenants", func(t *testing.T) {
+		for _, tenant := range []string{"", "tenant2"} {
+			params := &storage.FindProfilesParams{
+				Tenant:       tenant,
+				Service:      service1,
+				CreatedAtMin: createdAtMin,
+			}
+			_, err := sr.FindProfileIDs(context.Background(), params)
+			require.Equal(t, storage.ErrNotFound, err, "tenant %q", tenant)
+		}
+	})
+
+	t.Run("list profiles of other tenant", func(t *testing.T) {
+		list, err := sr.ListProfiles(context.Background(), "tenant2", []profile.ID{meta.ProfileID})
+		if err != nil {
+			return
+		}
+		defer list.Close()
+
+		if list.Next() {
+			_, err = list.Profile()
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("list services", func(t *testing.T) {
+		services, err := sr.ListServices(context.Background(), "tenant1")
+		require.NoError(t, err)
+		assert.Contains(t, services, service1)
+
+		services, _ = sr.ListServices(context.Background(), "")
+		assert.NotContains(t, services, service1)
+	})
+
+	t.Run("list tenants", func(t *testing.T) {
+		tenants, err := sr.ListTenants(context.Background())
+		require.NoError(t, err)
+		assert.Contains(t, tenants, "tenant1")
+		assert.NotContains(t, tenants, "tenant2")
+	})
+}
+
+type DeleterTestSuite struct {
+	suite.Suite
+
+	Reader  storage.Reader
+	Writer  storage.Writer
+	Deleter storage.Deleter
+}
+
+func (ts *DeleterTestSuite) TestDeleteProfiles() {
+	service := genServiceName()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	var metas []profile.Meta
+	for i := 0; i < 3; i++ {
+		meta, _ := WriteProfile(ts.T(), ts.Writer, &storage.WriteProfileParams{
+			Tenant:    "tenant1",
+			Service:   service,
+			Type:      profile.TypeCPU,
+			Labels:    profile.Labels{{"key1", "val1"}},
+			CreatedAt: createdAt.Add(time.Duration(i) * time.Second),
+		}, "../../../testdata/collector_cpu_1.prof")
+		metas = append(metas, meta)
+	}
+
+	params := &storage.FindProfilesParams{
+		Tenant:       "tenant1",
+		Service:      service,
+		CreatedAtMin: createdAt,
+		CreatedAtMax: createdAt.Add(time.Minute),
+	}
+
+	// profiles of other tenants aren't deleted
+	err := ts.Deleter.DeleteProfiles(context.Background(), "tenant2", []profile.ID{metas[0].ProfileID})
+	ts.Require().NoError(err)
+
+	ids, err := ts.Reader.FindProfileIDs(context.Background(), params)
+	ts.Require().NoError(err)
+	ts.Len(ids, 3)
+
+	err = ts.Deleter.DeleteProfiles(context.Background(), "tenant1", []profile.ID{metas[0].ProfileID, metas[2].ProfileID})
+	ts.Require().NoError(err)
+
+	ids, err = ts.Reader.FindProfileIDs(context.Background(), params)
+	ts.Require().NoError(err)
+	ts.Equal([]profile.ID{metas[1].ProfileID}, ids)
+
+	// the profiles are found neither by type nor by labels
+	for _, p := range []*storage.FindProfilesParams{
+		{Tenant: "tenant1", Service: service, Type: profile.TypeCPU, CreatedAtMin: params.CreatedAtMin, CreatedAtMax: params.CreatedAtMax},
+		{Tenant: "tenant1", Service: service, Labels: profile.Labels{{"key1", "val1"}}, CreatedAtMin: params.CreatedAtMin, CreatedAtMax: params.CreatedAtMax},
+	} {
+		ids, err = ts.Reader.FindProfileIDs(context.Background(), p)
+		ts.Require().NoError(err)
+		ts.Equal([]profile.ID{metas[1].ProfileID}, ids)
+	}
+
+	// deleted profiles are ignored
+	err = ts.Deleter.DeleteProfiles(context.Background(), "tenant1", []profile.ID{metas[0].ProfileID, metas[1].ProfileID})
+	ts.Require().NoError(err)
+
+	_, err = ts.Reader.FindProfileIDs(context.Background(), params)
+	ts.Equal(storage.ErrNotFound, err)
+}
+
+type SymbolStoreTestSuite struct {
+	suite.Suite
+
+	Store storage.SymbolStore
+}
+
+func (ts *SymbolStoreTestSuite) TestPutGetSymbols() {
+	buildID := fmt.Sprintf("%x", time.Now().UnixNano())
+	data := []byte("\x7fELF the binary")
+
+	_, err := ts.Store.GetSymbols(context.Background(), buildID)
+	ts.Require().Equal(storage.ErrNotFound, err)
+
+	err = ts.Store.PutSymbols(context.Background(), buildID, bytes.NewReader(data))
+	ts.Require().NoError(err)
+
+	got, err := ts.Store.GetSymbols(context.Background(), buildID)
+	ts.Require().NoError(err)
+	ts.Equal(data, got)
+
+	err = ts.Store.PutSymbols(context.Background(), "../"+buildID, bytes.NewReader(data))
+	ts.Require().Error(err)
+}
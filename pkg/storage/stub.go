@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
 	"github.com/profefe/profefe/pkg/profile"
 )
 
@@ -19,16 +20,19 @@ func (sw *StubWriter) WriteProfile(ctx context.Context, params *WriteProfilePara
 	return sw.WriteProfileFunc(ctx, params, r)
 }
 
-type ListServicesFunc func(ctx context.Context) ([]string, error)
+type ListServicesFunc func(ctx context.Context, tenant string) ([]string, error)
+
+type ListTenantsFunc func(ctx context.Context) ([]string, error)
 
 type FindProfilesFunc func(ctx context.Context, params *FindProfilesParams) ([]profile.Meta, error)
 
 type FindProfileIDsFunc func(ctx context.Context, params *FindProfilesParams) ([]profile.ID, error)
 
-type ListProfilesFunc func(ctx context.Context, pid []profile.ID) (ProfileList, error)
+type ListProfilesFunc func(ctx context.Context, tenant string, pid []profile.ID) (ProfileList, error)
 
 type StubReader struct {
 	ListServicesFunc
+	ListTenantsFunc
 	ListProfilesFunc
 	FindProfilesFunc
 	FindProfileIDsFunc
@@ -36,8 +40,12 @@ type StubReader struct {
 
 var _ Reader = (*StubReader)(nil)
 
-func (sr *StubReader) ListServices(ctx context.Context) ([]string, error) {
-	return sr.ListServicesFunc(ctx)
+func (sr *StubReader) ListServices(ctx context.Context, tenant string) ([]string, error) {
+	return sr.ListServicesFunc(ctx, tenant)
+}
+
+func (sr *StubReader) ListTenants(ctx context.Context) ([]string, error) {
+	return sr.ListTenantsFunc(ctx)
 }
 
 func (sr *StubReader) FindProfiles(ctx context.Context, params *FindProfilesParams) ([]profile.Meta, error) {
@@ -48,6 +56,37 @@ func (sr *StubReader) FindProfileIDs(ctx context.Context, params *FindProfilesPa
 	return sr.FindProfileIDsFunc(ctx, params)
 }
 
-func (sr *StubReader) ListProfiles(ctx context.Context, pid []profile.ID) (ProfileList, error) {
-	return sr.ListProfilesFunc(ctx, pid)
+func (sr *StubReader) ListProfiles(ctx context.Context, tenant string, pid []profile.ID) (ProfileList, error) {
+	return sr.ListProfilesFunc(ctx, tenant, pid)
+}
+
+type DeleteProfilesFunc func(ctx context.Context, tenant string, pids []profile.ID) error
+
+type StubDeleter struct {
+	DeleteProfilesFunc
+}
+
+var _ Deleter = (*StubDeleter)(nil)
+
+func (sd *StubDeleter) DeleteProfiles(ctx context.Context, tenant string, pids []profile.ID) error {
+	return sd.DeleteProfilesFunc(ctx, tenant, pids)
+}
+
+type MergeProfilesFunc func(ctx context.Context, params *AggregateParams) (*pprofProfile.Profile, error)
+
+type TopFunctionsFunc func(ctx context.Context, params *AggregateParams, sampleType string, limit int) ([]FunctionTotal, error)
+
+type StubAggregator struct {
+	MergeProfilesFunc
+	TopFunctionsFunc
+}
+
+var _ Aggregator = (*StubAggregator)(nil)
+
+func (sa *StubAggregator) MergeProfiles(ctx context.Context, params *AggregateParams) (*pprofProfile.Profile, error) {
+	return sa.MergeProfilesFunc(ctx, params)
+}
+
+func (sa *StubAggregator) TopFunctions(ctx context.Context, params *AggregateParams, sampleType string, limit int) ([]FunctionTotal, error) {
+	return sa.TopFunctionsFunc(ctx, params, sampleType, limit)
 }
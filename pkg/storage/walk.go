@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+)
+
+// the number of profiles found at once, if the params of WalkProfiles have no limit
+const defaultWalkLimit = 100
+
+// WalkProfiles calls the fn with the metas of all the profiles, that match the params and were created
+// within [CreatedAtMin, CreatedAtMax), batch by batch in the order of their creation time and ID.
+//
+// The storages return different profiles, if more than the limit match, e.g. the oldest or the newest ones,
+// and in different order. Thus, the time range is split in halves, until the profiles of every part fit
+// the limit; a part, that can't be split any further, is found again with the doubled limit.
+func WalkProfiles(ctx context.Context, sr Reader, params *FindProfilesParams, fn func(metas []profile.Meta) error) error {
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultWalkLimit
+	}
+
+	type timeRange struct {
+		min, max time.Time
+		limit    int
+	}
+
+	// the parts of the time range, left to walk; the earliest part is the last one
+	ranges := []timeRange{{params.CreatedAtMin, params.CreatedAtMax, limit}}
+	for len(ranges) > 0 {
+		r := ranges[len(ranges)-1]
+		ranges = ranges[:len(ranges)-1]
+
+		p := *params
+		p.CreatedAtMin, p.CreatedAtMax, p.Limit = r.min, r.max, r.limit
+		metas, err := sr.FindProfiles(ctx, &p)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if len(metas) >= r.limit {
+			if mid, ok := splitTimeRange(r.min, r.max); ok {
+				ranges = append(ranges, timeRange{mid, r.max, limit}, timeRange{r.min, mid, limit})
+			} else {
+				ranges = append(ranges, timeRange{r.min, r.max, 2 * r.limit})
+			}
+			continue
+		}
+
+		// some storages include the profiles created at the CreatedAtMax
+		n := 0
+		for _, meta := range metas {
+			if !meta.CreatedAt.Before(r.min) && meta.CreatedAt.Before(r.max) {
+				metas[n] = meta
+				n++
+			}
+		}
+		metas = metas[:n]
+		if len(metas) == 0 {
+			continue
+		}
+
+		sort.Slice(metas, func(i, j int) bool {
+			if !metas[i].CreatedAt.Equal(metas[j].CreatedAt) {
+				return metas[i].CreatedAt.Before(metas[j].CreatedAt)
+			}
+			return metas[i].ProfileID < metas[j].ProfileID
+		})
+
+		if err := fn(metas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the whole second, that splits the time range in two non-empty parts; some storages keep
+// the creation time of the profiles with the precision of a second
+func splitTimeRange(min, max time.Time) (time.Time, bool) {
+	mid := min.Add(max.Sub(min) / 2).Truncate(time.Second)
+	if !mid.After(min) {
+		mid = min.Truncate(time.Second).Add(time.Second)
+	}
+	return mid, mid.Before(max)
+}
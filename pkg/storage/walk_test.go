@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/profefe/profefe/pkg/profile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkProfiles(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var stored []profile.Meta
+	for i := 0; i < 20; i++ {
+		// some profiles are created at the same second
+		stored = append(stored, profile.Meta{
+			ProfileID: profile.ID(fmt.Sprintf("p%02d", i)),
+			CreatedAt: createdAt.Add(time.Duration(i/3) * time.Second),
+		})
+	}
+
+	// finds the profiles within [CreatedAtMin, CreatedAtMax], like some storages do; returns either the oldest
+	// profiles in ascending order, or the newest profiles in descending order, like badger does
+	newFindProfiles := func(newest bool) FindProfilesFunc {
+		return func(ctx context.Context, params *FindProfilesParams) ([]profile.Meta, error) {
+			var metas []profile.Meta
+			for _, meta := range stored {
+				if !meta.CreatedAt.Before(params.CreatedAtMin) && !meta.CreatedAt.After(params.CreatedAtMax) {
+					metas = append(metas, meta)
+				}
+			}
+			if newest {
+				sort.SliceStable(metas, func(i, j int) bool { return metas[i].CreatedAt.After(metas[j].CreatedAt) })
+			}
+			if len(metas) > params.Limit {
+				metas = metas[:params.Limit]
+			}
+			if len(metas) == 0 {
+				return nil, ErrNotFound
+			}
+			return metas, nil
+		}
+	}
+
+	for _, newest := range []bool{false, true} {
+		t.Run(fmt.Sprintf("newest=%v", newest), func(t *testing.T) {
+			sr := &StubReader{FindProfilesFunc: newFindProfiles(newest)}
+
+			params := &FindProfilesParams{
+				CreatedAtMin: createdAt,
+				// the profiles of the last second are outside of the range
+				CreatedAtMax: createdAt.Add(6 * time.Second),
+				// the profiles, created at the same second, don't fit the limit
+				Limit: 2,
+			}
+
+			var found []profile.Meta
+			err := WalkProfiles(context.Background(), sr, params, func(metas []profile.Meta) error {
+				found = append(found, metas...)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, stored[:18], found)
+			assert.Equal(t, 2, params.Limit)
+		})
+	}
+}
+
+func TestSplitTimeRange(t *testing.T) {
+	createdAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		min, max time.Duration
+		mid      time.Duration
+		ok       bool
+	}{
+		{0, 10 * time.Second, 5 * time.Second, true},
+		{0, 3 * time.Second, time.Second, true},
+		{500 * time.Millisecond, 1200 * time.Millisecond, time.Second, true},
+		{0, time.Second, time.Second, false},
+		{500 * time.Millisecond, 900 * time.Millisecond, time.Second, false},
+	}
+	for _, tc := range cases {
+		mid, ok := splitTimeRange(createdAt.Add(tc.min), createdAt.Add(tc.max))
+		assert.Equal(t, tc.ok, ok, "min %v, max %v", tc.min, tc.max)
+		if ok {
+			assert.Equal(t, createdAt.Add(tc.mid), mid, "min %v, max %v", tc.min, tc.max)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package symbolizer
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/profefe"
+	"github.com/profefe/profefe/pkg/storage"
+)
+
+const APISymbolsPath = "/api/0/symbols"
+
+// the maximum size of the uploaded binary
+const maxSymbolsSize = 1 << 30
+
+// Handler accepts the uploads of binaries and debug information, keyed by the build ID,
+// e.g. "POST /api/0/symbols/<build_id>".
+type Handler struct {
+	logger     *log.Logger
+	store      storage.SymbolStore
+	symbolizer *Symbolizer
+}
+
+func NewHandler(logger *log.Logger, store storage.SymbolStore, symbolizer *Symbolizer) *Handler {
+	return &Handler{
+		logger:     logger,
+		store:      store,
+		symbolizer: symbolizer,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if r.Method != http.MethodPost {
+		err = profefe.StatusError(http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+	} else {
+		err = h.HandleUploadSymbols(w, r)
+	}
+	profefe.HandleErrorHTTP(h.logger, err, w, r)
+}
+
+func (h *Handler) HandleUploadSymbols(w http.ResponseWriter, r *http.Request) error {
+	buildID := strings.Trim(strings.TrimPrefix(r.URL.Path, APISymbolsPath), "/")
+	if err := storage.ValidateBuildID(buildID); err != nil {
+		return profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: %s", err), nil)
+	}
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(r.Body, maxSymbolsSize+1)); err != nil {
+		return err
+	}
+	if buf.Len() > maxSymbolsSize {
+		return profefe.StatusError(http.StatusRequestEntityTooLarge, fmt.Sprintf("binary is larger than %d bytes", maxSymbolsSize), nil)
+	}
+
+	if err := validateObjFile(buildID, buf.Bytes()); err != nil {
+		return profefe.StatusError(http.StatusBadRequest, fmt.Sprintf("bad request: %s", err), nil)
+	}
+
+	if err := h.store.PutSymbols(r.Context(), buildID, &buf); err != nil {
+		return profefe.StatusError(http.StatusInternalServerError, fmt.Sprintf("failed to store symbols of %q", buildID), err)
+	}
+
+	if h.symbolizer != nil {
+		h.symbolizer.Forget(buildID)
+	}
+
+	profefe.ReplyJSON(w, struct {
+		BuildID string `json:"build_id"`
+	}{buildID})
+
+	return nil
+}
+
+// checks that the data is an ELF file with symbol information, that matches the build ID
+func validateObjFile(buildID string, data []byte) error {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("not an ELF file: %w", err)
+	}
+	if fileBuildID := gnuBuildID(f); fileBuildID != "" && fileBuildID != buildID {
+		return fmt.Errorf("build id %q doesn't match the build id of the file %q", buildID, fileBuildID)
+	}
+	_, err = openObjFile(data)
+	return err
+}
@@ -0,0 +1,71 @@
+package symbolizer
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	data := readTestBinary(t)
+
+	f, err := elf.NewFile(bytes.NewReader(data))
+	require.NoError(t, err)
+	buildID := gnuBuildID(f)
+	if buildID == "" {
+		t.Skip("test binary doesn't have GNU build id")
+	}
+
+	cases := []struct {
+		method   string
+		path     string
+		body     []byte
+		wantCode int
+	}{
+		{http.MethodGet, APISymbolsPath + "/" + buildID, nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, APISymbolsPath + "/", data, http.StatusBadRequest},
+		{http.MethodPost, APISymbolsPath + "/abc%2F123", data, http.StatusBadRequest},
+		{http.MethodPost, APISymbolsPath + "/" + buildID, []byte("not a binary"), http.StatusBadRequest},
+		{http.MethodPost, APISymbolsPath + "/abc123", data, http.StatusBadRequest},
+		{http.MethodPost, APISymbolsPath + "/" + buildID, data, http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			st := &testSymbolStore{}
+			testLogger := log.New(zaptest.NewLogger(t))
+			s := New(testLogger, st)
+			h := NewHandler(testLogger, st, s)
+
+			// the symbolizer remembers that there was no binary
+			_, err := s.objFile(context.Background(), buildID)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(tc.method, tc.path, bytes.NewReader(tc.body))
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+
+			require.Equal(t, tc.wantCode, resp.Code, resp.Body.String())
+			if tc.wantCode != http.StatusOK {
+				assert.Empty(t, st.symbols)
+				return
+			}
+
+			assert.Equal(t, data, st.symbols[buildID])
+			assert.JSONEq(t, `{"code":200,"body":{"build_id":"`+buildID+`"}}`, resp.Body.String())
+
+			// the uploaded binary is used by the following queries
+			obj, err := s.objFile(context.Background(), buildID)
+			require.NoError(t, err)
+			assert.NotNil(t, obj)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package symbolizer
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+)
+
+// frame is the symbol information of an address.
+type frame struct {
+	Func string
+	File string
+	Line int
+}
+
+// objFile resolves the addresses of an ELF binary, using Go's pclntab, DWARF and the symbol table,
+// whichever is available.
+type objFile struct {
+	typ elf.Type
+	// virtual address and file offset of the executable segment
+	textVaddr uint64
+	textOff   uint64
+
+	goTable *gosym.Table
+
+	dwarf    *dwarf.Data
+	dwarfCUs []dwarfCU
+
+	syms []elfSymbol
+}
+
+type dwarfCU struct {
+	low, high uint64
+	entry     *dwarf.Entry
+}
+
+type elfSymbol struct {
+	name  string
+	value uint64
+	size  uint64
+}
+
+func openObjFile(data []byte) (*objFile, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not read ELF file: %w", err)
+	}
+
+	obj := &objFile{
+		typ: f.Type,
+	}
+
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 {
+			obj.textVaddr = prog.Vaddr
+			obj.textOff = prog.Off
+			break
+		}
+	}
+
+	if pclntab, text := f.Section(".gopclntab"), f.Section(".text"); pclntab != nil && text != nil {
+		pcln, err := pclntab.Data()
+		if err == nil {
+			obj.goTable, _ = gosym.NewTable(nil, gosym.NewLineTable(pcln, text.Addr))
+		}
+	}
+
+	if d, err := f.DWARF(); err == nil {
+		obj.dwarf = d
+		obj.dwarfCUs = readDWARFCompileUnits(d)
+	}
+
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dynSyms...) {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		obj.syms = append(obj.syms, elfSymbol{name: sym.Name, value: sym.Value, size: sym.Size})
+	}
+	sort.Slice(obj.syms, func(i, j int) bool {
+		return obj.syms[i].value < obj.syms[j].value
+	})
+
+	if obj.goTable == nil && obj.dwarf == nil && len(obj.syms) == 0 {
+		return nil, errors.New("no symbol information in ELF file")
+	}
+
+	return obj, nil
+}
+
+func readDWARFCompileUnits(d *dwarf.Data) (cus []dwarfCU) {
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		ranges, err := d.Ranges(entry)
+		if err != nil {
+			continue
+		}
+		for _, rng := range ranges {
+			cus = append(cus, dwarfCU{low: rng[0], high: rng[1], entry: entry})
+		}
+		r.SkipChildren()
+	}
+	return cus
+}
+
+// returns the address in the file, that corresponds to the address in memory where the mapping was loaded
+func (obj *objFile) fileAddr(m *pprofProfile.Mapping, addr uint64) uint64 {
+	if obj.typ == elf.ET_EXEC {
+		// non-PIE executables are loaded at the addresses they were linked at
+		return addr
+	}
+	return addr - m.Start + m.Offset + obj.textVaddr - obj.textOff
+}
+
+// lookup returns the symbol information of the address in the file, or false if nothing was found.
+func (obj *objFile) lookup(addr uint64) (fr frame, ok bool) {
+	if obj.goTable != nil {
+		if file, line, fn := obj.goTable.PCToLine(addr); fn != nil {
+			return frame{Func: fn.Name, File: file, Line: line}, true
+		}
+	}
+
+	if sym, found := obj.lookupSymbol(addr); found {
+		fr.Func = sym.name
+		ok = true
+	}
+	if file, line, found := obj.lookupDWARFLine(addr); found {
+		fr.File, fr.Line = file, line
+		ok = true
+	}
+	return fr, ok
+}
+
+func (obj *objFile) lookupSymbol(addr uint64) (elfSymbol, bool) {
+	n := sort.Search(len(obj.syms), func(i int) bool {
+		return obj.syms[i].value > addr
+	})
+	if n == 0 {
+		return elfSymbol{}, false
+	}
+	sym := obj.syms[n-1]
+	if sym.size != 0 && addr >= sym.value+sym.size {
+		return elfSymbol{}, false
+	}
+	return sym, true
+}
+
+func (obj *objFile) lookupDWARFLine(addr uint64) (file string, line int, ok bool) {
+	for _, cu := range obj.dwarfCUs {
+		if addr < cu.low || addr >= cu.high {
+			continue
+		}
+		lr, err := obj.dwarf.LineReader(cu.entry)
+		if err != nil || lr == nil {
+			return "", 0, false
+		}
+		var le dwarf.LineEntry
+		if err := lr.SeekPC(addr, &le); err != nil || le.File == nil {
+			return "", 0, false
+		}
+		return le.File.Name, le.Line, true
+	}
+	return "", 0, false
+}
+
+// gnuBuildID returns hex-encoded GNU build ID of the ELF file, the same way runtime/pprof reports it
+// in profile's mappings, or empty string if the file doesn't have one.
+func gnuBuildID(f *elf.File) string {
+	for _, sect := range f.Sections {
+		if sect.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := sect.Data()
+		if err != nil {
+			continue
+		}
+		for len(data) >= 12 {
+			nameSize := f.ByteOrder.Uint32(data[0:4])
+			descSize := f.ByteOrder.Uint32(data[4:8])
+			noteType := f.ByteOrder.Uint32(data[8:12])
+			data = data[12:]
+
+			nameEnd := alignNote(nameSize)
+			descEnd := nameEnd + alignNote(descSize)
+			if uint64(len(data)) < descEnd {
+				break
+			}
+			name := data[:nameSize]
+			desc := data[nameEnd : nameEnd+uint64(descSize)]
+			data = data[descEnd:]
+
+			// NT_GNU_BUILD_ID
+			if noteType == 3 && string(name) == "GNU\x00" {
+				return hex.EncodeToString(desc)
+			}
+		}
+	}
+	return ""
+}
+
+func alignNote(n uint32) uint64 {
+	return (uint64(n) + 3) &^ 3
+}
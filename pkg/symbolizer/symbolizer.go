@@ -0,0 +1,203 @@
+// Package symbolizer resolves the addresses of profiles' locations, that arrive without symbol information,
+// using the binaries and debug information uploaded to a symbol store.
+package symbolizer
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// the number of parsed binaries kept in memory
+	defaultCacheSize = 16
+	// how long to remember that nothing was uploaded for a build ID
+	missingTTL = time.Minute
+)
+
+// Symbolizer resolves the addresses of profile's locations with the binaries from the symbol store.
+type Symbolizer struct {
+	logger *log.Logger
+	store  storage.SymbolStore
+
+	mu        sync.Mutex
+	cacheSize int
+	cache     map[string]*list.Element
+	lru       *list.List
+
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	buildID string
+	obj     *objFile
+	// set if there is no binary for the build ID in the store
+	missingUntil time.Time
+}
+
+func New(logger *log.Logger, store storage.SymbolStore) *Symbolizer {
+	return &Symbolizer{
+		logger:    logger,
+		store:     store,
+		cacheSize: defaultCacheSize,
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+		now:       time.Now,
+	}
+}
+
+// Symbolize adds function names, file names and line numbers to the locations that don't have them.
+// It reports whether any location was symbolized. The mappings, which binaries weren't uploaded,
+// are left as is.
+func (s *Symbolizer) Symbolize(ctx context.Context, pp *pprofProfile.Profile) (bool, error) {
+	var (
+		symbolized bool
+		funcs      map[frame]*pprofProfile.Function
+		nextFuncID uint64
+	)
+
+	// the mappings, that aren't fully symbolized, are flagged by the profiler
+	unsymbolized := make(map[*pprofProfile.Mapping]bool)
+	for _, m := range pp.Mapping {
+		if m.BuildID != "" && !(m.HasFunctions && m.HasFilenames && m.HasLineNumbers) {
+			unsymbolized[m] = true
+		}
+	}
+	if len(unsymbolized) == 0 {
+		return false, nil
+	}
+
+	objs := make(map[*pprofProfile.Mapping]*objFile)
+	for _, loc := range pp.Location {
+		m := loc.Mapping
+		if len(loc.Line) != 0 || !unsymbolized[m] {
+			continue
+		}
+
+		obj, ok := objs[m]
+		if !ok {
+			var err error
+			obj, err = s.objFile(ctx, m.BuildID)
+			if err != nil {
+				if ctx.Err() != nil {
+					return false, ctx.Err()
+				}
+				s.logger.Errorw("could not load symbols", "build_id", m.BuildID, zap.Error(err))
+			}
+			objs[m] = obj
+		}
+		if obj == nil {
+			continue
+		}
+
+		fr, ok := obj.lookup(obj.fileAddr(m, loc.Address))
+		if !ok {
+			continue
+		}
+
+		if funcs == nil {
+			funcs, nextFuncID = indexFunctions(pp)
+		}
+		// functions are identified by name and file, the line is the line of the location
+		key := frame{Func: fr.Func, File: fr.File}
+		fn := funcs[key]
+		if fn == nil {
+			nextFuncID++
+			fn = &pprofProfile.Function{
+				ID:         nextFuncID,
+				Name:       fr.Func,
+				SystemName: fr.Func,
+				Filename:   fr.File,
+			}
+			funcs[key] = fn
+			pp.Function = append(pp.Function, fn)
+		}
+		loc.Line = []pprofProfile.Line{{Function: fn, Line: int64(fr.Line)}}
+
+		m.HasFunctions = m.HasFunctions || fr.Func != ""
+		m.HasFilenames = m.HasFilenames || fr.File != ""
+		m.HasLineNumbers = m.HasLineNumbers || fr.Line != 0
+		symbolized = true
+	}
+
+	return symbolized, nil
+}
+
+func indexFunctions(pp *pprofProfile.Profile) (funcs map[frame]*pprofProfile.Function, maxID uint64) {
+	funcs = make(map[frame]*pprofProfile.Function, len(pp.Function))
+	for _, fn := range pp.Function {
+		funcs[frame{Func: fn.Name, File: fn.Filename}] = fn
+		if fn.ID > maxID {
+			maxID = fn.ID
+		}
+	}
+	return funcs, maxID
+}
+
+// returns the parsed binary of the build ID, or nil if nothing was uploaded for the build ID
+func (s *Symbolizer) objFile(ctx context.Context, buildID string) (*objFile, error) {
+	s.mu.Lock()
+	if el, ok := s.cache[buildID]; ok {
+		entry := el.Value.(*cacheEntry)
+		if entry.obj != nil || s.now().Before(entry.missingUntil) {
+			s.lru.MoveToFront(el)
+			s.mu.Unlock()
+			return entry.obj, nil
+		}
+	}
+	s.mu.Unlock()
+
+	// concurrent queries of the same build ID may load the binary several times, that's fine
+	entry := &cacheEntry{buildID: buildID}
+	data, err := s.store.GetSymbols(ctx, buildID)
+	if err == storage.ErrNotFound {
+		err = nil
+	} else if err == nil {
+		// the broken binary is remembered the same way as the missing one, to not parse it on every query
+		entry.obj, err = openObjFile(data)
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+	if entry.obj == nil {
+		entry.missingUntil = s.now().Add(missingTTL)
+	}
+
+	s.mu.Lock()
+	s.putLocked(entry)
+	s.mu.Unlock()
+
+	return entry.obj, err
+}
+
+// Forget drops the parsed binary of the build ID from the cache, e.g. after a new binary was uploaded.
+func (s *Symbolizer) Forget(buildID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.cache[buildID]; ok {
+		s.lru.Remove(el)
+		delete(s.cache, buildID)
+	}
+}
+
+func (s *Symbolizer) putLocked(entry *cacheEntry) {
+	if el, ok := s.cache[entry.buildID]; ok {
+		el.Value = entry
+		s.lru.MoveToFront(el)
+		return
+	}
+
+	s.cache[entry.buildID] = s.lru.PushFront(entry)
+	for s.lru.Len() > s.cacheSize {
+		el := s.lru.Back()
+		s.lru.Remove(el)
+		delete(s.cache, el.Value.(*cacheEntry).buildID)
+	}
+}
@@ -0,0 +1,187 @@
+package symbolizer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	pprofProfile "github.com/profefe/profefe/internal/pprof/profile"
+	"github.com/profefe/profefe/pkg/log"
+	"github.com/profefe/profefe/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+type testSymbolStore struct {
+	mu      sync.Mutex
+	symbols map[string][]byte
+	gets    int
+}
+
+func (st *testSymbolStore) PutSymbols(ctx context.Context, buildID string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.symbols == nil {
+		st.symbols = make(map[string][]byte)
+	}
+	st.symbols[buildID] = data
+	return nil
+}
+
+func (st *testSymbolStore) GetSymbols(ctx context.Context, buildID string) ([]byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.gets++
+	data, ok := st.symbols[buildID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return data, nil
+}
+
+// the test binary itself is used as the binary of the symbolized profile
+func readTestBinary(t *testing.T) []byte {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(exe)
+	require.NoError(t, err)
+	return data
+}
+
+func newTestProfile(t *testing.T, data []byte, funcName string) (pp *pprofProfile.Profile, entry uint64) {
+	obj, err := openObjFile(data)
+	require.NoError(t, err)
+	require.NotNil(t, obj.goTable)
+
+	fn := obj.goTable.LookupFunc(funcName)
+	require.NotNil(t, fn, "function %s not found", funcName)
+
+	// the mapping is set up so the addresses in memory are the same as the addresses in the file
+	m := &pprofProfile.Mapping{
+		ID:      1,
+		Start:   obj.textVaddr,
+		Limit:   obj.textVaddr + uint64(len(data)),
+		Offset:  obj.textOff,
+		File:    "/bin/app",
+		BuildID: "abc123",
+	}
+	pp = &pprofProfile.Profile{
+		SampleType: []*pprofProfile.ValueType{{Type: "samples", Unit: "count"}},
+		Mapping:    []*pprofProfile.Mapping{m},
+		Location: []*pprofProfile.Location{
+			{ID: 1, Mapping: m, Address: fn.Entry},
+			// an address outside of the binary stays unsymbolized
+			{ID: 2, Mapping: m, Address: 1},
+		},
+	}
+	pp.Sample = []*pprofProfile.Sample{
+		{Location: pp.Location, Value: []int64{1}},
+	}
+	return pp, fn.Entry
+}
+
+func TestSymbolizer_Symbolize(t *testing.T) {
+	data := readTestBinary(t)
+
+	st := &testSymbolStore{}
+	require.NoError(t, st.PutSymbols(context.Background(), "abc123", bytes.NewReader(data)))
+
+	s := New(log.New(zaptest.NewLogger(t)), st)
+
+	const funcName = "github.com/profefe/profefe/pkg/symbolizer.TestSymbolizer_Symbolize"
+	pp, _ := newTestProfile(t, data, funcName)
+
+	symbolized, err := s.Symbolize(context.Background(), pp)
+	require.NoError(t, err)
+	require.True(t, symbolized)
+
+	require.Len(t, pp.Location[0].Line, 1)
+	line := pp.Location[0].Line[0]
+	assert.Equal(t, funcName, line.Function.Name)
+	assert.Contains(t, line.Function.Filename, "symbolizer_test.go")
+	assert.NotZero(t, line.Line)
+	assert.Equal(t, uint64(1), line.Function.ID)
+
+	assert.Empty(t, pp.Location[1].Line)
+
+	m := pp.Mapping[0]
+	assert.True(t, m.HasFunctions)
+	assert.True(t, m.HasFilenames)
+	assert.True(t, m.HasLineNumbers)
+
+	require.NoError(t, pp.CheckValid())
+
+	// the profile survives serialization
+	var buf bytes.Buffer
+	require.NoError(t, pp.Write(&buf))
+	pp2, err := pprofProfile.Parse(&buf)
+	require.NoError(t, err)
+	require.Len(t, pp2.Location[0].Line, 1)
+	assert.Equal(t, funcName, pp2.Location[0].Line[0].Function.Name)
+
+	// symbolized mappings aren't symbolized again
+	symbolized, err = s.Symbolize(context.Background(), pp)
+	require.NoError(t, err)
+	assert.False(t, symbolized)
+	assert.Equal(t, 1, st.gets)
+}
+
+func TestSymbolizer_Symbolize_missingSymbols(t *testing.T) {
+	data := readTestBinary(t)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := &testSymbolStore{}
+	s := New(log.New(zaptest.NewLogger(t)), st)
+	s.now = func() time.Time { return now }
+
+	pp, _ := newTestProfile(t, data, "github.com/profefe/profefe/pkg/symbolizer.TestSymbolizer_Symbolize")
+
+	symbolized, err := s.Symbolize(context.Background(), pp)
+	require.NoError(t, err)
+	assert.False(t, symbolized)
+	assert.Empty(t, pp.Location[0].Line)
+
+	// the missing binary is remembered for a while
+	_, err = s.Symbolize(context.Background(), pp)
+	require.NoError(t, err)
+	assert.Equal(t, 1, st.gets)
+
+	require.NoError(t, st.PutSymbols(context.Background(), "abc123", bytes.NewReader(data)))
+
+	now = now.Add(missingTTL)
+	symbolized, err = s.Symbolize(context.Background(), pp)
+	require.NoError(t, err)
+	assert.True(t, symbolized)
+	assert.Equal(t, 2, st.gets)
+}
+
+func TestSymbolizer_cache(t *testing.T) {
+	st := &testSymbolStore{}
+	s := New(log.New(zaptest.NewLogger(t)), st)
+	s.cacheSize = 2
+
+	ctx := context.Background()
+	for _, buildID := range []string{"b1", "b2", "b1", "b3"} {
+		_, err := s.objFile(ctx, buildID)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, s.lru.Len())
+	assert.Contains(t, s.cache, "b1")
+	assert.Contains(t, s.cache, "b3")
+	assert.NotContains(t, s.cache, "b2")
+
+	s.Forget("b1")
+	assert.NotContains(t, s.cache, "b1")
+	assert.Equal(t, 1, s.lru.Len())
+}
@@ -0,0 +1,91 @@
+package tenant
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	ConfigFile string
+}
+
+func (conf *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&conf.ConfigFile, "tenants.config-file", "", "path to YAML file with per-tenant retention and quotas")
+}
+
+// Policy defines the limits applied to the profiles of a tenant. Zero value means no limit.
+type Policy struct {
+	// how long to keep the profiles; badger expires the data by itself, the other storages are swept
+	// by retention policies
+	Retention time.Duration `yaml:"retention,omitempty"`
+	// the maximum size of profiles the tenant can store during a day (UTC)
+	DailyQuotaBytes int64 `yaml:"daily_quota_bytes,omitempty"`
+}
+
+// Policies maps tenants to their policies.
+//
+// Example:
+//
+//	default:
+//	  retention: 120h
+//	tenants:
+//	  team-a:
+//	    retention: 720h
+//	    daily_quota_bytes: 10737418240
+type Policies struct {
+	// applied to the tenants that aren't listed
+	Default Policy            `yaml:"default"`
+	Tenants map[string]Policy `yaml:"tenants,omitempty"`
+}
+
+// Load reads the policies from the config file. Empty policies are returned, if the config file isn't set.
+func (conf *Config) Load() (*Policies, error) {
+	if conf.ConfigFile == "" {
+		return &Policies{}, nil
+	}
+
+	data, err := ioutil.ReadFile(conf.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := ParsePolicies(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not load tenants config %q: %w", conf.ConfigFile, err)
+	}
+	return policies, nil
+}
+
+func ParsePolicies(data []byte) (*Policies, error) {
+	var policies Policies
+	if err := yaml.UnmarshalStrict(data, &policies); err != nil {
+		return nil, err
+	}
+	for name := range policies.Tenants {
+		if err := ValidateName(name); err != nil {
+			return nil, err
+		}
+	}
+	return &policies, nil
+}
+
+// Get returns the policy of the tenant.
+func (p *Policies) Get(tenant string) Policy {
+	if policy, ok := p.Tenants[tenant]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Retention returns the retention period of the tenant, or zero if it isn't limited.
+func (p *Policies) Retention(tenant string) time.Duration {
+	return p.Get(tenant).Retention
+}
+
+// DailyQuota returns the daily quota of the tenant in bytes, or zero if it isn't limited.
+func (p *Policies) DailyQuota(tenant string) int64 {
+	return p.Get(tenant).DailyQuotaBytes
+}
@@ -0,0 +1,44 @@
+package tenant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies([]byte(`
+default:
+  retention: 120h
+tenants:
+  team-a:
+    retention: 720h
+    daily_quota_bytes: 1024
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, 720*time.Hour, policies.Retention("team-a"))
+	assert.Equal(t, int64(1024), policies.DailyQuota("team-a"))
+
+	assert.Equal(t, 120*time.Hour, policies.Retention("team-b"))
+	assert.Equal(t, int64(0), policies.DailyQuota("team-b"))
+	assert.Equal(t, 120*time.Hour, policies.Retention(Default))
+}
+
+func TestParsePolicies_badTenant(t *testing.T) {
+	_, err := ParsePolicies([]byte(`
+tenants:
+  team/a:
+    retention: 720h
+`))
+	require.Error(t, err)
+}
+
+func TestValidateName(t *testing.T) {
+	require.NoError(t, ValidateName(Default))
+	require.NoError(t, ValidateName("team_A-1"))
+	require.Error(t, ValidateName("team/a"))
+	require.Error(t, ValidateName("team.a"))
+}
@@ -0,0 +1,46 @@
+// Package tenant defines tenants, the isolated namespaces of profiles, that share the same collector.
+package tenant
+
+import (
+	"context"
+	"fmt"
+)
+
+// Default is the tenant of the requests that don't specify any tenant.
+// Profiles of the default tenant are stored the same way they were stored before tenants were introduced.
+const Default = ""
+
+// HeaderTenant is the HTTP header, or gRPC metadata key, the client passes the tenant with.
+const HeaderTenant = "X-Profefe-Tenant"
+
+const maxNameLen = 64
+
+// ValidateName checks that the tenant name is safe to be used as a part of storage keys.
+func ValidateName(name string) error {
+	if name == Default {
+		return nil
+	}
+	if len(name) > maxNameLen {
+		return fmt.Errorf("tenant name longer than %d characters", maxNameLen)
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_' {
+			continue
+		}
+		return fmt.Errorf("bad tenant name %q: only letters, digits, '-' and '_' are allowed", name)
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+func ContextWithTenant(parentCtx context.Context, tenant string) context.Context {
+	return context.WithValue(parentCtx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant of the request, or Default, if the context doesn't hold any.
+func FromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}